@@ -20,6 +20,12 @@ type ClusterCatalog struct {
 	SeedRegistry              bool   `yaml:"seed_registry"`
 	KuberangPath              string `yaml:"kuberang_path"`
 	LoadBalancedFQDN          string `yaml:"kubernetes_load_balanced_fqdn"`
+	DedicatedAPIServerCert    bool   `yaml:"dedicated_apiserver_cert"`
+
+	EtcdK8sClientPort        int `yaml:"etcd_k8s_client_port"`
+	EtcdK8sPeerPort          int `yaml:"etcd_k8s_peer_port"`
+	EtcdNetworkingClientPort int `yaml:"etcd_networking_client_port"`
+	EtcdNetworkingPeerPort   int `yaml:"etcd_networking_peer_port"`
 
 	APIServerOptions map[string]string `yaml:"kubernetes_api_server_option_overrides"`
 
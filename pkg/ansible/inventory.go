@@ -3,6 +3,7 @@ package ansible
 import (
 	"bytes"
 	"fmt"
+	"sort"
 )
 
 // Inventory is a collection of Nodes, keyed by role.
@@ -32,6 +33,8 @@ type Node struct {
 	SSHPort int
 	// SSHUser is the SSH user for logging into the node
 	SSHUser string
+	// Vars are extra host variables of the node
+	Vars map[string]string
 }
 
 // ToINI converts the inventory into INI format
@@ -44,7 +47,17 @@ func (i Inventory) ToINI() []byte {
 			if n.InternalIP != "" {
 				internalIP = n.InternalIP
 			}
-			fmt.Fprintf(w, "%q ansible_host=%q internal_ipv4=%q ansible_ssh_private_key_file=%q ansible_port=%d ansible_user=%q\n", n.Host, n.PublicIP, internalIP, n.SSHPrivateKey, n.SSHPort, n.SSHUser)
+			fmt.Fprintf(w, "%q ansible_host=%q internal_ipv4=%q ansible_ssh_private_key_file=%q ansible_port=%d ansible_user=%q", n.Host, n.PublicIP, internalIP, n.SSHPrivateKey, n.SSHPort, n.SSHUser)
+			// sort the vars so that the inventory is stable
+			names := make([]string, 0, len(n.Vars))
+			for name := range n.Vars {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(w, " %s=%q", name, n.Vars[name])
+			}
+			fmt.Fprint(w, "\n")
 		}
 	}
 
@@ -70,3 +70,36 @@ func TestInventoryINIGeneration(t *testing.T) {
 	}
 
 }
+
+func TestInventoryINIGenerationNodeVars(t *testing.T) {
+	inv := Inventory{
+		Roles: []Role{
+			{
+				Name: "worker",
+				Nodes: []Node{
+					{
+						Host:          "worker01",
+						PublicIP:      "10.0.0.3",
+						SSHPrivateKey: "id_rsa",
+						SSHPort:       22,
+						SSHUser:       "alice",
+						Vars: map[string]string{
+							"rack":                       "r1",
+							"ansible_python_interpreter": "/usr/bin/python3",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	ini := string(inv.ToINI())
+
+	expected := `[worker]
+"worker01" ansible_host="10.0.0.3" internal_ipv4="10.0.0.3" ansible_ssh_private_key_file="id_rsa" ansible_port=22 ansible_user="alice" ansible_python_interpreter="/usr/bin/python3" rack="r1"
+`
+
+	if ini != expected {
+		t.Errorf("expected format differs from obtained format. Expected: \n%s\nGot: \n%s\n", expected, ini)
+	}
+}
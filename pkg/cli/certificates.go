@@ -17,6 +17,9 @@ func NewCmdCertificates(out io.Writer) *cobra.Command {
 	}
 
 	cmd.AddCommand(NewCmdGenerate(out))
+	cmd.AddCommand(NewCmdInspect(out))
+	cmd.AddCommand(NewCmdBundle(out))
+	cmd.AddCommand(NewCmdRenew(out))
 
 	return cmd
 }
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/apprenda/kismatic/pkg/install"
+	"github.com/spf13/cobra"
+)
+
+type certificatesBundleOpts struct {
+	planFilename       string
+	generatedAssetsDir string
+	outputDir          string
+}
+
+// NewCmdBundle creates a new certificates bundle command
+func NewCmdBundle(out io.Writer) *cobra.Command {
+	opts := &certificatesBundleOpts{}
+	cmd := &cobra.Command{
+		Use:   "bundle <node> [options]",
+		Short: "Copy the certificates and keys required by a node to a directory, excluding the private key of the CA",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				cmd.Help()
+				return fmt.Errorf("expected the hostname of a node, but got: %v", args)
+			}
+			return doCertificatesBundle(out, args[0], opts)
+		},
+	}
+	addPlanFileFlag(cmd.Flags(), &opts.planFilename)
+	cmd.Flags().StringVar(&opts.generatedAssetsDir, "generated-assets-dir", "generated", "path to the directory where assets generated during the installation process will be stored")
+	cmd.Flags().StringVar(&opts.outputDir, "output-dir", "", "path to the directory where the bundle will be written. Defaults to <generated-assets-dir>/bundles/<node>")
+	return cmd
+}
+
+func doCertificatesBundle(out io.Writer, host string, opts *certificatesBundleOpts) error {
+	planner := &install.FilePlanner{File: opts.planFilename}
+	if !planner.PlanExists() {
+		return planFileNotFoundErr{filename: opts.planFilename}
+	}
+	plan, err := planner.Read()
+	if err != nil {
+		return fmt.Errorf("error reading plan file: %v", err)
+	}
+	var node *install.Node
+	nodes := plan.GetUniqueNodes()
+	for i := range nodes {
+		if nodes[i].Host == host {
+			node = &nodes[i]
+			break
+		}
+	}
+	if node == nil {
+		return fmt.Errorf("node %q is not defined in the plan file", host)
+	}
+	outputDir := opts.outputDir
+	if outputDir == "" {
+		outputDir = filepath.Join(opts.generatedAssetsDir, "bundles", host)
+	}
+	pki := &install.LocalPKI{
+		GeneratedCertsDirectory: filepath.Join(opts.generatedAssetsDir, "keys"),
+		Log:                     out,
+	}
+	_, err = pki.WriteNodeBundle(plan, *node, outputDir)
+	return err
+}
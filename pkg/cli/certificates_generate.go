@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
+	"time"
 
 	"github.com/apprenda/kismatic/pkg/install"
 	"github.com/apprenda/kismatic/pkg/util"
@@ -17,6 +18,7 @@ type certificatesGenerateOpts struct {
 	organizations      []string
 	overwrite          bool
 	generatedAssetsDir string
+	clockSkew          time.Duration
 }
 
 // NewCmdGenerate creates a new certificates generate command
@@ -52,6 +54,7 @@ func NewCmdGenerate(out io.Writer) *cobra.Command {
 	cmd.Flags().StringSliceVar(&opts.organizations, "organizations", []string{}, "comma-separated list of names that should be included in the certificate's organization field.")
 	cmd.Flags().BoolVar(&opts.overwrite, "overwrite", false, "overwrite existing certificate if it already exists in the target directory.")
 	cmd.Flags().StringVar(&opts.generatedAssetsDir, "generated-assets-dir", "generated", "path to the directory where assets generated during the installation process will be stored")
+	cmd.Flags().DurationVar(&opts.clockSkew, "clock-skew", 0, "backdate the start of the certificate's validity period by this duration, to tolerate nodes whose clock is behind. E.g. 5m")
 
 	return cmd
 }
@@ -64,6 +67,7 @@ func doCertificatesGenerate(name string, opts *certificatesGenerateOpts, out io.
 		GeneratedCertsDirectory: certsDir,
 		Log: out,
 	}
+	pki.ClockSkew = opts.clockSkew
 	ca, err := pki.GetClusterCA()
 	if err != nil {
 		return err
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/apprenda/kismatic/pkg/install"
+	"github.com/spf13/cobra"
+)
+
+type certificatesInspectOpts struct {
+	planFilename string
+	outputFormat string
+}
+
+// NewCmdInspect creates a new certificates inspect command
+func NewCmdInspect(out io.Writer) *cobra.Command {
+	opts := &certificatesInspectOpts{}
+	cmd := &cobra.Command{
+		Use:   "inspect",
+		Short: "Display the certificates that will be generated for the cluster, along with their subject alternative names",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return fmt.Errorf("Unexpected args: %v", args)
+			}
+			return doCertificatesInspect(out, opts)
+		},
+	}
+	addPlanFileFlag(cmd.Flags(), &opts.planFilename)
+	cmd.Flags().StringVarP(&opts.outputFormat, "output", "o", "simple", `output format (options "simple"|"json")`)
+	return cmd
+}
+
+func doCertificatesInspect(out io.Writer, opts *certificatesInspectOpts) error {
+	planner := &install.FilePlanner{File: opts.planFilename}
+	if !planner.PlanExists() {
+		return planFileNotFoundErr{filename: opts.planFilename}
+	}
+	plan, err := planner.Read()
+	if err != nil {
+		return fmt.Errorf("error reading plan file: %v", err)
+	}
+	certs, err := install.PreviewClusterCertificates(plan)
+	if err != nil {
+		return err
+	}
+
+	if opts.outputFormat == "json" {
+		b, err := json.MarshalIndent(certs, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error marshalling certificates: %v", err)
+		}
+		fmt.Fprintln(out, string(b))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprint(w, "Name\tCommon Name\tSubject Alternative Names\n")
+	for _, c := range certs {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", c.Name, c.CommonName, strings.Join(c.SubjectAlternateNames, ","))
+	}
+	return w.Flush()
+}
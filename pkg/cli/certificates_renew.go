@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/apprenda/kismatic/pkg/install"
+	"github.com/apprenda/kismatic/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+type certificatesRenewOpts struct {
+	planFile           string
+	generatedAssetsDir string
+	expiryThreshold    time.Duration
+	verbose            bool
+	outputFormat       string
+}
+
+// NewCmdRenew creates a new certificates renew command
+func NewCmdRenew(out io.Writer) *cobra.Command {
+	opts := &certificatesRenewOpts{}
+
+	cmd := &cobra.Command{
+		Use:   "renew [options]",
+		Short: "Renew the cluster certificates that are about to expire, and distribute them to the nodes",
+		Long: `Renew the cluster certificates that are about to expire, and distribute them to the nodes.
+
+The certificates that expire within the expiry threshold, or that do not exist, are re-issued by the
+existing cluster CA, expected to be in the --generated-assets-dir. The renewed certificates are copied
+to the nodes they are issued to, and the components that use them are restarted.
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return fmt.Errorf("unexpected args: %v", args)
+			}
+			if opts.expiryThreshold < 0 {
+				cmd.Help()
+				return fmt.Errorf("--expiry-threshold cannot be negative")
+			}
+			planner := &install.FilePlanner{File: opts.planFile}
+			executorOpts := install.ExecutorOptions{
+				GeneratedAssetsDirectory: opts.generatedAssetsDir,
+				OutputFormat:             opts.outputFormat,
+				Verbose:                  opts.verbose,
+			}
+			executor, err := install.NewExecutor(out, os.Stderr, executorOpts)
+			if err != nil {
+				return err
+			}
+			return doCertificatesRenew(out, planner, executor, opts)
+		},
+	}
+
+	addPlanFileFlag(cmd.Flags(), &opts.planFile)
+	cmd.Flags().StringVar(&opts.generatedAssetsDir, "generated-assets-dir", "generated", "path to the directory where assets generated during the installation process will be stored")
+	cmd.Flags().DurationVar(&opts.expiryThreshold, "expiry-threshold", 30*24*time.Hour, "renew the certificates that expire within this duration. E.g. 720h")
+	cmd.Flags().BoolVar(&opts.verbose, "verbose", false, "enable verbose logging from the installation")
+	cmd.Flags().StringVarP(&opts.outputFormat, "output", "o", "simple", "installation output format (options \"simple\"|\"raw\")")
+
+	return cmd
+}
+
+func doCertificatesRenew(out io.Writer, planner install.Planner, executor install.Executor, opts *certificatesRenewOpts) error {
+	if !planner.PlanExists() {
+		util.PrettyPrintErr(out, "Reading plan file")
+		return fmt.Errorf("plan file %q does not exist", opts.planFile)
+	}
+	util.PrettyPrintOk(out, "Reading plan file")
+	plan, err := planner.Read()
+	if err != nil {
+		util.PrettyPrintErr(out, "Reading plan file")
+		return fmt.Errorf("error reading plan file %q: %v", opts.planFile, err)
+	}
+	if err = validatePlan(out, plan); err != nil {
+		return err
+	}
+	if err = validateSSHConnectivity(out, plan); err != nil {
+		return err
+	}
+
+	renewed, err := executor.RenewCertificates(plan, opts.expiryThreshold)
+	if err != nil {
+		return err
+	}
+	if len(renewed) == 0 {
+		return nil
+	}
+
+	// The admin certificate is embedded in the kubeconfig
+	if util.Contains("admin", renewed) {
+		util.PrintHeader(out, "Generating Kubeconfig File", '=')
+		isDiff, err := install.RegenerateKubeconfig(plan, opts.generatedAssetsDir)
+		if err != nil {
+			return fmt.Errorf("error generating kubeconfig file: %v", err)
+		}
+		if isDiff {
+			util.PrettyPrintWarn(out, "An updated kubeconfig file has been generated in %q", opts.generatedAssetsDir)
+		}
+	}
+	util.PrintColor(out, util.Green, "\nRenewed %d certificates\n\n", len(renewed))
+	return nil
+}
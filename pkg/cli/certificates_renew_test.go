@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/apprenda/kismatic/pkg/install"
+)
+
+func TestCertificatesRenewCmdPlanNotFound(t *testing.T) {
+	out := &bytes.Buffer{}
+	fp := &fakePlanner{
+		exists: false,
+	}
+	fe := &fakeExecutor{}
+	opts := &certificatesRenewOpts{
+		planFile:        "planFile",
+		expiryThreshold: time.Hour,
+	}
+	err := doCertificatesRenew(out, fp, fe, opts)
+	if err == nil {
+		t.Errorf("renew did not return an error when the plan does not exist")
+	}
+
+	if fp.readCalled {
+		t.Errorf("attempted to read a non-existent plan file")
+	}
+	if fe.renewCalled {
+		t.Errorf("renewed the certificates without a plan file")
+	}
+}
+
+func TestCertificatesRenewCmdPlanInvalid(t *testing.T) {
+	out := &bytes.Buffer{}
+	fp := &fakePlanner{
+		exists: true,
+		plan:   &install.Plan{},
+	}
+	fe := &fakeExecutor{}
+	opts := &certificatesRenewOpts{
+		planFile:        "planFile",
+		expiryThreshold: time.Hour,
+	}
+	err := doCertificatesRenew(out, fp, fe, opts)
+	if err == nil {
+		t.Errorf("did not return an error with an invalid plan")
+	}
+
+	if !fp.readCalled {
+		t.Errorf("did not read the plan file")
+	}
+	if fe.renewCalled {
+		t.Errorf("renewed the certificates with an invalid plan")
+	}
+}
+
+func TestCertificatesRenewCmdNegativeThreshold(t *testing.T) {
+	cmd := NewCmdRenew(&bytes.Buffer{})
+	cmd.SetOutput(&bytes.Buffer{})
+	if err := cmd.Flags().Set("expiry-threshold", "-1h"); err != nil {
+		t.Fatalf("error setting the expiry threshold: %v", err)
+	}
+	if err := cmd.RunE(cmd, []string{}); err == nil {
+		t.Errorf("expected an error with a negative expiry threshold")
+	}
+}
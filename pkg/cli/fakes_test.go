@@ -1,6 +1,8 @@
 package cli
 
 import (
+	"time"
+
 	"github.com/apprenda/kismatic/pkg/install"
 	"github.com/apprenda/kismatic/pkg/tls"
 )
@@ -28,6 +30,7 @@ func (fp *fakePlanner) Write(p *install.Plan) error {
 
 type fakeExecutor struct {
 	installCalled bool
+	renewCalled   bool
 	err           error
 }
 
@@ -80,6 +83,11 @@ func (fe *fakeExecutor) MigrateEtcdCluster(install.Plan) error {
 	return nil
 }
 
+func (fe *fakeExecutor) RenewCertificates(p *install.Plan, expiryThreshold time.Duration) ([]string, error) {
+	fe.renewCalled = true
+	return nil, fe.err
+}
+
 func (fe *fakeExecutor) RunSmokeTest(p *install.Plan) error {
 	return nil
 }
@@ -18,6 +18,7 @@ type validateOpts struct {
 	verbose            bool
 	outputFormat       string
 	skipPreFlight      bool
+	strict             bool
 }
 
 // NewCmdValidate creates a new install validate command
@@ -39,6 +40,7 @@ func NewCmdValidate(out io.Writer, installOpts *installOpts) *cobra.Command {
 	cmd.Flags().BoolVar(&opts.verbose, "verbose", false, "enable verbose logging from the installation")
 	cmd.Flags().StringVarP(&opts.outputFormat, "output", "o", "simple", "installation output format (options simple|raw)")
 	cmd.Flags().BoolVar(&opts.skipPreFlight, "skip-preflight", false, "skip pre-flight checks")
+	cmd.Flags().BoolVar(&opts.strict, "strict", false, "treat plan file validation warnings as errors")
 	return cmd
 }
 
@@ -58,7 +60,7 @@ func doValidate(out io.Writer, planner install.Planner, opts *validateOpts) erro
 	util.PrettyPrintOk(out, "Reading installation plan file %q", opts.planFile)
 
 	// Validate plan file
-	if err := validatePlan(out, plan); err != nil {
+	if err := validatePlanWithOptions(out, plan, install.ValidationOptions{Strict: opts.strict}); err != nil {
 		return err
 	}
 
@@ -114,12 +116,21 @@ func newPKI(stdout io.Writer, options *validateOpts) (*install.LocalPKI, error)
 }
 
 func validatePlan(out io.Writer, plan *install.Plan) error {
-	ok, errs := install.ValidatePlan(plan)
-	if !ok {
+	return validatePlanWithOptions(out, plan, install.ValidationOptions{})
+}
+
+func validatePlanWithOptions(out io.Writer, plan *install.Plan, opts install.ValidationOptions) error {
+	res := install.ValidatePlanWithOptions(plan, opts)
+	if !res.Valid() {
 		util.PrettyPrintErr(out, "Validating installation plan file")
-		util.PrintValidationErrors(out, errs)
+		util.PrintValidationErrors(out, res.Errors)
 		return fmt.Errorf("Plan file validation error prevents installation from proceeding")
 	}
+	if len(res.Warnings) > 0 {
+		util.PrettyPrintWarn(out, "Validating installation plan file")
+		util.PrintValidationErrors(out, res.Warnings)
+		return nil
+	}
 	util.PrettyPrintOk(out, "Validating installation plan file")
 	return nil
 }
@@ -5,6 +5,7 @@ import (
 	"io"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/apprenda/kismatic/pkg/ansible"
 	"github.com/apprenda/kismatic/pkg/install/explain"
@@ -302,6 +303,9 @@ func (f *fakePKI) GenerateClusterCA(p *Plan) (*tls.CA, error) {
 	return nil, f.err
 }
 func (f *fakePKI) GenerateClusterCertificates(p *Plan, ca *tls.CA) error { return f.err }
+func (f *fakePKI) RenewClusterCerts(p *Plan, expiryThreshold time.Duration) ([]string, error) {
+	return nil, f.err
+}
 func (f *fakePKI) GenerateCertificate(name string, validityPeriod string, commonName string, subjectAlternateNames []string, organizations []string, ca *tls.CA, overwrite bool) (bool, error) {
 	return false, f.err
 }
@@ -0,0 +1,130 @@
+package install
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/apprenda/kismatic/pkg/util"
+)
+
+const (
+	adminKubeconfigFilename             = "admin.kubeconfig"
+	schedulerKubeconfigFilename         = "kube-scheduler.kubeconfig"
+	controllerManagerKubeconfigFilename = "kube-controller-manager.kubeconfig"
+)
+
+// WriteAllInOneBundle writes the consolidated output of a single node cluster
+// to the destination directory: the CA certificate, the certificates and keys
+// issued to the node, and the admin, scheduler and controller manager
+// kubeconfigs. The client certificates of the kubeconfigs are embedded in
+// them, and are not written to the directory as separate files. The private
+// keys of certificate authorities are never included.
+// Returns an error if the plan has more than one node, or if the node does
+// not have the etcd, master and worker roles.
+// Returns the names of the files in the bundle.
+func (lp *LocalPKI) WriteAllInOneBundle(p *Plan, dir string) ([]string, error) {
+	node, err := singleNode(p)
+	if err != nil {
+		return nil, err
+	}
+	if err = lp.checkBundleDirectory(dir); err != nil {
+		return nil, err
+	}
+
+	specs, err := certManifestForNode(*p, node)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]os.FileMode{lp.FileNames.CertFile("ca"): 0644}
+	for _, s := range specs {
+		// embedded in the kubeconfigs
+		if s.filename == schedulerCertFilenamePrefix || s.filename == controllerManagerCertFilenamePrefix {
+			continue
+		}
+		isCA, err := lp.isCACert(s.filename)
+		if err != nil {
+			return nil, err
+		}
+		if isCA {
+			return nil, fmt.Errorf("refusing to add the private key of certificate authority %q to the bundle of node %q", s.filename, node.Host)
+		}
+		files[lp.FileNames.CertFile(s.filename)] = 0644
+		files[lp.FileNames.KeyFile(s.filename)] = 0600
+	}
+	if _, ok := files[lp.FileNames.KeyFile("ca")]; ok {
+		return nil, fmt.Errorf("refusing to add the private key of the cluster CA to the bundle of node %q", node.Host)
+	}
+	names, err := lp.copyBundleFiles(files, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// The scheduler and controller manager run on the node, and reach the
+	// API server on the loopback interface
+	kubeconfigs := []struct {
+		filename string
+		cert     string
+		user     string
+		server   string
+	}{
+		{adminKubeconfigFilename, adminCertFilename, adminUser, "https://" + p.Master.LoadBalancedFQDN + ":6443"},
+		{schedulerKubeconfigFilename, schedulerCertFilenamePrefix, schedulerUser, "https://127.0.0.1:6443"},
+		{controllerManagerKubeconfigFilename, controllerManagerCertFilenamePrefix, controllerManagerUser, "https://127.0.0.1:6443"},
+	}
+	for _, k := range kubeconfigs {
+		if err := lp.writeBundleKubeconfig(p, k.cert, k.user, k.server, filepath.Join(dir, k.filename)); err != nil {
+			return nil, fmt.Errorf("error writing kubeconfig %q: %v", k.filename, err)
+		}
+		names = append(names, k.filename)
+	}
+	trustBundle, err := lp.writeBundleTrustAnchors(p, dir)
+	if err != nil {
+		return nil, err
+	}
+	names = append(names, trustBundle...)
+	sort.Strings(names)
+	util.PrettyPrintOk(lp.Log, "Wrote all-in-one bundle of node %q to %q", node.Host, dir)
+	return names, nil
+}
+
+// returns the node of a single node cluster, or an error if the plan has
+// more than one node or the node does not have all the required roles
+func singleNode(p *Plan) (Node, error) {
+	nodes := p.AllNodes()
+	if len(nodes) != 1 {
+		return Node{}, fmt.Errorf("the all-in-one bundle can only be written for a cluster with a single node, but the plan has %d nodes", len(nodes))
+	}
+	n := nodes[0]
+	for _, role := range []string{"etcd", "master", "worker"} {
+		if !n.HasRoles(role) {
+			return Node{}, fmt.Errorf("the all-in-one bundle requires node %q to have the etcd, master and worker roles, but it does not have the %s role", n.Node.Host, role)
+		}
+	}
+	return n.Node, nil
+}
+
+// writes a kubeconfig with the given certificate of the certificates
+// directory embedded in it
+func (lp *LocalPKI) writeBundleKubeconfig(p *Plan, certName, user, server, path string) error {
+	caEncoded, err := util.Base64String(filepath.Join(lp.GeneratedCertsDirectory, lp.FileNames.CertFile("ca")))
+	if err != nil {
+		return fmt.Errorf("error reading ca file for kubeconfig: %v", err)
+	}
+	certEncoded, err := util.Base64String(filepath.Join(lp.GeneratedCertsDirectory, lp.FileNames.CertFile(certName)))
+	if err != nil {
+		return fmt.Errorf("error reading certificate file for kubeconfig: %v", err)
+	}
+	keyEncoded, err := util.Base64String(filepath.Join(lp.GeneratedCertsDirectory, lp.FileNames.KeyFile(certName)))
+	if err != nil {
+		return fmt.Errorf("error reading certificate key file for kubeconfig: %v", err)
+	}
+	context := p.Cluster.Name + "-" + certName
+	kubeconfig, err := renderKubeconfig(ConfigOptions{caEncoded, server, p.Cluster.Name, user, context, certEncoded, keyEncoded})
+	if err != nil {
+		return err
+	}
+	// kubeconfigs contain private keys
+	return util.WriteFile(path, kubeconfig, 0600)
+}
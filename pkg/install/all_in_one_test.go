@@ -0,0 +1,125 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteAllInOneBundle(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	node := Node{Host: "dev01", IP: "10.1.0.1"}
+	p := &Plan{
+		Cluster: Cluster{
+			Name:         "someName",
+			Certificates: CertsConfig{Expiry: "1h"},
+			Networking:   NetworkConfig{ServiceCIDRBlock: "10.0.0.0/24"},
+		},
+		AddOns: AddOns{CNI: &CNI{}},
+		Etcd:   NodeGroup{Nodes: []Node{node}},
+		Master: MasterNodeGroup{
+			Nodes:            []Node{node},
+			LoadBalancedFQDN: "someFQDN",
+		},
+		Worker: NodeGroup{Nodes: []Node{node}},
+	}
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA: %v", err)
+	}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+
+	bundleDir, err := ioutil.TempDir("", "all-in-one-tests")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer cleanup(bundleDir, t)
+	files, err := pki.WriteAllInOneBundle(p, bundleDir)
+	if err != nil {
+		t.Fatalf("error writing all-in-one bundle: %v", err)
+	}
+	expected := []string{
+		"admin.kubeconfig",
+		"ca.pem",
+		"dev01-apiserver.pem",
+		"dev01-kubelet.pem",
+		"kube-controller-manager.kubeconfig",
+		"kube-scheduler.kubeconfig",
+	}
+	for _, f := range expected {
+		if !contains(f, files) {
+			t.Errorf("expected %q to be in the bundle, but got %v", f, files)
+		}
+	}
+	for _, f := range []string{"ca-key.pem", "kube-scheduler.pem", "kube-scheduler-key.pem", "kube-controller-manager.pem", "admin.pem"} {
+		if contains(f, files) {
+			t.Errorf("expected %q not to be in the bundle", f)
+		}
+		if _, err := os.Stat(filepath.Join(bundleDir, f)); !os.IsNotExist(err) {
+			t.Errorf("expected %q not to be written to the bundle directory", f)
+		}
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(bundleDir, "kube-scheduler.kubeconfig"))
+	if err != nil {
+		t.Fatalf("error reading scheduler kubeconfig: %v", err)
+	}
+	if !strings.Contains(string(b), "server: https://127.0.0.1:6443") || !strings.Contains(string(b), "name: system:kube-scheduler") {
+		t.Errorf("expected the scheduler kubeconfig to use the scheduler user and the local API server, but got:\n%s", b)
+	}
+	b, err = ioutil.ReadFile(filepath.Join(bundleDir, "admin.kubeconfig"))
+	if err != nil {
+		t.Fatalf("error reading admin kubeconfig: %v", err)
+	}
+	if !strings.Contains(string(b), "server: https://someFQDN:6443") {
+		t.Errorf("expected the admin kubeconfig to use the load balanced FQDN, but got:\n%s", b)
+	}
+	info, err := os.Stat(filepath.Join(bundleDir, "admin.kubeconfig"))
+	if err != nil {
+		t.Fatalf("error reading kubeconfig in bundle: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected kubeconfig to have mode 0600, but got %v", info.Mode().Perm())
+	}
+
+	if _, err := pki.WriteAllInOneBundle(p, pki.GeneratedCertsDirectory); err == nil {
+		t.Errorf("expected an error when writing the bundle to the certificates directory")
+	}
+}
+
+func TestWriteAllInOneBundleRequiresSingleNode(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	node := Node{Host: "dev01", IP: "10.1.0.1"}
+	tests := []struct {
+		etcd   []Node
+		master []Node
+		worker []Node
+	}{
+		{
+			etcd:   []Node{node},
+			master: []Node{node},
+			worker: []Node{node, {Host: "worker01", IP: "10.1.0.2"}},
+		},
+		{
+			etcd:   []Node{node},
+			master: []Node{node},
+		},
+		{},
+	}
+	for i, test := range tests {
+		p := &Plan{
+			Etcd:   NodeGroup{Nodes: test.etcd},
+			Master: MasterNodeGroup{Nodes: test.master},
+			Worker: NodeGroup{Nodes: test.worker},
+		}
+		if _, err := pki.WriteAllInOneBundle(p, "bundle"); err == nil {
+			t.Errorf("test %d: expected an error, but did not get one", i)
+		}
+	}
+}
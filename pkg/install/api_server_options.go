@@ -18,6 +18,8 @@ var protectedOptions = []string{
 	"etcd-keyfile",
 	"etcd-servers",
 	"insecure-port",
+	"kubelet-client-certificate",
+	"kubelet-client-key",
 	"secure-port",
 	"service-account-key-file",
 	"service-cluster-ip-range",
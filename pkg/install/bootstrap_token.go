@@ -0,0 +1,164 @@
+package install
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/apprenda/kismatic/pkg/util"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	bootstrapTokenFilename        = "bootstrap-token"
+	bootstrapTokenSecretFilename  = "bootstrap-token-secret.yaml"
+	bootstrapTokenSecretType      = "bootstrap.kubernetes.io/token"
+	bootstrapTokenSecretNamespace = "kube-system"
+	defaultBootstrapTokenTTL      = "24h"
+	bootstrapTokenAlphabet        = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+var bootstrapTokenUsages = []string{"authentication", "signing"}
+
+// returns the TTL of the bootstrap token, using the default if not set
+func (bt BootstrapToken) ttl() (time.Duration, error) {
+	ttl := bt.TTL
+	if ttl == "" {
+		ttl = defaultBootstrapTokenTTL
+	}
+	d, err := time.ParseDuration(ttl)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a valid duration for the bootstrap token TTL", ttl)
+	}
+	return d, nil
+}
+
+// returns the usages of the bootstrap token, using the defaults if not set
+func (bt BootstrapToken) usages() []string {
+	if len(bt.Usages) == 0 {
+		return bootstrapTokenUsages
+	}
+	return bt.Usages
+}
+
+type secretManifest struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   manifestMetadata  `yaml:"metadata"`
+	Type       string            `yaml:"type"`
+	StringData map[string]string `yaml:"stringData"`
+}
+
+type manifestMetadata struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// returns the metadata of a manifest generated for the cluster
+func (p Plan) manifestMetadata(name, namespace string) manifestMetadata {
+	meta := p.objectMeta(name, namespace)
+	return manifestMetadata{
+		Name:        meta.Name,
+		Namespace:   meta.Namespace,
+		Labels:      meta.Labels,
+		Annotations: meta.Annotations,
+	}
+}
+
+// generateBootstrapToken writes a new bootstrap token and the manifest of its
+// secret to the certificates directory, if the plan requires a bootstrap token
+// and one has not been generated yet.
+func (lp *LocalPKI) generateBootstrapToken(p *Plan) error {
+	bt := p.Cluster.Certificates.BootstrapToken
+	if bt == nil {
+		return nil
+	}
+	tokenFile := filepath.Join(lp.GeneratedCertsDirectory, bootstrapTokenFilename)
+	if _, err := os.Stat(tokenFile); err == nil {
+		util.PrettyPrintOk(lp.Log, "Found existing bootstrap token")
+		return nil
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error reading bootstrap token: %v", err)
+	}
+	ttl, err := bt.ttl()
+	if err != nil {
+		return err
+	}
+	r := lp.Rand
+	if r == nil {
+		r = rand.Reader
+	}
+	id, err := randomBootstrapTokenString(r, 6)
+	if err != nil {
+		return err
+	}
+	secret, err := randomBootstrapTokenString(r, 16)
+	if err != nil {
+		return err
+	}
+
+	s := secretManifest{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   p.manifestMetadata("bootstrap-token-"+id, bootstrapTokenSecretNamespace),
+		Type:       bootstrapTokenSecretType,
+		StringData: map[string]string{
+			"description":  "Bootstrap token generated by kismatic",
+			"token-id":     id,
+			"token-secret": secret,
+		},
+	}
+	if ttl > 0 {
+		now := time.Now
+		if lp.Now != nil {
+			now = lp.Now
+		}
+		s.StringData["expiration"] = now().Add(ttl).UTC().Format(time.RFC3339)
+	}
+	for _, u := range bt.usages() {
+		s.StringData["usage-bootstrap-"+u] = "true"
+	}
+	b, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("error encoding bootstrap token secret: %v", err)
+	}
+
+	if err := util.CreateDir(lp.GeneratedCertsDirectory, 0744); err != nil {
+		return err
+	}
+	secretFile := filepath.Join(lp.GeneratedCertsDirectory, bootstrapTokenSecretFilename)
+	if err := util.WriteFile(secretFile, b, 0600); err != nil {
+		return fmt.Errorf("error writing bootstrap token secret: %v", err)
+	}
+	// The token file is written last, as its existence signals that the token was generated
+	if err := util.WriteFile(tokenFile, []byte(id+"."+secret+"\n"), 0600); err != nil {
+		return fmt.Errorf("error writing bootstrap token: %v", err)
+	}
+	util.PrettyPrintOk(lp.Log, "Generated bootstrap token")
+	return nil
+}
+
+// returns a random string of the given length made up of characters that
+// are valid in a bootstrap token
+func randomBootstrapTokenString(r io.Reader, length int) (string, error) {
+	b := make([]byte, length)
+	max := byte(256 - (256 % len(bootstrapTokenAlphabet)))
+	buf := make([]byte, 1)
+	for i := 0; i < length; {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", fmt.Errorf("error generating bootstrap token: %v", err)
+		}
+		// discard values that would bias the distribution
+		if buf[0] >= max {
+			continue
+		}
+		b[i] = bootstrapTokenAlphabet[int(buf[0])%len(bootstrapTokenAlphabet)]
+		i++
+	}
+	return string(b), nil
+}
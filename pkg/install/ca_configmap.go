@@ -0,0 +1,70 @@
+package install
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+
+	"github.com/apprenda/kismatic/pkg/util"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	caConfigMapFilename         = "ca-configmap.yaml"
+	defaultCAConfigMapName      = "kube-root-ca.crt"
+	defaultCAConfigMapNamespace = "kube-system"
+	caConfigMapKey              = "ca.crt"
+)
+
+type configMapManifest struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   manifestMetadata  `yaml:"metadata"`
+	Data       map[string]string `yaml:"data"`
+}
+
+// returns the name of the ConfigMap, using the default if not set
+func (cm CAConfigMap) name() string {
+	if cm.Name == "" {
+		return defaultCAConfigMapName
+	}
+	return cm.Name
+}
+
+// returns the namespace of the ConfigMap, using the default if not set
+func (cm CAConfigMap) namespace() string {
+	if cm.Namespace == "" {
+		return defaultCAConfigMapNamespace
+	}
+	return cm.Namespace
+}
+
+// writeCAConfigMap writes the manifest of the ConfigMap that contains the
+// trust bundle of the cluster, given the certificates of the cluster CA, to
+// the certificates directory, if the plan requires one. The manifest only
+// ever contains certificates, never the private key of the CA.
+func (lp *LocalPKI) writeCAConfigMap(p *Plan, caCert []byte) error {
+	cm := p.Cluster.Certificates.CAConfigMap
+	if cm == nil {
+		return nil
+	}
+	var bundle bytes.Buffer
+	if err := lp.writeTrustBundle(&bundle, caCert); err != nil {
+		return err
+	}
+	m := configMapManifest{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata:   p.manifestMetadata(cm.name(), cm.namespace()),
+		Data:       map[string]string{caConfigMapKey: bundle.String()},
+	}
+	b, err := yaml.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("error encoding CA ConfigMap: %v", err)
+	}
+	if err := util.WriteFile(filepath.Join(lp.GeneratedCertsDirectory, caConfigMapFilename), b, 0644); err != nil {
+		return fmt.Errorf("error writing CA ConfigMap: %v", err)
+	}
+	util.PrettyPrintOk(lp.Log, "Wrote CA ConfigMap %s/%s", cm.namespace(), cm.name())
+	return nil
+}
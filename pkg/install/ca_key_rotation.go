@@ -0,0 +1,200 @@
+package install
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/apprenda/kismatic/pkg/tls"
+	"github.com/apprenda/kismatic/pkg/util"
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+// caTransitionBundleFilename is the file that holds the certificates of the
+// previous and the new cluster CA, while the key of the CA is rotated
+const caTransitionBundleFilename = "ca-transition-bundle.pem"
+
+// RotateCAKey replaces the private key of the cluster CA with a new key. The
+// new CA certificate has the same subject as the existing CA certificate, and
+// every certificate of the cluster is re-issued by the new CA. The
+// certificates of both CAs are written to ca-transition-bundle.pem, so that
+// clients can trust certificates issued by either CA until the re-issued
+// certificates are distributed to every node.
+// The private keys of the leaf certificates are reused, unless RotateKeys is set.
+func (lp *LocalPKI) RotateCAKey(p *Plan) error {
+	if lp.Log == nil {
+		lp.Log = ioutil.Discard
+	}
+	if lp.CASigner != nil {
+		return errors.New("the key of the CA is held by the CA signer, and cannot be rotated")
+	}
+	if lp.DisableCAKeyPersistence {
+		return errors.New("the key of the CA is not persisted to disk, and cannot be rotated")
+	}
+	if p.Cluster.Certificates.providedCA() {
+		return errors.New("the key of a CA that is provided in the plan cannot be rotated, as its certificate is issued outside of the cluster")
+	}
+	if lp.RootCAFile != "" {
+		return errors.New("the key of an intermediate CA cannot be rotated, as its certificate is issued by the root CA")
+	}
+	if len(lp.Roles) > 0 {
+		return errors.New("the key of the CA cannot be rotated when restricted to roles, as every certificate of the cluster must be re-issued")
+	}
+	if err := lp.validateCertsDirectory(); err != nil {
+		return err
+	}
+	if err := lp.validateSigningProfile(p); err != nil {
+		return err
+	}
+	oldCA, err := lp.GetClusterCA()
+	if err != nil {
+		return err
+	}
+	oldCert, err := helpers.ParseCertificatePEM(oldCA.Cert)
+	if err != nil {
+		return fmt.Errorf("error parsing CA certificate: %v", err)
+	}
+	manifest, err := certManifestForCluster(*p)
+	if err != nil {
+		return err
+	}
+
+	util.PrettyPrintWarn(lp.Log, "Rotating the key of the cluster CA. All %d certificates of the cluster will be re-issued, and must be distributed to the nodes", len(manifest))
+	key, cert, err := lp.newCAWithSubject(p, oldCert)
+	if err != nil {
+		return err
+	}
+	ca := &tls.CA{Cert: cert, Key: key}
+	if err := ca.VerifyKeyPair(); err != nil {
+		return fmt.Errorf("generated CA is invalid: %v", err)
+	}
+
+	if err := lp.tagClusterUID(p, manifest); err != nil {
+		return err
+	}
+	lp.tagIssuanceMetadata(p, manifest)
+	if err := lp.runHook("pre-generation", lp.PreHook); err != nil {
+		return err
+	}
+	// The transition bundle is written before the CA is replaced, so that
+	// the previous CA certificate is not lost if rotating fails
+	var bundle bytes.Buffer
+	bundle.Write(oldCA.Cert)
+	bundle.Write(cert)
+	bundleFile := filepath.Join(lp.GeneratedCertsDirectory, caTransitionBundleFilename)
+	if err := util.WriteFile(bundleFile, bundle.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing CA transition bundle: %v", err)
+	}
+	if err := lp.writeCert(key, cert, "ca"); err != nil {
+		return fmt.Errorf("error writing CA files: %v", err)
+	}
+	util.PrettyPrintOk(lp.Log, "Generated a new key for the cluster CA")
+
+	for _, s := range manifest {
+		if err := lp.generateCert(ca, s, p.Cluster.Certificates.leafExpiry()); err != nil {
+			return err
+		}
+		util.PrettyPrintOk(lp.Log, "Re-issued certificate for %s", s.description)
+	}
+	if err := lp.writeCAConfigMap(p, bundle.Bytes()); err != nil {
+		return err
+	}
+	if err := lp.writeManifest(p, manifest, nil); err != nil {
+		return err
+	}
+	if err := lp.updateChecksums(); err != nil {
+		return err
+	}
+	util.PrettyPrintWarn(lp.Log, "Distribute %s to the clients of the cluster until every node uses the re-issued certificates", caTransitionBundleFilename)
+	return lp.runHook("post-generation", lp.PostHook)
+}
+
+// returns a new CA with the subject and names of the existing CA certificate.
+// The key and expiry of the plan are used, or those of the existing CA if unset.
+func (lp *LocalPKI) newCAWithSubject(p *Plan, old *x509.Certificate) (key, cert []byte, err error) {
+	certs := p.Cluster.Certificates
+	req := csr.CertificateRequest{
+		Names: csrNames(old.Subject),
+		Hosts: old.DNSNames,
+	}
+	for _, ip := range old.IPAddresses {
+		req.Hosts = append(req.Hosts, ip.String())
+	}
+	// A nil key request must not be assigned to the interface field, as it
+	// would not compare equal to nil
+	kr := certs.caKeyRequest()
+	if kr == nil {
+		if kr, err = publicKeyRequest(old); err != nil {
+			return nil, nil, err
+		}
+	}
+	req.KeyRequest = kr
+	expiry := certs.CAExpiry
+	if expiry == "" {
+		expiry = old.NotAfter.Sub(old.NotBefore).String()
+	}
+	caOpts := certs.caOptions()
+	if lp.SerialBits != 0 {
+		if caOpts.Serial, err = tls.RandomSerial(lp.Rand, lp.SerialBits); err != nil {
+			return nil, nil, fmt.Errorf("error getting serial number for the CA: %v", err)
+		}
+	}
+	key, cert, err = lp.generator().NewCA(req, old.Subject.CommonName, expiry, caOpts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create CA Cert: %v", err)
+	}
+	c, err := helpers.ParseCertificatePEM(cert)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing CA certificate: %v", err)
+	}
+	if !bytes.Equal(c.RawSubject, old.RawSubject) {
+		return nil, nil, fmt.Errorf("the subject %q of the new CA certificate does not match the subject %q of the existing CA certificate", c.Subject.CommonName, old.Subject.CommonName)
+	}
+	return key, cert, nil
+}
+
+// returns the names of the certificate request that result in the subject
+func csrNames(subject pkix.Name) []csr.Name {
+	n := 0
+	for _, values := range [][]string{subject.Country, subject.Province, subject.Locality, subject.Organization, subject.OrganizationalUnit} {
+		if len(values) > n {
+			n = len(values)
+		}
+	}
+	at := func(values []string, i int) string {
+		if i < len(values) {
+			return values[i]
+		}
+		return ""
+	}
+	names := make([]csr.Name, 0, n)
+	for i := 0; i < n; i++ {
+		names = append(names, csr.Name{
+			C:  at(subject.Country, i),
+			ST: at(subject.Province, i),
+			L:  at(subject.Locality, i),
+			O:  at(subject.Organization, i),
+			OU: at(subject.OrganizationalUnit, i),
+		})
+	}
+	return names
+}
+
+// returns the key request for a key of the same algorithm and size as the
+// key of the certificate
+func publicKeyRequest(cert *x509.Certificate) (*csr.BasicKeyRequest, error) {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return keyRequest("rsa", pub.N.BitLen()), nil
+	case *ecdsa.PublicKey:
+		return keyRequest("ecdsa", pub.Curve.Params().BitSize), nil
+	}
+	return nil, fmt.Errorf("unsupported CA public key type %T", cert.PublicKey)
+}
@@ -0,0 +1,72 @@
+package install
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+func TestRotateCAKey(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	var log bytes.Buffer
+	pki.Log = &log
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	oldCA := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "ca.pem"), t)
+
+	if err := pki.RotateCAKey(p); err != nil {
+		t.Fatalf("unexpected error rotating the CA key: %v", err)
+	}
+	newCA := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "ca.pem"), t)
+	if !bytes.Equal(newCA.RawSubject, oldCA.RawSubject) {
+		t.Errorf("expected the subject of the CA to be kept, but got %v instead of %v", newCA.Subject, oldCA.Subject)
+	}
+	if bytes.Equal(newCA.RawSubjectPublicKeyInfo, oldCA.RawSubjectPublicKeyInfo) {
+		t.Errorf("expected the CA to have a new key")
+	}
+	for _, name := range []string{"admin", "etcd01-etcd", "master01-apiserver", "worker01-kubelet"} {
+		cert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, name+".pem"), t)
+		if err := cert.CheckSignatureFrom(newCA); err != nil {
+			t.Errorf("expected %s to be re-issued by the new CA: %v", name, err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, "ca-transition-bundle.pem"))
+	if err != nil {
+		t.Fatalf("error reading transition bundle: %v", err)
+	}
+	certs, err := helpers.ParseCertificatesPEM(b)
+	if err != nil {
+		t.Fatalf("error parsing transition bundle: %v", err)
+	}
+	if len(certs) != 2 || !certs[0].Equal(oldCA) || !certs[1].Equal(newCA) {
+		t.Errorf("expected the transition bundle to contain the previous and the new CA certificates")
+	}
+	if !strings.Contains(log.String(), "will be re-issued") {
+		t.Errorf("expected a warning that the certificates are re-issued, but got %q", log.String())
+	}
+}
+
+func TestRotateCAKeyRestrictedToRoles(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	p := getPlan()
+	if _, err := pki.GenerateClusterCA(p); err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	pki.Roles = []string{"worker"}
+	if err := pki.RotateCAKey(p); err == nil {
+		t.Errorf("expected an error when rotating the CA key for some of the roles only")
+	}
+}
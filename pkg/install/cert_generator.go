@@ -0,0 +1,36 @@
+package install
+
+import (
+	"github.com/apprenda/kismatic/pkg/tls"
+	"github.com/cloudflare/cfssl/csr"
+)
+
+// A certGenerator creates the cluster CA, and signs the certificates of the
+// cluster. It is the only dependency of the LocalPKI on the signing library,
+// so that tests can assert on the requests that are built without signing
+// real certificates.
+type certGenerator interface {
+	// NewCA returns the private key and certificate of a new CA
+	NewCA(req csr.CertificateRequest, commonName string, expiry string, opts tls.CAOptions) (key, cert []byte, err error)
+	// Sign returns the private key and certificate of a new certificate signed by the CA
+	Sign(ca *tls.CA, req csr.CertificateRequest, opts tls.CertOptions) (key, cert []byte, err error)
+}
+
+// tlsCertGenerator is the certGenerator backed by the tls package
+type tlsCertGenerator struct{}
+
+func (tlsCertGenerator) NewCA(req csr.CertificateRequest, commonName string, expiry string, opts tls.CAOptions) (key, cert []byte, err error) {
+	return tls.NewCACertFromRequest(req, commonName, expiry, opts)
+}
+
+func (tlsCertGenerator) Sign(ca *tls.CA, req csr.CertificateRequest, opts tls.CertOptions) (key, cert []byte, err error) {
+	return tls.NewCertWithOptions(ca, req, opts)
+}
+
+// returns the certGenerator of the PKI, which defaults to the tls package
+func (lp *LocalPKI) generator() certGenerator {
+	if lp.certGen != nil {
+		return lp.certGen
+	}
+	return tlsCertGenerator{}
+}
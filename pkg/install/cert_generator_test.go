@@ -0,0 +1,71 @@
+package install
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/apprenda/kismatic/pkg/tls"
+	"github.com/cloudflare/cfssl/csr"
+)
+
+// fakeCertGenerator records the signing requests, and returns the same key
+// pair for the CA and all the certificates
+type fakeCertGenerator struct {
+	key, cert []byte
+
+	mu       sync.Mutex
+	requests []csr.CertificateRequest
+}
+
+func (f *fakeCertGenerator) NewCA(req csr.CertificateRequest, commonName string, expiry string, opts tls.CAOptions) (key, cert []byte, err error) {
+	return f.key, f.cert, nil
+}
+
+func (f *fakeCertGenerator) Sign(ca *tls.CA, req csr.CertificateRequest, opts tls.CertOptions) (key, cert []byte, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, req)
+	return f.key, f.cert, nil
+}
+
+func TestGenerateClusterCertificatesUsesCertGenerator(t *testing.T) {
+	key, cert, err := tls.NewCACert("test/ca-csr.json", "someCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating CA cert: %v", err)
+	}
+	gen := &fakeCertGenerator{key: key, cert: cert}
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	pki.certGen = gen
+
+	p := getPlan()
+	p.Cluster.Certificates.Expiry = "1h"
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+
+	manifest, err := certManifestForCluster(*p)
+	if err != nil {
+		t.Fatalf("error getting certificate manifest: %v", err)
+	}
+	if len(gen.requests) != len(manifest) {
+		t.Errorf("expected %d signing requests, but got %d", len(manifest), len(gen.requests))
+	}
+	var found bool
+	for _, req := range gen.requests {
+		if req.CN != "kube-apiserver-kubelet-client" {
+			continue
+		}
+		found = true
+		if len(req.Names) != 1 || req.Names[0].O != "system:masters" {
+			t.Errorf("expected the request to be for the system:masters group, but got %v", req.Names)
+		}
+	}
+	if !found {
+		t.Errorf("expected a signing request for the API server kubelet client certificate")
+	}
+}
@@ -0,0 +1,158 @@
+package install
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apprenda/kismatic/pkg/tls"
+)
+
+// The CertificateActions describe what a run would do with a certificate
+const (
+	// CertificateActionCreate means that the certificate does not exist, and would be generated
+	CertificateActionCreate = "create"
+	// CertificateActionReuse means that the existing certificate is valid, and would be left unchanged
+	CertificateActionReuse = "reuse"
+	// CertificateActionRotate means that the existing certificate is no longer
+	// valid for the plan, and must be regenerated
+	CertificateActionRotate = "rotate"
+	// CertificateActionInvalid means that the existing certificate does not
+	// match the plan, such as when its SANs changed. Generating the
+	// certificates fails until the certificate is removed.
+	CertificateActionInvalid = "invalid"
+	// CertificateActionRemove means that the certificate is no longer required by the plan
+	CertificateActionRemove = "remove"
+)
+
+// A CertificateChange is the action that generating the cluster certificates
+// would take on a certificate, given the plan and the existing certificates
+type CertificateChange struct {
+	// Name of the certificate
+	Name string `json:"name"`
+	// Description of the certificate
+	Description string `json:"description"`
+	// Action is one of the CertificateActions
+	Action string `json:"action"`
+	// Reason explains why the certificate must be rotated or removed, or why
+	// it is invalid
+	Reason string `json:"reason,omitempty"`
+}
+
+// CertificateChanges returns the action that generating the cluster
+// certificates would take on each certificate, without modifying any files.
+// Certificates that are no longer required by the plan are reported with the
+// remove action, even though their files are only removed if
+// RemoveOrphanedCerts is set.
+func (lp *LocalPKI) CertificateChanges(p *Plan) ([]CertificateChange, error) {
+	if err := lp.validateCertsDirectory(); err != nil {
+		return nil, err
+	}
+	manifest, err := certManifestForCluster(*p)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := lp.existingCACert(p)
+	if err != nil {
+		return nil, err
+	}
+	changes := []CertificateChange{}
+	for _, s := range manifest {
+		c := CertificateChange{Name: s.filename, Description: s.description}
+		exists, err := lp.FileNames.CertKeyPairExists(s.filename, lp.GeneratedCertsDirectory)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			c.Action = CertificateActionCreate
+			changes = append(changes, c)
+			continue
+		}
+		if s.filename == adminCertFilenameKETPre133 {
+			cert, err := lp.FileNames.ReadCert(s.filename, lp.GeneratedCertsDirectory)
+			if err != nil {
+				return nil, fmt.Errorf("error reading certificate for %q: %v", s.description, err)
+			}
+			if isPre133AdminCert(cert) {
+				c.Action = CertificateActionRotate
+				c.Reason = "the admin certificate was generated by a version of kismatic older than 1.3.3"
+				changes = append(changes, c)
+				continue
+			}
+		}
+		warnings, err := lp.FileNames.CertValid(s.commonName, s.subjectAlternateNames, s.organizations, s.filename, lp.GeneratedCertsDirectory)
+		if err != nil {
+			return nil, err
+		}
+		if len(warnings) > 0 {
+			reasons := make([]string, 0, len(warnings))
+			for _, w := range warnings {
+				reasons = append(reasons, w.Error())
+			}
+			c.Action = CertificateActionInvalid
+			c.Reason = "generating the certificates would fail, as the certificate does not match the plan: " + strings.Join(reasons, "; ")
+			changes = append(changes, c)
+			continue
+		}
+		// The certificates are reissued by a CA that would be generated
+		if ca == nil {
+			c.Action = CertificateActionRotate
+			c.Reason = "the cluster CA does not exist, and would be generated"
+			changes = append(changes, c)
+			continue
+		}
+		// The same decision as when generating the certificates
+		reason, err := lp.reissueReason(ca, s)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			c.Action = CertificateActionRotate
+			c.Reason = reason
+			changes = append(changes, c)
+			continue
+		}
+		c.Action = CertificateActionReuse
+		changes = append(changes, c)
+	}
+
+	previous, err := lp.readManifest()
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		current := currentManifestNames(manifest)
+		for _, e := range previous.Certificates {
+			if current[e.Name] {
+				continue
+			}
+			changes = append(changes, CertificateChange{
+				Name:        e.Name,
+				Description: e.Description,
+				Action:      CertificateActionRemove,
+				Reason:      "the certificate is no longer required by the plan",
+			})
+		}
+	}
+	return changes, nil
+}
+
+// returns the CA certificate that generating the certificates would use, or
+// nil if the CA does not exist and would be generated. The key of the CA is
+// not read, as only the certificate is required to check the signatures.
+func (lp *LocalPKI) existingCACert(p *Plan) (*tls.CA, error) {
+	file := filepath.Join(lp.GeneratedCertsDirectory, lp.FileNames.CertFile("ca"))
+	if p.Cluster.Certificates.providedCA() {
+		file = p.Cluster.Certificates.CACertFile
+	}
+	cert, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA certificate: %v", err)
+	}
+	return &tls.CA{Cert: cert}, nil
+}
@@ -0,0 +1,106 @@
+package install
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCertificateChanges(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA: %v", err)
+	}
+	changes, err := pki.CertificateChanges(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, c := range changes {
+		if c.Action != CertificateActionCreate {
+			t.Errorf("%s: expected action %q before generating the certificates, but got %q", c.Name, CertificateActionCreate, c.Action)
+		}
+	}
+
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	// Remove a certificate, change the SANs of another, and remove a node from the plan
+	if err := os.Remove(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem")); err != nil {
+		t.Fatalf("error removing certificate: %v", err)
+	}
+	p.Master.LoadBalancedFQDN = "someOtherFQDN"
+	p.Storage.Nodes = p.Storage.Nodes[:1]
+
+	changes, err = pki.CertificateChanges(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	actions := map[string]string{}
+	for _, c := range changes {
+		actions[c.Name] = c.Action
+	}
+	expected := map[string]string{
+		"admin":              CertificateActionCreate,
+		"master01-apiserver": CertificateActionInvalid,
+		"storage02-kubelet":  CertificateActionRemove,
+		"worker01-kubelet":   CertificateActionReuse,
+		"etcd01-etcd":        CertificateActionReuse,
+	}
+	for name, action := range expected {
+		if actions[name] != action {
+			t.Errorf("%s: expected action %q, but got %q", name, action, actions[name])
+		}
+	}
+	if _, err := os.Stat(filepath.Join(pki.GeneratedCertsDirectory, "storage02-kubelet.pem")); err != nil {
+		t.Errorf("expected no files to be modified, but got error: %v", err)
+	}
+	// The invalid certificate makes the run fail
+	if err := pki.GenerateClusterCertificates(p, ca); err == nil {
+		t.Errorf("expected an error generating the certificates with an invalid certificate")
+	}
+}
+
+func TestCertificateChangesReplacedCA(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA: %v", err)
+	}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	// Replace the CA
+	for _, name := range []string{"ca.pem", "ca-key.pem"} {
+		if err := os.Remove(filepath.Join(pki.GeneratedCertsDirectory, name)); err != nil {
+			t.Fatalf("error removing CA: %v", err)
+		}
+	}
+	changes, err := pki.CertificateChanges(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, c := range changes {
+		if c.Name == "worker01-kubelet" && c.Action != CertificateActionRotate {
+			t.Errorf("expected the certificate to be rotated when the CA would be generated, but got %q", c.Action)
+		}
+	}
+	if ca, err = pki.GenerateClusterCA(p); err != nil {
+		t.Fatalf("error generating CA: %v", err)
+	}
+	changes, err = pki.CertificateChanges(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, c := range changes {
+		if c.Name == "worker01-kubelet" && c.Action != CertificateActionRotate {
+			t.Errorf("expected the certificate signed by another CA to be rotated, but got %q", c.Action)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package install
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apprenda/kismatic/pkg/util"
+)
+
+const certificateManifestFilename = "manifest.json"
+
+// The CertificateManifest lists the certificates that were generated for the cluster
+type CertificateManifest struct {
+	// ClusterUID is the UID of the cluster embedded in the certificates, if any
+	ClusterUID   string                     `json:"clusterUID,omitempty"`
+	Certificates []CertificateManifestEntry `json:"certificates"`
+}
+
+// A CertificateManifestEntry describes a generated certificate and its files
+type CertificateManifestEntry struct {
+	// Name of the certificate
+	Name string `json:"name"`
+	// Description of the certificate
+	Description string `json:"description"`
+	// CertFile is the name of the certificate file, relative to the manifest
+	CertFile string `json:"cert,omitempty"`
+	// KeyFile is the name of the private key file, relative to the manifest
+	KeyFile string `json:"key,omitempty"`
+	// Files are the names of other generated files, relative to the manifest
+	Files []string `json:"files,omitempty"`
+	// SHA256 is the SHA-256 fingerprint of the certificate, in hex
+	SHA256 string `json:"sha256,omitempty"`
+	// Serial is the serial number of the certificate, in decimal
+	Serial string `json:"serial,omitempty"`
+	// NotAfter is the expiry date of the certificate, in RFC 3339 format
+	NotAfter string `json:"notAfter,omitempty"`
+	// Failed is true if the files of the certificate could not be written
+	Failed bool `json:"failed,omitempty"`
+}
+
+// returns the path to the certificate manifest of the PKI
+func (lp *LocalPKI) manifestPath() string {
+	return filepath.Join(lp.GeneratedCertsDirectory, certificateManifestFilename)
+}
+
+// writeManifest records the CA, the given certificates and the other
+// generated secrets in the manifest. Certificates in the failed set are
+// marked as failed. The file is only written when its contents change.
+func (lp *LocalPKI) writeManifest(p *Plan, specs []certificateSpec, failed map[string]bool) error {
+	ca := lp.manifestEntry("ca", "cluster certificate authority")
+	if lp.DisableCAKeyPersistence || lp.CASigner != nil {
+		// The private key of the CA is not in the certificates directory
+		ca.KeyFile = ""
+	}
+	m := CertificateManifest{Certificates: []CertificateManifestEntry{ca}}
+	for _, s := range specs {
+		e := lp.manifestEntry(s.filename, s.description)
+		e.Failed = failed[s.filename]
+		m.Certificates = append(m.Certificates, e)
+	}
+	if lp.EmbedClusterUID {
+		uid, err := lp.clusterUID(p)
+		if err != nil {
+			return err
+		}
+		m.ClusterUID = uid
+	}
+	if p.Cluster.Certificates.BootstrapToken != nil {
+		m.Certificates = append(m.Certificates, CertificateManifestEntry{
+			Name:        bootstrapTokenFilename,
+			Description: "node bootstrap token",
+			Files:       []string{bootstrapTokenFilename, bootstrapTokenSecretFilename},
+		})
+	}
+	if p.Cluster.Certificates.CAConfigMap != nil {
+		m.Certificates = append(m.Certificates, CertificateManifestEntry{
+			Name:        caConfigMapFilename,
+			Description: "cluster CA ConfigMap",
+			Files:       []string{caConfigMapFilename},
+		})
+	}
+	exists, err := lp.encryptionConfigExists()
+	if err != nil {
+		return err
+	}
+	if exists {
+		m.Certificates = append(m.Certificates, encryptionConfigManifestEntry())
+	}
+	return lp.saveManifest(m)
+}
+
+// saveManifest writes the manifest if its contents changed
+func (lp *LocalPKI) saveManifest(m CertificateManifest) error {
+	b, err := canonicalManifest(m)
+	if err != nil {
+		return err
+	}
+	existing, err := ioutil.ReadFile(lp.manifestPath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading certificate manifest: %v", err)
+	}
+	if bytes.Equal(existing, b) {
+		return nil
+	}
+	if err := util.WriteFile(lp.manifestPath(), b, 0644); err != nil {
+		return fmt.Errorf("error writing certificate manifest: %v", err)
+	}
+	return nil
+}
+
+// canonicalManifest returns the JSON encoding of the manifest, with the
+// certificates sorted by name and the keys of every object sorted, so that
+// the manifests of two runs can be compared.
+func canonicalManifest(m CertificateManifest) ([]byte, error) {
+	certs := make([]CertificateManifestEntry, len(m.Certificates))
+	copy(certs, m.Certificates)
+	for i := range certs {
+		files := make([]string, len(certs[i].Files))
+		copy(files, certs[i].Files)
+		sort.Strings(files)
+		if len(files) > 0 {
+			certs[i].Files = files
+		}
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].Name < certs[j].Name })
+	m.Certificates = certs
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding certificate manifest: %v", err)
+	}
+	// Objects decoded into maps are encoded with their keys sorted
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("error encoding certificate manifest: %v", err)
+	}
+	b, err = json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding certificate manifest: %v", err)
+	}
+	return append(b, '\n'), nil
+}
+
+// readManifest returns the certificate manifest of the PKI, or nil if it does not exist
+func (lp *LocalPKI) readManifest() (*CertificateManifest, error) {
+	b, err := ioutil.ReadFile(lp.manifestPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading certificate manifest: %v", err)
+	}
+	m := &CertificateManifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("error decoding certificate manifest: %v", err)
+	}
+	return m, nil
+}
+
+// addManifestEntry adds the entry to the manifest, which is created if it
+// does not exist. The manifest is left untouched if the entry is in it already.
+func (lp *LocalPKI) addManifestEntry(e CertificateManifestEntry) error {
+	m, err := lp.readManifest()
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		m = &CertificateManifest{}
+	}
+	for _, existing := range m.Certificates {
+		if existing.Name == e.Name {
+			return nil
+		}
+	}
+	m.Certificates = append(m.Certificates, e)
+	return lp.saveManifest(*m)
+}
+
+// updateManifestEntry replaces the entry with the same name in the manifest,
+// if the manifest exists and lists it
+func (lp *LocalPKI) updateManifestEntry(e CertificateManifestEntry) error {
+	m, err := lp.readManifest()
+	if err != nil || m == nil {
+		return err
+	}
+	for i, existing := range m.Certificates {
+		if existing.Name == e.Name {
+			m.Certificates[i] = e
+			return lp.saveManifest(*m)
+		}
+	}
+	return nil
+}
+
+// handleOrphanedCerts reports the certificates of the previous manifest that
+// are not part of the current one, such as the certificates of a node that was
+// renamed or removed from the plan. The files of orphaned certificates are
+// removed if RemoveOrphanedCerts is set. The CA, the bootstrap token and the
+// encryption config are never considered orphaned. When there is no previous
+// manifest, such as for a directory generated by an older version, the
+// certificates in the directory are considered instead.
+func (lp *LocalPKI) handleOrphanedCerts(previous *CertificateManifest, specs []certificateSpec) error {
+	if previous == nil {
+		entries, err := lp.certsInDirectory()
+		if err != nil {
+			return err
+		}
+		previous = &CertificateManifest{Certificates: entries}
+	}
+	current := currentManifestNames(specs)
+	for _, e := range previous.Certificates {
+		if current[e.Name] {
+			continue
+		}
+		if !lp.RemoveOrphanedCerts {
+			util.PrettyPrintWarn(lp.Log, "Found certificate for %s that is no longer required by the plan", e.Description)
+			continue
+		}
+		files := append([]string{e.CertFile, e.KeyFile}, e.Files...)
+		for _, f := range files {
+			if f == "" {
+				continue
+			}
+			// Only remove files that are in the certificates directory
+			rel := filepath.Clean(filepath.FromSlash(f))
+			if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return fmt.Errorf("orphaned certificate file %q is not in the certificates directory", f)
+			}
+			path := filepath.Join(lp.GeneratedCertsDirectory, rel)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("error removing orphaned certificate file %q: %v", path, err)
+			}
+		}
+		util.PrettyPrintOk(lp.Log, "Removed orphaned certificate for %s", e.Description)
+	}
+	return nil
+}
+
+// certsInDirectory returns the entries of the certificates in the
+// certificates directory that have both a certificate and a key file. The
+// serving certificates of in-cluster services are skipped, as they are not
+// part of the cluster's certificate manifest.
+func (lp *LocalPKI) certsInDirectory() ([]CertificateManifestEntry, error) {
+	dir := lp.GeneratedCertsDirectory
+	// The name of the certificate is found by matching the file name with
+	// the parts of the template around the name placeholder
+	parts := strings.SplitN(lp.FileNames.CertFile("\x00"), "\x00", 2)
+	entries := []CertificateManifestEntry{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if len(parts) != 2 || !strings.HasPrefix(rel, parts[0]) || !strings.HasSuffix(rel, parts[1]) || len(rel) <= len(parts[0])+len(parts[1]) {
+			return nil
+		}
+		name := rel[len(parts[0]) : len(rel)-len(parts[1])]
+		if strings.HasSuffix(name, "-"+servingCertSuffix) {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(dir, lp.FileNames.KeyFile(name))); err != nil {
+			return nil
+		}
+		entries = append(entries, lp.manifestEntry(name, name))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing certificates directory %q: %v", dir, err)
+	}
+	return entries, nil
+}
+
+// returns the names of the manifest entries that are required for the given
+// specs. The CA, the CA ConfigMap, the bootstrap token and the encryption config
+// are always required.
+func currentManifestNames(specs []certificateSpec) map[string]bool {
+	current := map[string]bool{"ca": true, caConfigMapFilename: true, bootstrapTokenFilename: true, encryptionConfigFilename: true}
+	for _, s := range specs {
+		current[s.filename] = true
+	}
+	return current
+}
+
+func (lp *LocalPKI) manifestEntry(name, description string) CertificateManifestEntry {
+	e := CertificateManifestEntry{
+		Name:        name,
+		Description: description,
+		CertFile:    filepath.ToSlash(lp.FileNames.CertFile(name)),
+		KeyFile:     filepath.ToSlash(lp.FileNames.KeyFile(name)),
+	}
+	// The files are sorted, as they are in the manifest that is written
+	if files := lp.derFiles(name); len(files) > 0 {
+		sort.Strings(files)
+		e.Files = files
+	}
+	// The certificate is not recorded if it could not be written
+	if cert, err := lp.FileNames.ReadCert(name, lp.GeneratedCertsDirectory); err == nil {
+		e.SHA256 = certFingerprint(cert)
+		e.Serial = cert.SerialNumber.String()
+		e.NotAfter = cert.NotAfter.UTC().Format(time.RFC3339)
+	}
+	return e
+}
@@ -0,0 +1,92 @@
+package install
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/apprenda/kismatic/pkg/tls"
+)
+
+// WriteCertificateTable writes a plain-text table of the certificates of the
+// cluster in the certificates directory to the writer, with the host each
+// certificate is issued to, and its common name, organizations, SANs and
+// expiry date. Certificates that are not specific to a node have "-" as
+// their host. The rows are sorted by host and certificate name, and the
+// values of each cell are sorted, so that the tables of two runs can be
+// compared with a diff. Certificates that have not been generated are listed
+// as missing. Private keys and other secrets are never read.
+func (lp *LocalPKI) WriteCertificateTable(p *Plan, w io.Writer) error {
+	manifest, err := certManifestForCluster(*p)
+	if err != nil {
+		return err
+	}
+	specs := append([]certificateSpec{{filename: "ca"}}, manifest...)
+	sort.Slice(specs, func(i, j int) bool {
+		if specs[i].host != specs[j].host {
+			return specs[i].host < specs[j].host
+		}
+		return specs[i].filename < specs[j].filename
+	})
+	names := make([]string, 0, len(specs))
+	for _, s := range specs {
+		names = append(names, s.filename)
+	}
+	certs, err := lp.readCerts(names)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprint(tw, "HOST\tNAME\tCOMMON NAME\tORGANIZATIONS\tSUBJECT ALTERNATIVE NAMES\tNOT AFTER\n")
+	for _, s := range specs {
+		host := s.host
+		if host == "" {
+			host = "-"
+		}
+		cert, ok := certs[s.filename]
+		if !ok {
+			fmt.Fprintf(tw, "%s\t%s\t-\t-\t-\tmissing\n", host, s.filename)
+			continue
+		}
+		sans, err := certTableSANs(cert)
+		if err != nil {
+			return fmt.Errorf("error reading SANs of certificate %q: %v", s.filename, err)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", host, s.filename, tableCell([]string{cert.Subject.CommonName}),
+			tableCell(cert.Subject.Organization), tableCell(sans), cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+// returns the DNS names, IPs and URIs of the certificate
+func certTableSANs(cert *x509.Certificate) ([]string, error) {
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	uris, err := tls.CertURIs(cert)
+	if err != nil {
+		return nil, err
+	}
+	return append(sans, uris...), nil
+}
+
+// returns the sorted values as a single cell, or "-" if there are none
+func tableCell(values []string) string {
+	vs := []string{}
+	for _, v := range values {
+		if v != "" {
+			vs = append(vs, v)
+		}
+	}
+	if len(vs) == 0 {
+		return "-"
+	}
+	sort.Strings(vs)
+	return strings.Join(vs, ",")
+}
@@ -0,0 +1,79 @@
+package install
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteCertificateTable(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA: %v", err)
+	}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+
+	var first, second bytes.Buffer
+	if err := pki.WriteCertificateTable(p, &first); err != nil {
+		t.Fatalf("unexpected error writing table: %v", err)
+	}
+	if err := pki.WriteCertificateTable(p, &second); err != nil {
+		t.Fatalf("unexpected error writing table: %v", err)
+	}
+	if first.String() != second.String() {
+		t.Errorf("expected the table to be the same across runs, but got:\n%s\nand:\n%s", first.String(), second.String())
+	}
+	if strings.Contains(first.String(), "PRIVATE KEY") {
+		t.Errorf("expected the table not to contain private keys")
+	}
+
+	lines := strings.Split(strings.TrimSpace(first.String()), "\n")
+	if !strings.HasPrefix(lines[0], "HOST") {
+		t.Errorf("expected the table to start with a header, but got %q", lines[0])
+	}
+	hosts := []string{}
+	for _, l := range lines[1:] {
+		hosts = append(hosts, strings.Fields(l)[0])
+	}
+	for i := 1; i < len(hosts); i++ {
+		if hosts[i] < hosts[i-1] {
+			t.Errorf("expected the rows to be sorted by host, but got %v", hosts)
+			break
+		}
+	}
+	var kubelet []string
+	for _, l := range lines {
+		if f := strings.Fields(l); len(f) > 1 && f[1] == "worker01-kubelet" {
+			kubelet = f
+		}
+	}
+	expected := []string{"worker01", "worker01-kubelet", "system:node:worker01", "system:nodes"}
+	if len(kubelet) < len(expected) {
+		t.Fatalf("expected a row for the kubelet certificate of worker01, but got:\n%s", first.String())
+	}
+	for i, e := range expected {
+		if kubelet[i] != e {
+			t.Errorf("expected column %d of the kubelet row to be %q, but got %q", i, e, kubelet[i])
+		}
+	}
+
+	if err := os.Remove(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem")); err != nil {
+		t.Fatalf("error removing certificate: %v", err)
+	}
+	var out bytes.Buffer
+	if err := pki.WriteCertificateTable(p, &out); err != nil {
+		t.Fatalf("unexpected error writing table: %v", err)
+	}
+	for _, l := range strings.Split(out.String(), "\n") {
+		if f := strings.Fields(l); len(f) > 1 && f[1] == "admin" && f[len(f)-1] != "missing" {
+			t.Errorf("expected the admin certificate to be missing, but got %q", l)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package install
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/apprenda/kismatic/pkg/util"
+)
+
+const checksumsFilename = "checksums.sha256"
+
+// files that hold secrets, and are never included in the checksums file
+var secretFilenames = []string{bootstrapTokenFilename, bootstrapTokenSecretFilename, encryptionConfigFilename}
+
+// verifyChecksums warns about the files of the certificates directory that
+// were modified, or removed, since the checksums file was last written.
+// Nothing is verified if the checksums file does not exist.
+func (lp *LocalPKI) verifyChecksums() ([]string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(lp.GeneratedCertsDirectory, checksumsFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading checksums file: %v", err)
+	}
+	expected, err := parseChecksums(b)
+	if err != nil {
+		return nil, err
+	}
+	current, err := lp.checksums()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(expected))
+	for name := range expected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	modified := []string{}
+	for _, name := range names {
+		sum, ok := current[name]
+		switch {
+		case !ok:
+			util.PrettyPrintWarn(lp.Log, "%s was removed outside of kismatic, it does not match %s", name, checksumsFilename)
+		case sum != expected[name]:
+			util.PrettyPrintWarn(lp.Log, "%s was modified outside of kismatic, it does not match %s", name, checksumsFilename)
+		default:
+			continue
+		}
+		modified = append(modified, name)
+	}
+	return modified, nil
+}
+
+// updateChecksums rewrites the checksums file, if checksums are enabled
+func (lp *LocalPKI) updateChecksums() error {
+	if !lp.Checksums {
+		return nil
+	}
+	return lp.writeChecksums()
+}
+
+// writeChecksums writes the SHA-256 checksum of every file of the
+// certificates directory, other than private keys and secrets, to the
+// checksums file. Files are listed in lexical order, using the format of
+// sha256sum, so that the file is stable across runs.
+func (lp *LocalPKI) writeChecksums() error {
+	sums, err := lp.checksums()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s  %s\n", sums[name], name)
+	}
+	if err := util.WriteFile(filepath.Join(lp.GeneratedCertsDirectory, checksumsFilename), buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing checksums file: %v", err)
+	}
+	return nil
+}
+
+// returns the checksums of the files of the certificates directory, keyed
+// by their slash-separated path relative to the directory
+func (lp *LocalPKI) checksums() (map[string]string, error) {
+	sums := map[string]string{}
+	dir := lp.GeneratedCertsDirectory
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == checksumsFilename || contains(rel, secretFilenames) {
+			return nil
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if containsPrivateKey(b) || isDERPrivateKey(b) {
+			return nil
+		}
+		sum := sha256.Sum256(b)
+		sums[rel] = hex.EncodeToString(sum[:])
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error computing checksums of the certificates directory: %v", err)
+	}
+	return sums, nil
+}
+
+// returns the checksums listed in the contents of a checksums file
+func parseChecksums(b []byte) (map[string]string, error) {
+	sums := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; s.Scan(); line++ {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		fields := strings.SplitN(s.Text(), "  ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid entry on line %d of %s", line, checksumsFilename)
+		}
+		sums[fields[1]] = fields[0]
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("error reading checksums file: %v", err)
+	}
+	return sums, nil
+}
+
+// returns true if the data is a DER encoded PKCS #8 private key
+func isDERPrivateKey(b []byte) bool {
+	_, err := x509.ParsePKCS8PrivateKey(b)
+	return err == nil
+}
+
+// returns true if the PEM data contains a private key
+func containsPrivateKey(b []byte) bool {
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return false
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			return true
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package install
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChecksums(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	pki.Checksums = true
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, checksumsFilename))
+	if err != nil {
+		t.Fatalf("error reading checksums file: %v", err)
+	}
+	sums, err := parseChecksums(b)
+	if err != nil {
+		t.Fatalf("error parsing checksums file: %v", err)
+	}
+	if _, ok := sums["admin.pem"]; !ok {
+		t.Errorf("expected the admin certificate to be in the checksums file")
+	}
+	for name := range sums {
+		if strings.HasSuffix(name, "-key.pem") {
+			t.Errorf("expected private keys not to be in the checksums file, but found %q", name)
+		}
+	}
+
+	modified, err := pki.verifyChecksums()
+	if err != nil {
+		t.Fatalf("error verifying checksums: %v", err)
+	}
+	if len(modified) != 0 {
+		t.Errorf("expected no modified files, but got %v", modified)
+	}
+	if err = ioutil.WriteFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"), []byte("foo"), 0644); err != nil {
+		t.Fatalf("error modifying certificate: %v", err)
+	}
+	modified, err = pki.verifyChecksums()
+	if err != nil {
+		t.Fatalf("error verifying checksums: %v", err)
+	}
+	if !reflect.DeepEqual(modified, []string{"admin.pem"}) {
+		t.Errorf("expected the admin certificate to be reported as modified, but got %v", modified)
+	}
+}
@@ -0,0 +1,91 @@
+package install
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apprenda/kismatic/pkg/util"
+)
+
+const (
+	clusterUIDFilename = "cluster-uid"
+	// clusterUIDPrefix identifies the organizational unit that holds the cluster UID
+	clusterUIDPrefix = "kismatic-cluster-uid:"
+)
+
+// clusterUID returns the UID of the cluster, which is persisted in the
+// certificates directory. A new UID made up of the cluster name and a random
+// UUID is generated the first time.
+func (lp *LocalPKI) clusterUID(p *Plan) (string, error) {
+	file := filepath.Join(lp.GeneratedCertsDirectory, clusterUIDFilename)
+	b, err := ioutil.ReadFile(file)
+	if err == nil {
+		uid := strings.TrimSpace(string(b))
+		if uid == "" {
+			return "", fmt.Errorf("cluster UID file %q is empty", file)
+		}
+		return uid, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("error reading cluster UID: %v", err)
+	}
+	r := lp.Rand
+	if r == nil {
+		r = rand.Reader
+	}
+	uuid, err := newUUID(r)
+	if err != nil {
+		return "", err
+	}
+	uid := fmt.Sprintf("%s-%s", p.Cluster.Name, uuid)
+	if err := util.CreateDir(lp.GeneratedCertsDirectory, 0744); err != nil {
+		return "", err
+	}
+	if err := util.WriteFile(file, []byte(uid+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("error writing cluster UID: %v", err)
+	}
+	return uid, nil
+}
+
+// tagClusterUID sets the cluster UID on the specs, if enabled
+func (lp *LocalPKI) tagClusterUID(p *Plan, specs []certificateSpec) error {
+	if !lp.EmbedClusterUID {
+		return nil
+	}
+	uid, err := lp.clusterUID(p)
+	if err != nil {
+		return err
+	}
+	for i := range specs {
+		specs[i].clusterUID = uid
+	}
+	return nil
+}
+
+// CertClusterUID returns the UID of the cluster the certificate was generated
+// for, or an empty string if the certificate was not tagged with a cluster UID.
+func CertClusterUID(cert *x509.Certificate) string {
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		if strings.HasPrefix(ou, clusterUIDPrefix) {
+			return strings.TrimPrefix(ou, clusterUIDPrefix)
+		}
+	}
+	return ""
+}
+
+// returns a random (version 4) UUID
+func newUUID(r io.Reader) (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", fmt.Errorf("error generating UUID: %v", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,130 @@
+package install
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/apprenda/kismatic/pkg/util"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	encryptionConfigFilename = "encryption-config.yaml"
+	encryptionConfigKeyName  = "key1"
+	encryptionConfigKeySize  = 32
+)
+
+type encryptionConfig struct {
+	Kind       string                      `yaml:"kind"`
+	APIVersion string                      `yaml:"apiVersion"`
+	Resources  []encryptionConfigResources `yaml:"resources"`
+}
+
+type encryptionConfigResources struct {
+	Resources []string                   `yaml:"resources"`
+	Providers []encryptionConfigProvider `yaml:"providers"`
+}
+
+type encryptionConfigProvider struct {
+	AESCBC   *encryptionConfigAESCBC `yaml:"aescbc,omitempty"`
+	Identity *struct{}               `yaml:"identity,omitempty"`
+}
+
+type encryptionConfigAESCBC struct {
+	Keys []encryptionConfigKey `yaml:"keys"`
+}
+
+type encryptionConfigKey struct {
+	Name   string `yaml:"name"`
+	Secret string `yaml:"secret"`
+}
+
+// GenerateEncryptionConfig returns the configuration used by the API server to
+// encrypt secrets at rest, i.e. the file passed to --encryption-provider-config.
+// Secrets are encrypted with a randomly generated aescbc key. The configuration
+// is written to the certificates directory and recorded in the manifest. An
+// existing configuration is returned as is, so that the secrets that are
+// already encrypted remain readable.
+func (lp *LocalPKI) GenerateEncryptionConfig() ([]byte, error) {
+	path := filepath.Join(lp.GeneratedCertsDirectory, encryptionConfigFilename)
+	existing, err := ioutil.ReadFile(path)
+	if err == nil {
+		c := encryptionConfig{}
+		if err := yaml.Unmarshal(existing, &c); err != nil {
+			return nil, fmt.Errorf("error decoding existing encryption config: %v", err)
+		}
+		util.PrettyPrintOk(lp.Log, "Found existing encryption config")
+		return existing, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading encryption config: %v", err)
+	}
+
+	r := lp.Rand
+	if r == nil {
+		r = rand.Reader
+	}
+	key := make([]byte, encryptionConfigKeySize)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, fmt.Errorf("error generating encryption key: %v", err)
+	}
+	c := encryptionConfig{
+		Kind:       "EncryptionConfig",
+		APIVersion: "v1",
+		Resources: []encryptionConfigResources{
+			{
+				Resources: []string{"secrets"},
+				Providers: []encryptionConfigProvider{
+					{
+						AESCBC: &encryptionConfigAESCBC{
+							Keys: []encryptionConfigKey{{Name: encryptionConfigKeyName, Secret: base64.StdEncoding.EncodeToString(key)}},
+						},
+					},
+					// allows reading the secrets that were written before encryption was enabled
+					{Identity: &struct{}{}},
+				},
+			},
+		},
+	}
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding encryption config: %v", err)
+	}
+
+	if err := util.CreateDir(lp.GeneratedCertsDirectory, 0744); err != nil {
+		return nil, err
+	}
+	if err := util.WriteFile(path, b, 0600); err != nil {
+		return nil, fmt.Errorf("error writing encryption config: %v", err)
+	}
+	if err := lp.addManifestEntry(encryptionConfigManifestEntry()); err != nil {
+		return nil, err
+	}
+	util.PrettyPrintOk(lp.Log, "Generated encryption config")
+	return b, nil
+}
+
+func encryptionConfigManifestEntry() CertificateManifestEntry {
+	return CertificateManifestEntry{
+		Name:        encryptionConfigFilename,
+		Description: "secrets encryption config",
+		Files:       []string{encryptionConfigFilename},
+	}
+}
+
+// returns true if the encryption config exists in the certificates directory
+func (lp *LocalPKI) encryptionConfigExists() (bool, error) {
+	_, err := os.Stat(filepath.Join(lp.GeneratedCertsDirectory, encryptionConfigFilename))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error reading encryption config: %v", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,75 @@
+package install
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestGenerateEncryptionConfig(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	b, err := pki.GenerateEncryptionConfig()
+	if err != nil {
+		t.Fatalf("error generating encryption config: %v", err)
+	}
+	c := encryptionConfig{}
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		t.Fatalf("error decoding encryption config: %v", err)
+	}
+	if c.Kind != "EncryptionConfig" || len(c.Resources) != 1 {
+		t.Fatalf("unexpected encryption config:\n%s", string(b))
+	}
+	providers := c.Resources[0].Providers
+	if len(providers) != 2 || providers[0].AESCBC == nil || providers[1].Identity == nil {
+		t.Fatalf("expected the aescbc provider followed by the identity provider, but got:\n%s", string(b))
+	}
+	key, err := base64.StdEncoding.DecodeString(providers[0].AESCBC.Keys[0].Secret)
+	if err != nil {
+		t.Fatalf("error decoding encryption key: %v", err)
+	}
+	if len(key) != 32 {
+		t.Errorf("expected a 32 byte encryption key, but got %d bytes", len(key))
+	}
+
+	m, err := pki.readManifest()
+	if err != nil {
+		t.Fatalf("error reading manifest: %v", err)
+	}
+	if m == nil || len(m.Certificates) != 1 || m.Certificates[0].Name != encryptionConfigFilename {
+		t.Errorf("expected the encryption config to be recorded in the manifest, but got %+v", m)
+	}
+
+	// The key is reused when the config exists
+	again, err := pki.GenerateEncryptionConfig()
+	if err != nil {
+		t.Fatalf("error generating encryption config: %v", err)
+	}
+	if !bytes.Equal(b, again) {
+		t.Errorf("expected the existing encryption config to be reused")
+	}
+
+	// The config remains in the manifest after generating the cluster certificates
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA: %v", err)
+	}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	m, err = pki.readManifest()
+	if err != nil {
+		t.Fatalf("error reading manifest: %v", err)
+	}
+	found := false
+	for _, e := range m.Certificates {
+		found = found || e.Name == encryptionConfigFilename
+	}
+	if !found {
+		t.Errorf("expected the encryption config to be recorded in the manifest")
+	}
+}
@@ -41,6 +41,7 @@ type Executor interface {
 	UpgradeDockerRegistry(plan Plan) error
 	UpgradeClusterServices(plan Plan) error
 	MigrateEtcdCluster(plan Plan) error
+	RenewCertificates(p *Plan, expiryThreshold time.Duration) ([]string, error)
 }
 
 // DiagnosticsExecutor will run diagnostics on the nodes after an install
@@ -409,6 +410,62 @@ func (ae *ansibleExecutor) RunPlay(playName string, p *Plan) error {
 	return ae.execute(t)
 }
 
+// RenewCertificates renews the certificates of the cluster that expire within
+// the threshold, and distributes them to the nodes they are issued to. The
+// components that use the renewed certificates are restarted. Returns the
+// names of the renewed certificates.
+func (ae *ansibleExecutor) RenewCertificates(p *Plan, expiryThreshold time.Duration) ([]string, error) {
+	util.PrintHeader(ae.stdout, "Renewing Certificates", '=')
+	renewed, err := ae.pki.RenewClusterCerts(p, expiryThreshold)
+	if err != nil {
+		return renewed, fmt.Errorf("error renewing certificates: %v", err)
+	}
+	if len(renewed) == 0 {
+		return nil, nil
+	}
+	limit, err := renewedCertHosts(p, renewed)
+	if err != nil {
+		return renewed, err
+	}
+	cc, err := ae.buildClusterCatalog(p)
+	if err != nil {
+		return renewed, err
+	}
+	t := task{
+		name:           "renew-certificates",
+		playbook:       "renew-certificates.yaml",
+		inventory:      buildInventoryFromPlan(p),
+		clusterCatalog: *cc,
+		explainer:      ae.defaultExplainer(),
+		plan:           *p,
+		limit:          limit,
+	}
+	util.PrintHeader(ae.stdout, "Distributing Renewed Certificates", '=')
+	return renewed, ae.execute(t)
+}
+
+// returns the hosts of the nodes that the renewed certificates are issued
+// to, or nil if a certificate that is shared by all nodes was renewed
+func renewedCertHosts(p *Plan, renewed []string) ([]string, error) {
+	manifest, err := certManifestForCluster(*p)
+	if err != nil {
+		return nil, err
+	}
+	hosts := []string{}
+	for _, s := range manifest {
+		if !contains(s.filename, renewed) {
+			continue
+		}
+		if s.host == "" {
+			return nil, nil
+		}
+		if !contains(s.host, hosts) {
+			hosts = append(hosts, s.host)
+		}
+	}
+	return hosts, nil
+}
+
 func (ae *ansibleExecutor) AddVolume(plan *Plan, volume StorageVolume) error {
 	// Validate that there are enough storage nodes to satisfy the request
 	nodesRequired := volume.ReplicateCount * volume.DistributionCount
@@ -710,6 +767,11 @@ func (ae *ansibleExecutor) buildClusterCatalog(p *Plan) (*ansible.ClusterCatalog
 		TargetVersion:             KismaticVersion.String(),
 		APIServerOptions:          p.Cluster.APIServerOptions.Overrides,
 	}
+	etcdPorts := p.etcdPorts()
+	cc.EtcdK8sClientPort = etcdPorts.KubernetesClientPort
+	cc.EtcdK8sPeerPort = etcdPorts.KubernetesPeerPort
+	cc.EtcdNetworkingClientPort = etcdPorts.NetworkingClientPort
+	cc.EtcdNetworkingPeerPort = etcdPorts.NetworkingPeerPort
 	cc.LocalKubeconfigDirectory = filepath.Join(ae.options.GeneratedAssetsDirectory, "kubeconfig")
 	// absolute path required for ansible
 	generatedDir, err := filepath.Abs(filepath.Join(ae.options.GeneratedAssetsDirectory, "kubeconfig"))
@@ -718,6 +780,8 @@ func (ae *ansibleExecutor) buildClusterCatalog(p *Plan) (*ansible.ClusterCatalog
 	}
 	cc.LocalKubeconfigDirectory = generatedDir
 
+	cc.DedicatedAPIServerCert = p.Cluster.Certificates.DedicatedAPIServerCert
+
 	// Setup FQDN or default to first master
 	if p.Master.LoadBalancedFQDN != "" {
 		cc.LoadBalancedFQDN = p.Master.LoadBalancedFQDN
@@ -928,6 +992,7 @@ func installNodeToAnsibleNode(n *Node, s *SSHConfig) ansible.Node {
 		SSHPrivateKey: s.Key,
 		SSHUser:       s.User,
 		SSHPort:       s.Port,
+		Vars:          n.Vars,
 	}
 }
 
@@ -0,0 +1,52 @@
+package install
+
+import (
+	"net"
+	"strings"
+
+	"github.com/apprenda/kismatic/pkg/util"
+)
+
+// warnUnresolvedHostnames resolves the hostname of every node of the plan,
+// and warns about the hostnames that do not resolve, or that do not resolve
+// to any of the IP addresses of the node. It never fails, as the hostnames
+// are only resolved to catch a stale plan early.
+func (lp *LocalPKI) warnUnresolvedHostnames(p *Plan) {
+	if !lp.ResolveHostnames {
+		return
+	}
+	lookup := lp.LookupHost
+	if lookup == nil {
+		lookup = net.LookupHost
+	}
+	for _, n := range p.AllNodes() {
+		expected := []string{n.Node.IP}
+		if n.Node.InternalIP != "" && n.Node.InternalIP != n.Node.IP {
+			expected = append(expected, n.Node.InternalIP)
+		}
+		addrs, err := lookup(n.Node.Host)
+		if err != nil {
+			util.PrettyPrintWarn(lp.Log, "Hostname %q does not resolve, expected it to resolve to %s: %v", n.Node.Host, strings.Join(expected, ", "), err)
+			continue
+		}
+		if !resolvesToAny(addrs, expected) {
+			util.PrettyPrintWarn(lp.Log, "Hostname %q resolves to %s, which is not one of the IPs of the node: %s", n.Node.Host, strings.Join(addrs, ", "), strings.Join(expected, ", "))
+		}
+	}
+}
+
+// returns true if any of the resolved addresses is one of the expected IPs
+func resolvesToAny(addrs []string, expected []string) bool {
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			continue
+		}
+		for _, e := range expected {
+			if ip.Equal(net.ParseIP(e)) {
+				return true
+			}
+		}
+	}
+	return false
+}
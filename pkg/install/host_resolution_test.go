@@ -0,0 +1,59 @@
+package install
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWarnUnresolvedHostnames(t *testing.T) {
+	p := &Plan{
+		Etcd: NodeGroup{Nodes: []Node{{Host: "etcd01", IP: "10.1.0.1"}}},
+		Master: MasterNodeGroup{Nodes: []Node{
+			{Host: "master01", IP: "192.168.0.2", InternalIP: "10.1.0.2"},
+		}},
+		Worker: NodeGroup{Nodes: []Node{
+			{Host: "worker01", IP: "10.1.0.3"},
+			{Host: "worker02", IP: "10.1.0.4"},
+		}},
+	}
+	resolved := map[string][]string{
+		"etcd01":   {"10.1.0.1"},
+		"master01": {"10.1.0.2"},
+		"worker01": {"10.1.0.99"},
+	}
+	var log bytes.Buffer
+	pki := LocalPKI{
+		Log:              &log,
+		ResolveHostnames: true,
+		LookupHost: func(host string) ([]string, error) {
+			addrs, ok := resolved[host]
+			if !ok {
+				return nil, errors.New("no such host")
+			}
+			return addrs, nil
+		},
+	}
+	pki.warnUnresolvedHostnames(p)
+	out := log.String()
+	for _, host := range []string{"etcd01", "master01"} {
+		if strings.Contains(out, host) {
+			t.Errorf("expected no warning for %q, but got %q", host, out)
+		}
+	}
+	if !strings.Contains(out, `Hostname "worker01" resolves to 10.1.0.99, which is not one of the IPs of the node: 10.1.0.3`) {
+		t.Errorf("expected a warning for the hostname that resolves to another IP, but got %q", out)
+	}
+	if !strings.Contains(out, `Hostname "worker02" does not resolve, expected it to resolve to 10.1.0.4: no such host`) {
+		t.Errorf("expected a warning for the hostname that does not resolve, but got %q", out)
+	}
+
+	// hostnames are not resolved unless enabled
+	log.Reset()
+	pki.ResolveHostnames = false
+	pki.warnUnresolvedHostnames(p)
+	if log.Len() != 0 {
+		t.Errorf("expected hostnames not to be resolved, but got %q", log.String())
+	}
+}
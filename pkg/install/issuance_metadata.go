@@ -0,0 +1,92 @@
+package install
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IssuanceMetadata records how and when a certificate was issued. It is
+// embedded in the certificates as a non-critical extension when an
+// issuance metadata OID is configured.
+type IssuanceMetadata struct {
+	// KismaticVersion is the version of kismatic that issued the certificate
+	KismaticVersion string `asn1:"utf8"`
+	// PlanHash is the hash of the plan the certificate was issued for, as
+	// returned by Plan.Hash
+	PlanHash string `asn1:"utf8"`
+	// IssuedAt is the time at which the certificate was issued
+	IssuedAt time.Time `asn1:"generalized"`
+}
+
+// ParseOID parses an object identifier in dotted notation, such as 1.3.6.1.4.1.99999.1
+func ParseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("%q is not a valid object identifier", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%q is not a valid object identifier", s)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
+
+// CertIssuanceMetadata returns the issuance metadata embedded in the
+// certificate under the given OID, or nil if the certificate has none.
+func CertIssuanceMetadata(cert *x509.Certificate, oid asn1.ObjectIdentifier) (*IssuanceMetadata, error) {
+	for _, e := range cert.Extensions {
+		if !e.Id.Equal(oid) {
+			continue
+		}
+		m := &IssuanceMetadata{}
+		rest, err := asn1.Unmarshal(e.Value, m)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding issuance metadata: %v", err)
+		}
+		if len(rest) > 0 {
+			return nil, fmt.Errorf("error decoding issuance metadata: trailing data after the metadata")
+		}
+		return m, nil
+	}
+	return nil, nil
+}
+
+// tagIssuanceMetadata sets the hash of the plan on the specs, if issuance
+// metadata is enabled
+func (lp *LocalPKI) tagIssuanceMetadata(p *Plan, specs []certificateSpec) {
+	if lp.IssuanceMetadataOID == "" {
+		return
+	}
+	hash := p.Hash()
+	for i := range specs {
+		specs[i].planHash = hash
+	}
+}
+
+// returns the issuance metadata extension of the certificate for the spec,
+// issued at the given time
+func (lp *LocalPKI) issuanceMetadataExtension(spec certificateSpec, issuedAt time.Time) (pkix.Extension, error) {
+	oid, err := ParseOID(lp.IssuanceMetadataOID)
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("invalid issuance metadata OID: %v", err)
+	}
+	m := IssuanceMetadata{
+		KismaticVersion: KismaticVersion.String(),
+		PlanHash:        spec.planHash,
+		IssuedAt:        issuedAt.UTC().Truncate(time.Second),
+	}
+	b, err := asn1.Marshal(m)
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("error encoding issuance metadata: %v", err)
+	}
+	return pkix.Extension{Id: oid, Critical: false, Value: b}, nil
+}
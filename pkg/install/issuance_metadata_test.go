@@ -0,0 +1,78 @@
+package install
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIssuanceMetadata(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	pki.IssuanceMetadataOID = "1.3.6.1.4.1.99999.1"
+	issuedAt := time.Now().UTC().Truncate(time.Second)
+	pki.Now = func() time.Time { return issuedAt }
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	cert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"), t)
+	oid, _ := ParseOID(pki.IssuanceMetadataOID)
+	m, err := CertIssuanceMetadata(cert, oid)
+	if err != nil {
+		t.Fatalf("error reading issuance metadata: %v", err)
+	}
+	if m == nil {
+		t.Fatalf("expected the certificate to have issuance metadata")
+	}
+	if hash := p.Hash(); m.PlanHash != hash {
+		t.Errorf("expected plan hash %q, but got %q", hash, m.PlanHash)
+	}
+	if m.KismaticVersion != KismaticVersion.String() {
+		t.Errorf("expected kismatic version %q, but got %q", KismaticVersion.String(), m.KismaticVersion)
+	}
+	if !m.IssuedAt.Equal(issuedAt) {
+		t.Errorf("expected issuance time %v, but got %v", issuedAt, m.IssuedAt)
+	}
+	for _, e := range cert.Extensions {
+		if e.Id.Equal(oid) && e.Critical {
+			t.Errorf("expected the issuance metadata extension to be non-critical")
+		}
+	}
+
+	caCert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "ca.pem"), t)
+	if m, err := CertIssuanceMetadata(caCert, oid); err != nil || m != nil {
+		t.Errorf("expected no issuance metadata on the CA, but got %v %v", m, err)
+	}
+}
+
+func TestParseOID(t *testing.T) {
+	tests := []struct {
+		oid      string
+		expected []int
+		valid    bool
+	}{
+		{oid: "1.3.6.1.4.1.99999.1", expected: []int{1, 3, 6, 1, 4, 1, 99999, 1}, valid: true},
+		{oid: "2.5", expected: []int{2, 5}, valid: true},
+		{oid: "1", valid: false},
+		{oid: "1..2", valid: false},
+		{oid: "1.foo", valid: false},
+		{oid: "", valid: false},
+	}
+	for _, test := range tests {
+		oid, err := ParseOID(test.oid)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid = %v, but got error %v", test.oid, test.valid, err)
+			continue
+		}
+		if test.valid && !reflect.DeepEqual([]int(oid), test.expected) {
+			t.Errorf("%q: expected %v, but got %v", test.oid, test.expected, oid)
+		}
+	}
+}
@@ -70,20 +70,14 @@ func GenerateKubeconfig(p *Plan, generatedAssetsDir string) error {
 		return fmt.Errorf("error reading certificate key file for kubeconfig: %v", err)
 	}
 
-	// Process template file
-	tmpl, err := template.New("kubeconfig").Parse(kubeconfigTemplate)
-	if err != nil {
-		return fmt.Errorf("error reading config template: %v", err)
-	}
 	configOptions := ConfigOptions{caEncoded, server, cluster, user, context, certEncoded, keyEncoded}
-	var kubeconfig bytes.Buffer
-	err = tmpl.Execute(&kubeconfig, configOptions)
+	kubeconfig, err := renderKubeconfig(configOptions)
 	if err != nil {
-		return fmt.Errorf("error processing config template: %v", err)
+		return err
 	}
 	// Write config file
 	kubeconfigFile := filepath.Join(generatedAssetsDir, kubeconfigFilename)
-	err = ioutil.WriteFile(kubeconfigFile, kubeconfig.Bytes(), 0644)
+	err = util.WriteFile(kubeconfigFile, kubeconfig, 0644)
 	if err != nil {
 		return fmt.Errorf("error writing kubeconfig file: %v", err)
 	}
@@ -91,6 +85,21 @@ func GenerateKubeconfig(p *Plan, generatedAssetsDir string) error {
 	return nil
 }
 
+// renderKubeconfig returns the contents of a kubeconfig file with the given options
+func renderKubeconfig(configOptions ConfigOptions) ([]byte, error) {
+	// Process template file
+	tmpl, err := template.New("kubeconfig").Parse(kubeconfigTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config template: %v", err)
+	}
+	var kubeconfig bytes.Buffer
+	err = tmpl.Execute(&kubeconfig, configOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error processing config template: %v", err)
+	}
+	return kubeconfig.Bytes(), nil
+}
+
 // RegenerateKubeconfig backs up the old kubeconfig file if it exists. Returns
 // true if the new kubeconfig file is different than the previous one.
 // Otherwise returns false.
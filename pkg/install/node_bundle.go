@@ -0,0 +1,113 @@
+package install
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/apprenda/kismatic/pkg/util"
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+// WriteNodeBundle copies the files that the node needs to the destination
+// directory: the certificates and keys issued to the node, and the CA
+// certificate. The private keys of certificate authorities are never
+// included, so the bundle can be distributed to the node as a whole.
+// Returns the names of the files in the bundle.
+func (lp *LocalPKI) WriteNodeBundle(p *Plan, node Node, dir string) ([]string, error) {
+	if err := lp.checkBundleDirectory(dir); err != nil {
+		return nil, err
+	}
+
+	specs, err := certManifestForNode(*p, node)
+	if err != nil {
+		return nil, err
+	}
+	// the CA certificate is required to verify the peers of the node
+	files := map[string]os.FileMode{lp.FileNames.CertFile("ca"): 0644}
+	for _, s := range specs {
+		isCA, err := lp.isCACert(s.filename)
+		if err != nil {
+			return nil, err
+		}
+		if isCA {
+			return nil, fmt.Errorf("refusing to add the private key of certificate authority %q to the bundle of node %q", s.filename, node.Host)
+		}
+		files[lp.FileNames.CertFile(s.filename)] = 0644
+		files[lp.FileNames.KeyFile(s.filename)] = 0600
+	}
+	if _, ok := files[lp.FileNames.KeyFile("ca")]; ok {
+		return nil, fmt.Errorf("refusing to add the private key of the cluster CA to the bundle of node %q", node.Host)
+	}
+
+	names, err := lp.copyBundleFiles(files, dir)
+	if err != nil {
+		return nil, err
+	}
+	trustBundle, err := lp.writeBundleTrustAnchors(p, dir)
+	if err != nil {
+		return nil, err
+	}
+	names = append(names, trustBundle...)
+	sort.Strings(names)
+	util.PrettyPrintOk(lp.Log, "Wrote bundle of node %q to %q", node.Host, dir)
+	return names, nil
+}
+
+// returns an error if the bundle directory is the certificates directory
+func (lp *LocalPKI) checkBundleDirectory(dir string) error {
+	src, err := filepath.Abs(lp.GeneratedCertsDirectory)
+	if err != nil {
+		return fmt.Errorf("error getting absolute path of the certificates directory: %v", err)
+	}
+	dst, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("error getting absolute path of the bundle directory: %v", err)
+	}
+	if src == dst {
+		return fmt.Errorf("the bundle directory must be different from the certificates directory %q", lp.GeneratedCertsDirectory)
+	}
+	return nil
+}
+
+// copies the files of the certificates directory to the bundle directory,
+// with the given permissions. Returns the names of the files, sorted.
+func (lp *LocalPKI) copyBundleFiles(files map[string]os.FileMode, dir string) ([]string, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if err := util.CreateDir(dir, 0744); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		b, err := ioutil.ReadFile(filepath.Join(lp.GeneratedCertsDirectory, name))
+		if err != nil {
+			return nil, fmt.Errorf("error reading %q: %v", name, err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0744); err != nil {
+			return nil, fmt.Errorf("error creating directory for %q: %v", name, err)
+		}
+		if err := util.WriteFile(path, b, files[name]); err != nil {
+			return nil, fmt.Errorf("error writing %q: %v", name, err)
+		}
+	}
+	return names, nil
+}
+
+// returns true if the certificate with the given name is a CA certificate
+func (lp *LocalPKI) isCACert(name string) (bool, error) {
+	b, err := ioutil.ReadFile(filepath.Join(lp.GeneratedCertsDirectory, lp.FileNames.CertFile(name)))
+	if err != nil {
+		return false, fmt.Errorf("error reading certificate %q: %v", name, err)
+	}
+	cert, err := helpers.ParseCertificatePEM(b)
+	if err != nil {
+		return false, fmt.Errorf("error parsing certificate %q: %v", name, err)
+	}
+	return cert.IsCA, nil
+}
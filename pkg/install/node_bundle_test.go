@@ -0,0 +1,71 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteNodeBundle(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	p := &Plan{
+		Cluster: Cluster{
+			Name:         "someName",
+			Certificates: CertsConfig{Expiry: "1h"},
+			Networking:   NetworkConfig{ServiceCIDRBlock: "10.0.0.0/24"},
+		},
+		AddOns: AddOns{CNI: &CNI{}},
+		Etcd:   NodeGroup{Nodes: []Node{{Host: "etcd01", IP: "10.1.0.1"}}},
+		Master: MasterNodeGroup{
+			Nodes:            []Node{{Host: "master01", IP: "10.1.0.2"}},
+			LoadBalancedFQDN: "someFQDN",
+		},
+		Worker: NodeGroup{Nodes: []Node{{Host: "worker01", IP: "10.1.0.3"}}},
+	}
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA: %v", err)
+	}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+
+	bundleDir, err := ioutil.TempDir("", "node-bundle-tests")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer cleanup(bundleDir, t)
+	files, err := pki.WriteNodeBundle(p, p.Worker.Nodes[0], bundleDir)
+	if err != nil {
+		t.Fatalf("error writing node bundle: %v", err)
+	}
+	expected := []string{
+		"ca.pem",
+		"etcd-client-key.pem",
+		"etcd-client.pem",
+		"kube-proxy-key.pem",
+		"kube-proxy.pem",
+		"worker01-kubelet-key.pem",
+		"worker01-kubelet.pem",
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected bundle files %v, but got %v", expected, files)
+	}
+	if _, err := os.Stat(filepath.Join(bundleDir, "ca-key.pem")); !os.IsNotExist(err) {
+		t.Errorf("expected the CA private key to be excluded from the bundle")
+	}
+	info, err := os.Stat(filepath.Join(bundleDir, "worker01-kubelet-key.pem"))
+	if err != nil {
+		t.Fatalf("error reading private key in bundle: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected private key to have mode 0600, but got %v", info.Mode().Perm())
+	}
+
+	if _, err := pki.WriteNodeBundle(p, p.Worker.Nodes[0], pki.GeneratedCertsDirectory); err == nil {
+		t.Errorf("expected an error when writing the bundle to the certificates directory")
+	}
+}
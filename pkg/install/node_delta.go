@@ -0,0 +1,176 @@
+package install
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/apprenda/kismatic/pkg/tls"
+	"github.com/apprenda/kismatic/pkg/util"
+)
+
+// the node groups that nodes can be added to, or removed from, with a delta
+var nodeDeltaRoles = []string{"worker", "ingress", "storage"}
+
+// A NodeDelta describes the nodes that were added to, or removed from, the
+// cluster by a scaling event
+type NodeDelta struct {
+	// Added are the nodes that joined the cluster
+	Added []DeltaNode `yaml:"added,omitempty"`
+	// Removed are the hostnames of the nodes that left the cluster
+	Removed []string `yaml:"removed,omitempty"`
+}
+
+// A DeltaNode is a node that joined the cluster, along with the node groups
+// it joined, such as "worker" or "ingress"
+type DeltaNode struct {
+	Node  `yaml:",inline"`
+	Roles []string `yaml:"roles"`
+}
+
+// A NodeDeltaResult describes the certificate changes of applying a NodeDelta
+type NodeDeltaResult struct {
+	// Plan is the plan with the delta applied
+	Plan *Plan
+	// Generated are the names of the certificates that were generated for
+	// the added nodes. Certificates that existed already are not included.
+	Generated []string
+	// Removed are the names of the certificates that are no longer required,
+	// as their nodes were removed. Their files are only deleted if
+	// RemoveOrphanedCerts is set.
+	Removed []string
+}
+
+// Apply returns a copy of the plan with the delta applied. Nodes can only be
+// added to, or removed from, the worker, ingress and storage node groups.
+// Nodes that were added already, and nodes that were removed already, are
+// ignored, so that the same delta can be applied more than once.
+func (d NodeDelta) Apply(p *Plan) (*Plan, error) {
+	np := *p
+	groups := map[string]*NodeGroup{
+		"worker":  &np.Worker,
+		"ingress": (*NodeGroup)(&np.Ingress),
+		"storage": (*NodeGroup)(&np.Storage),
+	}
+	for _, g := range groups {
+		g.Nodes = append([]Node{}, g.Nodes...)
+	}
+	for _, host := range d.Removed {
+		for _, nodes := range [][]Node{np.Etcd.Nodes, np.Master.Nodes} {
+			for _, n := range nodes {
+				if n.Host == host {
+					return nil, fmt.Errorf("node %q is an etcd or master node, and cannot be removed with a delta", host)
+				}
+			}
+		}
+		for _, g := range groups {
+			nodes := []Node{}
+			for _, n := range g.Nodes {
+				if n.Host != host {
+					nodes = append(nodes, n)
+				}
+			}
+			if len(nodes) != len(g.Nodes) {
+				g.Nodes = nodes
+				g.ExpectedCount = len(nodes)
+			}
+		}
+	}
+	for _, a := range d.Added {
+		if contains(a.Host, d.Removed) {
+			return nil, fmt.Errorf("node %q cannot be both added and removed", a.Host)
+		}
+		if len(a.Roles) == 0 {
+			return nil, fmt.Errorf("node %q: at least one role is required, one of %v", a.Host, nodeDeltaRoles)
+		}
+		for _, r := range a.Roles {
+			g, ok := groups[r]
+			if !ok {
+				return nil, fmt.Errorf("node %q: role %q is invalid, nodes can only be added as one of %v", a.Host, r, nodeDeltaRoles)
+			}
+			exists := false
+			for _, n := range g.Nodes {
+				if n.Host != a.Host {
+					continue
+				}
+				if !n.Equal(a.Node) {
+					return nil, fmt.Errorf("node %q is already a %s node, with different attributes", a.Host, r)
+				}
+				exists = true
+			}
+			if !exists {
+				g.Nodes = append(g.Nodes, a.Node)
+				g.ExpectedCount = len(g.Nodes)
+			}
+		}
+	}
+	return &np, nil
+}
+
+// ApplyNodeDelta applies the delta to the plan, and generates the
+// certificates of the added nodes using the existing CA. The certificates of
+// the removed nodes are reported as orphaned, and removed if
+// RemoveOrphanedCerts is set. Certificates that exist already are kept, so
+// that applying the same delta again has no effect. The certificate manifest
+// is updated to reflect the plan with the delta applied.
+func (lp *LocalPKI) ApplyNodeDelta(p *Plan, d NodeDelta, ca *tls.CA) (*NodeDeltaResult, error) {
+	if lp.Log == nil {
+		lp.Log = ioutil.Discard
+	}
+	if ca == nil {
+		return nil, fmt.Errorf("ca cannot be nil")
+	}
+	np, err := d.Apply(p)
+	if err != nil {
+		return nil, err
+	}
+	res := &NodeDeltaResult{Plan: np}
+
+	for _, a := range d.Added {
+		specs, err := certManifestForNode(*np, a.Node)
+		if err != nil {
+			return nil, err
+		}
+		missing := []string{}
+		for _, s := range specs {
+			exists, err := lp.FileNames.CertKeyPairExists(s.filename, lp.GeneratedCertsDirectory)
+			if err != nil {
+				return nil, err
+			}
+			if !exists && !contains(s.filename, res.Generated) {
+				missing = append(missing, s.filename)
+			}
+		}
+		if err := lp.GenerateNodeCertificate(np, a.Node, ca); err != nil {
+			return nil, fmt.Errorf("error generating certificates for node %q: %v", a.Host, err)
+		}
+		res.Generated = append(res.Generated, missing...)
+	}
+
+	oldManifest, err := certManifestForCluster(*p)
+	if err != nil {
+		return nil, err
+	}
+	newManifest, err := certManifestForCluster(*np)
+	if err != nil {
+		return nil, err
+	}
+	current := specsByFilename(newManifest)
+	orphaned := &CertificateManifest{}
+	for _, s := range oldManifest {
+		if _, ok := current[s.filename]; !ok {
+			res.Removed = append(res.Removed, s.filename)
+			orphaned.Certificates = append(orphaned.Certificates, lp.manifestEntry(s.filename, s.description))
+		}
+	}
+	if err := lp.handleOrphanedCerts(orphaned, newManifest); err != nil {
+		return nil, err
+	}
+	if err := lp.writeManifest(np, newManifest, nil); err != nil {
+		return nil, err
+	}
+	if err := lp.updateChecksums(); err != nil {
+		return nil, err
+	}
+	util.PrettyPrintOk(lp.Log, "Applied node delta: %d certificates generated, %d certificates no longer required", len(res.Generated), len(res.Removed))
+	return res, nil
+}
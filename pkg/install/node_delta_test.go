@@ -0,0 +1,125 @@
+package install
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNodeDeltaApply(t *testing.T) {
+	p := getPlan()
+	p.Worker.ExpectedCount = len(p.Worker.Nodes)
+	d := NodeDelta{
+		Added:   []DeltaNode{{Node: Node{Host: "worker03", IP: "10.0.0.3"}, Roles: []string{"worker", "ingress"}}},
+		Removed: []string{"worker02"},
+	}
+	np, err := d.Apply(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hosts := func(nodes []Node) []string {
+		h := []string{}
+		for _, n := range nodes {
+			h = append(h, n.Host)
+		}
+		return h
+	}
+	if got := hosts(np.Worker.Nodes); !reflect.DeepEqual(got, []string{"worker01", "worker03"}) {
+		t.Errorf("expected workers %v, but got %v", []string{"worker01", "worker03"}, got)
+	}
+	if np.Worker.ExpectedCount != 2 {
+		t.Errorf("expected the worker count to be updated, but got %d", np.Worker.ExpectedCount)
+	}
+	if got := hosts(np.Ingress.Nodes); !reflect.DeepEqual(got, []string{"ingress01", "ingress02", "worker03"}) {
+		t.Errorf("expected the node to be added to the ingress nodes, but got %v", got)
+	}
+	if got := hosts(p.Worker.Nodes); !reflect.DeepEqual(got, []string{"worker01", "worker02"}) {
+		t.Errorf("expected the original plan to be left untouched, but got workers %v", got)
+	}
+
+	// Applying the delta again has no effect
+	again, err := d.Apply(np)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(again, np) {
+		t.Errorf("expected applying the delta again to have no effect")
+	}
+
+	invalid := []NodeDelta{
+		{Removed: []string{"etcd01"}},
+		{Added: []DeltaNode{{Node: Node{Host: "master03", IP: "10.0.0.4"}, Roles: []string{"master"}}}},
+		{Added: []DeltaNode{{Node: Node{Host: "worker04", IP: "10.0.0.4"}}}},
+		{Added: []DeltaNode{{Node: Node{Host: "worker01", IP: "10.0.0.4"}, Roles: []string{"worker"}}}},
+		{Added: []DeltaNode{{Node: Node{Host: "worker04", IP: "10.0.0.4"}, Roles: []string{"worker"}}}, Removed: []string{"worker04"}},
+	}
+	for i, d := range invalid {
+		if _, err := d.Apply(p); err == nil {
+			t.Errorf("test %d: expected an error", i)
+		}
+	}
+}
+
+func TestApplyNodeDelta(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	dir := pki.GeneratedCertsDirectory
+
+	p := getPlan()
+	p.Worker.Nodes = []Node{{Host: "worker01", IP: "10.0.0.1"}, {Host: "worker02", IP: "10.0.0.2"}}
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+
+	d := NodeDelta{
+		Added:   []DeltaNode{{Node: Node{Host: "worker03", IP: "10.0.0.3"}, Roles: []string{"worker"}}},
+		Removed: []string{"worker02"},
+	}
+	for i := 0; i < 2; i++ {
+		res, err := pki.ApplyNodeDelta(p, d, ca)
+		if err != nil {
+			t.Fatalf("error applying node delta: %v", err)
+		}
+		expectedGenerated := []string{"worker03-kubelet"}
+		if i > 0 {
+			// The certificates exist from the first run
+			expectedGenerated = nil
+		}
+		if !reflect.DeepEqual(res.Generated, expectedGenerated) {
+			t.Errorf("run %d: expected generated certificates %v, but got %v", i, expectedGenerated, res.Generated)
+		}
+		if !reflect.DeepEqual(res.Removed, []string{"worker02-kubelet"}) {
+			t.Errorf("run %d: expected removed certificates %v, but got %v", i, []string{"worker02-kubelet"}, res.Removed)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "worker03-kubelet.pem")); err != nil {
+		t.Errorf("expected the certificate of the added node to be generated: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "worker02-kubelet.pem")); err != nil {
+		t.Errorf("expected the certificate of the removed node to be kept: %v", err)
+	}
+	m, err := pki.readManifest()
+	if err != nil {
+		t.Fatalf("error reading manifest: %v", err)
+	}
+	names := map[string]bool{}
+	for _, e := range m.Certificates {
+		names[e.Name] = true
+	}
+	if !names["worker03-kubelet"] || names["worker02-kubelet"] {
+		t.Errorf("expected the manifest to reflect the delta, but got %v", names)
+	}
+
+	pki.RemoveOrphanedCerts = true
+	if _, err := pki.ApplyNodeDelta(p, d, ca); err != nil {
+		t.Fatalf("error applying node delta: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "worker02-kubelet.pem")); !os.IsNotExist(err) {
+		t.Errorf("expected the certificate of the removed node to be deleted, but got %v", err)
+	}
+}
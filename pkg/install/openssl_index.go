@@ -0,0 +1,113 @@
+package install
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/apprenda/kismatic/pkg/util"
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+const (
+	opensslIndexFilename  = "index.txt"
+	opensslSerialFilename = "serial"
+	// the format of the dates of the index, as written by OpenSSL
+	opensslIndexTimeFormat = "060102150405Z"
+)
+
+// serializes the updates of the index and serial files by concurrent certificate generation
+var opensslIndexMu sync.Mutex
+
+// the short names that OpenSSL uses for the attributes of a subject
+var opensslAttributeNames = []struct {
+	oid  asn1.ObjectIdentifier
+	name string
+}{
+	{asn1.ObjectIdentifier{2, 5, 4, 3}, "CN"},
+	{asn1.ObjectIdentifier{2, 5, 4, 5}, "serialNumber"},
+	{asn1.ObjectIdentifier{2, 5, 4, 6}, "C"},
+	{asn1.ObjectIdentifier{2, 5, 4, 7}, "L"},
+	{asn1.ObjectIdentifier{2, 5, 4, 8}, "ST"},
+	{asn1.ObjectIdentifier{2, 5, 4, 9}, "street"},
+	{asn1.ObjectIdentifier{2, 5, 4, 10}, "O"},
+	{asn1.ObjectIdentifier{2, 5, 4, 11}, "OU"},
+	{asn1.ObjectIdentifier{2, 5, 4, 17}, "postalCode"},
+}
+
+// recordIssuance appends the certificate to the OpenSSL index file, and
+// sets the serial file to the serial number that follows it, if the index
+// is enabled. It must only be called once the certificate is written to the
+// certificates directory. The certificate can be bundled with the CA.
+func (lp *LocalPKI) recordIssuance(certPEM []byte) error {
+	if !lp.OpenSSLIndex {
+		return nil
+	}
+	certs, _, err := helpers.ParseOneCertificateFromPEM(bytes.TrimSpace(certPEM))
+	if err != nil {
+		return fmt.Errorf("error parsing certificate for the OpenSSL index: %v", err)
+	}
+	if len(certs) == 0 {
+		return errors.New("error parsing certificate for the OpenSSL index: no certificate found")
+	}
+	cert := certs[0]
+	opensslIndexMu.Lock()
+	defer opensslIndexMu.Unlock()
+	f, err := os.OpenFile(filepath.Join(lp.GeneratedCertsDirectory, opensslIndexFilename), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening OpenSSL index file: %v", err)
+	}
+	if _, err := f.WriteString(opensslIndexEntry(cert)); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing OpenSSL index file: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing OpenSSL index file: %v", err)
+	}
+	next := new(big.Int).Add(cert.SerialNumber, big.NewInt(1))
+	if err := util.WriteFile(filepath.Join(lp.GeneratedCertsDirectory, opensslSerialFilename), []byte(opensslSerial(next)+"\n"), 0644); err != nil {
+		return fmt.Errorf("error writing OpenSSL serial file: %v", err)
+	}
+	return nil
+}
+
+// returns the line of the OpenSSL index for a valid certificate: the status,
+// the expiry, an empty revocation date, the serial number, the unknown
+// filename and the subject, separated by tabs.
+func opensslIndexEntry(cert *x509.Certificate) string {
+	return fmt.Sprintf("V\t%s\t\t%s\tunknown\t%s\n", cert.NotAfter.UTC().Format(opensslIndexTimeFormat), opensslSerial(cert.SerialNumber), opensslSubject(cert.Subject))
+}
+
+// returns the serial number in upper case hex, with an even number of digits
+func opensslSerial(serial *big.Int) string {
+	s := fmt.Sprintf("%X", serial)
+	if len(s)%2 != 0 {
+		s = "0" + s
+	}
+	return s
+}
+
+// returns the subject in the slash-separated format of OpenSSL, such as
+// /C=US/O=system:nodes/CN=system:node:worker01
+func opensslSubject(name pkix.Name) string {
+	parts := []string{}
+	for _, atv := range name.Names {
+		attr := atv.Type.String()
+		for _, n := range opensslAttributeNames {
+			if n.oid.Equal(atv.Type) {
+				attr = n.name
+				break
+			}
+		}
+		parts = append(parts, fmt.Sprintf("/%s=%v", attr, atv.Value))
+	}
+	return strings.Join(parts, "")
+}
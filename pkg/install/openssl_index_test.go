@@ -0,0 +1,132 @@
+package install
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+func TestGenerateClusterCertificatesOpenSSLIndex(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	pki.OpenSSLIndex = true
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	manifest, err := certManifestForCluster(*p)
+	if err != nil {
+		t.Fatalf("error getting certificate manifest: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, "index.txt"))
+	if err != nil {
+		t.Fatalf("error reading index file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != len(manifest) {
+		t.Errorf("expected %d entries in the index, but got %d", len(manifest), len(lines))
+	}
+	var last []string
+	for _, line := range lines {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 6 || fields[0] != "V" || fields[2] != "" || fields[4] != "unknown" {
+			t.Errorf("invalid index entry %q", line)
+			continue
+		}
+		last = fields
+	}
+	admin := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"), t)
+	entry := opensslIndexEntry(admin)
+	if !strings.Contains(string(b), entry) {
+		t.Errorf("expected the index to contain the entry of the admin certificate %q", entry)
+	}
+	if !strings.HasSuffix(entry, "\t/O=system:masters/CN=admin\n") {
+		t.Errorf("unexpected subject in the entry of the admin certificate %q", entry)
+	}
+
+	serial, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, "serial"))
+	if err != nil {
+		t.Fatalf("error reading serial file: %v", err)
+	}
+	if last == nil {
+		t.Fatalf("expected the index to have entries")
+	}
+	lastSerial, ok := new(big.Int).SetString(last[3], 16)
+	if !ok {
+		t.Fatalf("invalid serial number %q in the index", last[3])
+	}
+	expected := opensslSerial(lastSerial.Add(lastSerial, big.NewInt(1))) + "\n"
+	if string(serial) != expected {
+		t.Errorf("expected the serial file to contain %q, but got %q", expected, string(serial))
+	}
+}
+
+func TestWriteCertToOpenSSLIndex(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	pki.OpenSSLIndex = true
+	pki.BundleCACert = true
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	var key, cert bytes.Buffer
+	if err = pki.WriteCertTo(p, "admin", ca, &key, &cert); err != nil {
+		t.Fatalf("error writing certificate: %v", err)
+	}
+	for _, name := range []string{"index.txt", "serial"} {
+		if _, err := os.Stat(filepath.Join(pki.GeneratedCertsDirectory, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to be written for a certificate that is not written to disk, but got %v", name, err)
+		}
+	}
+
+	// Bundled certificates are recorded once written
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, "index.txt"))
+	if err != nil {
+		t.Fatalf("error reading index file: %v", err)
+	}
+	bundle, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"))
+	if err != nil {
+		t.Fatalf("error reading admin certificate: %v", err)
+	}
+	admin, _, err := helpers.ParseOneCertificateFromPEM(bundle)
+	if err != nil || len(admin) == 0 {
+		t.Fatalf("error parsing admin certificate: %v", err)
+	}
+	if !strings.Contains(string(b), opensslIndexEntry(admin[0])) {
+		t.Errorf("expected the index to contain the entry of the admin certificate")
+	}
+}
+
+func TestOpenSSLSerial(t *testing.T) {
+	tests := []struct {
+		serial   int64
+		expected string
+	}{
+		{serial: 1, expected: "01"},
+		{serial: 255, expected: "FF"},
+		{serial: 256, expected: "0100"},
+	}
+	for _, test := range tests {
+		if got := opensslSerial(big.NewInt(test.serial)); got != test.expected {
+			t.Errorf("serial %d: expected %q, but got %q", test.serial, test.expected, got)
+		}
+	}
+}
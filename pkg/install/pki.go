@@ -1,17 +1,30 @@
 package install
 
 import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
+	"net"
 	"os"
+	"os/exec"
+	"os/user"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/apprenda/kismatic/pkg/tls"
 	"github.com/apprenda/kismatic/pkg/util"
 	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/helpers"
 )
 
 const (
@@ -31,8 +44,40 @@ const (
 	kubeletUserPrefix                   = "system:node"
 	kubeletGroup                        = "system:nodes"
 	contivProxyServerCertFilename       = "contiv-proxy-server"
+	apiServerEtcdClientCertFilename     = "apiserver-etcd-client"
+	apiServerEtcdClientUser             = "kube-apiserver-etcd-client"
+	apiServerKubeletClientCertFilename  = "apiserver-kubelet-client"
+	apiServerKubeletClientUser          = "kube-apiserver-kubelet-client"
+	apiServerKubeletClientGroup         = "system:masters"
+	apiServerCertFilename               = "apiserver"
+	apiServerCertCommonName             = "kube-apiserver"
 )
 
+// defaultMaxSANs is the maximum number of SANs of a certificate, unless set in the plan
+const defaultMaxSANs = 100
+
+// maxCommonNameLength is the maximum length of the common name of a certificate, as defined in RFC 5280
+const maxCommonNameLength = 64
+
+// caExpiryTolerance is how much later than the CA a certificate can expire
+const caExpiryTolerance = 10 * time.Minute
+
+// defaultSlowKeyGenerationThreshold is how long generating a certificate can
+// take before warning that the system might be low on entropy
+const defaultSlowKeyGenerationThreshold = 10 * time.Second
+
+// serializes the warnings logged by concurrent certificate generation
+var slowKeyGenerationLogMu sync.Mutex
+
+// nodeRoles are the roles a node can have in the cluster
+var nodeRoles = []string{"etcd", "master", "worker", "ingress", "storage"}
+
+var clientAuthUsages = []string{"signing", "key encipherment", "client auth"}
+
+// peerUsages are the usages of the certificates that are used both to serve
+// and to connect to the other members of a cluster, such as etcd peers
+var peerUsages = []string{"signing", "key encipherment", "server auth", "client auth"}
+
 // The PKI provides a way for generating certificates for the cluster described by the Plan
 type PKI interface {
 	CertificateAuthorityExists() (bool, error)
@@ -41,6 +86,7 @@ type PKI interface {
 	GetClusterCA() (*tls.CA, error)
 	GenerateClusterCA(p *Plan) (*tls.CA, error)
 	GenerateClusterCertificates(p *Plan, ca *tls.CA) error
+	RenewClusterCerts(p *Plan, expiryThreshold time.Duration) ([]string, error)
 	GenerateCertificate(name string, validityPeriod string, commonName string, subjectAlternateNames []string, organizations []string, ca *tls.CA, overwrite bool) (bool, error)
 }
 
@@ -49,6 +95,205 @@ type LocalPKI struct {
 	CACsr                   string
 	GeneratedCertsDirectory string
 	Log                     io.Writer
+	// Group is the name or numeric ID of the group that should own the
+	// generated certificates directory and files. When set, the directory is
+	// made traversable by the group. Private keys remain readable only by the owner.
+	// Ownership is left untouched if empty.
+	Group string
+	// PreHook is a command, and its arguments, that is run before the
+	// cluster certificates are generated.
+	PreHook []string
+	// PostHook is a command, and its arguments, that is run after the
+	// cluster certificates are generated successfully.
+	PostHook []string
+	// DisableCAKeyPersistence prevents the CA's private key from being written
+	// to disk. The key is only held in memory to sign the cluster's certificates,
+	// which means that the CA cannot be read back to issue certificates later on.
+	// A generated CA is returned by GeneratedCA, so that its key can be stored
+	// elsewhere, such as in a vault.
+	DisableCAKeyPersistence bool
+	// CAConfigFile is the path to a cfssl configuration file that defines the
+	// signing profile used to sign certificates. Optional.
+	CAConfigFile string
+	// CASigningProfile is the signing profile defined in the CAConfigFile.
+	// The default profile of the file is used if empty.
+	CASigningProfile string
+	// BundleCACert appends the CA certificate to every generated certificate
+	// file, for clients that do not build the chain themselves. The CA
+	// certificate is still written to its own file.
+	BundleCACert bool
+	// Rand is the source of randomness used to generate private keys.
+	// Defaults to crypto/rand. Together with Now and SerialNumber, it
+	// can be used to generate reproducible certificates.
+	Rand io.Reader
+	// Now returns the time at which the validity period of generated
+	// certificates starts. Defaults to time.Now.
+	Now func() time.Time
+	// ClockSkew is the duration by which the start of the validity period of
+	// leaf certificates is backdated, so that they are valid on nodes whose
+	// clock is behind. The end of the validity period is unaffected.
+	// Certificates are not backdated if zero.
+	ClockSkew time.Duration
+	// SerialNumber returns the serial number of the next certificate.
+	// Random serial numbers are used by default.
+	SerialNumber func() (*big.Int, error)
+	// SerialBits is the number of random bits of the serial numbers of the
+	// CA and the leaf certificates, between 64 and 159 bits. It is ignored
+	// for leaf certificates when SerialNumber is set. The cfssl default
+	// is used if zero.
+	SerialBits int
+	// SlowKeyGenerationThreshold is how long generating the key of a
+	// certificate can take before a warning is logged, as key generation
+	// blocks when the system is low on entropy. Defaults to 10 seconds.
+	SlowKeyGenerationThreshold time.Duration
+	// IssuanceMetadataOID is the object identifier, in dotted notation, of
+	// a non-critical extension that is added to the leaf certificates to
+	// record the version of kismatic, the hash of the plan and the time
+	// they were issued at. The extension is not added if empty.
+	IssuanceMetadataOID string
+	// RootCAFile is the path to the root certificate that signed the cluster
+	// CA, when the cluster CA is an intermediate CA. Certificates are then
+	// written along with the intermediate certificate, and the chain is
+	// verified against the root before being written.
+	RootCAFile string
+	// CASigner signs certificates on behalf of the cluster CA, and can be
+	// backed by an HSM or a KMS so that the CA's private key is never read.
+	// When set, the CA certificate must exist in the certificates directory,
+	// as a CA cannot be generated for a key that is held externally.
+	CASigner crypto.Signer
+	// Concurrency is the maximum number of certificates that are generated
+	// concurrently. It defaults to the number of CPUs if less than 1. Setting
+	// it to 1 generates certificates sequentially. Certificates are always
+	// generated sequentially when Rand or SerialNumber are set.
+	Concurrency int
+	// EmbedClusterUID adds the UID of the cluster to the subject of the leaf
+	// certificates, and records it in the certificate manifest, so that the
+	// certificates of different clusters can be told apart. The UID is
+	// generated once, and persisted in the certificates directory.
+	EmbedClusterUID bool
+	// FileNames defines the names of the certificate and key files. Files are
+	// named "<name>.pem" and "<name>-key.pem" by default, which is the
+	// scheme expected by the installer.
+	FileNames tls.FileNameScheme
+	// RemoveOrphanedCerts removes the files of certificates that were
+	// generated previously, but that are no longer required by the plan.
+	// Orphaned certificates are only reported if false.
+	RemoveOrphanedCerts bool
+	// DryRun logs the certificates that would be generated, along with their
+	// SANs, without writing any files.
+	DryRun bool
+	// ContinueOnWriteFailure keeps generating the cluster certificates when
+	// the files of a certificate cannot be written. The failed certificates
+	// are marked as such in the manifest, and a CertWriteFailuresErr is
+	// returned once all the other certificates are generated. Failing to
+	// write the CA is always fatal.
+	ContinueOnWriteFailure bool
+	// Checksums maintains a checksums.sha256 file in the certificates
+	// directory, covering every file other than private keys and secrets,
+	// so that the directory can be kept under version control. Files that
+	// were modified outside of kismatic are reported before generating the
+	// certificates, and the file is rewritten afterwards.
+	Checksums bool
+	// Roles restricts the generation of the cluster certificates to those
+	// used by the given node roles, such as "etcd" or "master". The
+	// certificates of other roles, and those that are not specific to a role
+	// such as the admin certificate, are left untouched. All the
+	// certificates are generated if empty.
+	Roles []string
+	// OpenSSLIndex maintains the index.txt and serial files of an OpenSSL
+	// CA in the certificates directory, for tooling that relies on them.
+	// Every issued certificate is appended to the index with its serial
+	// number, subject and expiry, which also makes the index an audit log.
+	OpenSSLIndex bool
+	// RotateKeys generates new private keys when certificates are
+	// regenerated, such as when rotating the leaf certificates. The existing
+	// private keys are reused by default, and only the certificates are
+	// rewritten, unless a key does not match the current key configuration.
+	RotateKeys bool
+	// DER additionally writes the certificates and private keys in DER
+	// form, as "<name>.der" and "<name>-key.der", for consumers that do
+	// not support PEM. Private keys are written in PKCS #8 form. The PEM
+	// files remain the canonical files that are read back by kismatic.
+	DER bool
+	// SignTimeout is the maximum duration of a signing operation of the
+	// CA's signer, such as the CASigner, after which the operation fails
+	// and can be retried. Signing operations are not bounded if zero.
+	SignTimeout time.Duration
+	// SignRetries is the number of times a failed signing operation of the
+	// CA's signer is retried, so that a transient failure of a remote signer
+	// does not abort the generation of the certificates. Permanent errors,
+	// such as a PermanentSignerError, are never retried.
+	SignRetries int
+	// SignRetryBackoff is the delay before the first retry of a signing
+	// operation, which doubles after every retry. Defaults to a second.
+	SignRetryBackoff time.Duration
+	// ResolveHostnames resolves the hostname of every node before the
+	// certificates are generated, and warns if a hostname does not resolve,
+	// or does not resolve to the IP or internal IP of the node. Generation
+	// is never failed, so that it can be used in environments where only
+	// some of the hostnames are in DNS.
+	ResolveHostnames bool
+	// LookupHost resolves the hostnames when ResolveHostnames is set.
+	// Defaults to net.LookupHost.
+	LookupHost func(host string) ([]string, error)
+
+	// generatedCA is the generated CA, when DisableCAKeyPersistence is set
+	generatedCA *tls.CA
+	// certGen creates the CA and signs the certificates. Defaults to the tls package.
+	certGen certGenerator
+}
+
+// A CertWriteFailure is a certificate whose files could not be written
+type CertWriteFailure struct {
+	// Name of the certificate
+	Name string
+	// Err is the error that occurred when writing the files
+	Err error
+}
+
+// CertWriteFailuresErr is returned when the cluster certificates were
+// generated, except for those that could not be written. Only returned when
+// ContinueOnWriteFailure is set.
+type CertWriteFailuresErr struct {
+	Failures []CertWriteFailure
+}
+
+func (e CertWriteFailuresErr) Error() string {
+	names := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		names = append(names, f.Name)
+	}
+	return fmt.Sprintf("completed with %d failures. The following certificates could not be written: %s", len(e.Failures), strings.Join(names, ", "))
+}
+
+// A CertificatePreview describes a certificate that is generated for the cluster
+type CertificatePreview struct {
+	Name                  string   `json:"name"`
+	Description           string   `json:"description"`
+	CommonName            string   `json:"commonName"`
+	Organizations         []string `json:"organizations,omitempty"`
+	SubjectAlternateNames []string `json:"subjectAlternateNames,omitempty"`
+}
+
+// PreviewClusterCertificates returns the certificates that are generated for
+// the cluster described in the plan, with the final list of SANs each
+// certificate will contain.
+func PreviewClusterCertificates(p *Plan) ([]CertificatePreview, error) {
+	manifest, err := certManifestForCluster(*p)
+	if err != nil {
+		return nil, err
+	}
+	previews := make([]CertificatePreview, 0, len(manifest))
+	for _, s := range manifest {
+		previews = append(previews, CertificatePreview{
+			Name:                  s.filename,
+			Description:           s.description,
+			CommonName:            s.commonName,
+			Organizations:         s.organizations,
+			SubjectAlternateNames: uniqueStrings(s.subjectAlternateNames),
+		})
+	}
+	return previews, nil
 }
 
 type certificateSpec struct {
@@ -57,6 +302,37 @@ type certificateSpec struct {
 	commonName            string
 	subjectAlternateNames []string
 	organizations         []string
+	// usages are the key usages of the certificate. The signing defaults are used when empty.
+	usages []string
+	// clusterUID is the UID of the cluster, added to the subject of the certificate if set.
+	clusterUID string
+	// expiry is the validity period of the certificate, overriding the
+	// expiry of the leaf certificates if set.
+	expiry string
+	// uris are the URI SANs of the certificate, such as its SPIFFE ID.
+	uris []string
+	// host is the node the certificate is issued to, if the certificate is
+	// specific to a single node.
+	host string
+	// notAfter is the fixed expiry date of the certificate. The expiry is used if zero.
+	notAfter time.Time
+	// notBefore is the start of the validity period of the certificate, when
+	// the validity period is explicit
+	notBefore time.Time
+	// explicitValidity is true if the certificate is issued for the exact
+	// validity period between notBefore and notAfter, which can be in the past
+	explicitValidity bool
+	// planHash is the hash of the plan, recorded in the issuance metadata of the certificate.
+	planHash string
+	// key is the existing private key that the certificate is issued for.
+	// A new key is generated if nil.
+	key []byte
+	// keyRequest is the algorithm and size of the private key of the
+	// certificate. An RSA 2048 key is used if nil.
+	keyRequest *csr.BasicKeyRequest
+	// roles are the node roles that use the certificate. Empty for the
+	// certificates that are not specific to a role, such as the admin certificate.
+	roles []string
 }
 
 func (s certificateSpec) equal(other certificateSpec) bool {
@@ -107,41 +383,80 @@ func (s certificateSpec) equal(other certificateSpec) bool {
 func certManifestForNode(plan Plan, node Node) ([]certificateSpec, error) {
 	m := []certificateSpec{}
 	roles := plan.GetRolesForIP(node.IP)
+	// The SPIFFE ID of the node is added to the certificates issued to the node itself
+	var uris []string
+	if td := plan.Cluster.Certificates.SPIFFETrustDomain; td != "" {
+		uris = []string{spiffeID(td, node)}
+	}
 
 	// Certificates for etcd
 	if contains("etcd", roles) {
-		san := []string{node.Host, node.IP, "127.0.0.1"}
-		if node.InternalIP != "" {
+		san := append(nodeHostnameSANs(plan, node), node.IP)
+		san = append(san, etcdDefaultSANs()...)
+		if node.InternalIP != "" && !contains(node.InternalIP, san) {
 			san = append(san, node.InternalIP)
 		}
 		m = append(m, certificateSpec{
 			description:           fmt.Sprintf("%s etcd server", node.Host),
 			filename:              fmt.Sprintf("%s-etcd", node.Host),
+			host:                  node.Host,
+			expiry:                node.CertValidity,
+			uris:                  uris,
 			commonName:            node.Host,
 			subjectAlternateNames: san,
+			roles:                 []string{"etcd"},
+		})
+		// Peer certificate used by the etcd members to authenticate with each
+		// other. Peers only reach each other through the node's names and IPs.
+		peerSAN := append(nodeHostnameSANs(plan, node), node.IP)
+		if node.InternalIP != "" && !contains(node.InternalIP, peerSAN) {
+			peerSAN = append(peerSAN, node.InternalIP)
+		}
+		m = append(m, certificateSpec{
+			description:           fmt.Sprintf("%s etcd peer", node.Host),
+			filename:              fmt.Sprintf("%s-etcd-peer", node.Host),
+			host:                  node.Host,
+			expiry:                node.CertValidity,
+			uris:                  uris,
+			commonName:            node.Host,
+			subjectAlternateNames: peerSAN,
+			usages:                peerUsages,
+			roles:                 []string{"etcd"},
 		})
 	}
 
 	// Certificates for master
 	if contains("master", roles) {
-		// API Server certificate
-		san, err := clusterCertsSubjectAlternateNames(plan)
-		if err != nil {
-			return nil, err
+		first := len(m)
+		dedicated := plan.Cluster.Certificates.DedicatedAPIServerCert
+		// API Server certificate. The service SANs are only included when
+		// the API server does not have a dedicated serving certificate.
+		san := []string{}
+		if !dedicated {
+			var err error
+			if san, err = clusterCertsSubjectAlternateNames(plan); err != nil {
+				return nil, err
+			}
 		}
-		san = append(san, node.Host, node.IP, "127.0.0.1")
-		if node.InternalIP != "" {
-			san = append(san, node.InternalIP)
+		for _, h := range nodeHostnameSANs(plan, node) {
+			if !containsFold(h, san) {
+				san = append(san, h)
+			}
 		}
-		if !contains(plan.Master.LoadBalancedFQDN, san) {
-			san = append(san, plan.Master.LoadBalancedFQDN)
+		for _, ip := range []string{node.IP, node.InternalIP} {
+			if ip != "" && !contains(ip, san) {
+				san = append(san, ip)
+			}
 		}
-		if !contains(plan.Master.LoadBalancedShortName, san) {
-			san = append(san, plan.Master.LoadBalancedShortName)
+		if !dedicated {
+			san = appendAPIServerEndpointSANs(plan, san)
 		}
 		m = append(m, certificateSpec{
 			description:           fmt.Sprintf("%s API server", node.Host),
 			filename:              fmt.Sprintf("%s-apiserver", node.Host),
+			host:                  node.Host,
+			expiry:                node.CertValidity,
+			uris:                  uris,
 			commonName:            node.Host,
 			subjectAlternateNames: san,
 		})
@@ -157,22 +472,53 @@ func certManifestForNode(plan Plan, node Node) ([]certificateSpec, error) {
 			filename:    schedulerCertFilenamePrefix,
 			commonName:  schedulerUser,
 		})
+		// etcd client certificate used by the API server
+		m = append(m, certificateSpec{
+			description: "API server etcd client",
+			filename:    apiServerEtcdClientCertFilename,
+			commonName:  plan.apiServerEtcdClientCommonName(),
+			usages:      clientAuthUsages,
+		})
+		// Client certificate used by the API server to authenticate with the kubelets
+		m = append(m, certificateSpec{
+			description:   "API server kubelet client",
+			filename:      apiServerKubeletClientCertFilename,
+			commonName:    plan.apiServerKubeletClientCommonName(),
+			organizations: []string{plan.apiServerKubeletClientGroup()},
+			usages:        clientAuthUsages,
+		})
 		// Certificate for signing service account tokens
 		m = append(m, certificateSpec{
 			description: "service account signing",
 			filename:    serviceAccountCertFilename,
 			commonName:  serviceAccountCertCommonName,
 		})
+		for i := first; i < len(m); i++ {
+			m[i].roles = []string{"master"}
+		}
 	}
 
-	// Kubelet and kube-proxy client certificate
+	// Kubelet and kube-proxy client certificate. These are client certificates,
+	// so no default SANs are added for the worker roles.
 	if containsAny([]string{"master", "worker", "ingress", "storage"}, roles) {
-		m = append(m, certificateSpec{
+		first := len(m)
+		kubelet := certificateSpec{
 			description:   fmt.Sprintf("%s kubelet", node.Host),
 			filename:      fmt.Sprintf("%s-kubelet", node.Host),
+			host:          node.Host,
+			expiry:        node.CertValidity,
+			uris:          uris,
 			commonName:    fmt.Sprintf("%s:%s", kubeletUserPrefix, node.Host),
 			organizations: []string{kubeletGroup},
-		})
+		}
+		// Windows reports its hostname in uppercase, while the node is
+		// registered using the lowercase name. Include the names Windows
+		// might present itself with as SANs.
+		if node.Windows {
+			kubelet.commonName = fmt.Sprintf("%s:%s", kubeletUserPrefix, strings.ToLower(node.Host))
+			kubelet.subjectAlternateNames = windowsNodeSubjectAlternateNames(node)
+		}
+		m = append(m, kubelet)
 
 		m = append(m, certificateSpec{
 			description: "kube-proxy",
@@ -186,11 +532,111 @@ func certManifestForNode(plan Plan, node Node) ([]certificateSpec, error) {
 			filename:    "etcd-client",
 			commonName:  "etcd-client",
 		})
+		for i := first; i < len(m); i++ {
+			for _, r := range roles {
+				if r != "etcd" {
+					m[i].roles = append(m[i].roles, r)
+				}
+			}
+		}
 	}
 
+	applySigningProfile(plan, m)
+	applyKeyRequest(plan, m)
+	if err := setNotAfter(plan, m); err != nil {
+		return nil, err
+	}
+	if err := checkSANAllowlist(plan, m); err != nil {
+		return nil, err
+	}
+	if err := checkPublicAPIServerSANs(plan, m); err != nil {
+		return nil, err
+	}
+	if err := checkSANCount(plan, m); err != nil {
+		return nil, err
+	}
 	return m, nil
 }
 
+// returns the hostnames of the node that are included in the SANs of its
+// certificates. The alternate form of the hostname is included when node
+// short name SANs are enabled.
+func nodeHostnameSANs(plan Plan, node Node) []string {
+	names := []string{node.Host}
+	certs := plan.Cluster.Certificates
+	if !certs.NodeShortNameSANs {
+		return names
+	}
+	var alt string
+	if i := strings.Index(node.Host, "."); i > 0 {
+		alt = node.Host[:i]
+	} else if certs.NodeDomain != "" {
+		alt = node.Host + "." + strings.TrimSuffix(certs.NodeDomain, ".")
+	}
+	if alt != "" && !containsFold(alt, names) {
+		names = append(names, alt)
+	}
+	return names
+}
+
+// returns the SANs of a windows node, including its NetBIOS alias
+func windowsNodeSubjectAlternateNames(node Node) []string {
+	san := []string{}
+	for _, name := range []string{strings.ToLower(node.Host), strings.ToUpper(node.Host), node.NetBIOSName, node.IP, node.InternalIP} {
+		if name != "" && !contains(name, san) {
+			san = append(san, name)
+		}
+	}
+	return san
+}
+
+// appends the load balanced names and the extra IPs and names of the API
+// server to the SANs, skipping those that are already included
+func appendAPIServerEndpointSANs(plan Plan, san []string) []string {
+	if !contains(plan.Master.LoadBalancedFQDN, san) {
+		san = append(san, plan.Master.LoadBalancedFQDN)
+	}
+	if !contains(plan.Master.LoadBalancedShortName, san) {
+		san = append(san, plan.Master.LoadBalancedShortName)
+	}
+	for _, s := range plan.Master.APIServerExtraIPs {
+		if ip := net.ParseIP(s); ip != nil && !containsIP(ip, san) {
+			san = append(san, ip.String())
+		}
+	}
+	for _, s := range plan.Master.APIServerExtraNames {
+		if !containsFold(s, san) {
+			san = append(san, s)
+		}
+	}
+	return san
+}
+
+// returns the spec of the serving certificate shared by the API servers, when
+// the plan enables a dedicated certificate. Its SANs are the names of the
+// kubernetes service, the kubernetes service IP, the load balanced names,
+// the extra IPs and names of the API server, and the IPs of the master nodes.
+func dedicatedAPIServerCertSpec(plan Plan) (certificateSpec, error) {
+	san, err := clusterCertsSubjectAlternateNames(plan)
+	if err != nil {
+		return certificateSpec{}, err
+	}
+	san = appendAPIServerEndpointSANs(plan, san)
+	for _, n := range plan.Master.Nodes {
+		for _, ip := range []string{n.IP, n.InternalIP} {
+			if ip != "" && !contains(ip, san) {
+				san = append(san, ip)
+			}
+		}
+	}
+	return certificateSpec{
+		description:           "API server",
+		filename:              apiServerCertFilename,
+		commonName:            apiServerCertCommonName,
+		subjectAlternateNames: san,
+	}, nil
+}
+
 // returns a list of cert specs for the cluster described in the plan file
 func certManifestForCluster(plan Plan) ([]certificateSpec, error) {
 	m := []certificateSpec{}
@@ -204,19 +650,38 @@ func certManifestForCluster(plan Plan) ([]certificateSpec, error) {
 		}
 
 		// Some nodes share common certificates between them. E.g. the kube-proxy client cert.
-		// Before appending to the manifest, we ensure that this cert is not already in it.
+		// Before appending to the manifest, we ensure that this cert is not already in it,
+		// and record the roles of the node on the existing cert instead.
 		for _, s := range nodeManifest {
-			if !certSpecInManifest(s, m) {
+			i := certSpecIndex(s, m)
+			if i < 0 {
 				m = append(m, s)
+				continue
+			}
+			for _, r := range s.roles {
+				if !contains(r, m[i].roles) {
+					m[i].roles = append(m[i].roles, r)
+				}
 			}
 		}
 	}
 
+	// Serving certificate shared by the API servers. It is not part of the
+	// manifest of the master nodes, as it would be listed once per master.
+	if plan.Cluster.Certificates.DedicatedAPIServerCert {
+		spec, err := dedicatedAPIServerCertSpec(plan)
+		if err != nil {
+			return nil, err
+		}
+		spec.roles = []string{"master"}
+		m = append(m, spec)
+	}
+
 	// Certificate for docker registry
 	if plan.DockerRegistry.SetupInternal {
 		dockerRegistryNode := plan.Master.Nodes[0]
-		san := []string{dockerRegistryNode.Host, dockerRegistryNode.IP}
-		if dockerRegistryNode.InternalIP != "" {
+		san := append(nodeHostnameSANs(plan, dockerRegistryNode), dockerRegistryNode.IP)
+		if dockerRegistryNode.InternalIP != "" && !contains(dockerRegistryNode.InternalIP, san) {
 			san = append(san, dockerRegistryNode.InternalIP)
 		}
 		m = append(m, certificateSpec{
@@ -224,6 +689,7 @@ func certManifestForCluster(plan Plan) ([]certificateSpec, error) {
 			filename:              dockerRegistryCertFilename,
 			commonName:            dockerRegistryNode.Host,
 			subjectAlternateNames: san,
+			roles:                 []string{"master"},
 		})
 	}
 
@@ -233,6 +699,7 @@ func certManifestForCluster(plan Plan) ([]certificateSpec, error) {
 			description: "contiv proxy server",
 			filename:    contivProxyServerCertFilename,
 			commonName:  "auth-local.cisco.com", // using the same as contiv install script
+			roles:       []string{"master"},
 		})
 	}
 
@@ -244,70 +711,493 @@ func certManifestForCluster(plan Plan) ([]certificateSpec, error) {
 		organizations: []string{adminGroup},
 	})
 
+	// Client certificates defined in the plan
+	for _, c := range plan.Cluster.Certificates.ClientCertificates {
+		m = append(m, certificateSpec{
+			description:   fmt.Sprintf("%s client", c.Name),
+			filename:      c.Name,
+			commonName:    c.User,
+			organizations: c.Groups,
+			usages:        clientAuthUsages,
+		})
+	}
+
+	applySigningProfile(plan, m)
+	applyKeyRequest(plan, m)
+	if err := setNotAfter(plan, m); err != nil {
+		return nil, err
+	}
+	if err := checkSANAllowlist(plan, m); err != nil {
+		return nil, err
+	}
+	if err := checkPublicAPIServerSANs(plan, m); err != nil {
+		return nil, err
+	}
+	if err := checkSANCount(plan, m); err != nil {
+		return nil, err
+	}
+	if err := checkFilenameCollisions(m); err != nil {
+		return nil, err
+	}
 	return m, nil
 }
 
+// checkSANAllowlist returns an error if any of the specs has a SAN that is
+// not in the SAN allowlist of the plan. SANs are not restricted if the
+// allowlist is empty.
+func checkSANAllowlist(plan Plan, specs []certificateSpec) error {
+	allowlist := plan.Cluster.Certificates.SANAllowlist
+	if len(allowlist) == 0 {
+		return nil
+	}
+	for _, s := range specs {
+		for _, san := range s.subjectAlternateNames {
+			if !sanAllowed(san, allowlist) {
+				return fmt.Errorf("SAN %q of the certificate for %s (%s) is not in the SAN allowlist", san, s.description, s.commonName)
+			}
+		}
+	}
+	return nil
+}
+
+// the private and reserved IP ranges that are not routable on the internet
+var nonPublicIPRanges = mustParseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.0.0.0/24", "192.0.2.0/24", "192.168.0.0/16", "198.18.0.0/15", "198.51.100.0/24", "203.0.113.0/24", "240.0.0.0/4",
+	"::1/128", "fc00::/7", "fe80::/10", "2001:db8::/32",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// returns true if the IP is in a private or reserved range
+func isNonPublicIP(ip net.IP) bool {
+	for _, n := range nonPublicIPRanges {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPublicAPIServerSANs returns an error listing the private and reserved
+// IP SANs of the API server certificates when the cluster is public. The
+// loopback address and the kubernetes service IP are always accepted, as they
+// are only reachable from within the cluster.
+func checkPublicAPIServerSANs(plan Plan, specs []certificateSpec) error {
+	if !plan.Cluster.Certificates.PublicCluster {
+		return nil
+	}
+	accepted := masterDefaultSANs()
+	if ip, err := getKubernetesServiceIP(&plan); err == nil {
+		accepted = append(accepted, ip)
+	}
+	offending := []string{}
+	for _, s := range specs {
+		if s.filename != apiServerCertFilename && !strings.HasSuffix(s.filename, "-apiserver") {
+			continue
+		}
+		for _, san := range s.subjectAlternateNames {
+			ip := net.ParseIP(san)
+			if ip == nil || containsIP(ip, accepted) || !isNonPublicIP(ip) {
+				continue
+			}
+			offending = append(offending, fmt.Sprintf("%s (%s)", san, s.description))
+		}
+	}
+	if len(offending) > 0 {
+		return fmt.Errorf("the cluster is public, but the API server certificates would include the private or reserved IP SANs: %s", strings.Join(offending, ", "))
+	}
+	return nil
+}
+
+// checkSANCount returns an error if any of the specs has more SANs than the
+// maximum allowed by the plan
+func checkSANCount(plan Plan, specs []certificateSpec) error {
+	max := plan.Cluster.Certificates.maxSANs()
+	for _, s := range specs {
+		if n := len(uniqueStrings(s.subjectAlternateNames)); n > max {
+			return fmt.Errorf("the certificate for %s (%s) has %d SANs, which is more than the maximum of %d. The maximum can be raised with the max_sans certificates option", s.description, s.commonName, n, max)
+		}
+	}
+	return nil
+}
+
+// returns true if the SAN matches an IP address, CIDR block or DNS name of
+// the allowlist. DNS names are compared case-insensitively, and SANs that are
+// neither IP addresses nor DNS names are never allowed.
+func sanAllowed(san string, allowlist []string) bool {
+	ip := net.ParseIP(san)
+	if ip == nil && !isDNSName(san) {
+		return false
+	}
+	for _, a := range allowlist {
+		if ip == nil {
+			if strings.EqualFold(san, a) {
+				return true
+			}
+			continue
+		}
+		if allowedIP := net.ParseIP(a); allowedIP != nil && allowedIP.Equal(ip) {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(a); err == nil && cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns true if the name is a DNS name made of valid labels. The first
+// label can be a wildcard.
+func isDNSName(name string) bool {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".")
+	if labels[0] == "*" && len(labels) > 1 {
+		labels = labels[1:]
+	}
+	for _, l := range labels {
+		if len(l) > 63 || !dnsLabelRE.MatchString(l) {
+			return false
+		}
+	}
+	return true
+}
+
+// applySigningProfile sets the usages of the signing profile defined in the
+// plan on the specs that do not require specific usages
+func applySigningProfile(plan Plan, specs []certificateSpec) {
+	profile := plan.Cluster.Certificates.SigningProfile
+	if profile == nil {
+		return
+	}
+	for i := range specs {
+		if len(specs[i].usages) == 0 {
+			specs[i].usages = profile.Usages
+		}
+	}
+}
+
+// applyKeyRequest sets the key algorithm and size defined in the plan on the specs
+func applyKeyRequest(plan Plan, specs []certificateSpec) {
+	for i := range specs {
+		specs[i].keyRequest = plan.Cluster.Certificates.leafKeyRequest()
+	}
+}
+
+// setNotAfter sets the fixed expiry date defined in the plan on the specs
+func setNotAfter(plan Plan, specs []certificateSpec) error {
+	notAfter, err := plan.Cluster.Certificates.notAfter()
+	if err != nil {
+		return err
+	}
+	for i := range specs {
+		specs[i].notAfter = notAfter
+	}
+	return nil
+}
+
+// returns the fixed expiry date of the leaf certificates, or the zero time if not set
+func (c CertsConfig) notAfter() (time.Time, error) {
+	if c.NotAfter == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, c.NotAfter)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a valid RFC 3339 date for the certificates not after date", c.NotAfter)
+	}
+	return t, nil
+}
+
+// returns the maximum number of SANs of a certificate, using the default if not set
+func (c CertsConfig) maxSANs() int {
+	if c.MaxSANs == 0 {
+		return defaultMaxSANs
+	}
+	return c.MaxSANs
+}
+
+// returns the expiry of the leaf certificates, which is defined by the
+// signing profile if set
+func (c CertsConfig) leafExpiry() string {
+	if c.SigningProfile != nil && c.SigningProfile.Expiry != "" {
+		return c.SigningProfile.Expiry
+	}
+	return c.Expiry
+}
+
+// returns the expiry of the CA certificate, which is the default expiry of
+// the CA certificates when not set
+func (c CertsConfig) caExpiry() string {
+	if c.CAExpiry == "" {
+		return tls.DefaultCAExpiry
+	}
+	return c.CAExpiry
+}
+
+// checkFilenameCollisions returns an error if two different certificates
+// would be written to the same files, which would result in one overwriting
+// the other. File names are compared without regard to case, as certificates
+// are often copied to case-insensitive file systems.
+func checkFilenameCollisions(specs []certificateSpec) error {
+	seen := make(map[string]certificateSpec, len(specs))
+	for _, s := range specs {
+		key := strings.ToLower(s.filename)
+		if other, ok := seen[key]; ok && !other.equal(s) {
+			return fmt.Errorf("the certificates for %s and %s would both be written to the %q files. Make sure the hostnames of the nodes are unique", other.description, s.description, s.filename)
+		}
+		seen[key] = s
+	}
+	return nil
+}
+
 // CertificateAuthorityExists returns true if the CA for the cluster exists
 func (lp *LocalPKI) CertificateAuthorityExists() (bool, error) {
-	return tls.CertKeyPairExists("ca", lp.GeneratedCertsDirectory)
+	return lp.FileNames.CertKeyPairExists("ca", lp.GeneratedCertsDirectory)
 }
 
 // NodeCertificateExists returns true if the node's key and certificate exist
 func (lp *LocalPKI) NodeCertificateExists(node Node) (bool, error) {
-	return tls.CertKeyPairExists(node.Host, lp.GeneratedCertsDirectory)
+	return lp.FileNames.CertKeyPairExists(node.Host, lp.GeneratedCertsDirectory)
 }
 
 // GetClusterCA returns the cluster CA
 func (lp *LocalPKI) GetClusterCA() (*tls.CA, error) {
-	key, cert, err := tls.ReadCACert("ca", lp.GeneratedCertsDirectory)
+	if lp.CASigner != nil {
+		return lp.getExternallySignedCA()
+	}
+	if lp.DisableCAKeyPersistence {
+		return nil, errors.New("the CA private key is not persisted to disk, so the CA cannot be read back to issue certificates")
+	}
+	key, cert, err := lp.FileNames.ReadCACert("ca", lp.GeneratedCertsDirectory)
 	if err != nil {
 		return nil, fmt.Errorf("error reading CA certificate/key: %v", err)
 	}
-	return &tls.CA{
+	ca := &tls.CA{
 		Cert: cert,
 		Key:  key,
-	}, nil
+	}
+	if err := ca.VerifyKeyPair(); err != nil {
+		return nil, fmt.Errorf("invalid CA in %q: %v", lp.GeneratedCertsDirectory, err)
+	}
+	return ca, nil
+}
+
+// returns the cluster CA, which signs certificates using the CASigner
+func (lp *LocalPKI) getExternallySignedCA() (*tls.CA, error) {
+	cert, err := ioutil.ReadFile(filepath.Join(lp.GeneratedCertsDirectory, lp.FileNames.CertFile("ca")))
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA certificate: %v", err)
+	}
+	ca := &tls.CA{
+		Cert:   cert,
+		Signer: lp.CASigner,
+	}
+	if err := ca.VerifyKeyPair(); err != nil {
+		return nil, fmt.Errorf("invalid CA signer: %v", err)
+	}
+	return ca, nil
 }
 
-// GenerateClusterCA creates a Certificate Authority for the cluster
+// ExportTrustBundle writes the certificates of all the CAs in use by the
+// cluster to the writer, as a single PEM bundle. The bundle contains the
+// cluster CA, followed by the root CA when the cluster CA is an intermediate,
+// and the extra trust anchors of the plan. Private keys are never included.
+func (lp *LocalPKI) ExportTrustBundle(p *Plan, w io.Writer) error {
+	caCert, err := ioutil.ReadFile(filepath.Join(lp.GeneratedCertsDirectory, lp.FileNames.CertFile("ca")))
+	if err != nil {
+		return fmt.Errorf("error reading CA certificate: %v", err)
+	}
+	extra, err := p.Cluster.Certificates.extraTrustAnchors()
+	if err != nil {
+		return err
+	}
+	return lp.writeTrustBundle(w, caCert, extra...)
+}
+
+// writes the trust bundle of the cluster to the writer, given the
+// certificate of the cluster CA, followed by the extra certificates
+func (lp *LocalPKI) writeTrustBundle(w io.Writer, caCert []byte, extra ...*x509.Certificate) error {
+	names := []string{lp.FileNames.CertFile("ca")}
+	pems := [][]byte{caCert}
+	if lp.RootCAFile != "" {
+		b, err := ioutil.ReadFile(lp.RootCAFile)
+		if err != nil {
+			return fmt.Errorf("error reading CA certificate: %v", err)
+		}
+		names = append(names, lp.RootCAFile)
+		pems = append(pems, b)
+	}
+	var certs []*x509.Certificate
+	for i, b := range pems {
+		cs, err := helpers.ParseCertificatesPEM(b)
+		if err != nil {
+			return fmt.Errorf("error parsing CA certificate %q: %v", names[i], err)
+		}
+		certs = append(certs, cs...)
+	}
+	seen := map[string]bool{}
+	for _, c := range append(certs, extra...) {
+		if seen[string(c.Raw)] {
+			continue
+		}
+		seen[string(c.Raw)] = true
+		if err := pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}); err != nil {
+			return fmt.Errorf("error writing trust bundle: %v", err)
+		}
+	}
+	return nil
+}
+
+// GenerateClusterCA creates a Certificate Authority for the cluster. When the
+// plan provides an existing CA certificate and key, they are validated and
+// used as the CA instead.
 func (lp *LocalPKI) GenerateClusterCA(p *Plan) (*tls.CA, error) {
-	exists, err := tls.CertKeyPairExists("ca", lp.GeneratedCertsDirectory)
+	if err := lp.validateCertsDirectory(); err != nil {
+		return nil, err
+	}
+	if p.Cluster.Certificates.providedCA() {
+		return lp.useProvidedCA(p)
+	}
+	if lp.CASigner != nil {
+		// The CA's key is held by the signer, so the CA cannot be generated
+		return lp.getExternallySignedCA()
+	}
+	exists, err := lp.FileNames.CertKeyPairExists("ca", lp.GeneratedCertsDirectory)
 	if err != nil {
 		return nil, fmt.Errorf("error verifying CA certificate/key: %v", err)
 	}
 	if exists {
 		return lp.GetClusterCA()
 	}
+	if lp.DisableCAKeyPersistence {
+		_, err := os.Stat(filepath.Join(lp.GeneratedCertsDirectory, lp.FileNames.CertFile("ca")))
+		if err == nil {
+			return nil, errors.New("found an existing CA certificate, but its private key was not persisted. The CA cannot be used to issue certificates")
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error verifying CA certificate: %v", err)
+		}
+	}
 
 	// CA keypair doesn't exist, generate one
-	util.PrettyPrintOk(lp.Log, "Generating cluster Certificate Authority")
-	key, cert, err := tls.NewCACert(lp.CACsr, p.Cluster.Name, p.Cluster.Certificates.CAExpiry)
+	if strings.TrimSpace(p.Cluster.Name) == "" {
+		return nil, errors.New("the cluster name is required to generate the CA, as it is used as the common name of the CA certificate")
+	}
+	if lp.DryRun {
+		util.PrettyPrintOk(lp.Log, "Would generate cluster Certificate Authority")
+	} else {
+		util.PrettyPrintOk(lp.Log, "Generating cluster Certificate Authority")
+	}
+	var key, cert []byte
+	certs := p.Cluster.Certificates
+	var req csr.CertificateRequest
+	if certs.CACSR != nil {
+		req = certs.CACSR.certificateRequest()
+	} else if req, err = tls.ReadCSRFile(lp.CACsr); err != nil {
+		return nil, fmt.Errorf("failed to read CA CSR: %v", err)
+	}
+	if kr := certs.caKeyRequest(); kr != nil {
+		req.KeyRequest = kr
+	}
+	caOpts := certs.caOptions()
+	if lp.SerialBits != 0 {
+		if caOpts.Serial, err = tls.RandomSerial(lp.Rand, lp.SerialBits); err != nil {
+			return nil, fmt.Errorf("error getting serial number for the CA: %v", err)
+		}
+	}
+	key, cert, err = lp.generator().NewCA(req, p.Cluster.Name, certs.caExpiry(), caOpts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create CA Cert: %v", err)
 	}
-	if err = tls.WriteCert(key, cert, "ca", lp.GeneratedCertsDirectory); err != nil {
+	if err := (&tls.CA{Cert: cert, Key: key}).VerifyKeyPair(); err != nil {
+		return nil, fmt.Errorf("generated CA is invalid: %v", err)
+	}
+	if lp.DryRun {
+		// The CA is only kept in memory
+		return &tls.CA{
+			Cert: cert,
+			Key:  key,
+		}, nil
+	}
+	persistedKey := key
+	if lp.DisableCAKeyPersistence {
+		persistedKey = nil
+	}
+	if err = lp.writeCert(persistedKey, cert, "ca"); err != nil {
 		return nil, fmt.Errorf("error writing CA files: %v", err)
 	}
-	return &tls.CA{
+	ca := &tls.CA{
 		Cert: cert,
 		Key:  key,
-	}, nil
+	}
+	if lp.DisableCAKeyPersistence {
+		lp.generatedCA = ca
+	}
+	return ca, nil
+}
+
+// GeneratedCA returns the CA that was generated when DisableCAKeyPersistence
+// is set, as its key is not written to disk. Returns nil if a CA has not been
+// generated.
+func (lp *LocalPKI) GeneratedCA() *tls.CA {
+	return lp.generatedCA
 }
 
 // GenerateClusterCertificates creates all certificates required for the cluster
-// described in the plan file.
+// described in the plan file. Existing certificates that match the plan, have
+// not expired and are signed by the CA are kept, so that generating the
+// certificates of a running cluster again does not replace them. Expired
+// certificates, and certificates signed by another CA, are issued again.
 func (lp *LocalPKI) GenerateClusterCertificates(p *Plan, ca *tls.CA) error {
 	if lp.Log == nil {
 		lp.Log = ioutil.Discard
 	}
 
+	if err := lp.validateCertsDirectory(); err != nil {
+		return err
+	}
+	if err := lp.validateSigningProfile(p); err != nil {
+		return err
+	}
+	if err := lp.validateRoles(); err != nil {
+		return err
+	}
+
 	manifest, err := certManifestForCluster(*p)
 	if err != nil {
 		return err
 	}
+	lp.warnUnresolvedHostnames(p)
 
-	for _, s := range manifest {
-		exists, err := tls.CertKeyPairExists(s.filename, lp.GeneratedCertsDirectory)
+	if lp.DryRun {
+		return lp.logDryRun(lp.specsForRoles(manifest))
+	}
+	if lp.Checksums {
+		if _, err := lp.verifyChecksums(); err != nil {
+			return err
+		}
+	}
+	if err := lp.tagClusterUID(p, manifest); err != nil {
+		return err
+	}
+	lp.tagIssuanceMetadata(p, manifest)
+
+	if err := lp.runHook("pre-generation", lp.PreHook); err != nil {
+		return err
+	}
+
+	missing := []certificateSpec{}
+	for _, s := range lp.specsForRoles(manifest) {
+		exists, err := lp.FileNames.CertKeyPairExists(s.filename, lp.GeneratedCertsDirectory)
 		if err != nil {
 			return err
 		}
@@ -315,7 +1205,7 @@ func (lp *LocalPKI) GenerateClusterCertificates(p *Plan, ca *tls.CA) error {
 		// Pre-existing admin certificates from KET < 1.3.3 are not valid
 		// due to changes required for RBAC. Rename it if necessary.
 		if exists && s.filename == adminCertFilenameKETPre133 {
-			ok, err := renamePre133AdminCert(s.filename, lp.GeneratedCertsDirectory)
+			ok, err := renamePre133AdminCert(s.filename, lp.GeneratedCertsDirectory, lp.FileNames)
 			if err != nil {
 				return err
 			}
@@ -327,7 +1217,7 @@ func (lp *LocalPKI) GenerateClusterCertificates(p *Plan, ca *tls.CA) error {
 		}
 
 		if exists {
-			warnings, err := tls.CertValid(s.commonName, s.subjectAlternateNames, s.organizations, s.filename, lp.GeneratedCertsDirectory)
+			warnings, err := lp.FileNames.CertValid(s.commonName, s.subjectAlternateNames, s.organizations, s.filename, lp.GeneratedCertsDirectory)
 			if err != nil {
 				return err
 			}
@@ -336,36 +1226,382 @@ func (lp *LocalPKI) GenerateClusterCertificates(p *Plan, ca *tls.CA) error {
 				util.PrintValidationErrors(lp.Log, warnings)
 				return fmt.Errorf("invalid certificate found for %q", s.description)
 			}
+			reason, err := lp.reissueReason(ca, s)
+			if err != nil {
+				return err
+			}
+			if reason != "" {
+				util.PrettyPrintWarn(lp.Log, "Found certificate for %s, but %s. Regenerating", s.description, reason)
+				if s.key, err = lp.reusableKey(s); err != nil {
+					return err
+				}
+				missing = append(missing, s)
+				continue
+			}
 			// This cert is valid, move onto the next certificate
 			util.PrettyPrintOk(lp.Log, "Found valid certificate for %s", s.description)
 			continue
 		}
 
 		// Cert doesn't exist. Generate it
-		if err := generateCert(ca, lp.GeneratedCertsDirectory, s, p.Cluster.Certificates.Expiry); err != nil {
-			return err
-		}
-		util.PrettyPrintOk(lp.Log, "Generated certificate for %s", s.description)
+		missing = append(missing, s)
+	}
+	err = lp.generateCerts(ca, missing, p.Cluster.Certificates.leafExpiry())
+	writeFailures, degraded := err.(CertWriteFailuresErr)
+	if err != nil && !degraded {
+		return err
+	}
+	failed := map[string]bool{}
+	for _, f := range writeFailures.Failures {
+		failed[f.Name] = true
+	}
+
+	if err := lp.generateBootstrapToken(p); err != nil {
+		return err
+	}
+	if err := lp.writeCAConfigMap(p, ca.Cert); err != nil {
+		return err
+	}
+	previous, err := lp.readManifest()
+	if err != nil {
+		return err
+	}
+	if err := lp.writeManifest(p, manifest, failed); err != nil {
+		return err
+	}
+	if err := lp.handleOrphanedCerts(previous, manifest); err != nil {
+		return err
+	}
+	if err := lp.updateChecksums(); err != nil {
+		return err
+	}
+	if degraded {
+		// The post-generation hook is only run if all certificates were generated
+		return writeFailures
+	}
+	return lp.runHook("post-generation", lp.PostHook)
+}
+
+// validateRoles returns an error if the PKI is restricted to an unknown role
+func (lp *LocalPKI) validateRoles() error {
+	for _, r := range lp.Roles {
+		if !contains(r, nodeRoles) {
+			return fmt.Errorf("role %q is invalid. Options are %v", r, nodeRoles)
+		}
 	}
 	return nil
 }
 
+// specsForRoles returns the specs of the certificates used by the roles the
+// PKI is restricted to, or all the specs if it is not restricted.
+func (lp *LocalPKI) specsForRoles(specs []certificateSpec) []certificateSpec {
+	if len(lp.Roles) == 0 {
+		return specs
+	}
+	selected := []certificateSpec{}
+	for _, s := range specs {
+		if containsAny(lp.Roles, s.roles) {
+			selected = append(selected, s)
+		}
+	}
+	return selected
+}
+
+// logDryRun logs the certificates of the manifest that would be generated
+func (lp *LocalPKI) logDryRun(manifest []certificateSpec) error {
+	for _, s := range manifest {
+		exists, err := lp.FileNames.CertKeyPairExists(s.filename, lp.GeneratedCertsDirectory)
+		if err != nil {
+			return err
+		}
+		if exists {
+			util.PrettyPrintSkipped(lp.Log, "Found existing certificate for %s", s.description)
+		} else {
+			util.PrettyPrintOk(lp.Log, "Would generate certificate for %s", s.description)
+		}
+		if sans := uniqueStrings(s.subjectAlternateNames); len(sans) > 0 {
+			fmt.Fprintf(lp.Log, "    SANs: %s\n", strings.Join(sans, ", "))
+		}
+	}
+	return nil
+}
+
+// RotateLeafCerts regenerates all the certificates of the cluster described
+// in the plan using the existing cluster CA, which is never regenerated.
+// Existing certificates are overwritten with new ones that have a fresh
+// validity period. Only the certificates of the Roles are rotated, if set.
+func (lp *LocalPKI) RotateLeafCerts(p *Plan) error {
+	if lp.Log == nil {
+		lp.Log = ioutil.Discard
+	}
+	if err := lp.validateSigningProfile(p); err != nil {
+		return err
+	}
+	if err := lp.validateRoles(); err != nil {
+		return err
+	}
+	ca, err := lp.GetClusterCA()
+	if err != nil {
+		return err
+	}
+	manifest, err := certManifestForCluster(*p)
+	if err != nil {
+		return err
+	}
+	if err := lp.tagClusterUID(p, manifest); err != nil {
+		return err
+	}
+	lp.tagIssuanceMetadata(p, manifest)
+	if err := lp.runHook("pre-generation", lp.PreHook); err != nil {
+		return err
+	}
+	for _, s := range lp.specsForRoles(manifest) {
+		if err := lp.generateCert(ca, s, p.Cluster.Certificates.leafExpiry()); err != nil {
+			return err
+		}
+		util.PrettyPrintOk(lp.Log, "Rotated certificate for %s", s.description)
+	}
+	if err := lp.writeManifest(p, manifest, nil); err != nil {
+		return err
+	}
+	if err := lp.updateChecksums(); err != nil {
+		return err
+	}
+	return lp.runHook("post-generation", lp.PostHook)
+}
+
+// RenewClusterCerts regenerates the certificates of the cluster described in
+// the plan that expire within the threshold, or that do not exist, using the
+// existing cluster CA. Certificates that remain valid for longer than the
+// threshold are left untouched. The private keys of the renewed certificates
+// are reused, unless RotateKeys is set. Only the certificates of the Roles
+// are renewed, if set. Returns the names of the renewed certificates.
+func (lp *LocalPKI) RenewClusterCerts(p *Plan, expiryThreshold time.Duration) ([]string, error) {
+	if lp.Log == nil {
+		lp.Log = ioutil.Discard
+	}
+	if expiryThreshold < 0 {
+		return nil, fmt.Errorf("the expiry threshold %s cannot be negative", expiryThreshold)
+	}
+	if err := lp.validateSigningProfile(p); err != nil {
+		return nil, err
+	}
+	if err := lp.validateRoles(); err != nil {
+		return nil, err
+	}
+	ca, err := lp.GetClusterCA()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := certManifestForCluster(*p)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now
+	if lp.Now != nil {
+		now = lp.Now
+	}
+	caCert, err := helpers.ParseCertificatePEM(ca.Cert)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing CA certificate: %v", err)
+	}
+	if caCert.NotAfter.Sub(now()) < expiryThreshold {
+		util.PrettyPrintWarn(lp.Log, "The cluster CA expires on %s, and must be renewed separately", caCert.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	if err := lp.tagClusterUID(p, manifest); err != nil {
+		return nil, err
+	}
+	lp.tagIssuanceMetadata(p, manifest)
+	expiring := []certificateSpec{}
+	for _, s := range lp.specsForRoles(manifest) {
+		cert, err := lp.FileNames.ReadCert(s.filename, lp.GeneratedCertsDirectory)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error reading certificate for %q: %v", s.description, err)
+		}
+		if err == nil && cert.NotAfter.Sub(now()) >= expiryThreshold {
+			continue
+		}
+		expiring = append(expiring, s)
+	}
+	if len(expiring) == 0 {
+		util.PrettyPrintOk(lp.Log, "No certificates expire within %s", expiryThreshold)
+		return nil, nil
+	}
+
+	if err := lp.runHook("pre-generation", lp.PreHook); err != nil {
+		return nil, err
+	}
+	renewed := make([]string, 0, len(expiring))
+	for _, s := range expiring {
+		if err := lp.generateCert(ca, s, p.Cluster.Certificates.leafExpiry()); err != nil {
+			return renewed, err
+		}
+		util.PrettyPrintOk(lp.Log, "Renewed certificate for %s", s.description)
+		renewed = append(renewed, s.filename)
+	}
+	if err := lp.writeManifest(p, manifest, nil); err != nil {
+		return renewed, err
+	}
+	if err := lp.updateChecksums(); err != nil {
+		return renewed, err
+	}
+	return renewed, lp.runHook("post-generation", lp.PostHook)
+}
+
+// RegenerateCert regenerates the certificate with the given name, such as
+// "admin" or "master01-apiserver", using the existing cluster CA. Other
+// certificates are left untouched. An error listing the valid names is
+// returned if the plan does not define a certificate with the given name.
+func (lp *LocalPKI) RegenerateCert(p *Plan, name string) error {
+	if lp.Log == nil {
+		lp.Log = ioutil.Discard
+	}
+	spec, err := lp.clusterCertSpec(p, name)
+	if err != nil {
+		return err
+	}
+	ca, err := lp.GetClusterCA()
+	if err != nil {
+		return err
+	}
+	if err := lp.generateCert(ca, spec, p.Cluster.Certificates.leafExpiry()); err != nil {
+		return err
+	}
+	if err := lp.updateManifestEntry(lp.manifestEntry(spec.filename, spec.description)); err != nil {
+		return err
+	}
+	if err := lp.updateChecksums(); err != nil {
+		return err
+	}
+	util.PrettyPrintOk(lp.Log, "Regenerated certificate for %s", spec.description)
+	return nil
+}
+
+// WriteCertTo generates the certificate with the given name, such as "admin"
+// or "master01-apiserver", and writes the PEM encoded key and certificate to
+// the provided writers instead of the certificates directory. Nothing is
+// written to disk, so existing certificates are left untouched.
+func (lp *LocalPKI) WriteCertTo(p *Plan, name string, ca *tls.CA, keyOut, certOut io.Writer) error {
+	if ca == nil {
+		return fmt.Errorf("ca cannot be nil")
+	}
+	spec, err := lp.clusterCertSpec(p, name)
+	if err != nil {
+		return err
+	}
+	key, cert, err := lp.newCert(ca, spec, p.Cluster.Certificates.leafExpiry())
+	if err != nil {
+		return err
+	}
+	if _, err := keyOut.Write(key); err != nil {
+		return fmt.Errorf("error writing key for %q: %v", spec.description, err)
+	}
+	if _, err := certOut.Write(cert); err != nil {
+		return fmt.Errorf("error writing cert for %q: %v", spec.description, err)
+	}
+	return nil
+}
+
+// WriteCertWithValidityTo generates the certificate with the given name, like
+// WriteCertTo, but for the exact validity period between notBefore and
+// notAfter. The validity period can be in the past or in the future, so that
+// expired or not yet valid certificates can be issued on demand.
+//
+// This is meant for testing how the components handle the expiry and
+// rotation of their certificates. The expiry, the fixed expiry date and the
+// clock skew of the plan are ignored, and the certificate is only written to
+// the provided writers, so it never replaces a certificate of the cluster.
+func (lp *LocalPKI) WriteCertWithValidityTo(p *Plan, name string, ca *tls.CA, notBefore, notAfter time.Time, keyOut, certOut io.Writer) error {
+	if ca == nil {
+		return fmt.Errorf("ca cannot be nil")
+	}
+	if notBefore.IsZero() || notAfter.IsZero() {
+		return fmt.Errorf("both the start and the end of the validity period are required")
+	}
+	if !notAfter.After(notBefore) {
+		return fmt.Errorf("the end of the validity period %s must be after its start %s", notAfter.UTC().Format(time.RFC3339), notBefore.UTC().Format(time.RFC3339))
+	}
+	spec, err := lp.clusterCertSpec(p, name)
+	if err != nil {
+		return err
+	}
+	spec.notBefore = notBefore
+	spec.notAfter = notAfter
+	spec.explicitValidity = true
+	key, cert, err := lp.newCert(ca, spec, p.Cluster.Certificates.leafExpiry())
+	if err != nil {
+		return err
+	}
+	if _, err := keyOut.Write(key); err != nil {
+		return fmt.Errorf("error writing key for %q: %v", spec.description, err)
+	}
+	if _, err := certOut.Write(cert); err != nil {
+		return fmt.Errorf("error writing cert for %q: %v", spec.description, err)
+	}
+	return nil
+}
+
+// returns the spec of the certificate with the given name. An error listing
+// the valid names is returned if the plan does not define the certificate.
+func (lp *LocalPKI) clusterCertSpec(p *Plan, name string) (certificateSpec, error) {
+	if err := lp.validateSigningProfile(p); err != nil {
+		return certificateSpec{}, err
+	}
+	manifest, err := certManifestForCluster(*p)
+	if err != nil {
+		return certificateSpec{}, err
+	}
+	if err := lp.tagClusterUID(p, manifest); err != nil {
+		return certificateSpec{}, err
+	}
+	lp.tagIssuanceMetadata(p, manifest)
+	specs := specsByFilename(manifest)
+	spec, ok := specs[name]
+	if !ok {
+		names := make([]string, 0, len(specs))
+		for n := range specs {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return certificateSpec{}, fmt.Errorf("unknown certificate %q, valid names are: %s", name, strings.Join(names, ", "))
+	}
+	return spec, nil
+}
+
+// runHook runs the given hook command, if any. The certificates directory
+// and the manifest path are exposed to the command through the
+// KISMATIC_CERTS_DIR and KISMATIC_CERTS_MANIFEST environment variables.
+func (lp *LocalPKI) runHook(name string, hook []string) error {
+	if len(hook) == 0 {
+		return nil
+	}
+	cmd := exec.Command(hook[0], hook[1:]...)
+	cmd.Env = append(os.Environ(),
+		"KISMATIC_CERTS_DIR="+lp.GeneratedCertsDirectory,
+		"KISMATIC_CERTS_MANIFEST="+lp.manifestPath(),
+	)
+	cmd.Stdout = lp.Log
+	cmd.Stderr = lp.Log
+	if err := cmd.Run(); err != nil {
+		util.PrettyPrintErr(lp.Log, "Running %s hook %q", name, strings.Join(hook, " "))
+		return fmt.Errorf("error running %s hook: %v", name, err)
+	}
+	util.PrettyPrintOk(lp.Log, "Running %s hook %q", name, strings.Join(hook, " "))
+	return nil
+}
+
 // Validates that the certificate was generated by us. If so, renames it
 // to make a backup and returns true. Otherwise returns false.
-func renamePre133AdminCert(filename, dir string) (bool, error) {
-	cert, err := tls.ReadCert(filename, dir)
+func renamePre133AdminCert(filename, dir string, fileNames tls.FileNameScheme) (bool, error) {
+	cert, err := fileNames.ReadCert(filename, dir)
 
 	if err != nil {
 		return false, fmt.Errorf("error reading admin certificate: %v", err)
 	}
 	// Ensure it was generated by us
-	if len(cert.Subject.Organization) == 1 && cert.Subject.Organization[0] == "Apprenda" &&
-		len(cert.Subject.OrganizationalUnit) == 1 && cert.Subject.OrganizationalUnit[0] == "Kismatic" &&
-		len(cert.Subject.Country) == 1 && cert.Subject.Country[0] == "US" &&
-		len(cert.Subject.Province) == 1 && cert.Subject.Province[0] == "NY" &&
-		len(cert.Subject.Locality) == 1 && cert.Subject.Locality[0] == "Troy" {
-
-		certFile := filepath.Join(dir, filename+".pem")
+	if isPre133AdminCert(cert) {
+		certFile := filepath.Join(dir, fileNames.CertFile(filename))
 		if err = os.Rename(certFile, certFile+".bak"); err != nil {
 			return false, fmt.Errorf("error backing up existing admin certificate: %v", err)
 		}
@@ -374,6 +1610,15 @@ func renamePre133AdminCert(filename, dir string) (bool, error) {
 	return false, nil
 }
 
+// returns true if the certificate is an admin certificate generated by KET < 1.3.3
+func isPre133AdminCert(cert *x509.Certificate) bool {
+	return len(cert.Subject.Organization) == 1 && cert.Subject.Organization[0] == "Apprenda" &&
+		len(cert.Subject.OrganizationalUnit) == 1 && cert.Subject.OrganizationalUnit[0] == "Kismatic" &&
+		len(cert.Subject.Country) == 1 && cert.Subject.Country[0] == "US" &&
+		len(cert.Subject.Province) == 1 && cert.Subject.Province[0] == "NY" &&
+		len(cert.Subject.Locality) == 1 && cert.Subject.Locality[0] == "Troy"
+}
+
 // ValidateClusterCertificates validates any certificates that already exist
 // in the expected directory.
 func (lp *LocalPKI) ValidateClusterCertificates(p *Plan) (warns []error, errs []error) {
@@ -385,7 +1630,7 @@ func (lp *LocalPKI) ValidateClusterCertificates(p *Plan) (warns []error, errs []
 		return nil, []error{err}
 	}
 	for _, s := range manifest {
-		exists, err := tls.CertKeyPairExists(s.filename, lp.GeneratedCertsDirectory)
+		exists, err := lp.FileNames.CertKeyPairExists(s.filename, lp.GeneratedCertsDirectory)
 		if err != nil {
 			errs = append(errs, err)
 			continue
@@ -393,7 +1638,7 @@ func (lp *LocalPKI) ValidateClusterCertificates(p *Plan) (warns []error, errs []
 		if !exists {
 			continue // nothing to validate... move on
 		}
-		warn, err := tls.CertValid(s.commonName, s.subjectAlternateNames, s.organizations, s.filename, lp.GeneratedCertsDirectory)
+		warn, err := lp.FileNames.CertValid(s.commonName, s.subjectAlternateNames, s.organizations, s.filename, lp.GeneratedCertsDirectory)
 		if err != nil {
 			errs = append(errs, err)
 		}
@@ -406,17 +1651,24 @@ func (lp *LocalPKI) ValidateClusterCertificates(p *Plan) (warns []error, errs []
 
 // GenerateNodeCertificate creates a private key and certificate for the given node
 func (lp *LocalPKI) GenerateNodeCertificate(plan *Plan, node Node, ca *tls.CA) error {
+	if err := lp.validateSigningProfile(plan); err != nil {
+		return err
+	}
 	m, err := certManifestForNode(*plan, node)
 	if err != nil {
 		return err
 	}
+	if err := lp.tagClusterUID(plan, m); err != nil {
+		return err
+	}
+	lp.tagIssuanceMetadata(plan, m)
 	for _, s := range m {
-		exists, err := tls.CertKeyPairExists(s.filename, lp.GeneratedCertsDirectory)
+		exists, err := lp.FileNames.CertKeyPairExists(s.filename, lp.GeneratedCertsDirectory)
 		if err != nil {
 			return err
 		}
 		if exists {
-			warn, err := tls.CertValid(s.commonName, s.subjectAlternateNames, s.organizations, s.filename, lp.GeneratedCertsDirectory)
+			warn, err := lp.FileNames.CertValid(s.commonName, s.subjectAlternateNames, s.organizations, s.filename, lp.GeneratedCertsDirectory)
 			if err != nil {
 				return err
 			}
@@ -425,12 +1677,19 @@ func (lp *LocalPKI) GenerateNodeCertificate(plan *Plan, node Node, ca *tls.CA) e
 				util.PrintValidationErrors(lp.Log, warn)
 				return fmt.Errorf("invalid certificate found for %q", s.description)
 			}
-			// This cert is valid, move on
-			util.PrettyPrintOk(lp.Log, "Found valid certificate for %s", s.description)
-			continue
+			reason, err := lp.reissueReason(ca, s)
+			if err != nil {
+				return err
+			}
+			if reason == "" {
+				// This cert is valid, move on
+				util.PrettyPrintOk(lp.Log, "Found valid certificate for %s", s.description)
+				continue
+			}
+			util.PrettyPrintWarn(lp.Log, "Found certificate for %s, but %s. Regenerating", s.description, reason)
 		}
-		// Cert doesn't exist. Generate it
-		if err := generateCert(ca, lp.GeneratedCertsDirectory, s, plan.Cluster.Certificates.Expiry); err != nil {
+		// Cert doesn't exist, or cannot be used. Generate it
+		if err := lp.generateCert(ca, s, plan.Cluster.Certificates.leafExpiry()); err != nil {
 			return err
 		}
 		util.PrettyPrintOk(lp.Log, "Generated certificate for %s", s.description)
@@ -451,7 +1710,10 @@ func (lp *LocalPKI) GenerateCertificate(name string, validityPeriod string, comm
 	if ca == nil {
 		return false, fmt.Errorf("ca cannot be nil")
 	}
-	exists, err := tls.CertKeyPairExists(name, lp.GeneratedCertsDirectory)
+	if err := lp.validateSigningProfile(nil); err != nil {
+		return false, err
+	}
+	exists, err := lp.FileNames.CertKeyPairExists(name, lp.GeneratedCertsDirectory)
 	if err != nil {
 		return false, fmt.Errorf("could not determine if certificate for %s exists: %v", name, err)
 	}
@@ -469,24 +1731,195 @@ func (lp *LocalPKI) GenerateCertificate(name string, validityPeriod string, comm
 		organizations:         organizations,
 	}
 
-	if err := generateCert(ca, lp.GeneratedCertsDirectory, spec, validityPeriod); err != nil {
+	if err := lp.generateCert(ca, spec, validityPeriod); err != nil {
 		return exists, fmt.Errorf("could not generate certificate %s: %v", name, err)
 	}
 
 	return exists, nil
 }
 
-func generateCert(ca *tls.CA, certDir string, spec certificateSpec, expiryStr string) error {
+// returns the number of certificates that can be generated concurrently
+func (lp *LocalPKI) concurrency() int {
+	// Rand and SerialNumber are not safe for concurrent use, and certificates
+	// must be generated in order to be reproducible
+	if lp.Rand != nil || lp.SerialNumber != nil {
+		return 1
+	}
+	if lp.Concurrency < 1 {
+		return runtime.NumCPU()
+	}
+	return lp.Concurrency
+}
+
+// generateCerts generates the certificates for the given specs, using up to
+// lp.concurrency() goroutines to create the keys and sign the certificates.
+// Certificates are written and logged in the order of the specs, and the
+// error of the first spec that failed is returned. Write failures are
+// collected in a CertWriteFailuresErr instead if ContinueOnWriteFailure is set.
+func (lp *LocalPKI) generateCerts(ca *tls.CA, specs []certificateSpec, expiryStr string) error {
+	failures := []CertWriteFailure{}
+	write := func(key, cert []byte, s certificateSpec) error {
+		err := lp.writeCert(key, cert, s.filename)
+		if err == nil {
+			if err := lp.recordIssuance(cert); err != nil {
+				return err
+			}
+			util.PrettyPrintOk(lp.Log, "Generated certificate for %s", s.description)
+			return nil
+		}
+		if !lp.ContinueOnWriteFailure {
+			return fmt.Errorf("error writing cert for %q: %v", s.description, err)
+		}
+		util.PrettyPrintErr(lp.Log, "Failed to write certificate for %s: %v", s.description, err)
+		failures = append(failures, CertWriteFailure{Name: s.filename, Err: err})
+		return nil
+	}
+	workers := lp.concurrency()
+	if workers == 1 {
+		for _, s := range specs {
+			key, cert, err := lp.newCert(ca, s, expiryStr)
+			if err != nil {
+				return err
+			}
+			if err := write(key, cert, s); err != nil {
+				return err
+			}
+		}
+		return writeFailuresErr(failures)
+	}
+	type result struct {
+		key, cert []byte
+		err       error
+	}
+	results := make([]result, len(specs))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, s := range specs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, s certificateSpec) {
+			defer wg.Done()
+			key, cert, err := lp.newCert(ca, s, expiryStr)
+			results[i] = result{key: key, cert: cert, err: err}
+			<-sem
+		}(i, s)
+	}
+	wg.Wait()
+	for i, s := range specs {
+		r := results[i]
+		if r.err != nil {
+			return r.err
+		}
+		if err := write(r.key, r.cert, s); err != nil {
+			return err
+		}
+	}
+	return writeFailuresErr(failures)
+}
+
+// returns a CertWriteFailuresErr if there are any failures, or nil otherwise
+func writeFailuresErr(failures []CertWriteFailure) error {
+	if len(failures) == 0 {
+		return nil
+	}
+	return CertWriteFailuresErr{Failures: failures}
+}
+
+func (lp *LocalPKI) generateCert(ca *tls.CA, spec certificateSpec, expiryStr string) error {
+	existingKey, err := lp.reusableKey(spec)
+	if err != nil {
+		return err
+	}
+	spec.key = existingKey
+	key, cert, err := lp.newCert(ca, spec, expiryStr)
+	if err != nil {
+		return err
+	}
+	if existingKey != nil {
+		// Only the certificate is rewritten when the key is reused
+		key = nil
+	}
+	if err = lp.writeCert(key, cert, spec.filename); err != nil {
+		return fmt.Errorf("error writing cert for %q: %v", spec.description, err)
+	}
+	return lp.recordIssuance(cert)
+}
+
+// reusableKey returns the existing private key of the certificate, unless
+// RotateKeys is set, or the key does not match the key request of the leaf
+// certificates. Returns nil if a new key has to be generated.
+func (lp *LocalPKI) reusableKey(spec certificateSpec) ([]byte, error) {
+	if lp.RotateKeys {
+		return nil, nil
+	}
+	key, err := ioutil.ReadFile(filepath.Join(lp.GeneratedCertsDirectory, lp.FileNames.KeyFile(spec.filename)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading private key for %q: %v", spec.description, err)
+	}
+	kr := spec.leafKeyRequest()
+	if ok, err := tls.KeyMatches(key, kr.A, kr.S); err != nil || !ok {
+		if lp.Log != nil {
+			util.PrettyPrintWarn(lp.Log, "The existing private key for %s does not match the key configuration, generating a new key", spec.description)
+		}
+		return nil, nil
+	}
+	return key, nil
+}
+
+// reissueReason returns why the existing certificate of the spec must be
+// issued again, even though its names match the spec: it has expired, or it
+// is not signed by the CA. Returns an empty string if the certificate can be
+// kept as is.
+func (lp *LocalPKI) reissueReason(ca *tls.CA, spec certificateSpec) (string, error) {
+	cert, err := lp.FileNames.ReadCert(spec.filename, lp.GeneratedCertsDirectory)
+	if err != nil {
+		return "", fmt.Errorf("error reading certificate for %q: %v", spec.description, err)
+	}
+	now := time.Now()
+	if lp.Now != nil {
+		now = lp.Now()
+	}
+	if !now.Before(cert.NotAfter) {
+		return fmt.Sprintf("it expired on %s", cert.NotAfter.UTC().Format(time.RFC3339)), nil
+	}
+	if ca == nil || len(ca.Cert) == 0 {
+		return "", nil
+	}
+	caCert, err := helpers.ParseCertificatePEM(ca.Cert)
+	if err != nil {
+		return "", fmt.Errorf("error parsing CA certificate: %v", err)
+	}
+	if err := cert.CheckSignatureFrom(caCert); err != nil {
+		return "it is not signed by the cluster CA", nil
+	}
+	return "", nil
+}
+
+// returns the key request of the certificate, or the key request of an RSA
+// 2048 key if the spec does not define one
+func (s certificateSpec) leafKeyRequest() *csr.BasicKeyRequest {
+	if s.keyRequest != nil {
+		return s.keyRequest
+	}
+	return keyRequest("", 0)
+}
+
+// newCert returns the key and certificate for the given spec, signed by the
+// CA. The key of the spec is reused if set, and a new key is generated otherwise.
+func (lp *LocalPKI) newCert(ca *tls.CA, spec certificateSpec, expiryStr string) (key, cert []byte, err error) {
+	if spec.expiry != "" {
+		expiryStr = spec.expiry
+	}
 	expiry, err := time.ParseDuration(expiryStr)
 	if err != nil {
-		return fmt.Errorf("%q is not a valid duration for certificate expiry", expiryStr)
+		return nil, nil, fmt.Errorf("%q is not a valid duration for certificate expiry", expiryStr)
 	}
 	req := csr.CertificateRequest{
-		CN: spec.commonName,
-		KeyRequest: &csr.BasicKeyRequest{
-			A: "rsa",
-			S: 2048,
-		},
+		CN:         spec.commonName,
+		KeyRequest: spec.leafKeyRequest(),
 	}
 
 	if len(spec.subjectAlternateNames) > 0 {
@@ -497,31 +1930,358 @@ func generateCert(ca *tls.CA, certDir string, spec certificateSpec, expiryStr st
 		name := csr.Name{O: org}
 		req.Names = append(req.Names, name)
 	}
+	if spec.clusterUID != "" {
+		req.Names = append(req.Names, csr.Name{OU: clusterUIDPrefix + spec.clusterUID})
+	}
 
-	key, cert, err := tls.NewCert(ca, req, expiry)
+	signingCA := *ca
+	signingCA.Signer = lp.retryingSigner(ca.Signer, spec)
+	if lp.CAConfigFile != "" {
+		signingCA.ConfigFile = lp.CAConfigFile
+		signingCA.Profile = lp.CASigningProfile
+	}
+	opts := tls.CertOptions{
+		Expiry: expiry,
+		Usages: spec.usages,
+		Rand:   lp.Rand,
+		Key:    spec.key,
+		URIs:   spec.uris,
+	}
+	// ECDSA keys cannot be used for key encipherment, which is one of the
+	// usages of the client and serving certificates, and of the signing
+	// defaults. The usages of a CA config file are validated instead.
+	if kr := spec.leafKeyRequest(); kr.A != "rsa" && (len(opts.Usages) > 0 || lp.CAConfigFile == "") {
+		opts.Usages = tls.UsagesForKeyAlgorithm(opts.Usages, kr.A)
+	}
+	if lp.Now != nil {
+		opts.NotBefore = lp.Now()
+	}
+	if spec.explicitValidity {
+		opts.NotBefore = spec.notBefore
+		opts.NotAfter = spec.notAfter
+	} else if lp.ClockSkew > 0 {
+		if opts.NotBefore.IsZero() {
+			opts.NotBefore = time.Now()
+		}
+		opts.NotBefore = opts.NotBefore.Add(-lp.ClockSkew)
+		opts.Expiry += lp.ClockSkew
+	}
+	if !spec.notAfter.IsZero() && !spec.explicitValidity {
+		now := time.Now()
+		if lp.Now != nil {
+			now = lp.Now()
+		}
+		if !spec.notAfter.After(now) {
+			return nil, nil, fmt.Errorf("the certificate for %q cannot be generated, as its fixed expiry date %s has already passed", spec.description, spec.notAfter.UTC().Format(time.RFC3339))
+		}
+		opts.NotAfter = spec.notAfter
+	}
+	if lp.SerialNumber != nil {
+		if opts.Serial, err = lp.SerialNumber(); err != nil {
+			return nil, nil, fmt.Errorf("error getting serial number for %q: %v", spec.description, err)
+		}
+	} else if lp.SerialBits != 0 {
+		if opts.Serial, err = tls.RandomSerial(lp.Rand, lp.SerialBits); err != nil {
+			return nil, nil, fmt.Errorf("error getting serial number for %q: %v", spec.description, err)
+		}
+	}
+	if lp.IssuanceMetadataOID != "" {
+		issuedAt := time.Now()
+		if lp.Now != nil {
+			issuedAt = lp.Now()
+		}
+		ext, err := lp.issuanceMetadataExtension(spec, issuedAt)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts.Extensions = append(opts.Extensions, ext)
+	}
+	stop := lp.warnOnSlowKeyGeneration(spec)
+	key, cert, err = lp.generator().Sign(&signingCA, req, opts)
+	stop()
 	if err != nil {
-		return fmt.Errorf("error generating certs for %q: %v", spec.description, err)
+		return nil, nil, fmt.Errorf("error generating certs for %q: %v", spec.description, err)
 	}
-	if err = tls.WriteCert(key, cert, spec.filename, certDir); err != nil {
-		return fmt.Errorf("error writing cert for %q: %v", spec.description, err)
+	now := time.Now()
+	if lp.Now != nil {
+		now = lp.Now()
+	}
+	if err := checkExpiryWithinCA(ca, spec, cert, now); err != nil {
+		return nil, nil, err
+	}
+	if lp.BundleCACert || lp.RootCAFile != "" {
+		cert = tls.BundleCACert(cert, ca.Cert)
+	}
+	if lp.RootCAFile != "" {
+		root, err := ioutil.ReadFile(lp.RootCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading root CA certificate: %v", err)
+		}
+		if err := tls.VerifyChain(cert, root); err != nil {
+			return nil, nil, fmt.Errorf("error verifying certificate chain for %q: %v", spec.description, err)
+		}
+	}
+	return key, cert, nil
+}
+
+// warnOnSlowKeyGeneration logs a warning if the certificate is not generated
+// within the threshold, which usually means that reading random data is
+// blocked because the system is low on entropy. The returned function must
+// be called once the certificate is generated.
+func (lp *LocalPKI) warnOnSlowKeyGeneration(spec certificateSpec) (stop func()) {
+	if lp.Log == nil {
+		return func() {}
+	}
+	threshold := lp.SlowKeyGenerationThreshold
+	if threshold <= 0 {
+		threshold = defaultSlowKeyGenerationThreshold
+	}
+	t := time.AfterFunc(threshold, func() {
+		slowKeyGenerationLogMu.Lock()
+		defer slowKeyGenerationLogMu.Unlock()
+		util.PrettyPrintWarn(lp.Log, "Generating the private key for %s is taking longer than %s. The system might be low on entropy, consider running an entropy daemon such as haveged or rng-tools", spec.description, threshold)
+	})
+	return func() { t.Stop() }
+}
+
+// checkExpiryWithinCA returns an error if the certificate expires after the
+// CA, as it would stop working when the CA expires regardless of its own
+// validity period. Certificates that expire within a few minutes of the CA
+// are accepted, as both are backdated when signed.
+func checkExpiryWithinCA(ca *tls.CA, spec certificateSpec, certPEM []byte, now time.Time) error {
+	caCert, err := helpers.ParseCertificatePEM(ca.Cert)
+	if err != nil {
+		return fmt.Errorf("error parsing CA certificate: %v", err)
+	}
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		return fmt.Errorf("error parsing certificate for %q: %v", spec.description, err)
+	}
+	if cert.NotAfter.After(caCert.NotAfter.Add(caExpiryTolerance)) {
+		remaining := caCert.NotAfter.Sub(now)
+		remaining -= remaining % time.Hour
+		if remaining < 0 {
+			remaining = 0
+		}
+		return fmt.Errorf("the certificate for %q would expire on %s, after the CA expires on %s. Reduce the certificate expiry to %s or less, or renew the CA",
+			spec.description, cert.NotAfter.UTC().Format(time.RFC3339), caCert.NotAfter.UTC().Format(time.RFC3339), remaining)
 	}
 	return nil
 }
 
-func clusterCertsSubjectAlternateNames(plan Plan) ([]string, error) {
-	kubeServiceIP, err := getKubernetesServiceIP(&plan)
+// validateCertsDirectory returns an error if the file name scheme is invalid,
+// or if the certificates directory exists, but is not a directory. Otherwise,
+// every file operation on the directory would fail with a confusing error.
+func (lp *LocalPKI) validateCertsDirectory() error {
+	if err := lp.FileNames.Validate(); err != nil {
+		return fmt.Errorf("invalid certificate file names: %v", err)
+	}
+	fi, err := os.Stat(lp.GeneratedCertsDirectory)
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("Error getting kubernetes service IP: %v", err)
+		return fmt.Errorf("error checking certificates directory %q: %v", lp.GeneratedCertsDirectory, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("certificates directory %q exists and is not a directory", lp.GeneratedCertsDirectory)
+	}
+	return nil
+}
+
+// validateSigningProfile verifies that the configured signing profile exists
+// and is valid, and that its key usages are supported by the key algorithm of
+// the leaf certificates, so that misconfigurations are caught before
+// generating anything. The plan is nil when certificates are not generated
+// for a plan.
+func (lp *LocalPKI) validateSigningProfile(p *Plan) error {
+	algo := keyRequest("", 0).A
+	var planProfile *SigningProfile
+	if p != nil {
+		algo = p.Cluster.Certificates.leafKeyRequest().A
+		planProfile = p.Cluster.Certificates.SigningProfile
+	}
+	if planProfile != nil {
+		if err := tls.ValidateUsagesForKeyAlgorithm(planProfile.Usages, algo); err != nil {
+			return fmt.Errorf("the signing profile of the plan is not compatible with the %s key algorithm of the certificates: %v. Remove the key usage from the signing profile", algo, err)
+		}
+	}
+	if lp.CAConfigFile == "" {
+		return nil
+	}
+	profile, err := tls.LoadSigningProfile(lp.CAConfigFile, lp.CASigningProfile)
+	if err != nil {
+		return fmt.Errorf("invalid CA signing configuration: %v", err)
+	}
+	// The signing profile of the plan takes precedence over the usages of
+	// the CA signing configuration
+	if planProfile == nil {
+		name := lp.CASigningProfile
+		if name == "" {
+			name = "default"
+		}
+		if err := tls.ValidateUsagesForKeyAlgorithm(profile.Usage, algo); err != nil {
+			return fmt.Errorf("signing profile %q in %q is not compatible with the %s key algorithm of the certificates: %v. Remove the key usage from the signing profile, or use a different profile", name, lp.CAConfigFile, algo, err)
+		}
+	}
+	return nil
+}
+
+// writeCert writes the key and certificate to the generated certificates
+// directory, and applies the configured group ownership.
+func (lp *LocalPKI) writeCert(key, cert []byte, name string) error {
+	if err := lp.FileNames.WriteCert(key, cert, name, lp.GeneratedCertsDirectory); err != nil {
+		return err
+	}
+	if err := lp.writeCertDER(key, cert, name); err != nil {
+		return err
+	}
+	return lp.setGroupOwnership(name)
+}
+
+// writeCertDER writes the DER versions of the key and certificate, if DER
+// output is enabled. When the key is nil, because the existing key was
+// reused, the DER key is only written if it does not exist yet.
+func (lp *LocalPKI) writeCertDER(key, cert []byte, name string) error {
+	if !lp.DER {
+		return nil
+	}
+	dir := lp.GeneratedCertsDirectory
+	if key == nil {
+		if _, err := os.Stat(filepath.Join(dir, lp.FileNames.DERKeyFile(name))); os.IsNotExist(err) {
+			b, err := ioutil.ReadFile(filepath.Join(dir, lp.FileNames.KeyFile(name)))
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("error reading private key: %v", err)
+			}
+			key = b
+		}
+	}
+	return lp.FileNames.WriteCertDER(key, cert, name, dir)
+}
+
+// returns the DER files of the certificate that exist in the certificates directory
+func (lp *LocalPKI) derFiles(name string) []string {
+	if !lp.DER {
+		return nil
+	}
+	files := []string{}
+	for _, f := range []string{lp.FileNames.DERCertFile(name), lp.FileNames.DERKeyFile(name)} {
+		if _, err := os.Stat(filepath.Join(lp.GeneratedCertsDirectory, f)); err == nil {
+			files = append(files, filepath.ToSlash(f))
+		}
+	}
+	return files
+}
+
+// setGroupOwnership sets the group of the certificates directory and the
+// files for the given cert name. Chown is skipped with a warning when the
+// process lacks the privileges to change group ownership.
+func (lp *LocalPKI) setGroupOwnership(name string) error {
+	if lp.Group == "" {
+		return nil
 	}
-	defaultCertHosts := []string{
+	gid, err := lookupGroupID(lp.Group)
+	if err != nil {
+		return err
+	}
+	dir := lp.GeneratedCertsDirectory
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("error reading certificates directory %q: %v", dir, err)
+	}
+	// Group members need to be able to traverse the directory to read the certificates
+	if err = os.Chmod(dir, info.Mode().Perm()|0050); err != nil {
+		return fmt.Errorf("error setting permissions on certificates directory %q: %v", dir, err)
+	}
+	paths := []string{dir, filepath.Join(dir, lp.FileNames.KeyFile(name)), filepath.Join(dir, lp.FileNames.CertFile(name))}
+	for _, f := range lp.derFiles(name) {
+		paths = append(paths, filepath.Join(dir, filepath.FromSlash(f)))
+	}
+	for _, path := range paths {
+		if err := os.Chown(path, -1, gid); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			if os.IsPermission(err) {
+				util.PrettyPrintWarn(lp.Log, "Insufficient privileges to set group of %q to %q, skipping", path, lp.Group)
+				return nil
+			}
+			return fmt.Errorf("error setting group of %q to %q: %v", path, lp.Group, err)
+		}
+	}
+	return nil
+}
+
+// lookupGroupID returns the numeric ID of the given group, which can be
+// a group name or a numeric group ID.
+func lookupGroupID(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, fmt.Errorf("error looking up group %q: %v", group, err)
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return 0, fmt.Errorf("group %q has a non-numeric ID %q", group, g.Gid)
+	}
+	return gid, nil
+}
+
+// returns the SANs that every etcd server certificate includes, in addition
+// to the addresses and hostnames of the node
+func etcdDefaultSANs() []string {
+	return []string{"127.0.0.1"}
+}
+
+// returns the SANs that every API server certificate includes, in addition to
+// the addresses and hostnames of the node. These are the names that clients
+// inside the cluster use to reach the API server through its service.
+func masterDefaultSANs() []string {
+	return []string{
 		"kubernetes",
 		"kubernetes.default",
 		"kubernetes.default.svc",
-		"kubernetes.default.svc.cluster.local",
+		"kubernetes.default.svc." + clusterDomain,
 		"127.0.0.1",
-		kubeServiceIP,
 	}
-	return defaultCertHosts, nil
+}
+
+func clusterCertsSubjectAlternateNames(plan Plan) ([]string, error) {
+	defaultCertHosts := masterDefaultSANs()
+	if plan.Cluster.Certificates.DisableKubernetesServiceIPSAN {
+		return defaultCertHosts, nil
+	}
+	kubeServiceIP, err := getKubernetesServiceIP(&plan)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting kubernetes service IP: %v", err)
+	}
+	return append(defaultCertHosts, kubeServiceIP), nil
+}
+
+// returns the given strings without duplicates, preserving their order
+func uniqueStrings(xs []string) []string {
+	if xs == nil {
+		return nil
+	}
+	unique := []string{}
+	for _, x := range xs {
+		if !contains(x, unique) {
+			unique = append(unique, x)
+		}
+	}
+	return unique
+}
+
+// returns true if one of the strings is the given IP, in any notation
+func containsIP(ip net.IP, xs []string) bool {
+	for _, x := range xs {
+		if ip.Equal(net.ParseIP(x)) {
+			return true
+		}
+	}
+	return false
 }
 
 func contains(x string, xs []string) bool {
@@ -542,11 +2302,12 @@ func containsAny(x []string, xs []string) bool {
 	return false
 }
 
-func certSpecInManifest(spec certificateSpec, manifest []certificateSpec) bool {
-	for _, s := range manifest {
+// returns the index of the spec in the manifest, or -1 if it is not in it
+func certSpecIndex(spec certificateSpec, manifest []certificateSpec) int {
+	for i, s := range manifest {
 		if s.equal(spec) {
-			return true
+			return i
 		}
 	}
-	return false
+	return -1
 }
@@ -1,21 +1,31 @@
 package install
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"net"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/apprenda/kismatic/pkg/tls"
 	"github.com/apprenda/kismatic/pkg/util"
+	"github.com/cloudflare/cfssl/csr"
 	"github.com/cloudflare/cfssl/helpers"
+	yaml "gopkg.in/yaml.v2"
 )
 
 func getPKI(t *testing.T) LocalPKI {
@@ -194,13 +204,17 @@ func TestClusterCAExistsGenerationSkipped(t *testing.T) {
 	pki := getPKI(t)
 	defer cleanup(pki.GeneratedCertsDirectory, t)
 
+	key, cert, err := tls.NewCACert("test/ca-csr.json", "someCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating CA: %v", err)
+	}
 	caFile := filepath.Join(pki.GeneratedCertsDirectory, "ca.pem")
-	if _, err := os.Create(caFile); err != nil {
+	if err := ioutil.WriteFile(caFile, cert, 0644); err != nil {
 		t.Fatalf("error creating ca.pem file: %v", err)
 	}
 
 	keyFile := filepath.Join(pki.GeneratedCertsDirectory, "ca-key.pem")
-	if _, err := os.Create(keyFile); err != nil {
+	if err := ioutil.WriteFile(keyFile, key, 0600); err != nil {
 		t.Fatalf("error creating ca-key.pem: %v", err)
 	}
 
@@ -213,7 +227,7 @@ func TestClusterCAExistsGenerationSkipped(t *testing.T) {
 	if err != nil {
 		t.Errorf("error getting contents for %q: %v", caFile, err)
 	}
-	if len(caContents) != 0 {
+	if !bytes.Equal(caContents, cert) {
 		t.Error("CA File was modified")
 	}
 
@@ -222,7 +236,7 @@ func TestClusterCAExistsGenerationSkipped(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error getting stat for %q: %v", keyFile, err)
 	}
-	if len(keyContents) != 0 {
+	if !bytes.Equal(keyContents, key) {
 		t.Error("Key file was modified")
 	}
 }
@@ -495,6 +509,17 @@ func TestGenerateClusterCertificatesValidateCertificateInformation(t *testing.T)
 			expectedCommonName:    fmt.Sprintf("system:node:%s", storageNode.Host),
 			expectedOrganizations: []string{"system:nodes"},
 		},
+		{
+			name:               "api server etcd client certificate",
+			certFilename:       "apiserver-etcd-client.pem",
+			expectedCommonName: "kube-apiserver-etcd-client",
+		},
+		{
+			name:                  "api server kubelet client certificate",
+			certFilename:          "apiserver-kubelet-client.pem",
+			expectedCommonName:    "kube-apiserver-kubelet-client",
+			expectedOrganizations: []string{"system:masters"},
+		},
 		{
 			name:                  "admin user certificate",
 			certFilename:          "admin.pem",
@@ -771,7 +796,7 @@ func TestValidateClusterCertificatesInvalidCerts(t *testing.T) {
 				p.Etcd.Nodes = []Node{etcd}
 				return p
 			},
-			expectedWarnings: 1,
+			expectedWarnings: 2, // etcd server and etcd peer certs
 		},
 		{
 			description: "bad master certificates",
@@ -1112,3 +1137,2417 @@ func TestGenerateCertificate(t *testing.T) {
 		}
 	}
 }
+
+func TestGenerateClusterCertificatesGroupOwnership(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	pki.Group = strconv.Itoa(os.Getgid())
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	info, err := os.Stat(pki.GeneratedCertsDirectory)
+	if err != nil {
+		t.Fatalf("error reading certificates directory: %v", err)
+	}
+	if info.Mode().Perm()&0050 != 0050 {
+		t.Errorf("expected certificates directory to be traversable by the group, but mode was %v", info.Mode())
+	}
+	keyInfo, err := os.Stat(filepath.Join(pki.GeneratedCertsDirectory, "ca-key.pem"))
+	if err != nil {
+		t.Fatalf("error reading CA key: %v", err)
+	}
+	if keyInfo.Mode().Perm() != 0600 {
+		t.Errorf("expected CA key to be readable only by the owner, but mode was %v", keyInfo.Mode())
+	}
+}
+
+func TestLookupGroupID(t *testing.T) {
+	gid, err := lookupGroupID("1234")
+	if err != nil {
+		t.Fatalf("unexpected error looking up numeric group: %v", err)
+	}
+	if gid != 1234 {
+		t.Errorf("expected gid 1234, got %d", gid)
+	}
+	if _, err := lookupGroupID("this-group-does-not-exist"); err == nil {
+		t.Errorf("expected an error looking up a non-existent group")
+	}
+}
+
+func TestAPIServerEtcdClientCertCommonNameOverride(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	p.Cluster.Certificates.APIServerEtcdClientCommonName = "my-etcd-user"
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateNodeCertificate(p, p.Master.Nodes[0], ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	cert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "apiserver-etcd-client.pem"), t)
+	if cert.Subject.CommonName != "my-etcd-user" {
+		t.Errorf("expected common name %q, but got %q", "my-etcd-user", cert.Subject.CommonName)
+	}
+	if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Errorf("expected the certificate to be valid for client auth only, but got %v", cert.ExtKeyUsage)
+	}
+}
+
+func TestAPIServerKubeletClientCertOverride(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	p.Cluster.Certificates.APIServerKubeletClientCommonName = "my-kubelet-client"
+	p.Cluster.Certificates.APIServerKubeletClientGroup = "my-kubelet-admins"
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateNodeCertificate(p, p.Master.Nodes[0], ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	cert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "apiserver-kubelet-client.pem"), t)
+	if cert.Subject.CommonName != "my-kubelet-client" {
+		t.Errorf("expected common name %q, but got %q", "my-kubelet-client", cert.Subject.CommonName)
+	}
+	if !reflect.DeepEqual(cert.Subject.Organization, []string{"my-kubelet-admins"}) {
+		t.Errorf("expected organization %q, but got %v", "my-kubelet-admins", cert.Subject.Organization)
+	}
+	if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Errorf("expected the certificate to be valid for client auth only, but got %v", cert.ExtKeyUsage)
+	}
+}
+
+func TestGenerateClusterCertificatesWritesManifest(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, "manifest.json"))
+	if err != nil {
+		t.Fatalf("error reading manifest: %v", err)
+	}
+	m := CertificateManifest{}
+	if err = json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("error decoding manifest: %v", err)
+	}
+	for _, e := range m.Certificates {
+		if _, err := os.Stat(filepath.Join(pki.GeneratedCertsDirectory, e.CertFile)); err != nil {
+			t.Errorf("certificate %q listed in the manifest was not found: %v", e.CertFile, err)
+		}
+	}
+}
+
+func TestCanonicalManifest(t *testing.T) {
+	m := CertificateManifest{
+		ClusterUID: "someUID",
+		Certificates: []CertificateManifestEntry{
+			{Name: "worker01-kubelet", Description: "worker01 kubelet", CertFile: "worker01-kubelet.pem"},
+			{Name: "bootstrap-token", Description: "node bootstrap token", Files: []string{"bootstrap-token-secret.yaml", "bootstrap-token"}},
+			{Name: "ca", Description: "cluster certificate authority", CertFile: "ca.pem"},
+		},
+	}
+	b, err := canonicalManifest(m)
+	if err != nil {
+		t.Fatalf("error encoding manifest: %v", err)
+	}
+	reordered := m
+	reordered.Certificates = []CertificateManifestEntry{m.Certificates[2], m.Certificates[0], m.Certificates[1]}
+	again, err := canonicalManifest(reordered)
+	if err != nil {
+		t.Fatalf("error encoding manifest: %v", err)
+	}
+	if !bytes.Equal(b, again) {
+		t.Errorf("expected the encoding not to depend on the order of the certificates:\n%s\n%s", b, again)
+	}
+	s := string(b)
+	if strings.Index(s, `"bootstrap-token"`) > strings.Index(s, `"ca"`) || strings.Index(s, `"ca"`) > strings.Index(s, `"worker01-kubelet"`) {
+		t.Errorf("expected the certificates to be sorted by name:\n%s", s)
+	}
+	if strings.Index(s, `"certificates"`) > strings.Index(s, `"clusterUID"`) {
+		t.Errorf("expected the keys to be sorted:\n%s", s)
+	}
+	if m.Certificates[1].Files[0] != "bootstrap-token-secret.yaml" {
+		t.Errorf("expected the manifest not to be modified")
+	}
+}
+
+func TestGenerateClusterCertificatesExpiredCertsAreRegen(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	adminFile := filepath.Join(pki.GeneratedCertsDirectory, "admin.pem")
+	adminBefore := mustReadCertFile(adminFile, t)
+	keyBefore, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, "admin-key.pem"))
+	if err != nil {
+		t.Fatalf("error reading key: %v", err)
+	}
+
+	// The certificates of the plan expire in 1h
+	now := time.Now().Add(2 * time.Hour)
+	pki.Now = func() time.Time { return now }
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	adminAfter := mustReadCertFile(adminFile, t)
+	if adminBefore.Equal(adminAfter) {
+		t.Fatalf("expected the expired admin certificate to be regenerated")
+	}
+	if !now.Before(adminAfter.NotAfter) {
+		t.Errorf("expected the regenerated certificate to be valid after %v, but it expires on %v", now, adminAfter.NotAfter)
+	}
+	keyAfter, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, "admin-key.pem"))
+	if err != nil {
+		t.Fatalf("error reading key: %v", err)
+	}
+	if !bytes.Equal(keyBefore, keyAfter) {
+		t.Errorf("expected the private key of the expired certificate to be reused")
+	}
+}
+
+func TestGenerateClusterCertificatesCertsOfAnotherCAAreRegen(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	key, cert, err := tls.NewCACert("test/ca-csr.json", "someOtherCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating CA for test: %v", err)
+	}
+	otherCA := &tls.CA{Key: key, Cert: cert}
+	if err = pki.GenerateClusterCertificates(p, otherCA); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	otherCACert, err := helpers.ParseCertificatePEM(cert)
+	if err != nil {
+		t.Fatalf("error parsing CA certificate: %v", err)
+	}
+	for _, name := range []string{"admin", "etcd01-etcd", "master01-apiserver"} {
+		c := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, name+".pem"), t)
+		if err := c.CheckSignatureFrom(otherCACert); err != nil {
+			t.Errorf("expected the certificate %q to be regenerated by the current CA: %v", name, err)
+		}
+	}
+}
+
+func TestGenerateClusterCertificatesRunsHooks(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	out := filepath.Join(pki.GeneratedCertsDirectory, "hook-out")
+	pki.PreHook = []string{"sh", "-c", "echo pre >> " + out}
+	pki.PostHook = []string{"sh", "-c", "echo $KISMATIC_CERTS_MANIFEST >> " + out}
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("error reading hook output: %v", err)
+	}
+	expected := fmt.Sprintf("pre\n%s\n", filepath.Join(pki.GeneratedCertsDirectory, "manifest.json"))
+	if string(b) != expected {
+		t.Errorf("expected hook output %q, but got %q", expected, string(b))
+	}
+
+	// A failing hook should fail generation
+	pki.PostHook = []string{"false"}
+	if err = pki.GenerateClusterCertificates(p, ca); err == nil {
+		t.Errorf("expected an error when the post-generation hook fails")
+	}
+}
+
+func TestGenerateClusterCADisableCAKeyPersistence(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	pki.DisableCAKeyPersistence = true
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if len(ca.Key) == 0 {
+		t.Errorf("expected the CA key to be available in memory")
+	}
+	if _, err = os.Stat(filepath.Join(pki.GeneratedCertsDirectory, "ca.pem")); err != nil {
+		t.Errorf("expected the CA certificate to be written: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(pki.GeneratedCertsDirectory, "ca-key.pem")); !os.IsNotExist(err) {
+		t.Errorf("expected the CA private key to not be written, but got: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+
+	// The CA cannot be reused
+	if _, err = pki.GetClusterCA(); err == nil {
+		t.Errorf("expected an error reading the cluster CA")
+	}
+	if _, err = pki.GenerateClusterCA(p); err == nil {
+		t.Errorf("expected an error generating the cluster CA when a CA certificate already exists")
+	}
+}
+
+func TestGenerateClusterCertificatesInvalidSigningProfile(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	pki.CAConfigFile = "test/ca-config.json"
+	pki.CASigningProfile = "doesnotexist"
+	if err = pki.GenerateClusterCertificates(p, ca); err == nil {
+		t.Fatalf("expected an error when using a signing profile that does not exist")
+	}
+	// Nothing should have been generated
+	if exists, _ := tls.CertKeyPairExists("admin", pki.GeneratedCertsDirectory); exists {
+		t.Errorf("expected no certificates to be generated")
+	}
+
+	pki.CASigningProfile = "kubernetes"
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Errorf("error generating cluster certificates with a valid signing profile: %v", err)
+	}
+}
+
+func TestGenerateClusterCertificatesSigningProfileKeyAlgorithm(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	// key agreement is not supported by the rsa keys of the certificates
+	config := `{"signing": {"default": {"usages": ["signing", "key agreement", "server auth", "client auth"], "expiry": "8760h"}}}`
+	pki.CAConfigFile = filepath.Join(pki.GeneratedCertsDirectory, "ca-config.json")
+	if err := ioutil.WriteFile(pki.CAConfigFile, []byte(config), 0644); err != nil {
+		t.Fatalf("error writing CA config for test: %v", err)
+	}
+	err = pki.GenerateClusterCertificates(p, ca)
+	if err == nil {
+		t.Fatalf("expected an error when the signing profile is not compatible with the key algorithm")
+	}
+	if !strings.Contains(err.Error(), "rsa") || !strings.Contains(err.Error(), "key agreement") {
+		t.Errorf("expected the error to name the key algorithm and the key usage, but got: %v", err)
+	}
+	if exists, _ := tls.CertKeyPairExists("admin", pki.GeneratedCertsDirectory); exists {
+		t.Errorf("expected no certificates to be generated")
+	}
+
+	// The signing profile of the plan takes precedence
+	p.Cluster.Certificates.SigningProfile = &SigningProfile{Usages: []string{"signing", "key encipherment", "server auth", "client auth"}}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Errorf("error generating cluster certificates with a compatible signing profile in the plan: %v", err)
+	}
+	p.Cluster.Certificates.SigningProfile = &SigningProfile{Usages: []string{"signing", "key agreement", "server auth"}}
+	if err = pki.RotateLeafCerts(p); err == nil {
+		t.Errorf("expected an error when the signing profile of the plan is not compatible with the key algorithm")
+	}
+}
+
+func TestGenerateNodeCertificateWindowsNode(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	node := Node{
+		Host:        "WinWorker01",
+		IP:          "10.10.10.10",
+		Windows:     true,
+		NetBIOSName: "WINWORKER",
+	}
+	p.Worker.Nodes = append(p.Worker.Nodes, node)
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateNodeCertificate(p, node, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	cert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "WinWorker01-kubelet.pem"), t)
+	expectedCN := "system:node:winworker01"
+	if cert.Subject.CommonName != expectedCN {
+		t.Errorf("expected common name %q, but got %q", expectedCN, cert.Subject.CommonName)
+	}
+	for _, name := range []string{"winworker01", "WINWORKER01", "WINWORKER"} {
+		if !contains(name, cert.DNSNames) {
+			t.Errorf("expected %q to be in the DNS names %v", name, cert.DNSNames)
+		}
+	}
+	if len(cert.IPAddresses) != 1 || cert.IPAddresses[0].String() != node.IP {
+		t.Errorf("expected IP SANs to be [%s], but got %v", node.IP, cert.IPAddresses)
+	}
+}
+
+func TestGenerateClusterCertificatesBundleCACert(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	pki.BundleCACert = true
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	certPEM, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, "worker01-kubelet.pem"))
+	if err != nil {
+		t.Fatalf("failed to read certificate file: %v", err)
+	}
+	certs, err := helpers.ParseCertificatesPEM(certPEM)
+	if err != nil {
+		t.Fatalf("error parsing certificate bundle: %v", err)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("expected the certificate file to contain 2 certificates, but got %d", len(certs))
+	}
+	if certs[0].Subject.CommonName != "system:node:worker01" {
+		t.Errorf("expected the leaf certificate to be first, but got %q", certs[0].Subject.CommonName)
+	}
+	caCert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "ca.pem"), t)
+	if !certs[1].Equal(caCert) {
+		t.Errorf("expected the second certificate to be the CA certificate")
+	}
+	// bundled certificates must still pass validation
+	if warn, errs := pki.ValidateClusterCertificates(p); len(warn) > 0 || len(errs) > 0 {
+		t.Errorf("expected bundled certificates to be valid, but got warnings %v and errors %v", warn, errs)
+	}
+}
+
+func TestGenerateClusterCertificatesSerialAndTimeSources(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	now := time.Date(2017, time.June, 1, 12, 0, 0, 0, time.UTC)
+	pki.Now = func() time.Time { return now }
+	var serial int64
+	pki.SerialNumber = func() (*big.Int, error) {
+		serial++
+		return big.NewInt(serial), nil
+	}
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	manifest, err := certManifestForCluster(*p)
+	if err != nil {
+		t.Fatalf("error getting certificate manifest: %v", err)
+	}
+	seen := map[string]bool{}
+	for _, s := range manifest {
+		cert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, s.filename+".pem"), t)
+		if !cert.NotBefore.Equal(now) {
+			t.Errorf("%s: expected the certificate to be valid from %v, but got %v", s.filename, now, cert.NotBefore)
+		}
+		if seen[cert.SerialNumber.String()] {
+			t.Errorf("%s: serial number %v is not unique", s.filename, cert.SerialNumber)
+		}
+		seen[cert.SerialNumber.String()] = true
+	}
+}
+
+func TestGenerateClusterCertificatesClockSkew(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	now := time.Date(2017, time.June, 1, 12, 0, 0, 0, time.UTC)
+	pki.Now = func() time.Time { return now }
+	pki.ClockSkew = 5 * time.Minute
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	expiry, err := time.ParseDuration(p.Cluster.Certificates.Expiry)
+	if err != nil {
+		t.Fatalf("invalid expiry in test plan: %v", err)
+	}
+	cert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"), t)
+	if expected := now.Add(-5 * time.Minute); !cert.NotBefore.Equal(expected) {
+		t.Errorf("expected the certificate to be valid from %v, but got %v", expected, cert.NotBefore)
+	}
+	if expected := now.Add(expiry); !cert.NotAfter.Equal(expected) {
+		t.Errorf("expected the certificate to be valid until %v, but got %v", expected, cert.NotAfter)
+	}
+}
+
+func TestRotateLeafCerts(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	caBefore := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "ca.pem"), t)
+	adminBefore := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"), t)
+
+	if err = pki.RotateLeafCerts(p); err != nil {
+		t.Fatalf("error rotating certificates: %v", err)
+	}
+	caAfter := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "ca.pem"), t)
+	if !caBefore.Equal(caAfter) {
+		t.Errorf("expected the CA certificate to be preserved")
+	}
+	adminAfter := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"), t)
+	if adminBefore.Equal(adminAfter) {
+		t.Errorf("expected the admin certificate to be rotated")
+	}
+	if err = adminAfter.CheckSignatureFrom(caAfter); err != nil {
+		t.Errorf("expected the rotated certificate to be signed by the existing CA: %v", err)
+	}
+	if warn, errs := pki.ValidateClusterCertificates(p); len(warn) > 0 || len(errs) > 0 {
+		t.Errorf("expected rotated certificates to be valid, but got warnings %v and errors %v", warn, errs)
+	}
+}
+
+func TestGenerateClusterCertificatesKeyRequest(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	p.Cluster.Certificates.KeyAlgorithm = "ecdsa"
+	p.Cluster.Certificates.KeySize = 384
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	for _, name := range []string{"admin", "master01-apiserver", "etcd01-etcd", "worker01-kubelet"} {
+		cert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, name+".pem"), t)
+		pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+		if !ok {
+			t.Errorf("expected the key of %q to be an ECDSA key, but got %T", name, cert.PublicKey)
+			continue
+		}
+		if pub.Curve.Params().BitSize != 384 {
+			t.Errorf("expected the key of %q to be a 384 bit key, but got %d", name, pub.Curve.Params().BitSize)
+		}
+		if cert.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
+			t.Errorf("expected the certificate %q of an ECDSA key not to have the key encipherment usage", name)
+		}
+	}
+	caCert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "ca.pem"), t)
+	if _, ok := caCert.PublicKey.(*ecdsa.PublicKey); ok {
+		t.Errorf("expected the key algorithm of the certificates not to apply to the CA")
+	}
+}
+
+func TestRenewClusterCerts(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	adminFile := filepath.Join(pki.GeneratedCertsDirectory, "admin.pem")
+	adminBefore := mustReadCertFile(adminFile, t)
+
+	// The certificates of the plan expire in 1h
+	renewed, err := pki.RenewClusterCerts(p, 0)
+	if err != nil {
+		t.Fatalf("error renewing certificates: %v", err)
+	}
+	if len(renewed) != 0 {
+		t.Errorf("expected no certificates to be renewed, but got %v", renewed)
+	}
+	if !adminBefore.Equal(mustReadCertFile(adminFile, t)) {
+		t.Errorf("expected the admin certificate to be left untouched")
+	}
+
+	manifest, err := certManifestForCluster(*p)
+	if err != nil {
+		t.Fatalf("error getting the certificates of the cluster: %v", err)
+	}
+	renewed, err = pki.RenewClusterCerts(p, 2*time.Hour)
+	if err != nil {
+		t.Fatalf("error renewing certificates: %v", err)
+	}
+	if len(renewed) != len(manifest) {
+		t.Errorf("expected all %d certificates to be renewed, but got %v", len(manifest), renewed)
+	}
+	adminAfter := mustReadCertFile(adminFile, t)
+	if adminBefore.Equal(adminAfter) {
+		t.Errorf("expected the admin certificate to be renewed")
+	}
+	if err = adminAfter.CheckSignatureFrom(mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "ca.pem"), t)); err != nil {
+		t.Errorf("expected the renewed certificate to be signed by the existing CA: %v", err)
+	}
+
+	// Certificates that do not exist are renewed regardless of the threshold
+	if err = os.Remove(adminFile); err != nil {
+		t.Fatalf("error removing the admin certificate: %v", err)
+	}
+	renewed, err = pki.RenewClusterCerts(p, 0)
+	if err != nil {
+		t.Fatalf("error renewing certificates: %v", err)
+	}
+	if !reflect.DeepEqual(renewed, []string{"admin"}) {
+		t.Errorf("expected only the admin certificate to be renewed, but got %v", renewed)
+	}
+
+	if _, err = pki.RenewClusterCerts(p, -time.Hour); err == nil {
+		t.Errorf("expected an error with a negative expiry threshold")
+	}
+}
+
+func TestRotateLeafCertsReusesKeys(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	adminKey := filepath.Join(pki.GeneratedCertsDirectory, "admin-key.pem")
+	keyBefore, err := ioutil.ReadFile(adminKey)
+	if err != nil {
+		t.Fatalf("error reading key: %v", err)
+	}
+	certBefore := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"), t)
+
+	if err = pki.RotateLeafCerts(p); err != nil {
+		t.Fatalf("error rotating certificates: %v", err)
+	}
+	keyAfter, err := ioutil.ReadFile(adminKey)
+	if err != nil {
+		t.Fatalf("error reading key: %v", err)
+	}
+	if !bytes.Equal(keyBefore, keyAfter) {
+		t.Errorf("expected the private key to be reused")
+	}
+	certAfter := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"), t)
+	if certBefore.Equal(certAfter) {
+		t.Errorf("expected the certificate to be rotated")
+	}
+	if !reflect.DeepEqual(certBefore.PublicKey, certAfter.PublicKey) {
+		t.Errorf("expected the rotated certificate to be issued for the existing key")
+	}
+
+	// A key that does not match the key configuration is regenerated
+	ecdsaKey, _, err := tls.NewCACertFromRequest(csr.CertificateRequest{KeyRequest: &csr.BasicKeyRequest{A: "ecdsa", S: 256}}, "someCN", "1h", tls.CAOptions{})
+	if err != nil {
+		t.Fatalf("error generating key for test: %v", err)
+	}
+	if err = ioutil.WriteFile(adminKey, ecdsaKey, 0600); err != nil {
+		t.Fatalf("error writing key for test: %v", err)
+	}
+	if err = pki.RegenerateCert(p, "admin"); err != nil {
+		t.Fatalf("error regenerating certificate: %v", err)
+	}
+	keyAfter, err = ioutil.ReadFile(adminKey)
+	if err != nil {
+		t.Fatalf("error reading key: %v", err)
+	}
+	if ok, err := tls.KeyMatches(keyAfter, "rsa", 2048); err != nil || !ok {
+		t.Errorf("expected a new RSA key to be generated, but got error %v", err)
+	}
+
+	// Fresh keys are generated when forced
+	pki.RotateKeys = true
+	keyBefore = keyAfter
+	if err = pki.RegenerateCert(p, "admin"); err != nil {
+		t.Fatalf("error regenerating certificate: %v", err)
+	}
+	keyAfter, err = ioutil.ReadFile(adminKey)
+	if err != nil {
+		t.Fatalf("error reading key: %v", err)
+	}
+	if bytes.Equal(keyBefore, keyAfter) {
+		t.Errorf("expected a new private key when rotating keys")
+	}
+}
+
+func TestRotateLeafCertsNoCA(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	if err := pki.RotateLeafCerts(getPlan()); err == nil {
+		t.Errorf("expected an error when the CA does not exist")
+	}
+}
+
+func TestRegenerateCert(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	adminBefore := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"), t)
+	etcdBefore := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "etcd01-etcd.pem"), t)
+
+	if err = pki.RegenerateCert(p, "admin"); err != nil {
+		t.Fatalf("error regenerating certificate: %v", err)
+	}
+	adminAfter := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"), t)
+	if adminBefore.Equal(adminAfter) {
+		t.Errorf("expected the admin certificate to be regenerated")
+	}
+	etcdAfter := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "etcd01-etcd.pem"), t)
+	if !etcdBefore.Equal(etcdAfter) {
+		t.Errorf("expected other certificates to be left untouched")
+	}
+
+	err = pki.RegenerateCert(p, "foo")
+	if err == nil {
+		t.Fatalf("expected an error for an unknown certificate name")
+	}
+	if !strings.Contains(err.Error(), "admin") {
+		t.Errorf("expected the error to list the valid names, but got %q", err)
+	}
+}
+
+func TestWriteCertTo(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	var key, cert bytes.Buffer
+	if err = pki.WriteCertTo(p, "admin", ca, &key, &cert); err != nil {
+		t.Fatalf("error writing certificate: %v", err)
+	}
+	parsed, err := helpers.ParseCertificatePEM(cert.Bytes())
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	if parsed.Subject.CommonName != adminUser {
+		t.Errorf("expected common name %q, but got %q", adminUser, parsed.Subject.CommonName)
+	}
+	if _, err = helpers.ParsePrivateKeyPEM(key.Bytes()); err != nil {
+		t.Errorf("error parsing private key: %v", err)
+	}
+	exists, err := pki.FileNames.CertKeyPairExists("admin", pki.GeneratedCertsDirectory)
+	if err != nil {
+		t.Fatalf("error checking for certificate files: %v", err)
+	}
+	if exists {
+		t.Errorf("expected the certificate not to be written to the certificates directory")
+	}
+
+	if err = pki.WriteCertTo(p, "foo", ca, &key, &cert); err == nil {
+		t.Errorf("expected an error for an unknown certificate name")
+	}
+}
+
+func TestWriteCertWithValidityTo(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	tests := []struct {
+		notBefore time.Time
+		notAfter  time.Time
+	}{
+		// Expired
+		{notBefore: now.Add(-2 * time.Hour), notAfter: now.Add(-time.Hour)},
+		// Not yet valid
+		{notBefore: now.Add(10 * time.Minute), notAfter: now.Add(40 * time.Minute)},
+	}
+	for i, test := range tests {
+		var key, cert bytes.Buffer
+		if err = pki.WriteCertWithValidityTo(p, "admin", ca, test.notBefore, test.notAfter, &key, &cert); err != nil {
+			t.Errorf("test %d: error writing certificate: %v", i, err)
+			continue
+		}
+		parsed, err := helpers.ParseCertificatePEM(cert.Bytes())
+		if err != nil {
+			t.Fatalf("error parsing certificate: %v", err)
+		}
+		if !parsed.NotBefore.Equal(test.notBefore) || !parsed.NotAfter.Equal(test.notAfter) {
+			t.Errorf("test %d: expected validity period %v - %v, but got %v - %v", i, test.notBefore, test.notAfter, parsed.NotBefore, parsed.NotAfter)
+		}
+	}
+	exists, err := pki.FileNames.CertKeyPairExists("admin", pki.GeneratedCertsDirectory)
+	if err != nil {
+		t.Fatalf("error checking for certificate files: %v", err)
+	}
+	if exists {
+		t.Errorf("expected the certificate not to be written to the certificates directory")
+	}
+
+	var key, cert bytes.Buffer
+	if err = pki.WriteCertWithValidityTo(p, "admin", ca, now, now.Add(-time.Hour), &key, &cert); err == nil {
+		t.Errorf("expected an error when the validity period ends before it starts")
+	}
+	if err = pki.WriteCertWithValidityTo(p, "admin", ca, time.Time{}, now, &key, &cert); err == nil {
+		t.Errorf("expected an error when the start of the validity period is missing")
+	}
+}
+
+func TestGenerateClusterCertificatesSerialBits(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	pki.SerialBits = 64
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	for _, name := range []string{"ca.pem", "admin.pem"} {
+		cert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, name), t)
+		if cert.SerialNumber.BitLen() > 64 {
+			t.Errorf("%s: expected a serial number of up to 64 bits, but got %d bits", name, cert.SerialNumber.BitLen())
+		}
+	}
+
+	other := getPKI(t)
+	defer cleanup(other.GeneratedCertsDirectory, t)
+	other.SerialBits = 32
+	if _, err := other.GenerateClusterCA(p); err == nil {
+		t.Errorf("expected an error when the serial number size is too small")
+	}
+}
+
+// slowReader blocks before the first read, like a depleted entropy pool
+type slowReader struct {
+	delay time.Duration
+	once  sync.Once
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	r.once.Do(func() { time.Sleep(r.delay) })
+	return rand.Read(p)
+}
+
+func TestNewCertWarnsOnSlowKeyGeneration(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	var log bytes.Buffer
+	pki.Log = &log
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	pki.SlowKeyGenerationThreshold = 10 * time.Millisecond
+	pki.Rand = &slowReader{delay: 200 * time.Millisecond}
+	spec := certificateSpec{description: "etcd01 etcd server", filename: "etcd01-etcd", commonName: "etcd01"}
+	if _, _, err = pki.newCert(ca, spec, "1h"); err != nil {
+		t.Fatalf("error generating certificate: %v", err)
+	}
+	slowKeyGenerationLogMu.Lock()
+	out := log.String()
+	slowKeyGenerationLogMu.Unlock()
+	if !strings.Contains(out, "etcd01 etcd server") || !strings.Contains(out, "entropy") {
+		t.Errorf("expected a warning about low entropy naming the certificate, but got %q", out)
+	}
+}
+
+func TestGenerateClusterCertificatesGeneratedCA(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	if _, err := pki.GenerateClusterCA(p); err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if pki.GeneratedCA() != nil {
+		t.Errorf("expected the generated CA to be returned only when its key is not persisted")
+	}
+
+	pki = getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	pki.DisableCAKeyPersistence = true
+	if pki.GeneratedCA() != nil {
+		t.Fatalf("expected no CA before generation")
+	}
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if pki.GeneratedCA() != ca {
+		t.Errorf("expected the generated CA to be returned")
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	admin, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"))
+	if err != nil {
+		t.Fatalf("error reading admin certificate: %v", err)
+	}
+	if err := tls.VerifyChain(admin, ca.Cert); err != nil {
+		t.Errorf("expected the certificates to be signed by the generated CA: %v", err)
+	}
+}
+
+func TestGenerateClusterCertificatesForRoles(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	pki.Roles = []string{"etcd"}
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	for _, name := range []string{"etcd01-etcd", "etcd02-etcd"} {
+		exists, err := pki.FileNames.CertKeyPairExists(name, pki.GeneratedCertsDirectory)
+		if err != nil {
+			t.Fatalf("error checking for certificate %q: %v", name, err)
+		}
+		if !exists {
+			t.Errorf("expected the %q certificate to be generated", name)
+		}
+	}
+	for _, name := range []string{"admin", "master01-apiserver", "worker01-kubelet", "kube-proxy"} {
+		exists, err := pki.FileNames.CertKeyPairExists(name, pki.GeneratedCertsDirectory)
+		if err != nil {
+			t.Fatalf("error checking for certificate %q: %v", name, err)
+		}
+		if exists {
+			t.Errorf("expected the %q certificate not to be generated", name)
+		}
+	}
+
+	pki.Roles = []string{"foo"}
+	if err = pki.GenerateClusterCertificates(p, ca); err == nil {
+		t.Errorf("expected an error for an unknown role")
+	}
+}
+
+func TestCertManifestSharedCertRoles(t *testing.T) {
+	p := getPlan()
+	p.Master.Nodes = []Node{{Host: "master01", IP: "10.0.0.1"}}
+	p.Worker.Nodes = []Node{{Host: "worker01", IP: "10.0.0.2"}}
+	p.Ingress.Nodes = []Node{}
+	p.Storage.Nodes = []Node{}
+	m, err := certManifestForCluster(*p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, s := range m {
+		if s.filename == kubeProxyCertFilenamePrefix && !(contains("master", s.roles) && contains("worker", s.roles)) {
+			t.Errorf("expected the kube-proxy certificate to be used by masters and workers, but got %v", s.roles)
+		}
+		if s.filename == adminCertFilename && len(s.roles) != 0 {
+			t.Errorf("expected the admin certificate not to be specific to a role, but got %v", s.roles)
+		}
+	}
+}
+
+func TestGenerateClusterCACertsDirectoryIsFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "pki-tests")
+	if err != nil {
+		t.Fatalf("error creating temp file: %v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	pki.GeneratedCertsDirectory = f.Name()
+	_, err = pki.GenerateClusterCA(getPlan())
+	if err == nil {
+		t.Fatalf("expected an error when the certificates directory is a file")
+	}
+	if !strings.Contains(err.Error(), "is not a directory") {
+		t.Errorf("expected a clear error message, but got %q", err)
+	}
+}
+
+func TestGenerateClusterCAFromPlanCSR(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	pki.CACsr = "test/doesnotexist.json"
+
+	p := getPlan()
+	p.Cluster.Certificates.CACSR = &CACSR{
+		Names: []CSRName{
+			{
+				Country:      "US",
+				Organization: "someOrg",
+			},
+		},
+	}
+	if _, err := pki.GenerateClusterCA(p); err != nil {
+		t.Fatalf("error generating CA: %v", err)
+	}
+	caCert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "ca.pem"), t)
+	if !reflect.DeepEqual(caCert.Subject.Organization, []string{"someOrg"}) {
+		t.Errorf("expected organization %q, but got %v", "someOrg", caCert.Subject.Organization)
+	}
+	if caCert.Subject.CommonName != p.Cluster.Name {
+		t.Errorf("expected common name %q, but got %q", p.Cluster.Name, caCert.Subject.CommonName)
+	}
+}
+
+func TestGenerateClusterCertificatesBootstrapToken(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	now := time.Date(2017, time.June, 1, 12, 0, 0, 0, time.UTC)
+	pki.Now = func() time.Time { return now }
+
+	p := getPlan()
+	p.Cluster.Certificates.BootstrapToken = &BootstrapToken{
+		TTL:    "1h",
+		Usages: []string{"authentication"},
+	}
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	token, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, "bootstrap-token"))
+	if err != nil {
+		t.Fatalf("error reading bootstrap token: %v", err)
+	}
+	parts := strings.Split(strings.TrimSpace(string(token)), ".")
+	if len(parts) != 2 || len(parts[0]) != 6 || len(parts[1]) != 16 {
+		t.Fatalf("expected token in the format <id>.<secret>, but got %q", token)
+	}
+
+	secret, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, "bootstrap-token-secret.yaml"))
+	if err != nil {
+		t.Fatalf("error reading bootstrap token secret: %v", err)
+	}
+	s := secretManifest{}
+	if err = yaml.Unmarshal(secret, &s); err != nil {
+		t.Fatalf("error parsing bootstrap token secret: %v", err)
+	}
+	if s.Type != "bootstrap.kubernetes.io/token" {
+		t.Errorf("expected secret type %q, but got %q", "bootstrap.kubernetes.io/token", s.Type)
+	}
+	if s.Metadata.Name != "bootstrap-token-"+parts[0] {
+		t.Errorf("expected secret name %q, but got %q", "bootstrap-token-"+parts[0], s.Metadata.Name)
+	}
+	expected := map[string]string{
+		"token-id":                       parts[0],
+		"token-secret":                   parts[1],
+		"expiration":                     "2017-06-01T13:00:00Z",
+		"usage-bootstrap-authentication": "true",
+	}
+	for k, v := range expected {
+		if s.StringData[k] != v {
+			t.Errorf("expected %s to be %q, but got %q", k, v, s.StringData[k])
+		}
+	}
+	if _, ok := s.StringData["usage-bootstrap-signing"]; ok {
+		t.Errorf("expected the token to not be usable for signing")
+	}
+
+	// the token must not change when generating again
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	tokenAgain, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, "bootstrap-token"))
+	if err != nil {
+		t.Fatalf("error reading bootstrap token: %v", err)
+	}
+	if !bytes.Equal(token, tokenAgain) {
+		t.Errorf("expected the existing bootstrap token to be kept")
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, "manifest.json"))
+	if err != nil {
+		t.Fatalf("error reading manifest: %v", err)
+	}
+	m := CertificateManifest{}
+	if err = json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("error parsing manifest: %v", err)
+	}
+	found := false
+	for _, e := range m.Certificates {
+		if e.Name == "bootstrap-token" && len(e.Files) == 2 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected the bootstrap token to be in the manifest, but got %+v", m.Certificates)
+	}
+}
+
+func TestGenerateNodeCertificateDisableKubernetesServiceIPSAN(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	p.Cluster.Certificates.DisableKubernetesServiceIPSAN = true
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	node := p.Master.Nodes[0]
+	if err = pki.GenerateNodeCertificate(p, node, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	cert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, node.Host+"-apiserver.pem"), t)
+	for _, ip := range cert.IPAddresses {
+		if ip.String() == "10.0.0.1" {
+			t.Errorf("expected the kubernetes service IP to not be in the certificate SANs")
+		}
+	}
+	if !contains("kubernetes.default", cert.DNSNames) {
+		t.Errorf("expected the default kubernetes names to be in the SANs, but got %v", cert.DNSNames)
+	}
+}
+
+func TestPreviewClusterCertificates(t *testing.T) {
+	p := getPlan()
+	previews, err := PreviewClusterCertificates(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var apiServer *CertificatePreview
+	for i := range previews {
+		if previews[i].Name == "master01-apiserver" {
+			apiServer = &previews[i]
+		}
+	}
+	if apiServer == nil {
+		t.Fatalf("expected the API server certificate to be in the preview")
+	}
+	for _, san := range []string{"10.0.0.1", "kubernetes.default.svc.cluster.local", "someFQDN", "someShortName"} {
+		if !contains(san, apiServer.SubjectAlternateNames) {
+			t.Errorf("expected %q to be in the SANs %v", san, apiServer.SubjectAlternateNames)
+		}
+	}
+	if len(uniqueStrings(apiServer.SubjectAlternateNames)) != len(apiServer.SubjectAlternateNames) {
+		t.Errorf("expected SANs to be deduplicated, but got %v", apiServer.SubjectAlternateNames)
+	}
+}
+
+func TestGenerateClusterCertificatesDryRun(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	log := &bytes.Buffer{}
+	pki.Log = log
+	pki.DryRun = true
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	files, err := ioutil.ReadDir(pki.GeneratedCertsDirectory)
+	if err != nil {
+		t.Fatalf("error reading certificates directory: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected no files to be written in dry-run mode, but found %d", len(files))
+	}
+	if !strings.Contains(log.String(), "someFQDN") {
+		t.Errorf("expected the SANs to be logged, but got:\n%s", log.String())
+	}
+}
+
+func TestGenerateServingCert(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	p := getPlan()
+	if _, err := pki.GenerateClusterCA(p); err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err := pki.GenerateServingCert(p, "metrics-server", "kube-system", []string{"10.0.0.50"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cert, err := tls.ReadCert("metrics-server-kube-system-serving", pki.GeneratedCertsDirectory)
+	if err != nil {
+		t.Fatalf("error reading serving certificate: %v", err)
+	}
+	if cert.Subject.CommonName != "metrics-server.kube-system.svc" {
+		t.Errorf("expected CN %q, but got %q", "metrics-server.kube-system.svc", cert.Subject.CommonName)
+	}
+	for _, san := range []string{"metrics-server.kube-system.svc", "metrics-server.kube-system.svc.cluster.local"} {
+		if !contains(san, cert.DNSNames) {
+			t.Errorf("expected %q to be in the DNS SANs %v", san, cert.DNSNames)
+		}
+	}
+	if len(cert.IPAddresses) != 1 || cert.IPAddresses[0].String() != "10.0.0.50" {
+		t.Errorf("expected the extra IP SAN to be included, but got %v", cert.IPAddresses)
+	}
+	if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Errorf("expected the certificate to be valid for server auth only, but got %v", cert.ExtKeyUsage)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, "metrics-server-kube-system-serving-secret.yaml"))
+	if err != nil {
+		t.Fatalf("error reading secret manifest: %v", err)
+	}
+	secret := secretManifest{}
+	if err := yaml.Unmarshal(b, &secret); err != nil {
+		t.Fatalf("error unmarshalling secret manifest: %v", err)
+	}
+	if secret.Type != "kubernetes.io/tls" || secret.Metadata.Namespace != "kube-system" {
+		t.Errorf("unexpected secret type or namespace: %q, %q", secret.Type, secret.Metadata.Namespace)
+	}
+	for _, k := range []string{"tls.crt", "tls.key", "ca.crt"} {
+		if secret.StringData[k] == "" {
+			t.Errorf("expected secret to contain %q", k)
+		}
+	}
+}
+
+func TestGenerateServingCertInvalidName(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	if err := pki.GenerateServingCert(getPlan(), "My_Webhook", "default", nil); err == nil {
+		t.Errorf("expected an error for an invalid service name, but got nil")
+	}
+}
+
+func TestGenerateClusterCertificatesIntermediateCA(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	rootKey, rootCert, err := tls.NewCACert("test/ca-csr.json", "someRootCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating root CA for test: %v", err)
+	}
+	req := csr.CertificateRequest{
+		CN:         "someIntermediateCA",
+		KeyRequest: &csr.BasicKeyRequest{A: "rsa", S: 2048},
+	}
+	intKey, intCert, err := tls.NewIntermediateCACert(&tls.CA{Key: rootKey, Cert: rootCert}, req, "24h", tls.CAOptions{})
+	if err != nil {
+		t.Fatalf("error creating intermediate CA for test: %v", err)
+	}
+	rootFile := filepath.Join(pki.GeneratedCertsDirectory, "root.pem")
+	if err := ioutil.WriteFile(rootFile, rootCert, 0644); err != nil {
+		t.Fatalf("error writing root CA for test: %v", err)
+	}
+	pki.RootCAFile = rootFile
+
+	p := getPlan()
+	ca := &tls.CA{Key: intKey, Cert: intCert}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, "etcd01-etcd.pem"))
+	if err != nil {
+		t.Fatalf("error reading certificate: %v", err)
+	}
+	chain, err := helpers.ParseCertificatesPEM(b)
+	if err != nil {
+		t.Fatalf("error parsing certificate chain: %v", err)
+	}
+	if len(chain) != 2 {
+		t.Fatalf("expected the certificate file to contain the leaf and the intermediate, but got %d certificates", len(chain))
+	}
+	if chain[1].Subject.CommonName != "someIntermediateCA" {
+		t.Errorf("expected the intermediate to follow the leaf, but got %q", chain[1].Subject.CommonName)
+	}
+	if !bytes.Equal(chain[0].AuthorityKeyId, chain[1].SubjectKeyId) {
+		t.Errorf("expected the authority key ID of the leaf to match the intermediate")
+	}
+
+	// A root that did not sign the intermediate must be rejected
+	_, otherRoot, err := tls.NewCACert("test/ca-csr.json", "someOtherRootCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating root CA for test: %v", err)
+	}
+	if err := ioutil.WriteFile(rootFile, otherRoot, 0644); err != nil {
+		t.Fatalf("error writing root CA for test: %v", err)
+	}
+	if _, err := pki.GenerateCertificate("someCert", "1h", "someCN", nil, nil, ca, true); err == nil {
+		t.Errorf("expected an error when the chain cannot be verified against the root")
+	}
+}
+
+func TestGenerateClusterCertificatesWithCASigner(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	priv, err := helpers.ParsePrivateKeyPEM(ca.Key)
+	if err != nil {
+		t.Fatalf("error parsing CA key: %v", err)
+	}
+	// The key is held by the signer only
+	if err := os.Remove(filepath.Join(pki.GeneratedCertsDirectory, "ca-key.pem")); err != nil {
+		t.Fatalf("error removing CA key: %v", err)
+	}
+	pki.CASigner = priv
+
+	signingCA, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("unexpected error getting the CA: %v", err)
+	}
+	if signingCA.Key != nil {
+		t.Errorf("expected the CA key not to be read")
+	}
+	if err := pki.GenerateClusterCertificates(p, signingCA); err != nil {
+		t.Fatalf("unexpected error generating certificates: %v", err)
+	}
+	caCert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "ca.pem"), t)
+	admin := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"), t)
+	if err := admin.CheckSignatureFrom(caCert); err != nil {
+		t.Errorf("expected the certificate to be signed by the CA: %v", err)
+	}
+
+	otherKey, _, err := tls.NewCACert("test/ca-csr.json", "someOtherCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating CA for test: %v", err)
+	}
+	otherPriv, err := helpers.ParsePrivateKeyPEM(otherKey)
+	if err != nil {
+		t.Fatalf("error parsing CA key: %v", err)
+	}
+	pki.CASigner = otherPriv
+	if _, err := pki.GetClusterCA(); err == nil {
+		t.Errorf("expected an error when the signer does not match the CA certificate")
+	}
+}
+
+func TestExportTrustBundle(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	p := getPlan()
+	if _, err := pki.GenerateClusterCA(p); err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	_, rootCert, err := tls.NewCACert("test/ca-csr.json", "someRootCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating root CA for test: %v", err)
+	}
+	rootFile := filepath.Join(pki.GeneratedCertsDirectory, "root.pem")
+	if err := ioutil.WriteFile(rootFile, rootCert, 0644); err != nil {
+		t.Fatalf("error writing root CA for test: %v", err)
+	}
+	pki.RootCAFile = rootFile
+
+	out := &bytes.Buffer{}
+	if err := pki.ExportTrustBundle(p, out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(out.String(), "PRIVATE KEY") {
+		t.Errorf("expected the trust bundle not to contain private keys")
+	}
+	certs, err := helpers.ParseCertificatesPEM(out.Bytes())
+	if err != nil {
+		t.Fatalf("error parsing trust bundle: %v", err)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("expected 2 certificates in the trust bundle, but got %d", len(certs))
+	}
+	if certs[0].Subject.CommonName != p.Cluster.Name || certs[1].Subject.CommonName != "someRootCA" {
+		t.Errorf("unexpected certificates in the trust bundle: %q, %q", certs[0].Subject.CommonName, certs[1].Subject.CommonName)
+	}
+}
+
+func TestExportTrustBundleNoCA(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	if err := pki.ExportTrustBundle(getPlan(), ioutil.Discard); err == nil {
+		t.Errorf("expected an error when the CA does not exist")
+	}
+}
+
+func TestGenerateClusterCertificatesCAConfigMap(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	p := getPlan()
+	p.Cluster.Certificates.CAConfigMap = &CAConfigMap{}
+	p.Metadata = &Metadata{
+		Labels:      map[string]string{"team": "infra"},
+		Annotations: map[string]string{"owner": "ops"},
+	}
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(pki.GeneratedCertsDirectory, "ca-configmap.yaml"))
+	if err != nil {
+		t.Fatalf("error reading CA ConfigMap: %v", err)
+	}
+	if strings.Contains(string(b), "PRIVATE KEY") {
+		t.Errorf("expected the CA ConfigMap not to contain private keys")
+	}
+	cm := configMapManifest{}
+	if err = yaml.Unmarshal(b, &cm); err != nil {
+		t.Fatalf("error parsing CA ConfigMap: %v", err)
+	}
+	if cm.Kind != "ConfigMap" || cm.Metadata.Name != "kube-root-ca.crt" || cm.Metadata.Namespace != "kube-system" {
+		t.Errorf("unexpected CA ConfigMap %s %s/%s", cm.Kind, cm.Metadata.Namespace, cm.Metadata.Name)
+	}
+	if cm.Metadata.Labels["team"] != "infra" || cm.Metadata.Annotations["owner"] != "ops" {
+		t.Errorf("expected the metadata of the plan to be applied, but got %v", cm.Metadata)
+	}
+	certs, err := helpers.ParseCertificatesPEM([]byte(cm.Data["ca.crt"]))
+	if err != nil {
+		t.Fatalf("error parsing CA certificate of the ConfigMap: %v", err)
+	}
+	if len(certs) != 1 || certs[0].Subject.CommonName != p.Cluster.Name {
+		t.Errorf("expected the ConfigMap to contain the cluster CA certificate")
+	}
+
+	m, err := pki.readManifest()
+	if err != nil {
+		t.Fatalf("error reading manifest: %v", err)
+	}
+	var found bool
+	for _, e := range m.Certificates {
+		found = found || e.Name == "ca-configmap.yaml"
+	}
+	if !found {
+		t.Errorf("expected the CA ConfigMap to be recorded in the manifest")
+	}
+}
+
+func TestCertManifestAPIServerExtraIPs(t *testing.T) {
+	p := getPlan()
+	p.Master.APIServerExtraIPs = []string{"203.0.113.10", "2001:db8::0001", p.Master.Nodes[0].IP}
+	m, err := certManifestForNode(*p, p.Master.Nodes[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var san []string
+	for _, s := range m {
+		if s.filename == p.Master.Nodes[0].Host+"-apiserver" {
+			san = s.subjectAlternateNames
+		}
+	}
+	for _, ip := range []string{"203.0.113.10", "2001:db8::1"} {
+		if !contains(ip, san) {
+			t.Errorf("expected %q to be in the API server SANs %v", ip, san)
+		}
+	}
+	count := 0
+	for _, s := range san {
+		if s == p.Master.Nodes[0].IP {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("expected the node IP to appear once in the SANs, but found it %d times", count)
+	}
+}
+
+func TestGenerateClusterCertificatesOrphanedCerts(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	log := &bytes.Buffer{}
+	pki.Log = log
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+
+	// Rename a worker node
+	p.Worker.Nodes[0].Host = "worker01-renamed"
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	if !strings.Contains(log.String(), "no longer required") {
+		t.Errorf("expected the orphaned certificate to be reported, but got:\n%s", log.String())
+	}
+	if _, err := os.Stat(filepath.Join(pki.GeneratedCertsDirectory, "worker01-kubelet.pem")); err != nil {
+		t.Errorf("expected the orphaned certificate to be kept: %v", err)
+	}
+
+	pki.RemoveOrphanedCerts = true
+	p.Worker.Nodes[0].Host = "worker01-renamed-again"
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	for _, f := range []string{"worker01-renamed-kubelet.pem", "worker01-renamed-kubelet-key.pem"} {
+		if _, err := os.Stat(filepath.Join(pki.GeneratedCertsDirectory, f)); !os.IsNotExist(err) {
+			t.Errorf("expected orphaned file %q to be removed", f)
+		}
+	}
+	for _, f := range []string{"ca.pem", "ca-key.pem", "worker01-renamed-again-kubelet.pem"} {
+		if _, err := os.Stat(filepath.Join(pki.GeneratedCertsDirectory, f)); err != nil {
+			t.Errorf("expected %q to exist: %v", f, err)
+		}
+	}
+}
+
+func TestGenerateClusterCertificatesOrphanedCertsNoManifest(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	log := &bytes.Buffer{}
+	pki.Log = log
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	if err = pki.GenerateServingCert(p, "webhook", "default", nil); err != nil {
+		t.Fatalf("failed to generate serving cert: %v", err)
+	}
+
+	// Directories generated by older versions do not have a manifest
+	if err = os.Remove(filepath.Join(pki.GeneratedCertsDirectory, certificateManifestFilename)); err != nil {
+		t.Fatalf("error removing manifest: %v", err)
+	}
+	pki.RemoveOrphanedCerts = true
+	p.Worker.Nodes[0].Host = "worker01-renamed"
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	if !strings.Contains(log.String(), "Removed orphaned certificate for worker01-kubelet") {
+		t.Errorf("expected the orphaned certificate to be removed, but got:\n%s", log.String())
+	}
+	for _, f := range []string{"worker01-kubelet.pem", "worker01-kubelet-key.pem"} {
+		if _, err := os.Stat(filepath.Join(pki.GeneratedCertsDirectory, f)); !os.IsNotExist(err) {
+			t.Errorf("expected orphaned file %q to be removed", f)
+		}
+	}
+	for _, f := range []string{"ca.pem", "ca-key.pem", "webhook-default-serving.pem", "worker01-renamed-kubelet.pem"} {
+		if _, err := os.Stat(filepath.Join(pki.GeneratedCertsDirectory, f)); err != nil {
+			t.Errorf("expected %q to exist: %v", f, err)
+		}
+	}
+}
+
+func TestLocalPKIConcurrency(t *testing.T) {
+	tests := []struct {
+		pki      LocalPKI
+		expected int
+	}{
+		{pki: LocalPKI{}, expected: runtime.NumCPU()},
+		{pki: LocalPKI{Concurrency: -1}, expected: runtime.NumCPU()},
+		{pki: LocalPKI{Concurrency: 1}, expected: 1},
+		{pki: LocalPKI{Concurrency: 4}, expected: 4},
+		{pki: LocalPKI{Concurrency: 4, Rand: rand.Reader}, expected: 1},
+	}
+	for i, test := range tests {
+		if c := test.pki.concurrency(); c != test.expected {
+			t.Errorf("test %d: expected concurrency %d, but got %d", i, test.expected, c)
+		}
+	}
+}
+
+func TestGenerateClusterCertificatesConcurrently(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	pki.Concurrency = 4
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	if warn, errs := pki.ValidateClusterCertificates(p); len(warn) > 0 || len(errs) > 0 {
+		t.Errorf("expected certificates to be valid, but got warnings %v and errors %v", warn, errs)
+	}
+}
+
+func TestGenerateClusterCertificatesClusterUID(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	pki.EmbedClusterUID = true
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	uid, err := pki.clusterUID(p)
+	if err != nil {
+		t.Fatalf("error reading cluster UID: %v", err)
+	}
+	if !strings.HasPrefix(uid, p.Cluster.Name+"-") {
+		t.Errorf("expected the cluster UID to start with the cluster name, but got %q", uid)
+	}
+	admin := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"), t)
+	if got := CertClusterUID(admin); got != uid {
+		t.Errorf("expected the certificate to be tagged with cluster UID %q, but got %q", uid, got)
+	}
+	if !reflect.DeepEqual(admin.Subject.Organization, []string{adminGroup}) {
+		t.Errorf("expected the organizations to be preserved, but got %v", admin.Subject.Organization)
+	}
+
+	b, err := ioutil.ReadFile(pki.manifestPath())
+	if err != nil {
+		t.Fatalf("error reading manifest: %v", err)
+	}
+	m := CertificateManifest{}
+	if err = json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("error decoding manifest: %v", err)
+	}
+	if m.ClusterUID != uid {
+		t.Errorf("expected the manifest to record cluster UID %q, but got %q", uid, m.ClusterUID)
+	}
+
+	// The UID is stable across runs
+	if err = pki.RegenerateCert(p, "admin"); err != nil {
+		t.Fatalf("error regenerating certificate: %v", err)
+	}
+	admin = mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"), t)
+	if got := CertClusterUID(admin); got != uid {
+		t.Errorf("expected the regenerated certificate to be tagged with cluster UID %q, but got %q", uid, got)
+	}
+}
+
+func TestGenerateClusterCertificatesFileNames(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	pki.FileNames = tls.FileNameScheme{Cert: "{name}.crt", Key: "{name}.key"}
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	for _, f := range []string{"ca.crt", "ca.key", "admin.crt", "admin.key"} {
+		if _, err := os.Stat(filepath.Join(pki.GeneratedCertsDirectory, f)); err != nil {
+			t.Errorf("expected %q to exist: %v", f, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem")); !os.IsNotExist(err) {
+		t.Errorf("expected files not to use the default names")
+	}
+	if _, err = pki.GetClusterCA(); err != nil {
+		t.Errorf("error reading back the CA: %v", err)
+	}
+	if warn, errs := pki.ValidateClusterCertificates(p); len(warn) > 0 || len(errs) > 0 {
+		t.Errorf("expected certificates to be valid, but got warnings %v and errors %v", warn, errs)
+	}
+	b, err := ioutil.ReadFile(pki.manifestPath())
+	if err != nil {
+		t.Fatalf("error reading manifest: %v", err)
+	}
+	if !strings.Contains(string(b), `"admin.crt"`) {
+		t.Errorf("expected the manifest to use the file name scheme, but got:\n%s", b)
+	}
+
+	pki.FileNames = tls.FileNameScheme{Cert: "cert.pem", Key: "key.pem"}
+	if err = pki.GenerateClusterCertificates(p, ca); err == nil {
+		t.Errorf("expected an error for a file name scheme that results in colliding files")
+	}
+}
+
+func TestCertManifestAPIServerExtraNames(t *testing.T) {
+	p := getPlan()
+	p.Master.APIServerExtraNames = []string{"api.internal.example.com", "api.example.com", "SOMEFQDN"}
+	m, err := certManifestForNode(*p, p.Master.Nodes[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var san []string
+	for _, s := range m {
+		if s.filename == p.Master.Nodes[0].Host+"-apiserver" {
+			san = s.subjectAlternateNames
+		}
+	}
+	for _, name := range []string{"api.internal.example.com", "api.example.com"} {
+		if !contains(name, san) {
+			t.Errorf("expected %q to be in the API server SANs %v", name, san)
+		}
+	}
+	if contains("SOMEFQDN", san) {
+		t.Errorf("expected names that differ only in case to be deduplicated, but got %v", san)
+	}
+}
+
+func TestGenerateClusterCACAKeyRequest(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	p.Cluster.Certificates.CAKeyAlgorithm = "ecdsa"
+	p.Cluster.Certificates.CAKeySize = 384
+	if _, err := pki.GenerateClusterCA(p); err != nil {
+		t.Fatalf("error generating CA: %v", err)
+	}
+	ca := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "ca.pem"), t)
+	if ca.PublicKeyAlgorithm != x509.ECDSA {
+		t.Errorf("expected an ECDSA CA key, but got %v", ca.PublicKeyAlgorithm)
+	}
+	if pub, ok := ca.PublicKey.(*ecdsa.PublicKey); !ok || pub.Curve.Params().BitSize != 384 {
+		t.Errorf("expected a 384 bit ECDSA CA key")
+	}
+	// The rest of the CSR file is still used
+	if len(ca.Subject.Organization) == 0 {
+		t.Errorf("expected the subject of the CA CSR file to be used")
+	}
+}
+
+func TestNodeHostnameSANs(t *testing.T) {
+	tests := []struct {
+		host       string
+		shortNames bool
+		domain     string
+		expected   []string
+	}{
+		{host: "node01.example.com", expected: []string{"node01.example.com"}},
+		{host: "node01.example.com", shortNames: true, expected: []string{"node01.example.com", "node01"}},
+		{host: "node01", shortNames: true, expected: []string{"node01"}},
+		{host: "node01", shortNames: true, domain: "example.com.", expected: []string{"node01", "node01.example.com"}},
+		{host: "node01.example.com", shortNames: true, domain: "example.com", expected: []string{"node01.example.com", "node01"}},
+	}
+	for _, test := range tests {
+		p := getPlan()
+		p.Cluster.Certificates.NodeShortNameSANs = test.shortNames
+		p.Cluster.Certificates.NodeDomain = test.domain
+		san := nodeHostnameSANs(*p, Node{Host: test.host})
+		if !reflect.DeepEqual(san, test.expected) {
+			t.Errorf("%q: expected %v, but got %v", test.host, test.expected, san)
+		}
+	}
+}
+
+func TestCertManifestNodeShortNameSANs(t *testing.T) {
+	p := getPlan()
+	p.Cluster.Certificates.NodeShortNameSANs = true
+	node := Node{Host: "master01.example.com", IP: "99.99.99.99"}
+	p.Master.Nodes[0] = node
+	m, err := certManifestForNode(*p, node)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, s := range m {
+		if s.filename != node.Host+"-etcd" && s.filename != node.Host+"-apiserver" {
+			continue
+		}
+		if !contains("master01", s.subjectAlternateNames) {
+			t.Errorf("%s: expected the short name to be in the SANs %v", s.filename, s.subjectAlternateNames)
+		}
+		if len(uniqueStrings(s.subjectAlternateNames)) != len(s.subjectAlternateNames) {
+			t.Errorf("%s: expected the SANs to be unique, but got %v", s.filename, s.subjectAlternateNames)
+		}
+	}
+}
+
+func TestCertManifestRoleDefaultSANs(t *testing.T) {
+	p := getPlan()
+	etcd := Node{Host: "etcd01", IP: "10.0.1.1"}
+	worker := Node{Host: "worker01", IP: "10.0.1.2"}
+	p.Etcd.Nodes = []Node{etcd}
+	p.Worker.Nodes = []Node{worker}
+	p.Ingress.Nodes = []Node{}
+	p.Storage.Nodes = []Node{}
+
+	m, err := certManifestForNode(*p, etcd)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m) != 2 {
+		t.Fatalf("expected the etcd server and peer certificates for the etcd node, but got %d", len(m))
+	}
+	if containsAny(etcdDefaultSANs(), m[1].subjectAlternateNames) {
+		t.Errorf("expected the etcd peer certificate not to include the loopback address, but got %v", m[1].subjectAlternateNames)
+	}
+	if !contains("127.0.0.1", m[0].subjectAlternateNames) {
+		t.Errorf("expected the etcd server certificate to include the loopback address, but got %v", m[0].subjectAlternateNames)
+	}
+	if containsAny(masterDefaultSANs()[:4], m[0].subjectAlternateNames) {
+		t.Errorf("expected the etcd server certificate not to include the kubernetes service names, but got %v", m[0].subjectAlternateNames)
+	}
+
+	m, err = certManifestForNode(*p, worker)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, s := range m {
+		if containsAny(masterDefaultSANs(), s.subjectAlternateNames) {
+			t.Errorf("%s: expected no API server SANs on a worker certificate, but got %v", s.filename, s.subjectAlternateNames)
+		}
+	}
+}
+
+func TestGenerateClusterCertificatesEtcdPeerCert(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	peer := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "etcd01-etcd-peer.pem"), t)
+	if peer.Subject.CommonName != "etcd01" {
+		t.Errorf("expected the common name of the peer certificate to be the hostname, but got %q", peer.Subject.CommonName)
+	}
+	for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth} {
+		found := false
+		for _, u := range peer.ExtKeyUsage {
+			found = found || u == usage
+		}
+		if !found {
+			t.Errorf("expected the peer certificate to have the extended key usage %v, but got %v", usage, peer.ExtKeyUsage)
+		}
+	}
+	if err := peer.VerifyHostname("etcd01"); err != nil {
+		t.Errorf("expected the peer certificate to be valid for the hostname: %v", err)
+	}
+	if err := peer.VerifyHostname("127.0.0.1"); err == nil {
+		t.Errorf("expected the peer certificate not to be valid for the loopback address")
+	}
+	server := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "etcd01-etcd.pem"), t)
+	if reflect.DeepEqual(server.PublicKey, peer.PublicKey) {
+		t.Errorf("expected the peer and server certificates to have distinct keys")
+	}
+}
+
+func TestCertManifestInternalIPSameAsIP(t *testing.T) {
+	p := getPlan()
+	node := Node{Host: "etcd01", IP: "10.0.1.1", InternalIP: "10.0.1.1"}
+	p.Etcd.Nodes = []Node{node}
+	p.Master.Nodes = []Node{node}
+	p.Worker.Nodes = []Node{}
+	p.Ingress.Nodes = []Node{}
+	p.Storage.Nodes = []Node{}
+	p.DockerRegistry.SetupInternal = true
+
+	m, err := certManifestForCluster(*p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, s := range m {
+		if !reflect.DeepEqual(s.subjectAlternateNames, uniqueStrings(s.subjectAlternateNames)) {
+			t.Errorf("%s: expected no duplicate SANs, but got %v", s.filename, s.subjectAlternateNames)
+		}
+	}
+}
+
+func TestCertManifestSANAllowlist(t *testing.T) {
+	p := getPlan()
+	m, err := certManifestForCluster(*p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	allowlist := []string{}
+	for _, s := range m {
+		for _, san := range s.subjectAlternateNames {
+			if san != "kubernetes" && !contains(san, allowlist) {
+				allowlist = append(allowlist, san)
+			}
+		}
+	}
+	p.Cluster.Certificates.SANAllowlist = allowlist
+	_, err = certManifestForCluster(*p)
+	if err == nil {
+		t.Fatalf("expected an error when a SAN is not in the allowlist")
+	}
+	if !strings.Contains(err.Error(), `"kubernetes"`) || !strings.Contains(err.Error(), "etcd01") {
+		t.Errorf("expected the error to name the SAN and the host, but got %q", err)
+	}
+	p.Cluster.Certificates.SANAllowlist = append(allowlist, "KUBERNETES")
+	if _, err = certManifestForCluster(*p); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCertManifestMaxSANs(t *testing.T) {
+	p := getPlan()
+	for i := 0; i < defaultMaxSANs; i++ {
+		p.Master.APIServerExtraNames = append(p.Master.APIServerExtraNames, fmt.Sprintf("api%d.example.com", i))
+	}
+	_, err := certManifestForCluster(*p)
+	if err == nil {
+		t.Fatalf("expected an error when a certificate has too many SANs")
+	}
+	if !strings.Contains(err.Error(), "API server") || !strings.Contains(err.Error(), fmt.Sprintf("maximum of %d", defaultMaxSANs)) {
+		t.Errorf("expected the error to name the certificate and the maximum, but got %q", err)
+	}
+	p.Cluster.Certificates.MaxSANs = 2 * defaultMaxSANs
+	if _, err = certManifestForCluster(*p); err != nil {
+		t.Errorf("unexpected error when the maximum is raised: %v", err)
+	}
+}
+
+func TestSANAllowed(t *testing.T) {
+	allowlist := []string{"10.0.0.0/24", "192.168.1.1", "foo.example.com"}
+	tests := []struct {
+		san     string
+		allowed bool
+	}{
+		{san: "10.0.0.42", allowed: true},
+		{san: "10.0.1.1", allowed: false},
+		{san: "192.168.1.1", allowed: true},
+		{san: "192.168.1.2", allowed: false},
+		{san: "Foo.Example.com", allowed: true},
+		{san: "bar.example.com", allowed: false},
+		{san: "10.0.0.0/24", allowed: false},
+		{san: "foo.example.com/24", allowed: false},
+	}
+	for _, test := range tests {
+		if allowed := sanAllowed(test.san, allowlist); allowed != test.allowed {
+			t.Errorf("%q: expected allowed = %v, but got %v", test.san, test.allowed, allowed)
+		}
+	}
+}
+
+func TestCertManifestFilenameCollision(t *testing.T) {
+	p := getPlan()
+	if _, err := certManifestForCluster(*p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p.Worker.Nodes[1].Host = "WORKER01"
+	p.Worker.Nodes[1].IP = "99.99.99.98"
+	if _, err := certManifestForCluster(*p); err == nil {
+		t.Errorf("expected an error when two nodes would share certificate files")
+	}
+}
+
+func TestGenerateClusterCertificatesExpiryExceedsCA(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	p.Cluster.Certificates.CAExpiry = "2h"
+	p.Cluster.Certificates.Expiry = "2h"
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA: %v", err)
+	}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("expected certificates that expire along with the CA to be valid, but got error: %v", err)
+	}
+
+	p.Cluster.Certificates.Expiry = "3h"
+	if err := pki.RotateLeafCerts(p); err == nil {
+		t.Errorf("expected an error when the certificates would expire after the CA")
+	}
+}
+
+func TestGenerateClusterCertificatesNodeCertValidity(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	now := time.Now().Truncate(time.Second)
+	pki.Now = func() time.Time { return now }
+
+	p := getPlan()
+	p.Cluster.Certificates.CAExpiry = "100h"
+	p.Master.Nodes[0].CertValidity = "48h"
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA: %v", err)
+	}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("failed to generate certs: %v", err)
+	}
+	tests := []struct {
+		name   string
+		expiry time.Duration
+	}{
+		{name: "master01-apiserver", expiry: 48 * time.Hour},
+		{name: "master01-kubelet", expiry: 48 * time.Hour},
+		{name: "master02-apiserver", expiry: time.Hour},
+		{name: "worker01-kubelet", expiry: time.Hour},
+		{name: "kube-proxy", expiry: time.Hour},
+	}
+	for _, test := range tests {
+		cert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, test.name+".pem"), t)
+		if d := cert.NotAfter.Sub(cert.NotBefore); d != test.expiry {
+			t.Errorf("%s: expected the certificate to be valid for %s, but got %s", test.name, test.expiry, d)
+		}
+	}
+
+	p.Master.Nodes[0].CertValidity = "200h"
+	if err := pki.RotateLeafCerts(p); err == nil {
+		t.Errorf("expected an error when the certificates of the node would expire after the CA")
+	}
+}
+
+func TestGetClusterCAMismatchedKeyPair(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	otherKey, _, err := tls.NewCACert("test/ca-csr.json", "someOtherCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating CA: %v", err)
+	}
+	if _, err := pki.GenerateClusterCA(getPlan()); err != nil {
+		t.Fatalf("error generating CA: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(pki.GeneratedCertsDirectory, "ca-key.pem"), otherKey, 0600); err != nil {
+		t.Fatalf("error writing CA key: %v", err)
+	}
+	if _, err := pki.GetClusterCA(); err == nil {
+		t.Errorf("expected an error when the CA key does not match the CA certificate")
+	}
+	if _, err := pki.GenerateClusterCA(getPlan()); err == nil {
+		t.Errorf("expected an error when the existing CA key does not match the CA certificate")
+	}
+}
+
+func TestGenerateClusterCertificatesSigningProfile(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	p.Cluster.Certificates.SigningProfile = &SigningProfile{
+		Usages: []string{"signing", "key encipherment", "server auth"},
+		Expiry: "2h",
+	}
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA: %v", err)
+	}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+
+	kubelet := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "worker01-kubelet.pem"), t)
+	if !reflect.DeepEqual(kubelet.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}) {
+		t.Errorf("expected the usages of the signing profile, but got %v", kubelet.ExtKeyUsage)
+	}
+	if d := kubelet.NotAfter.Sub(time.Now()); d < time.Hour || d > 2*time.Hour {
+		t.Errorf("expected the certificate to expire in 2h, but it expires on %v", kubelet.NotAfter)
+	}
+	etcdClient := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "apiserver-etcd-client.pem"), t)
+	if !reflect.DeepEqual(etcdClient.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}) {
+		t.Errorf("expected client certificates to keep their usages, but got %v", etcdClient.ExtKeyUsage)
+	}
+}
+
+func TestGenerateClusterCertificatesContinueOnWriteFailure(t *testing.T) {
+	for _, continueOnFailure := range []bool{false, true} {
+		pki := getPKI(t)
+		defer cleanup(pki.GeneratedCertsDirectory, t)
+		pki.ContinueOnWriteFailure = continueOnFailure
+
+		p := getPlan()
+		ca, err := pki.GenerateClusterCA(p)
+		if err != nil {
+			t.Fatalf("error generating CA: %v", err)
+		}
+		// A directory in place of the private key makes the write fail
+		if err := os.Mkdir(filepath.Join(pki.GeneratedCertsDirectory, "worker01-kubelet-key.pem"), 0755); err != nil {
+			t.Fatalf("error creating directory: %v", err)
+		}
+		err = pki.GenerateClusterCertificates(p, ca)
+		if !continueOnFailure {
+			if err == nil {
+				t.Errorf("expected an error when a certificate cannot be written")
+			}
+			continue
+		}
+		failures, ok := err.(CertWriteFailuresErr)
+		if !ok {
+			t.Fatalf("expected a CertWriteFailuresErr, but got %v", err)
+		}
+		if len(failures.Failures) != 1 || failures.Failures[0].Name != "worker01-kubelet" {
+			t.Errorf("expected the kubelet certificate of worker01 to fail, but got %v", failures.Failures)
+		}
+		if _, err := os.Stat(filepath.Join(pki.GeneratedCertsDirectory, "worker02-kubelet.pem")); err != nil {
+			t.Errorf("expected the other certificates to be generated, but got error: %v", err)
+		}
+		m, err := pki.readManifest()
+		if err != nil {
+			t.Fatalf("error reading manifest: %v", err)
+		}
+		for _, e := range m.Certificates {
+			if e.Failed != (e.Name == "worker01-kubelet") {
+				t.Errorf("%s: expected failed = %v in the manifest", e.Name, !e.Failed)
+			}
+		}
+	}
+}
+
+func TestGenerateClusterCertificatesNotAfter(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	notAfter := time.Now().Add(90 * time.Minute).UTC().Truncate(time.Second)
+	p := getPlan()
+	p.Cluster.Certificates.NotAfter = notAfter.Format(time.RFC3339)
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA: %v", err)
+	}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	cert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"), t)
+	if !cert.NotAfter.Equal(notAfter) {
+		t.Errorf("expected the certificate to expire on %v, but got %v", notAfter, cert.NotAfter)
+	}
+
+	p.Cluster.Certificates.NotAfter = time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	if err := pki.RotateLeafCerts(p); err == nil {
+		t.Errorf("expected an error when the not after date has passed")
+	}
+}
+
+func TestGenerateClusterCertificatesClientCertificates(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	p.Cluster.Certificates.ClientCertificates = []ClientCertificate{
+		{Name: "ops", User: "alice", Groups: []string{"system:masters", "ops"}},
+	}
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA: %v", err)
+	}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	// The organizations are in the order of their DER encoding
+	t.Run("ops", validateClientCertificateAndKey(pki.GeneratedCertsDirectory, "ops.pem", "alice", "ops", "system:masters"))
+	cert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "ops.pem"), t)
+	if !reflect.DeepEqual(cert.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}) {
+		t.Errorf("expected a client certificate, but got usages %v", cert.ExtKeyUsage)
+	}
+}
+
+func TestGenerateClusterCAEmptyClusterName(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+
+	p := getPlan()
+	p.Cluster.Name = ""
+	if _, err := pki.GenerateClusterCA(p); err == nil {
+		t.Errorf("expected an error when the cluster name is empty")
+	}
+}
+
+func TestGenerateClusterCertificatesDER(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	dir := pki.GeneratedCertsDirectory
+
+	pki.DER = true
+	pki.Checksums = true
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA: %v", err)
+	}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	for _, name := range []string{"ca", "admin", "etcd01-etcd"} {
+		certDER, err := ioutil.ReadFile(filepath.Join(dir, name+".der"))
+		if err != nil {
+			t.Errorf("%s: error reading DER certificate: %v", name, err)
+			continue
+		}
+		cert, err := x509.ParseCertificate(certDER)
+		if err != nil {
+			t.Errorf("%s: error parsing DER certificate: %v", name, err)
+			continue
+		}
+		if expected := mustReadCertFile(filepath.Join(dir, name+".pem"), t); !cert.Equal(expected) {
+			t.Errorf("%s: expected the DER certificate to be the same as the PEM certificate", name)
+		}
+		keyDER, err := ioutil.ReadFile(filepath.Join(dir, name+"-key.der"))
+		if err != nil {
+			t.Errorf("%s: error reading DER private key: %v", name, err)
+			continue
+		}
+		if _, err := x509.ParsePKCS8PrivateKey(keyDER); err != nil {
+			t.Errorf("%s: error parsing DER private key: %v", name, err)
+		}
+	}
+
+	m, err := pki.readManifest()
+	if err != nil {
+		t.Fatalf("error reading manifest: %v", err)
+	}
+	written, err := filepath.Glob(filepath.Join(dir, "admin*"))
+	if err != nil {
+		t.Fatalf("error listing admin files: %v", err)
+	}
+	for i := range written {
+		written[i] = filepath.Base(written[i])
+	}
+	sort.Strings(written)
+	for _, e := range m.Certificates {
+		if e.Name != "admin" {
+			continue
+		}
+		if e.CertFile != "admin.pem" || e.KeyFile != "admin-key.pem" || !reflect.DeepEqual(e.Files, []string{"admin-key.der", "admin.der"}) {
+			t.Errorf("expected the PEM files and the DER files in the manifest, but got %+v", e)
+		}
+		listed := append([]string{e.CertFile, e.KeyFile}, e.Files...)
+		sort.Strings(listed)
+		if !reflect.DeepEqual(listed, written) {
+			t.Errorf("expected the manifest to list every written file %v, but got %v", written, listed)
+		}
+	}
+
+	sums, err := ioutil.ReadFile(filepath.Join(dir, checksumsFilename))
+	if err != nil {
+		t.Fatalf("error reading checksums file: %v", err)
+	}
+	if !strings.Contains(string(sums), " admin.der\n") || strings.Contains(string(sums), "admin-key.der") {
+		t.Errorf("expected the DER certificate, but not the DER private key, to be checksummed:\n%s", sums)
+	}
+}
+
+func TestCertManifestDedicatedAPIServerCert(t *testing.T) {
+	p := Plan{
+		Cluster: Cluster{
+			Name:         "someName",
+			Certificates: CertsConfig{Expiry: "1h", DedicatedAPIServerCert: true},
+			Networking:   NetworkConfig{ServiceCIDRBlock: "10.0.0.0/24"},
+		},
+		AddOns: AddOns{CNI: &CNI{}},
+		Etcd:   NodeGroup{Nodes: []Node{{Host: "etcd01", IP: "10.1.0.1"}}},
+		Master: MasterNodeGroup{
+			Nodes: []Node{
+				{Host: "master01", IP: "10.1.0.2", InternalIP: "10.2.0.2"},
+				{Host: "master02", IP: "10.1.0.3"},
+			},
+			LoadBalancedFQDN:      "someFQDN",
+			LoadBalancedShortName: "someShortName",
+		},
+		Worker: NodeGroup{Nodes: []Node{{Host: "worker01", IP: "10.1.0.4"}}},
+	}
+	manifest, err := certManifestForCluster(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	count := 0
+	for _, s := range manifest {
+		if s.filename == apiServerCertFilename {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("expected a single dedicated API server certificate, but got %d", count)
+	}
+	specs := specsByFilename(manifest)
+	expected := []string{
+		"kubernetes", "kubernetes.default", "kubernetes.default.svc", "kubernetes.default.svc.cluster.local", "127.0.0.1", "10.0.0.1",
+		"someFQDN", "someShortName", "10.1.0.2", "10.2.0.2", "10.1.0.3",
+	}
+	apiServer := specs[apiServerCertFilename]
+	if apiServer.commonName != apiServerCertCommonName {
+		t.Errorf("expected common name %q, but got %q", apiServerCertCommonName, apiServer.commonName)
+	}
+	if !reflect.DeepEqual(apiServer.subjectAlternateNames, expected) {
+		t.Errorf("expected the dedicated API server certificate SANs to be %v, but got %v", expected, apiServer.subjectAlternateNames)
+	}
+	if san := specs["master01-apiserver"].subjectAlternateNames; !reflect.DeepEqual(san, []string{"master01", "10.1.0.2", "10.2.0.2"}) {
+		t.Errorf("expected the node API server certificate to drop the service SANs, but got %v", san)
+	}
+	if i := rotationPhaseIndex(apiServerCertFilename); rotationPhases[i].Name != "servers" {
+		t.Errorf("expected the dedicated API server certificate to be rotated with the servers, but got %q", rotationPhases[i].Name)
+	}
+
+	p.Cluster.Certificates.DedicatedAPIServerCert = false
+	manifest, err = certManifestForCluster(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	specs = specsByFilename(manifest)
+	if _, ok := specs[apiServerCertFilename]; ok {
+		t.Errorf("expected no dedicated API server certificate by default")
+	}
+	if san := specs["master01-apiserver"].subjectAlternateNames; !contains("kubernetes", san) || !contains("someFQDN", san) {
+		t.Errorf("expected the node API server certificate to include the service SANs by default, but got %v", san)
+	}
+}
+
+func TestCertManifestPublicCluster(t *testing.T) {
+	p := Plan{
+		Cluster: Cluster{
+			Name:         "someName",
+			Certificates: CertsConfig{Expiry: "1h", PublicCluster: true},
+			Networking:   NetworkConfig{ServiceCIDRBlock: "10.0.0.0/24"},
+		},
+		AddOns: AddOns{CNI: &CNI{}},
+		// Private IPs are accepted on the certificates of other components
+		Etcd: NodeGroup{Nodes: []Node{{Host: "etcd01", IP: "10.1.0.1"}}},
+		Master: MasterNodeGroup{
+			Nodes:                 []Node{{Host: "master01", IP: "52.0.0.2"}},
+			LoadBalancedFQDN:      "someFQDN",
+			LoadBalancedShortName: "someShortName",
+		},
+		Worker: NodeGroup{Nodes: []Node{{Host: "worker01", IP: "10.1.0.3"}}},
+	}
+	if _, err := certManifestForCluster(p); err != nil {
+		t.Errorf("expected the loopback address and the kubernetes service IP to be accepted, but got %v", err)
+	}
+
+	p.Master.Nodes = []Node{{Host: "master01", IP: "52.0.0.2", InternalIP: "10.2.0.2"}}
+	p.Master.APIServerExtraIPs = []string{"192.168.1.1"}
+	_, err := certManifestForCluster(p)
+	if err == nil {
+		t.Fatalf("expected an error when the API server certificate includes private IPs")
+	}
+	for _, ip := range []string{"10.2.0.2", "192.168.1.1"} {
+		if !strings.Contains(err.Error(), ip) {
+			t.Errorf("expected the error to list %s, but got %v", ip, err)
+		}
+	}
+
+	p.Cluster.Certificates.DedicatedAPIServerCert = true
+	if _, err := certManifestForCluster(p); err == nil {
+		t.Errorf("expected an error when the dedicated API server certificate includes private IPs")
+	}
+
+	p.Cluster.Certificates.PublicCluster = false
+	if _, err := certManifestForCluster(p); err != nil {
+		t.Errorf("expected private IPs to be accepted unless the cluster is public, but got %v", err)
+	}
+}
+
+func TestIsNonPublicIP(t *testing.T) {
+	tests := []struct {
+		ip        string
+		nonPublic bool
+	}{
+		{ip: "10.0.0.1", nonPublic: true},
+		{ip: "172.16.5.4", nonPublic: true},
+		{ip: "172.32.0.1", nonPublic: false},
+		{ip: "192.168.0.1", nonPublic: true},
+		{ip: "100.64.0.1", nonPublic: true},
+		{ip: "169.254.169.254", nonPublic: true},
+		{ip: "52.0.0.2", nonPublic: false},
+		{ip: "fd00::1", nonPublic: true},
+		{ip: "2600::1", nonPublic: false},
+	}
+	for _, test := range tests {
+		if nonPublic := isNonPublicIP(net.ParseIP(test.ip)); nonPublic != test.nonPublic {
+			t.Errorf("%s: expected non public = %v, but got %v", test.ip, test.nonPublic, nonPublic)
+		}
+	}
+}
+
+func TestGenerateClusterCertificatesSPIFFE(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	p := getPlan()
+	p.Cluster.Certificates.SPIFFETrustDomain = "example.org"
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	tests := []struct {
+		name string
+		uris []string
+	}{
+		{name: "etcd01-etcd", uris: []string{"spiffe://example.org/node/etcd01"}},
+		{name: "master01-apiserver", uris: []string{"spiffe://example.org/node/master01"}},
+		{name: "worker01-kubelet", uris: []string{"spiffe://example.org/node/worker01"}},
+		{name: "kube-proxy"},
+		{name: "admin"},
+	}
+	for _, test := range tests {
+		cert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, test.name+".pem"), t)
+		uris, err := tls.CertURIs(cert)
+		if err != nil {
+			t.Fatalf("%s: error reading URI SANs: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(uris, test.uris) {
+			t.Errorf("%s: expected URI SANs %v, but got %v", test.name, test.uris, uris)
+		}
+	}
+	// The other SANs are kept
+	cert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "master01-apiserver.pem"), t)
+	if !contains("master01", cert.DNSNames) || !certHasIP(cert.IPAddresses, net.ParseIP("99.99.99.99")) {
+		t.Errorf("expected the DNS and IP SANs to be kept, but got %v and %v", cert.DNSNames, cert.IPAddresses)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"os"
 	"regexp"
 	"strings"
@@ -289,20 +290,56 @@ func WritePlanTemplate(p *Plan, w PlanReadWriter) error {
 	return nil
 }
 
+// ServiceNetwork returns the service network of the plan, parsed from its
+// service CIDR block. An error is returned if the block is not valid, or if
+// it is too small to allocate the kubernetes and DNS service IPs.
+func (p *Plan) ServiceNetwork() (*net.IPNet, error) {
+	return parseServiceCIDR(p.Cluster.Networking.ServiceCIDRBlock)
+}
+
+// returns the n-th address of the service network of the plan
+func (p *Plan) serviceIP(n int) (net.IP, error) {
+	serviceNet, err := p.ServiceNetwork()
+	if err != nil {
+		return nil, err
+	}
+	ip, err := util.GetIPFromNetwork(serviceNet, n)
+	if err != nil {
+		return nil, err
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4, nil
+	}
+	return ip, nil
+}
+
+// KubernetesServiceIP returns the cluster IP of the kubernetes service, which
+// is the first address of the service CIDR block of the plan.
+func KubernetesServiceIP(p *Plan) (net.IP, error) {
+	ip, err := p.serviceIP(1)
+	if err != nil {
+		return nil, fmt.Errorf("error getting kubernetes service IP: %v", err)
+	}
+	return ip, nil
+}
+
 func getKubernetesServiceIP(p *Plan) (string, error) {
-	ip, err := util.GetIPFromCIDR(p.Cluster.Networking.ServiceCIDRBlock, 1)
+	ip, err := KubernetesServiceIP(p)
 	if err != nil {
-		return "", fmt.Errorf("error getting kubernetes service IP: %v", err)
+		return "", err
 	}
-	return ip.To4().String(), nil
+	return ip.String(), nil
 }
 
 func getDNSServiceIP(p *Plan) (string, error) {
-	ip, err := util.GetIPFromCIDR(p.Cluster.Networking.ServiceCIDRBlock, 2)
+	if p.Cluster.Networking.DNSServiceIP != "" {
+		return p.Cluster.Networking.DNSServiceIP, nil
+	}
+	ip, err := p.serviceIP(2)
 	if err != nil {
 		return "", fmt.Errorf("error getting DNS service IP: %v", err)
 	}
-	return ip.To4().String(), nil
+	return ip.String(), nil
 }
 
 func generateAlphaNumericPassword() (string, error) {
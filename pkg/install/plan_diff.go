@@ -0,0 +1,168 @@
+package install
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Change is a difference between two plans that affects the certificates of
+// the cluster, and requires them to be regenerated.
+type Change struct {
+	// Certificate is the name of the affected certificate. Empty when the
+	// change affects all the certificates signed by the CA.
+	Certificate string
+	// Field is the certificate attribute that changed
+	Field string
+	// Old is the value in the old plan. Empty if the certificate was added.
+	Old string
+	// New is the value in the new plan. Empty if the certificate was removed.
+	New string
+}
+
+func (c Change) String() string {
+	cert := c.Certificate
+	if cert == "" {
+		cert = "all certificates"
+	}
+	switch {
+	case c.Field == "certificate" && c.Old == "":
+		return fmt.Sprintf("%s: added", cert)
+	case c.Field == "certificate" && c.New == "":
+		return fmt.Sprintf("%s: removed", cert)
+	default:
+		return fmt.Sprintf("%s: %s changed from %q to %q", cert, c.Field, c.Old, c.New)
+	}
+}
+
+// DiffPlans returns the changes between the old and new plans that affect the
+// cluster's certificates. Changes that have no effect on the certificates are
+// not reported.
+func DiffPlans(old, new *Plan) ([]Change, error) {
+	changes := []Change{}
+
+	// Changes to the CA
+	oldCerts, newCerts := old.Cluster.Certificates, new.Cluster.Certificates
+	if old.Cluster.Name != new.Cluster.Name {
+		changes = append(changes, Change{Certificate: "ca", Field: "common name", Old: old.Cluster.Name, New: new.Cluster.Name})
+	}
+	if oldCerts.CAExpiry != newCerts.CAExpiry {
+		changes = append(changes, Change{Certificate: "ca", Field: "expiry", Old: oldCerts.CAExpiry, New: newCerts.CAExpiry})
+	}
+	if o, n := strings.Join(oldCerts.CAKeyUsages, ","), strings.Join(newCerts.CAKeyUsages, ","); o != n {
+		changes = append(changes, Change{Certificate: "ca", Field: "key usages", Old: o, New: n})
+	}
+	if o, n := caKeyRequestString(oldCerts), caKeyRequestString(newCerts); o != n {
+		changes = append(changes, Change{Certificate: "ca", Field: "key request", Old: o, New: n})
+	}
+	if o, n := caCSRString(oldCerts.CACSR), caCSRString(newCerts.CACSR); o != n {
+		changes = append(changes, Change{Certificate: "ca", Field: "certificate request", Old: o, New: n})
+	}
+
+	// Changes to all leaf certificates
+	if o, n := oldCerts.leafExpiry(), newCerts.leafExpiry(); o != n {
+		changes = append(changes, Change{Field: "expiry", Old: o, New: n})
+	}
+	if oldCerts.NotAfter != newCerts.NotAfter {
+		changes = append(changes, Change{Field: "not after", Old: oldCerts.NotAfter, New: newCerts.NotAfter})
+	}
+	if o, n := leafKeyRequestString(oldCerts), leafKeyRequestString(newCerts); o != n {
+		changes = append(changes, Change{Field: "key request", Old: o, New: n})
+	}
+	if o, n := signingProfileString(oldCerts.SigningProfile), signingProfileString(newCerts.SigningProfile); o != n {
+		changes = append(changes, Change{Field: "signing profile", Old: o, New: n})
+	}
+
+	// Changes to individual certificates
+	oldManifest, err := certManifestForCluster(*old)
+	if err != nil {
+		return nil, fmt.Errorf("error building certificate manifest of the old plan: %v", err)
+	}
+	newManifest, err := certManifestForCluster(*new)
+	if err != nil {
+		return nil, fmt.Errorf("error building certificate manifest of the new plan: %v", err)
+	}
+	oldSpecs := specsByFilename(oldManifest)
+	newSpecs := specsByFilename(newManifest)
+	names := []string{}
+	for name := range oldSpecs {
+		names = append(names, name)
+	}
+	for name := range newSpecs {
+		if _, ok := oldSpecs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		o, inOld := oldSpecs[name]
+		n, inNew := newSpecs[name]
+		switch {
+		case !inOld:
+			changes = append(changes, Change{Certificate: name, Field: "certificate", New: n.description})
+		case !inNew:
+			changes = append(changes, Change{Certificate: name, Field: "certificate", Old: o.description})
+		default:
+			if o.commonName != n.commonName {
+				changes = append(changes, Change{Certificate: name, Field: "common name", Old: o.commonName, New: n.commonName})
+			}
+			if o, n := sortedJoin(o.subjectAlternateNames), sortedJoin(n.subjectAlternateNames); o != n {
+				changes = append(changes, Change{Certificate: name, Field: "subject alternate names", Old: o, New: n})
+			}
+			if o, n := sortedJoin(o.uris), sortedJoin(n.uris); o != n {
+				changes = append(changes, Change{Certificate: name, Field: "URI SANs", Old: o, New: n})
+			}
+			if o, n := sortedJoin(o.organizations), sortedJoin(n.organizations); o != n {
+				changes = append(changes, Change{Certificate: name, Field: "organizations", Old: o, New: n})
+			}
+			if o, n := strings.Join(o.usages, ","), strings.Join(n.usages, ","); o != n {
+				changes = append(changes, Change{Certificate: name, Field: "usages", Old: o, New: n})
+			}
+		}
+	}
+	return changes, nil
+}
+
+func specsByFilename(specs []certificateSpec) map[string]certificateSpec {
+	m := make(map[string]certificateSpec, len(specs))
+	for _, s := range specs {
+		m[s.filename] = s
+	}
+	return m
+}
+
+func sortedJoin(xs []string) string {
+	sorted := make([]string, len(xs))
+	copy(sorted, xs)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+func caCSRString(c *CACSR) string {
+	if c == nil {
+		return ""
+	}
+	return fmt.Sprintf("%+v", *c)
+}
+
+func caKeyRequestString(c CertsConfig) string {
+	kr := c.caKeyRequest()
+	if kr == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s-%d", kr.A, kr.S)
+}
+
+func leafKeyRequestString(c CertsConfig) string {
+	kr := c.leafKeyRequest()
+	return fmt.Sprintf("%s-%d", kr.A, kr.S)
+}
+
+// returns the usages of the signing profile, as its expiry is compared as
+// the expiry of the leaf certificates
+func signingProfileString(p *SigningProfile) string {
+	if p == nil {
+		return ""
+	}
+	return strings.Join(p.Usages, ",")
+}
@@ -0,0 +1,121 @@
+package install
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffPlansNoChanges(t *testing.T) {
+	changes, err := DiffPlans(getPlan(), getPlan())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes, but got %v", changes)
+	}
+}
+
+func TestDiffPlansIgnoresNonCertificateChanges(t *testing.T) {
+	old := getPlan()
+	new := getPlan()
+	new.Cluster.AdminPassword = "someOtherPassword"
+	new.Cluster.PackageRepoURLs = "http://example.com"
+	changes, err := DiffPlans(old, new)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes, but got %v", changes)
+	}
+}
+
+func TestDiffPlansCertificateChanges(t *testing.T) {
+	old := getPlan()
+	new := getPlan()
+	new.Cluster.Name = "someOtherName"
+	new.Cluster.Certificates.Expiry = "2h"
+	new.Master.LoadBalancedFQDN = "someOtherFQDN"
+	new.Worker.Nodes = append(new.Worker.Nodes, Node{Host: "worker03", IP: "10.10.10.10"})
+	changes, err := DiffPlans(old, new)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		"ca/common name": "someOtherName",
+		"/expiry":        "2h",
+		"master01-apiserver/subject alternate names": "",
+		"worker03-kubelet/certificate":               "worker03 kubelet",
+	}
+	for key, value := range expected {
+		found := false
+		for _, c := range changes {
+			if c.Certificate+"/"+c.Field == key && (value == "" || c.New == value) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected change %q, but got %v", key, changes)
+		}
+	}
+}
+
+func TestDiffPlansLeafKeyRequest(t *testing.T) {
+	old := getPlan()
+	new := getPlan()
+	new.Cluster.Certificates.KeyAlgorithm = "ecdsa"
+	new.Cluster.Certificates.KeySize = 256
+	changes, err := DiffPlans(old, new)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	found := false
+	for _, c := range changes {
+		if c.Certificate == "" && c.Field == "key request" && c.Old == "rsa-2048" && c.New == "ecdsa-256" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected the key request of the certificates to change, but got %v", changes)
+	}
+}
+
+func TestDiffPlansSigningProfile(t *testing.T) {
+	old := getPlan()
+	new := getPlan()
+	new.Cluster.Certificates.SigningProfile = &SigningProfile{Usages: []string{"signing", "key encipherment", "server auth"}}
+	changes, err := DiffPlans(old, new)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	found := false
+	for _, c := range changes {
+		if c.Certificate == "" && c.Field == "signing profile" && c.Old == "" && c.New == "signing,key encipherment,server auth" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected the signing profile of the certificates to change, but got %v", changes)
+	}
+}
+
+func TestDiffPlansRemovedCertificate(t *testing.T) {
+	old := getPlan()
+	new := getPlan()
+	new.Worker.Nodes = new.Worker.Nodes[:1]
+	changes, err := DiffPlans(old, new)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	found := false
+	for _, c := range changes {
+		if !strings.HasPrefix(c.Certificate, "worker02-") || c.Field != "certificate" || c.New != "" {
+			t.Errorf("unexpected change %v", c)
+		}
+		if c.Certificate == "worker02-kubelet" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected the worker02 kubelet certificate to be removed, but got %v", changes)
+	}
+}
@@ -0,0 +1,88 @@
+package install
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+)
+
+// the fields of the plan that affect the cluster certificates
+type planHashInput struct {
+	ClusterName           string
+	ServiceCIDRBlock      string
+	Certificates          CertsConfig
+	LoadBalancedFQDN      string
+	LoadBalancedShortName string
+	APIServerExtraIPs     []string
+	APIServerExtraNames   []string
+	Nodes                 []planHashNode
+	DockerRegistryNode    string
+	CNIProvider           string
+}
+
+type planHashNode struct {
+	Host        string
+	IP          string
+	InternalIP  string
+	Windows     bool
+	NetBIOSName string
+	Roles       []string
+	// omitted when unset, so that the hash of existing plans is unchanged
+	CertValidity string `json:",omitempty"`
+}
+
+// Hash returns the SHA-256 hash, in hex, of the fields of the plan that
+// affect the cluster certificates. Plans with the same hash produce the same
+// certificates, so a change in the hash means that certificates need to be
+// regenerated. The fields that contribute to the hash are:
+//
+//   - the cluster name, which is the common name of the CA
+//   - the service CIDR block, from which the kubernetes service IP is derived
+//   - the certificates configuration, including the expiry, key and CA
+//     settings, the signing profile and the client certificates
+//   - the load balanced FQDN and short name of the master nodes, and the
+//     extra IPs and names of the API server
+//   - the host, IP, internal IP, Windows flag, NetBIOS name, certificate
+//     validity and roles of every node, regardless of the order in which
+//     nodes are listed
+//   - the host of the first master node, when the internal docker registry
+//     is set up, as the registry certificate is issued for it
+//   - the CNI provider, as contiv requires its own certificate
+//
+// Other fields, such as node variables, the pod CIDR block, add-ons or
+// the SSH configuration, do not contribute.
+func (p *Plan) Hash() string {
+	in := planHashInput{
+		ClusterName:           p.Cluster.Name,
+		ServiceCIDRBlock:      p.Cluster.Networking.ServiceCIDRBlock,
+		Certificates:          p.Cluster.Certificates,
+		LoadBalancedFQDN:      p.Master.LoadBalancedFQDN,
+		LoadBalancedShortName: p.Master.LoadBalancedShortName,
+		APIServerExtraIPs:     p.Master.APIServerExtraIPs,
+		APIServerExtraNames:   p.Master.APIServerExtraNames,
+	}
+	if p.AddOns.CNI != nil {
+		in.CNIProvider = p.AddOns.CNI.Provider
+	}
+	for _, n := range p.AllNodes() {
+		in.Nodes = append(in.Nodes, planHashNode{
+			Host:         n.Node.Host,
+			IP:           n.Node.IP,
+			InternalIP:   n.Node.InternalIP,
+			Windows:      n.Node.Windows,
+			NetBIOSName:  n.Node.NetBIOSName,
+			Roles:        n.Roles,
+			CertValidity: n.Node.CertValidity,
+		})
+	}
+	sort.Slice(in.Nodes, func(i, j int) bool { return in.Nodes[i].Host < in.Nodes[j].Host })
+	if p.DockerRegistry.SetupInternal && len(p.Master.Nodes) > 0 {
+		in.DockerRegistryNode = p.Master.Nodes[0].Host
+	}
+	// The input only holds strings, numbers, booleans and slices, which
+	// cannot fail to encode. Struct fields are encoded in a fixed order.
+	b, _ := json.Marshal(in)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,72 @@
+package install
+
+import "testing"
+
+func TestPlanHash(t *testing.T) {
+	hash := getPlan().Hash()
+	if hash != getPlan().Hash() {
+		t.Fatalf("expected the hash of the same plan to be stable")
+	}
+	tests := []struct {
+		name    string
+		modify  func(p *Plan)
+		changes bool
+	}{
+		{
+			name:   "node variables",
+			modify: func(p *Plan) { p.Worker.Nodes[0].Vars = map[string]string{"foo": "bar"} },
+		},
+		{
+			name:   "pod CIDR block",
+			modify: func(p *Plan) { p.Cluster.Networking.PodCIDRBlock = "172.16.0.0/16" },
+		},
+		{
+			name:   "SSH user",
+			modify: func(p *Plan) { p.Cluster.SSH.User = "someone-else" },
+		},
+		{
+			name: "node order",
+			modify: func(p *Plan) {
+				n := p.Worker.Nodes
+				n[0], n[len(n)-1] = n[len(n)-1], n[0]
+			},
+		},
+		{
+			name:    "node IP",
+			modify:  func(p *Plan) { p.Worker.Nodes[0].IP = "10.0.0.1" },
+			changes: true,
+		},
+		{
+			name:    "node host",
+			modify:  func(p *Plan) { p.Worker.Nodes[0].Host = "someOtherHost" },
+			changes: true,
+		},
+		{
+			name:    "service CIDR block",
+			modify:  func(p *Plan) { p.Cluster.Networking.ServiceCIDRBlock = "172.17.0.0/16" },
+			changes: true,
+		},
+		{
+			name:    "certificate expiry",
+			modify:  func(p *Plan) { p.Cluster.Certificates.Expiry = "2h" },
+			changes: true,
+		},
+		{
+			name:    "node certificate validity",
+			modify:  func(p *Plan) { p.Worker.Nodes[0].CertValidity = "48h" },
+			changes: true,
+		},
+		{
+			name:    "API server extra names",
+			modify:  func(p *Plan) { p.Master.APIServerExtraNames = []string{"api.example.com"} },
+			changes: true,
+		},
+	}
+	for _, test := range tests {
+		p := getPlan()
+		test.modify(p)
+		if changed := p.Hash() != hash; changed != test.changes {
+			t.Errorf("%s: expected the hash to change = %v, but got %v", test.name, test.changes, changed)
+		}
+	}
+}
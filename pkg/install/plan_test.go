@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -206,3 +207,88 @@ func TestReadDeprecatedDashboard(t *testing.T) {
 	}
 
 }
+
+func TestKubernetesServiceIP(t *testing.T) {
+	tests := []struct {
+		cidr     string
+		expected string
+		valid    bool
+	}{
+		{cidr: "172.20.0.0/16", expected: "172.20.0.1", valid: true},
+		{cidr: "10.0.0.0/30", expected: "10.0.0.1", valid: true},
+		{cidr: "fd00:10:96::/112", expected: "fd00:10:96::1", valid: true},
+		{cidr: "10.0.0.0/31", valid: false},
+		{cidr: "10.0.0.0/32", valid: false},
+		{cidr: "foo", valid: false},
+	}
+	for _, test := range tests {
+		p := &Plan{}
+		p.Cluster.Networking.ServiceCIDRBlock = test.cidr
+		ip, err := KubernetesServiceIP(p)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid = %v, but got error %v", test.cidr, test.valid, err)
+			continue
+		}
+		if test.valid && ip.String() != test.expected {
+			t.Errorf("%q: expected %s, but got %s", test.cidr, test.expected, ip)
+		}
+	}
+}
+
+func TestPlanServiceNetwork(t *testing.T) {
+	tests := []struct {
+		cidr     string
+		expected string
+		dnsIP    string
+		valid    bool
+	}{
+		{cidr: "172.20.0.0/16", expected: "172.20.0.0/16", dnsIP: "172.20.0.2", valid: true},
+		{cidr: "172.20.5.4/16", expected: "172.20.0.0/16", dnsIP: "172.20.0.2", valid: true},
+		{cidr: "fd00:10:96::/112", expected: "fd00:10:96::/112", dnsIP: "fd00:10:96::2", valid: true},
+		{cidr: "10.0.0.0/31", valid: false},
+		{cidr: "10.0.0.1", valid: false},
+		{cidr: "", valid: false},
+	}
+	for _, test := range tests {
+		p := &Plan{}
+		p.Cluster.Networking.ServiceCIDRBlock = test.cidr
+		serviceNet, err := p.ServiceNetwork()
+		if (err == nil) != test.valid {
+			t.Errorf("%q: expected valid = %v, but got error %v", test.cidr, test.valid, err)
+			continue
+		}
+		if !test.valid {
+			continue
+		}
+		if serviceNet.String() != test.expected {
+			t.Errorf("%q: expected service network %s, but got %s", test.cidr, test.expected, serviceNet)
+		}
+		dnsIP, err := getDNSServiceIP(p)
+		if err != nil {
+			t.Errorf("%q: unexpected error getting DNS service IP: %v", test.cidr, err)
+		} else if dnsIP != test.dnsIP {
+			t.Errorf("%q: expected DNS service IP %s, but got %s", test.cidr, test.dnsIP, dnsIP)
+		}
+	}
+}
+
+func TestPlanAllNodes(t *testing.T) {
+	p := &Plan{}
+	p.Etcd.Nodes = []Node{{Host: "node01", IP: "10.0.0.1"}}
+	p.Master.Nodes = []Node{{Host: "node01", IP: "10.0.0.1"}, {Host: "master02", IP: "10.0.0.2"}}
+	p.Worker.Nodes = []Node{{Host: "worker01", IP: "10.0.0.3"}, {Host: "node01", IP: "10.0.0.1"}}
+	p.Ingress.Nodes = []Node{{Host: "worker01", IP: "10.0.0.3"}}
+
+	nodes := p.AllNodes()
+	expected := []PlanNode{
+		{Node: Node{Host: "node01", IP: "10.0.0.1"}, Roles: []string{"etcd", "master", "worker"}},
+		{Node: Node{Host: "master02", IP: "10.0.0.2"}, Roles: []string{"master"}},
+		{Node: Node{Host: "worker01", IP: "10.0.0.3"}, Roles: []string{"worker", "ingress"}},
+	}
+	if !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("expected nodes %+v, but got %+v", expected, nodes)
+	}
+	if !nodes[2].HasRoles("ingress") || nodes[1].HasRoles("etcd", "worker") {
+		t.Errorf("unexpected roles %v %v", nodes[1].Roles, nodes[2].Roles)
+	}
+}
@@ -5,7 +5,11 @@ import (
 	"net"
 	"strconv"
 
+	"github.com/apprenda/kismatic/pkg/data"
 	"github.com/apprenda/kismatic/pkg/ssh"
+	"github.com/apprenda/kismatic/pkg/tls"
+	"github.com/apprenda/kismatic/pkg/util"
+	"github.com/cloudflare/cfssl/csr"
 )
 
 const (
@@ -36,6 +40,9 @@ type NetworkConfig struct {
 	Type             string `yaml:"type,omitempty"`
 	PodCIDRBlock     string `yaml:"pod_cidr_block"`
 	ServiceCIDRBlock string `yaml:"service_cidr_block"`
+	// DNSServiceIP is the cluster IP of the DNS service. Derived from the
+	// service CIDR block when empty.
+	DNSServiceIP     string `yaml:"dns_service_ip,omitempty"`
 	UpdateHostsFiles bool   `yaml:"update_hosts_files"`
 	HTTPProxy        string `yaml:"http_proxy"`
 	HTTPSProxy       string `yaml:"https_proxy"`
@@ -46,6 +53,203 @@ type NetworkConfig struct {
 type CertsConfig struct {
 	Expiry   string
 	CAExpiry string `yaml:"ca_expiry"`
+	// CACertFile is the path of an existing CA certificate, such as an
+	// intermediate of a corporate CA, that signs the cluster certificates
+	// instead of a generated CA. Requires the CA key file.
+	CACertFile string `yaml:"ca_cert_file,omitempty"`
+	// CAKeyFile is the path of the private key of the existing CA certificate
+	CAKeyFile string `yaml:"ca_key_file,omitempty"`
+	// APIServerEtcdClientCommonName is the common name of the client certificate
+	// used by the API server to authenticate with etcd
+	APIServerEtcdClientCommonName string `yaml:"apiserver_etcd_client_common_name,omitempty"`
+	// APIServerKubeletClientCommonName is the common name of the client
+	// certificate used by the API server to authenticate with the kubelets
+	APIServerKubeletClientCommonName string `yaml:"apiserver_kubelet_client_common_name,omitempty"`
+	// APIServerKubeletClientGroup is the group of the client certificate used
+	// by the API server to authenticate with the kubelets. The group must be
+	// authorized to access the kubelet API by the kubelet's authorization mode.
+	// Defaults to system:masters.
+	APIServerKubeletClientGroup string `yaml:"apiserver_kubelet_client_group,omitempty"`
+	// CAKeyUsages is the list of key usages of the cluster CA.
+	// Defaults to "cert sign" and "crl sign".
+	CAKeyUsages []string `yaml:"ca_key_usages,omitempty"`
+	// CACSR is the certificate request used to create the cluster CA.
+	// The CA CSR file of the installer is used if unset.
+	CACSR *CACSR `yaml:"ca_csr,omitempty"`
+	// BootstrapToken configures the generation of a token that nodes use
+	// to join the cluster. A token is not generated if unset.
+	BootstrapToken *BootstrapToken `yaml:"bootstrap_token,omitempty"`
+	// CAConfigMap writes the manifest of a ConfigMap that contains the
+	// certificate of the cluster CA, for distributing the trust of the
+	// cluster. The manifest is not written if unset.
+	CAConfigMap *CAConfigMap `yaml:"ca_configmap,omitempty"`
+	// ExtraTrustAnchors are the certificates of additional CAs that the nodes
+	// must trust, such as the CA of a proxy that intercepts TLS traffic. They
+	// are appended to the exported trust bundle, after the CAs of the cluster.
+	// Only certificates can be provided, never private keys.
+	ExtraTrustAnchors []TrustAnchor `yaml:"extra_trust_anchors,omitempty"`
+	// TrustAnchorsInNodeBundles writes the trust bundle, including the extra
+	// trust anchors, to trust-bundle.pem in the bundles of the nodes. The CA
+	// certificate of the bundles is not modified, as it is also used to
+	// authenticate clients.
+	TrustAnchorsInNodeBundles bool `yaml:"trust_anchors_in_node_bundles,omitempty"`
+	// DedicatedAPIServerCert generates a serving certificate that is shared
+	// by the API servers, named apiserver.pem, with the names of the
+	// kubernetes service, the kubernetes service IP, the load balanced names
+	// and the IPs of the master nodes as its only SANs. The API server
+	// certificate of each master node is then limited to the node's own
+	// hostnames and IPs.
+	DedicatedAPIServerCert bool `yaml:"dedicated_apiserver_cert,omitempty"`
+	// SPIFFETrustDomain adds the SPIFFE ID of each node,
+	// spiffe://<trust domain>/node/<hostname>, as a URI SAN of the
+	// certificates issued to the node itself, so that they can be used as
+	// SPIFFE SVIDs. SPIFFE IDs are not added if empty.
+	SPIFFETrustDomain string `yaml:"spiffe_trust_domain,omitempty"`
+	// DisableKubernetesServiceIPSAN omits the kubernetes service IP, which is
+	// derived from the service CIDR, from the API server certificate SANs.
+	DisableKubernetesServiceIPSAN bool `yaml:"disable_kubernetes_service_ip_san,omitempty"`
+	// CAKeyAlgorithm is the algorithm of the CA's private key, either rsa or
+	// ecdsa. Overrides the key request of the CA CSR when set.
+	CAKeyAlgorithm string `yaml:"ca_key_algorithm,omitempty"`
+	// CAKeySize is the size of the CA's private key. Overrides the key request
+	// of the CA CSR when set. Defaults to 2048 for rsa keys, and 256 for ecdsa keys.
+	CAKeySize int `yaml:"ca_key_size,omitempty"`
+	// KeyAlgorithm is the algorithm of the private keys of the certificates
+	// issued by the cluster CA, either rsa or ecdsa. Defaults to rsa.
+	KeyAlgorithm string `yaml:"key_algorithm,omitempty"`
+	// KeySize is the size of the private keys of the certificates issued by
+	// the cluster CA. Defaults to 2048 for rsa keys, and 256 for ecdsa keys.
+	KeySize int `yaml:"key_size,omitempty"`
+	// NodeShortNameSANs adds the alternate form of each node's hostname to
+	// the SANs of its certificates: the leftmost label when the hostname is
+	// a FQDN, or the hostname followed by the NodeDomain when it is a short name.
+	NodeShortNameSANs bool `yaml:"node_short_name_sans,omitempty"`
+	// NodeDomain is the DNS domain that is appended to the short hostnames
+	// of nodes when NodeShortNameSANs is set. Short hostnames are not
+	// expanded if empty.
+	NodeDomain string `yaml:"node_domain,omitempty"`
+	// NodeAddressHints warns about node addresses that are likely mistakes,
+	// such as an IP and internal IP that differ by a single character, or an
+	// address shared by multiple nodes. An internal IP that is the same as the
+	// IP is always accepted, and only included once in the certificate SANs.
+	NodeAddressHints bool `yaml:"node_address_hints,omitempty"`
+	// SigningProfile defines how the CA signs the cluster certificates,
+	// taking precedence over the signing profile of the CA config file.
+	// The CA config file, or the cfssl defaults, are used if unset.
+	SigningProfile *SigningProfile `yaml:"signing_profile,omitempty"`
+	// NotAfter is a fixed date, in RFC 3339 format, on which all the leaf
+	// certificates expire regardless of when they are issued. Takes
+	// precedence over the expiry. E.g. 2030-01-01T00:00:00Z
+	NotAfter string `yaml:"not_after,omitempty"`
+	// ClientCertificates are additional client certificates that are
+	// generated for the cluster, such as those of users or automation.
+	ClientCertificates []ClientCertificate `yaml:"client_certificates,omitempty"`
+	// SANAllowlist is the list of IP addresses, CIDR blocks and DNS names
+	// that the SANs of the certificates are restricted to. Generating the
+	// certificates fails if any of them would include another SAN.
+	// SANs are not restricted if empty.
+	SANAllowlist []string `yaml:"san_allowlist,omitempty"`
+	// PublicCluster marks the cluster as publicly reachable. Generating the
+	// certificates fails if the API server certificates would include a
+	// private or reserved IP address, such as an RFC 1918 node IP, other
+	// than the loopback address and the kubernetes service IP. Off by default.
+	PublicCluster bool `yaml:"public_cluster,omitempty"`
+	// MaxSANs is the maximum number of SANs of a certificate, as some TLS
+	// implementations fail to handle certificates with too many SANs.
+	// Defaults to 100.
+	MaxSANs int `yaml:"max_sans,omitempty"`
+}
+
+// ClientCertificate is a client certificate used to authenticate with the
+// cluster as a user that belongs to one or more groups
+type ClientCertificate struct {
+	// Name of the certificate, which is used as its file name
+	Name string `yaml:"name"`
+	// User is the name of the user, set as the common name of the certificate
+	User string `yaml:"user"`
+	// Groups are the groups the user belongs to, set as the organizations of
+	// the certificate. E.g. system:masters. The organizations are encoded as
+	// a DER set, so they are ordered with the shorter names first, regardless
+	// of the order of the groups.
+	Groups []string `yaml:"groups"`
+}
+
+// SigningProfile is the policy used by the CA to sign certificates
+type SigningProfile struct {
+	// Usages is the list of key usages of the certificates, as defined by
+	// cfssl. E.g. "signing", "key encipherment", "server auth", "client auth".
+	// Certificates that require specific usages, such as client certificates,
+	// keep their own usages.
+	Usages []string `yaml:"usages"`
+	// Expiry is the validity period of the certificates. Defaults to the
+	// expiry of the certificates configuration.
+	Expiry string `yaml:"expiry,omitempty"`
+}
+
+// BootstrapToken configures the token used by nodes to join the cluster
+type BootstrapToken struct {
+	// TTL is the duration after which the token expires. A TTL of 0 means
+	// the token never expires. Defaults to 24h.
+	TTL string `yaml:"ttl,omitempty"`
+	// Usages is the list of ways in which the token can be used. Options
+	// are authentication and signing. Defaults to both.
+	Usages []string `yaml:"usages,omitempty"`
+}
+
+// CAConfigMap configures the ConfigMap that contains the cluster CA certificate
+type CAConfigMap struct {
+	// Name is the name of the ConfigMap. Defaults to kube-root-ca.crt.
+	Name string `yaml:"name,omitempty"`
+	// Namespace is the namespace of the ConfigMap. Defaults to kube-system.
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// TrustAnchor is a PEM file or PEM data with the certificates of one or more
+// CAs. Exactly one of the file and the PEM data must be set.
+type TrustAnchor struct {
+	// File is the path of the PEM file
+	File string `yaml:"file,omitempty"`
+	// PEM is the PEM encoded certificates
+	PEM string `yaml:"pem,omitempty"`
+}
+
+// CACSR is the certificate request of the cluster CA
+type CACSR struct {
+	// KeyAlgorithm is the algorithm of the CA's private key. Defaults to rsa.
+	KeyAlgorithm string `yaml:"key_algorithm,omitempty"`
+	// KeySize is the size of the CA's private key. Defaults to 2048.
+	KeySize int `yaml:"key_size,omitempty"`
+	// Names make up the subject of the CA certificate
+	Names []CSRName `yaml:"names,omitempty"`
+	// Hosts are the subject alternate names of the CA certificate
+	Hosts []string `yaml:"hosts,omitempty"`
+}
+
+// CSRName is a name in the subject of a certificate request
+type CSRName struct {
+	Country            string `yaml:"country,omitempty"`
+	State              string `yaml:"state,omitempty"`
+	Locality           string `yaml:"locality,omitempty"`
+	Organization       string `yaml:"organization,omitempty"`
+	OrganizationalUnit string `yaml:"organizational_unit,omitempty"`
+}
+
+// returns the certificate request for the CA defined in the plan
+func (c CACSR) certificateRequest() csr.CertificateRequest {
+	req := csr.CertificateRequest{
+		KeyRequest: keyRequest(c.KeyAlgorithm, c.KeySize),
+		Hosts:      c.Hosts,
+	}
+	for _, n := range c.Names {
+		req.Names = append(req.Names, csr.Name{
+			C:  n.Country,
+			ST: n.State,
+			L:  n.Locality,
+			O:  n.Organization,
+			OU: n.OrganizationalUnit,
+		})
+	}
+	return req
 }
 
 // SSHConfig describes the cluster's SSH configuration for accessing nodes
@@ -75,6 +279,35 @@ type Node struct {
 	Host       string
 	IP         string
 	InternalIP string
+	// Windows is true if the node runs Windows
+	Windows bool `yaml:"windows,omitempty"`
+	// NetBIOSName is the NetBIOS alias of a Windows node. Optional.
+	NetBIOSName string `yaml:"netbios_name,omitempty"`
+	// Vars are extra Ansible variables, such as ansible_python_interpreter,
+	// that are set on the node in the generated inventory. Optional.
+	Vars map[string]string `yaml:"vars,omitempty"`
+	// CertValidity is the validity period of the certificates issued to the
+	// node itself, such as its etcd server, API server and kubelet
+	// certificates. Overrides the expiry of the cluster's leaf certificates
+	// for the node. Certificates that are shared by nodes are unaffected. Optional.
+	CertValidity string `yaml:"cert_validity,omitempty"`
+}
+
+// nodeKey identifies a node in the plan, regardless of its variables
+type nodeKey struct {
+	host, ip, internalIP string
+	windows              bool
+	netBIOSName          string
+}
+
+func (n Node) key() nodeKey {
+	return nodeKey{host: n.Host, ip: n.IP, internalIP: n.InternalIP, windows: n.Windows, netBIOSName: n.NetBIOSName}
+}
+
+// Equal returns true if both nodes are the same node of the plan. The
+// variables of the nodes are not compared.
+func (n Node) Equal(other Node) bool {
+	return n.key() == other.key()
 }
 
 // A NodeGroup is a collection of nodes
@@ -83,6 +316,135 @@ type NodeGroup struct {
 	Nodes         []Node
 }
 
+// Metadata is applied to all the Kubernetes objects generated for the cluster
+type Metadata struct {
+	// Labels are added to every generated object
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// Annotations are added to every generated object
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// returns the metadata of a Kubernetes object generated for the cluster,
+// including the cluster-wide labels and annotations defined in the plan
+func (p Plan) objectMeta(name, namespace string) data.ObjectMeta {
+	meta := data.ObjectMeta{
+		Name:      name,
+		Namespace: namespace,
+	}
+	if p.Metadata == nil {
+		return meta
+	}
+	if len(p.Metadata.Labels) > 0 {
+		meta.Labels = make(map[string]string, len(p.Metadata.Labels))
+		for k, v := range p.Metadata.Labels {
+			meta.Labels[k] = v
+		}
+	}
+	if len(p.Metadata.Annotations) > 0 {
+		meta.Annotations = make(map[string]string, len(p.Metadata.Annotations))
+		for k, v := range p.Metadata.Annotations {
+			meta.Annotations[k] = v
+		}
+	}
+	return meta
+}
+
+// EtcdPorts are the ports used by the etcd clusters. The default port is used
+// when a port is not set.
+type EtcdPorts struct {
+	// KubernetesClientPort is the client port of the Kubernetes etcd cluster
+	KubernetesClientPort int `yaml:"kubernetes_client,omitempty"`
+	// KubernetesPeerPort is the peer port of the Kubernetes etcd cluster
+	KubernetesPeerPort int `yaml:"kubernetes_peer,omitempty"`
+	// NetworkingClientPort is the client port of the networking etcd cluster
+	NetworkingClientPort int `yaml:"networking_client,omitempty"`
+	// NetworkingPeerPort is the peer port of the networking etcd cluster
+	NetworkingPeerPort int `yaml:"networking_peer,omitempty"`
+}
+
+const (
+	defaultEtcdK8sClientPort        = 2379
+	defaultEtcdK8sPeerPort          = 2380
+	defaultEtcdNetworkingClientPort = 6666
+	defaultEtcdNetworkingPeerPort   = 6660
+)
+
+// returns the key request for the given algorithm and size, with defaults
+// applied to the values that are not set
+func keyRequest(algo string, size int) *csr.BasicKeyRequest {
+	if algo == "" {
+		algo = "rsa"
+	}
+	if size == 0 {
+		if algo == "ecdsa" {
+			size = 256
+		} else {
+			size = 2048
+		}
+	}
+	return &csr.BasicKeyRequest{A: algo, S: size}
+}
+
+// returns the key request of the cluster CA set in the plan, or nil if the
+// key request of the CA CSR should be used
+func (c CertsConfig) caKeyRequest() *csr.BasicKeyRequest {
+	if c.CAKeyAlgorithm == "" && c.CAKeySize == 0 {
+		return nil
+	}
+	return keyRequest(c.CAKeyAlgorithm, c.CAKeySize)
+}
+
+// returns the key request of the certificates issued by the cluster CA
+func (c CertsConfig) leafKeyRequest() *csr.BasicKeyRequest {
+	return keyRequest(c.KeyAlgorithm, c.KeySize)
+}
+
+// returns the algorithm of the cluster CA's key, and false if it is defined
+// by the CA CSR file of the installer
+func (c CertsConfig) caKeyAlgorithm() (string, bool) {
+	if kr := c.caKeyRequest(); kr != nil {
+		return kr.A, true
+	}
+	if c.CACSR != nil {
+		return keyRequest(c.CACSR.KeyAlgorithm, c.CACSR.KeySize).A, true
+	}
+	return "", false
+}
+
+// returns the basic constraints and key usages of the cluster CA
+func (c CertsConfig) caOptions() tls.CAOptions {
+	return tls.CAOptions{
+		Usages: c.CAKeyUsages,
+	}
+}
+
+// returns the etcd ports of the cluster, with defaults applied to the ports
+// that were not set in the plan
+func (p Plan) etcdPorts() EtcdPorts {
+	ports := EtcdPorts{
+		KubernetesClientPort: defaultEtcdK8sClientPort,
+		KubernetesPeerPort:   defaultEtcdK8sPeerPort,
+		NetworkingClientPort: defaultEtcdNetworkingClientPort,
+		NetworkingPeerPort:   defaultEtcdNetworkingPeerPort,
+	}
+	if p.EtcdPorts == nil {
+		return ports
+	}
+	if p.EtcdPorts.KubernetesClientPort != 0 {
+		ports.KubernetesClientPort = p.EtcdPorts.KubernetesClientPort
+	}
+	if p.EtcdPorts.KubernetesPeerPort != 0 {
+		ports.KubernetesPeerPort = p.EtcdPorts.KubernetesPeerPort
+	}
+	if p.EtcdPorts.NetworkingClientPort != 0 {
+		ports.NetworkingClientPort = p.EtcdPorts.NetworkingClientPort
+	}
+	if p.EtcdPorts.NetworkingPeerPort != 0 {
+		ports.NetworkingPeerPort = p.EtcdPorts.NetworkingPeerPort
+	}
+	return ports
+}
+
 // An OptionalNodeGroup is a collection of nodes that can be empty
 type OptionalNodeGroup NodeGroup
 
@@ -101,6 +463,15 @@ type MasterNodeGroup struct {
 	LoadBalancedFQDN      string `yaml:"load_balanced_fqdn"`
 	LoadBalancedShortName string `yaml:"load_balanced_short_name"`
 	Nodes                 []Node
+	// APIServerExtraIPs are additional IP addresses, such as NAT or egress
+	// addresses, on which clients reach the API server. They are added to
+	// the SANs of the API server certificates.
+	APIServerExtraIPs []string `yaml:"api_server_extra_ips,omitempty"`
+	// APIServerExtraNames are additional DNS names on which clients reach the
+	// API server. In split-horizon DNS environments, both the internal and the
+	// external names of the API server should be listed. They are added to the
+	// SANs of the API server certificates.
+	APIServerExtraNames []string `yaml:"api_server_extra_names,omitempty"`
 }
 
 // DockerRegistry details for docker registry, either confgiured by the cli or customer provided
@@ -141,6 +512,8 @@ type Plan struct {
 	DockerRegistry DockerRegistry `yaml:"docker_registry"`
 	AddOns         AddOns         `yaml:"add_ons"`
 	Features       *Features      `yaml:"features,omitempty"`
+	Metadata       *Metadata      `yaml:"metadata,omitempty"`
+	EtcdPorts      *EtcdPorts     `yaml:"etcd_ports,omitempty"`
 	Etcd           NodeGroup
 	Master         MasterNodeGroup
 	Worker         NodeGroup
@@ -246,14 +619,59 @@ type DeprecatedPackageManager struct {
 // GetUniqueNodes returns a list of the unique nodes that are listed in the plan file.
 // That is, if a node has multiple roles, it will only appear once in the list.
 func (p *Plan) GetUniqueNodes() []Node {
-	seenNodes := map[Node]bool{}
+	seenNodes := map[nodeKey]bool{}
 	nodes := []Node{}
 	for _, node := range p.getAllNodes() {
-		if seenNodes[node] {
+		if seenNodes[node.key()] {
 			continue
 		}
 		nodes = append(nodes, node)
-		seenNodes[node] = true
+		seenNodes[node.key()] = true
+	}
+	return nodes
+}
+
+// A PlanNode is a node of the plan, along with the roles it has in the cluster
+type PlanNode struct {
+	Node  Node
+	Roles []string
+}
+
+// HasRoles returns true if the node has any of the roles
+func (n PlanNode) HasRoles(roles ...string) bool {
+	return util.Intersects(roles, n.Roles)
+}
+
+// AllNodes returns the nodes of the plan that certificates are generated for,
+// deduplicated by host. A node that is listed in multiple node groups appears
+// once, with the roles of all the groups it belongs to. Nodes are returned in
+// the order they first appear in the etcd, master, worker, ingress and
+// storage node groups.
+func (p *Plan) AllNodes() []PlanNode {
+	groups := []struct {
+		role  string
+		nodes []Node
+	}{
+		{role: "etcd", nodes: p.Etcd.Nodes},
+		{role: "master", nodes: p.Master.Nodes},
+		{role: "worker", nodes: p.Worker.Nodes},
+		{role: "ingress", nodes: p.Ingress.Nodes},
+		{role: "storage", nodes: p.Storage.Nodes},
+	}
+	index := map[string]int{}
+	nodes := []PlanNode{}
+	for _, g := range groups {
+		for _, n := range g.nodes {
+			i, ok := index[n.Host]
+			if !ok {
+				index[n.Host] = len(nodes)
+				nodes = append(nodes, PlanNode{Node: n, Roles: []string{g.role}})
+				continue
+			}
+			if !contains(g.role, nodes[i].Roles) {
+				nodes[i].Roles = append(nodes[i].Roles, g.role)
+			}
+		}
 	}
 	return nodes
 }
@@ -385,6 +803,30 @@ func hasIP(nodes *[]Node, ip string) bool {
 	return false
 }
 
+// returns the common name of the API server's etcd client certificate
+func (p Plan) apiServerEtcdClientCommonName() string {
+	if p.Cluster.Certificates.APIServerEtcdClientCommonName != "" {
+		return p.Cluster.Certificates.APIServerEtcdClientCommonName
+	}
+	return apiServerEtcdClientUser
+}
+
+// returns the common name of the API server's kubelet client certificate
+func (p Plan) apiServerKubeletClientCommonName() string {
+	if p.Cluster.Certificates.APIServerKubeletClientCommonName != "" {
+		return p.Cluster.Certificates.APIServerKubeletClientCommonName
+	}
+	return apiServerKubeletClientUser
+}
+
+// returns the group of the API server's kubelet client certificate
+func (p Plan) apiServerKubeletClientGroup() string {
+	if p.Cluster.Certificates.APIServerKubeletClientGroup != "" {
+		return p.Cluster.Certificates.APIServerKubeletClientGroup
+	}
+	return apiServerKubeletClientGroup
+}
+
 // ConfigureDockerWithPrivateRegistry returns true when confgiuring an external or on cluster registry is required
 func (r DockerRegistry) ConfigureDockerWithPrivateRegistry() bool {
 	return r.Address != "" || r.SetupInternal
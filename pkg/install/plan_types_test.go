@@ -13,3 +13,26 @@ func TestCanReadAPIServerOverrides(t *testing.T) {
 
 	assertEqual(t, p.Cluster.APIServerOptions.Overrides["runtime-config"], "beta/v2api=true,alpha/v1api=true")
 }
+
+func TestPlanObjectMeta(t *testing.T) {
+	p := Plan{
+		Metadata: &Metadata{
+			Labels:      map[string]string{"team": "platform"},
+			Annotations: map[string]string{"example.com/managed-by": "kismatic"},
+		},
+	}
+	meta := p.objectMeta("some-name", "kube-system")
+	assertEqual(t, meta.Name, "some-name")
+	assertEqual(t, meta.Namespace, "kube-system")
+	assertEqual(t, meta.Labels["team"], "platform")
+	assertEqual(t, meta.Annotations["example.com/managed-by"], "kismatic")
+
+	// Changing the object's metadata must not modify the plan
+	meta.Labels["team"] = "other"
+	assertEqual(t, p.Metadata.Labels["team"], "platform")
+
+	meta = Plan{}.objectMeta("some-name", "")
+	if meta.Labels != nil || meta.Annotations != nil {
+		t.Errorf("expected no labels or annotations, but got %v and %v", meta.Labels, meta.Annotations)
+	}
+}
@@ -0,0 +1,114 @@
+package install
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/apprenda/kismatic/pkg/tls"
+	"github.com/apprenda/kismatic/pkg/util"
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+// returns true if the plan provides an existing CA certificate and key
+func (c CertsConfig) providedCA() bool {
+	return c.CACertFile != "" || c.CAKeyFile != ""
+}
+
+// returns the errors of the CA certificate and key provided in the plan
+func (c CertsConfig) validateProvidedCA() []error {
+	errs := []error{}
+	if c.CACertFile == "" || c.CAKeyFile == "" {
+		return append(errs, errors.New("Both the CA certificate file and the CA key file are required to use an existing CA"))
+	}
+	// The settings of a generated CA cannot be applied to an existing CA
+	if c.CACSR != nil {
+		errs = append(errs, errors.New("CA CSR cannot be set when using an existing CA"))
+	}
+	if c.CAKeyAlgorithm != "" || c.CAKeySize != 0 {
+		errs = append(errs, errors.New("CA key algorithm and size cannot be set when using an existing CA"))
+	}
+	if len(c.CAKeyUsages) > 0 {
+		errs = append(errs, errors.New("CA key usages cannot be set when using an existing CA"))
+	}
+	if _, err := readProvidedCA(c, time.Now()); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// reads the CA certificate and key provided in the plan. Returns an error if
+// the certificate is not the certificate of a CA that can sign certificates,
+// is not valid at the given time, or does not match the key.
+func readProvidedCA(c CertsConfig, now time.Time) (*tls.CA, error) {
+	cert, err := ioutil.ReadFile(c.CACertFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading CA certificate: %v", err)
+	}
+	key, err := ioutil.ReadFile(c.CAKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading CA key: %v", err)
+	}
+	parsed, err := helpers.ParseCertificatePEM(cert)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing CA certificate %q: %v", c.CACertFile, err)
+	}
+	if !parsed.BasicConstraintsValid || !parsed.IsCA {
+		return nil, fmt.Errorf("Certificate %q is not the certificate of a CA", c.CACertFile)
+	}
+	if parsed.KeyUsage != 0 && parsed.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, fmt.Errorf("CA certificate %q does not have the %q key usage, and cannot sign certificates", c.CACertFile, "cert sign")
+	}
+	if now.Before(parsed.NotBefore) || now.After(parsed.NotAfter) {
+		return nil, fmt.Errorf("CA certificate %q is only valid from %s to %s", c.CACertFile, parsed.NotBefore.UTC().Format(time.RFC3339), parsed.NotAfter.UTC().Format(time.RFC3339))
+	}
+	ca := &tls.CA{Cert: cert, Key: key}
+	if err := ca.VerifyKeyPair(); err != nil {
+		return nil, fmt.Errorf("CA certificate %q and key %q are invalid: %v", c.CACertFile, c.CAKeyFile, err)
+	}
+	return ca, nil
+}
+
+// returns the CA provided in the plan, after writing it to the certificates
+// directory. Returns an error if the certificates directory holds another CA.
+func (lp *LocalPKI) useProvidedCA(p *Plan) (*tls.CA, error) {
+	if lp.CASigner != nil {
+		return nil, errors.New("an existing CA certificate and key cannot be provided in the plan when using a CA signer")
+	}
+	now := time.Now()
+	if lp.Now != nil {
+		now = lp.Now()
+	}
+	ca, err := readProvidedCA(p.Cluster.Certificates, now)
+	if err != nil {
+		return nil, err
+	}
+	if lp.DryRun {
+		util.PrettyPrintOk(lp.Log, "Would use the existing Certificate Authority %q", p.Cluster.Certificates.CACertFile)
+		return ca, nil
+	}
+	existing, err := ioutil.ReadFile(filepath.Join(lp.GeneratedCertsDirectory, lp.FileNames.CertFile("ca")))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading CA certificate: %v", err)
+	}
+	if err == nil {
+		if !bytes.Equal(bytes.TrimSpace(existing), bytes.TrimSpace(ca.Cert)) {
+			return nil, fmt.Errorf("the certificates directory contains a CA certificate that is not the CA certificate %q of the plan", p.Cluster.Certificates.CACertFile)
+		}
+		return ca, nil
+	}
+	util.PrettyPrintOk(lp.Log, "Using the existing Certificate Authority %q", p.Cluster.Certificates.CACertFile)
+	key := ca.Key
+	if lp.DisableCAKeyPersistence {
+		key = nil
+	}
+	if err := lp.writeCert(key, ca.Cert, "ca"); err != nil {
+		return nil, fmt.Errorf("error writing CA files: %v", err)
+	}
+	return ca, nil
+}
@@ -0,0 +1,135 @@
+package install
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apprenda/kismatic/pkg/tls"
+	"github.com/cloudflare/cfssl/csr"
+)
+
+// writes a CA that is an intermediate of a new root CA to the directory, and
+// returns the paths of its certificate and key
+func writeProvidedCA(dir, name string, t *testing.T) (certFile, keyFile string) {
+	rootKey, rootCert, err := tls.NewCACert("test/ca-csr.json", "someCorporateCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating root CA for test: %v", err)
+	}
+	req := csr.CertificateRequest{
+		CN:         name,
+		KeyRequest: &csr.BasicKeyRequest{A: "rsa", S: 2048},
+	}
+	key, cert, err := tls.NewIntermediateCACert(&tls.CA{Key: rootKey, Cert: rootCert}, req, "24h", tls.CAOptions{})
+	if err != nil {
+		t.Fatalf("error creating intermediate CA for test: %v", err)
+	}
+	certFile = filepath.Join(dir, name+".pem")
+	keyFile = filepath.Join(dir, name+"-key.pem")
+	if err := ioutil.WriteFile(certFile, cert, 0644); err != nil {
+		t.Fatalf("error writing CA certificate for test: %v", err)
+	}
+	if err := ioutil.WriteFile(keyFile, key, 0600); err != nil {
+		t.Fatalf("error writing CA key for test: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestGenerateClusterCAProvidedCA(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	caDir, err := ioutil.TempDir("", "provided-ca-tests")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer cleanup(caDir, t)
+	certFile, keyFile := writeProvidedCA(caDir, "someIntermediateCA", t)
+
+	p := getPlan()
+	p.Cluster.Certificates.CACertFile = certFile
+	p.Cluster.Certificates.CAKeyFile = keyFile
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("unexpected error using the provided CA: %v", err)
+	}
+	provided, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		t.Fatalf("error reading provided CA: %v", err)
+	}
+	if !bytes.Equal(ca.Cert, provided) {
+		t.Errorf("expected the provided CA to be used")
+	}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	caCert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "ca.pem"), t)
+	if caCert.Subject.CommonName != "someIntermediateCA" {
+		t.Errorf("expected the provided CA to be written to the certificates directory, but got %q", caCert.Subject.CommonName)
+	}
+	admin := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"), t)
+	if err := admin.CheckSignatureFrom(caCert); err != nil {
+		t.Errorf("expected the certificates to be signed by the provided CA: %v", err)
+	}
+
+	// Using the CA again is allowed, but not replacing it with another CA
+	if _, err := pki.GenerateClusterCA(p); err != nil {
+		t.Errorf("unexpected error using the provided CA again: %v", err)
+	}
+	p.Cluster.Certificates.CACertFile, p.Cluster.Certificates.CAKeyFile = writeProvidedCA(caDir, "otherIntermediateCA", t)
+	if _, err := pki.GenerateClusterCA(p); err == nil {
+		t.Errorf("expected an error when the certificates directory contains another CA")
+	}
+	if err := pki.RotateCAKey(p); err == nil {
+		t.Errorf("expected an error when rotating the key of a provided CA")
+	}
+}
+
+func TestReadProvidedCA(t *testing.T) {
+	caDir, err := ioutil.TempDir("", "provided-ca-tests")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer cleanup(caDir, t)
+	certFile, keyFile := writeProvidedCA(caDir, "someCA", t)
+	_, otherKeyFile := writeProvidedCA(caDir, "otherCA", t)
+
+	caKey, caCert, err := tls.NewCACert("test/ca-csr.json", "someRootCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating CA for test: %v", err)
+	}
+	req := csr.CertificateRequest{CN: "someLeaf", KeyRequest: &csr.BasicKeyRequest{A: "rsa", S: 2048}}
+	leafKey, leafCert, err := tls.NewCert(&tls.CA{Key: caKey, Cert: caCert}, req, time.Hour)
+	if err != nil {
+		t.Fatalf("error creating certificate for test: %v", err)
+	}
+	leafCertFile := filepath.Join(caDir, "leaf.pem")
+	leafKeyFile := filepath.Join(caDir, "leaf-key.pem")
+	if err := ioutil.WriteFile(leafCertFile, leafCert, 0644); err != nil {
+		t.Fatalf("error writing certificate for test: %v", err)
+	}
+	if err := ioutil.WriteFile(leafKeyFile, leafKey, 0600); err != nil {
+		t.Fatalf("error writing key for test: %v", err)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		certFile string
+		keyFile  string
+		now      time.Time
+		valid    bool
+	}{
+		{certFile: certFile, keyFile: keyFile, now: now, valid: true},
+		{certFile: certFile, keyFile: otherKeyFile, now: now, valid: false},
+		{certFile: certFile, keyFile: keyFile, now: now.Add(48 * time.Hour), valid: false},
+		{certFile: leafCertFile, keyFile: leafKeyFile, now: now, valid: false},
+		{certFile: filepath.Join(caDir, "does-not-exist.pem"), keyFile: keyFile, now: now, valid: false},
+	}
+	for i, test := range tests {
+		c := CertsConfig{CACertFile: test.certFile, CAKeyFile: test.keyFile}
+		if _, err := readProvidedCA(c, test.now); (err == nil) != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got error %v", i, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package install
+
+import "strings"
+
+// A RotationPhase is a set of certificates that must be deployed to the
+// cluster together when rotating certificates. The phases are deployed in
+// order, and each phase should only start once the previous one is complete.
+type RotationPhase struct {
+	// Name of the phase
+	Name string `json:"name"`
+	// Description explains how the certificates of the phase are deployed
+	Description string `json:"description"`
+	// Certificates are the names of the certificates deployed in the phase
+	Certificates []string `json:"certificates"`
+}
+
+// The rotation phases, in the order they are deployed
+var rotationPhases = []RotationPhase{
+	{
+		Name:        "etcd",
+		Description: "etcd peer and server certificates. Deploy to every etcd node, then restart the etcd members one at a time, waiting for each to rejoin the cluster to preserve quorum",
+	},
+	{
+		Name:        "servers",
+		Description: "API server and other serving certificates. Deploy, then restart the servers one at a time behind the load balancer",
+	},
+	{
+		Name:        "control plane",
+		Description: "client certificates of the control plane components. Deploy to every master node, then restart the components",
+	},
+	{
+		Name:        "nodes",
+		Description: "client certificates of the kubelet, kube-proxy and etcd clients on every node. Deploy, then restart the components one node at a time",
+	},
+	{
+		Name:        "clients",
+		Description: "client certificates used by users and automation. Distribute to the users once the cluster has been rotated",
+	},
+}
+
+// RotationPhases returns the certificates of the cluster described in the
+// plan, grouped in the phases in which they must be deployed when rotating
+// certificates on a live cluster. Servers are rotated before their clients,
+// so that the cluster remains available throughout the rotation. Phases
+// without certificates are omitted.
+func RotationPhases(p *Plan) ([]RotationPhase, error) {
+	manifest, err := certManifestForCluster(*p)
+	if err != nil {
+		return nil, err
+	}
+	phases := make([]RotationPhase, len(rotationPhases))
+	copy(phases, rotationPhases)
+	for _, s := range manifest {
+		i := rotationPhaseIndex(s.filename)
+		phases[i].Certificates = append(phases[i].Certificates, s.filename)
+	}
+	result := []RotationPhase{}
+	for _, phase := range phases {
+		if len(phase.Certificates) > 0 {
+			result = append(result, phase)
+		}
+	}
+	return result, nil
+}
+
+// returns the index of the rotation phase of the certificate with the given name
+func rotationPhaseIndex(name string) int {
+	switch {
+	case strings.HasSuffix(name, "-etcd"), strings.HasSuffix(name, "-etcd-peer"):
+		return 0
+	case strings.HasSuffix(name, "-apiserver"), name == apiServerCertFilename, name == dockerRegistryCertFilename, name == contivProxyServerCertFilename:
+		return 1
+	case name == apiServerEtcdClientCertFilename, name == apiServerKubeletClientCertFilename, name == controllerManagerCertFilenamePrefix, name == schedulerCertFilenamePrefix, name == serviceAccountCertFilename:
+		return 2
+	case strings.HasSuffix(name, "-kubelet"), name == kubeProxyCertFilenamePrefix, name == "etcd-client":
+		return 3
+	default:
+		return 4
+	}
+}
@@ -0,0 +1,44 @@
+package install
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRotationPhases(t *testing.T) {
+	p := &Plan{
+		Cluster: Cluster{
+			Name:         "someName",
+			Certificates: CertsConfig{Expiry: "1h"},
+			Networking:   NetworkConfig{ServiceCIDRBlock: "10.0.0.0/24"},
+		},
+		AddOns: AddOns{CNI: &CNI{}},
+		Etcd:   NodeGroup{Nodes: []Node{{Host: "etcd01", IP: "10.1.0.1"}}},
+		Master: MasterNodeGroup{
+			Nodes:            []Node{{Host: "master01", IP: "10.1.0.2"}},
+			LoadBalancedFQDN: "someFQDN",
+		},
+		Worker: NodeGroup{Nodes: []Node{{Host: "worker01", IP: "10.1.0.3"}}},
+	}
+	phases, err := RotationPhases(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string][]string{
+		"etcd":          {"etcd01-etcd", "etcd01-etcd-peer"},
+		"servers":       {"master01-apiserver"},
+		"control plane": {"kube-controller-manager", "kube-scheduler", "apiserver-etcd-client", "apiserver-kubelet-client", "service-account"},
+		"nodes":         {"master01-kubelet", "kube-proxy", "etcd-client", "worker01-kubelet"},
+		"clients":       {"admin"},
+	}
+	names := []string{}
+	for _, phase := range phases {
+		names = append(names, phase.Name)
+		if !reflect.DeepEqual(phase.Certificates, expected[phase.Name]) {
+			t.Errorf("phase %q: expected certificates %v, but got %v", phase.Name, expected[phase.Name], phase.Certificates)
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"etcd", "servers", "control plane", "nodes", "clients"}) {
+		t.Errorf("unexpected order of phases: %v", names)
+	}
+}
@@ -0,0 +1,123 @@
+package install
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// An EndpointCoverage lists the certificates that cover an endpoint through
+// which clients reach a service of the cluster
+type EndpointCoverage struct {
+	// Service is the service that is reached through the endpoint, either
+	// "apiserver" or "etcd"
+	Service string `json:"service"`
+	// Endpoint is the DNS name or IP address of the endpoint
+	Endpoint string `json:"endpoint"`
+	// Certificates are the names of the issued certificates that include
+	// the endpoint in their SANs
+	Certificates []string `json:"certificates,omitempty"`
+}
+
+// Covered returns true if at least one certificate covers the endpoint
+func (c EndpointCoverage) Covered() bool {
+	return len(c.Certificates) > 0
+}
+
+// SANCoverageReport returns every endpoint of the API server and etcd that is
+// configured in the plan, along with the issued certificates whose SANs
+// cover it. The endpoints of the API server are its load balanced names, its
+// extra names and IPs, the names and IP of the kubernetes service, and the
+// hostnames and IPs of the master nodes. The endpoints of etcd are the
+// hostnames and IPs of the etcd nodes. An endpoint that is not covered by
+// any certificate in the certificates directory has no certificates.
+func (lp *LocalPKI) SANCoverageReport(p *Plan) ([]EndpointCoverage, error) {
+	apiServerEndpoints, err := apiServerEndpoints(*p)
+	if err != nil {
+		return nil, err
+	}
+	apiServerCerts := []string{}
+	for _, n := range p.Master.Nodes {
+		apiServerCerts = append(apiServerCerts, fmt.Sprintf("%s-apiserver", n.Host))
+	}
+	if p.Cluster.Certificates.DedicatedAPIServerCert {
+		apiServerCerts = append(apiServerCerts, apiServerCertFilename)
+	}
+	etcdCerts := []string{}
+	for _, n := range p.Etcd.Nodes {
+		etcdCerts = append(etcdCerts, fmt.Sprintf("%s-etcd", n.Host))
+	}
+
+	report := []EndpointCoverage{}
+	for _, s := range []struct {
+		service   string
+		endpoints []string
+		certs     []string
+	}{
+		{service: "apiserver", endpoints: apiServerEndpoints, certs: apiServerCerts},
+		{service: "etcd", endpoints: nodeEndpoints(p.Etcd.Nodes), certs: etcdCerts},
+	} {
+		certs, err := lp.readCerts(uniqueStrings(s.certs))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range s.endpoints {
+			c := EndpointCoverage{Service: s.service, Endpoint: e}
+			for _, name := range uniqueStrings(s.certs) {
+				if cert, ok := certs[name]; ok && cert.VerifyHostname(e) == nil {
+					c.Certificates = append(c.Certificates, name)
+				}
+			}
+			report = append(report, c)
+		}
+	}
+	return report, nil
+}
+
+// returns the endpoints through which clients reach the API server
+func apiServerEndpoints(plan Plan) ([]string, error) {
+	san, err := clusterCertsSubjectAlternateNames(plan)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := appendUniqueFold([]string{}, appendAPIServerEndpointSANs(plan, san)...)
+	return appendUniqueFold(endpoints, nodeEndpoints(plan.Master.Nodes)...), nil
+}
+
+// returns the hostnames and IPs of the nodes
+func nodeEndpoints(nodes []Node) []string {
+	endpoints := []string{}
+	for _, n := range nodes {
+		endpoints = appendUniqueFold(endpoints, n.Host, n.IP, n.InternalIP)
+	}
+	return endpoints
+}
+
+// appends the non-empty strings that are not in the list already, compared
+// case-insensitively
+func appendUniqueFold(xs []string, values ...string) []string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" && !containsFold(v, xs) {
+			xs = append(xs, v)
+		}
+	}
+	return xs
+}
+
+// reads the certificates with the given names from the certificates
+// directory. The certificates that do not exist are not returned.
+func (lp *LocalPKI) readCerts(names []string) (map[string]*x509.Certificate, error) {
+	certs := make(map[string]*x509.Certificate, len(names))
+	for _, name := range names {
+		cert, err := lp.FileNames.ReadCert(name, lp.GeneratedCertsDirectory)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading certificate %q: %v", name, err)
+		}
+		certs[name] = cert
+	}
+	return certs, nil
+}
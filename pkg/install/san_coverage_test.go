@@ -0,0 +1,63 @@
+package install
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSANCoverageReport(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	p := getPlan()
+	p.Master.APIServerExtraNames = []string{"api.example.com"}
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	// The certificates of master02 are not distributed yet
+	if err := os.Remove(filepath.Join(pki.GeneratedCertsDirectory, "master02-apiserver.pem")); err != nil {
+		t.Fatalf("error removing certificate: %v", err)
+	}
+	// A name that is added to the plan after the certificates were issued
+	p.Master.APIServerExtraNames = append(p.Master.APIServerExtraNames, "new.example.com")
+
+	report, err := pki.SANCoverageReport(p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	coverage := map[string]EndpointCoverage{}
+	for _, c := range report {
+		coverage[c.Service+" "+c.Endpoint] = c
+	}
+	tests := []struct {
+		endpoint string
+		certs    []string
+	}{
+		{endpoint: "apiserver api.example.com", certs: []string{"master01-apiserver"}},
+		{endpoint: "apiserver kubernetes.default", certs: []string{"master01-apiserver"}},
+		{endpoint: "apiserver " + p.Master.LoadBalancedFQDN, certs: []string{"master01-apiserver"}},
+		{endpoint: "apiserver master01", certs: []string{"master01-apiserver"}},
+		{endpoint: "apiserver master02"},
+		{endpoint: "apiserver new.example.com"},
+		{endpoint: "etcd etcd01", certs: []string{"etcd01-etcd"}},
+		{endpoint: "etcd 99.99.99.99", certs: []string{"etcd01-etcd", "etcd02-etcd"}},
+	}
+	for _, test := range tests {
+		c, ok := coverage[test.endpoint]
+		if !ok {
+			t.Errorf("expected endpoint %q to be in the report", test.endpoint)
+			continue
+		}
+		if !reflect.DeepEqual(c.Certificates, test.certs) {
+			t.Errorf("%s: expected covering certificates %v, but got %v", test.endpoint, test.certs, c.Certificates)
+		}
+		if c.Covered() != (len(test.certs) > 0) {
+			t.Errorf("%s: expected covered = %v", test.endpoint, len(test.certs) > 0)
+		}
+	}
+}
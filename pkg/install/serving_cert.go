@@ -0,0 +1,96 @@
+package install
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"github.com/apprenda/kismatic/pkg/util"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	clusterDomain       = "cluster.local"
+	tlsSecretType       = "kubernetes.io/tls"
+	servingCertSuffix   = "serving"
+	servingSecretSuffix = "secret.yaml"
+)
+
+var servingCertUsages = []string{"signing", "key encipherment", "server auth"}
+
+var dnsLabelRE = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// returns the spec of the serving certificate for the given in-cluster service
+func servingCertSpec(serviceName, namespace string, extraSANs []string) (certificateSpec, error) {
+	if !dnsLabelRE.MatchString(serviceName) || len(serviceName) > 63 {
+		return certificateSpec{}, fmt.Errorf("service name %q is not a valid DNS label", serviceName)
+	}
+	if !dnsLabelRE.MatchString(namespace) || len(namespace) > 63 {
+		return certificateSpec{}, fmt.Errorf("namespace %q is not a valid DNS label", namespace)
+	}
+	svc := fmt.Sprintf("%s.%s.svc", serviceName, namespace)
+	san := []string{
+		serviceName,
+		fmt.Sprintf("%s.%s", serviceName, namespace),
+		svc,
+		fmt.Sprintf("%s.%s", svc, clusterDomain),
+	}
+	return certificateSpec{
+		description:           fmt.Sprintf("%s serving", svc),
+		filename:              fmt.Sprintf("%s-%s-%s", serviceName, namespace, servingCertSuffix),
+		commonName:            svc,
+		subjectAlternateNames: uniqueStrings(append(san, extraSANs...)),
+		usages:                servingCertUsages,
+	}, nil
+}
+
+// GenerateServingCert creates a serving certificate signed by the cluster CA
+// for the given in-cluster service, such as an admission webhook or the
+// metrics-server. The key and certificate are written to the certificates
+// directory along with a TLS secret manifest that contains them.
+func (lp *LocalPKI) GenerateServingCert(p *Plan, serviceName, namespace string, extraSANs []string) error {
+	if err := lp.validateSigningProfile(p); err != nil {
+		return err
+	}
+	spec, err := servingCertSpec(serviceName, namespace, extraSANs)
+	if err != nil {
+		return err
+	}
+	spec.keyRequest = p.Cluster.Certificates.leafKeyRequest()
+	ca, err := lp.GetClusterCA()
+	if err != nil {
+		return err
+	}
+	key, cert, err := lp.newCert(ca, spec, p.Cluster.Certificates.leafExpiry())
+	if err != nil {
+		return err
+	}
+	if err = lp.writeCert(key, cert, spec.filename); err != nil {
+		return fmt.Errorf("error writing cert for %q: %v", spec.description, err)
+	}
+	if err = lp.recordIssuance(cert); err != nil {
+		return err
+	}
+
+	s := secretManifest{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   p.manifestMetadata(fmt.Sprintf("%s-%s", serviceName, servingCertSuffix), namespace),
+		Type:       tlsSecretType,
+		StringData: map[string]string{
+			"tls.crt": string(cert),
+			"tls.key": string(key),
+			"ca.crt":  string(ca.Cert),
+		},
+	}
+	b, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("error encoding secret for %q: %v", spec.description, err)
+	}
+	secretFile := filepath.Join(lp.GeneratedCertsDirectory, fmt.Sprintf("%s-%s", spec.filename, servingSecretSuffix))
+	if err := util.WriteFile(secretFile, b, 0600); err != nil {
+		return fmt.Errorf("error writing secret for %q: %v", spec.description, err)
+	}
+	util.PrettyPrintOk(lp.Log, "Generated serving certificate for %s", spec.description)
+	return nil
+}
@@ -0,0 +1,136 @@
+package install
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/apprenda/kismatic/pkg/util"
+)
+
+// defaultSignRetryBackoff is the delay before the first retry of a failed
+// signing operation, unless set
+const defaultSignRetryBackoff = time.Second
+
+// PermanentSignerError is returned by a CASigner for errors that cannot be
+// resolved by retrying the signing operation, such as when the signing
+// policy of a KMS denies the request. Signing is never retried on a
+// permanent error.
+type PermanentSignerError struct {
+	Err error
+}
+
+func (e PermanentSignerError) Error() string {
+	return e.Err.Error()
+}
+
+// returns true if the error must not be retried. Errors that report
+// themselves as not temporary, as net.Error does, are also permanent.
+func isPermanentSignerError(err error) bool {
+	switch e := err.(type) {
+	case PermanentSignerError, *PermanentSignerError:
+		return true
+	case interface {
+		Temporary() bool
+	}:
+		return !e.Temporary()
+	}
+	return false
+}
+
+// signTimeoutErr is returned when a signing operation does not complete
+// within the sign timeout. It is always retried.
+type signTimeoutErr struct {
+	timeout time.Duration
+}
+
+func (e signTimeoutErr) Error() string {
+	return fmt.Sprintf("signing did not complete within %s", e.timeout)
+}
+
+// retryingSigner is a crypto.Signer that bounds the duration of each signing
+// operation of the underlying signer, and retries the failed operations
+// with an exponential backoff
+type retryingSigner struct {
+	signer      crypto.Signer
+	timeout     time.Duration
+	retries     int
+	backoff     time.Duration
+	log         io.Writer
+	description string
+}
+
+// returns the signer used to sign the certificate for the spec. The signer
+// of the CA is wrapped with the sign timeout and retries, if any are set.
+func (lp *LocalPKI) retryingSigner(signer crypto.Signer, spec certificateSpec) crypto.Signer {
+	if signer == nil || (lp.SignTimeout <= 0 && lp.SignRetries <= 0) {
+		return signer
+	}
+	backoff := lp.SignRetryBackoff
+	if backoff <= 0 {
+		backoff = defaultSignRetryBackoff
+	}
+	return &retryingSigner{
+		signer:      signer,
+		timeout:     lp.SignTimeout,
+		retries:     lp.SignRetries,
+		backoff:     backoff,
+		log:         lp.Log,
+		description: spec.description,
+	}
+}
+
+func (s *retryingSigner) Public() crypto.PublicKey {
+	return s.signer.Public()
+}
+
+func (s *retryingSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	attempts := s.retries + 1
+	delay := s.backoff
+	for attempt := 1; ; attempt++ {
+		signature, err := s.sign(rand, digest, opts)
+		if err == nil {
+			return signature, nil
+		}
+		// The error is returned as is when the operation is not retried
+		if isPermanentSignerError(err) || attempts == 1 {
+			return nil, err
+		}
+		if attempt >= attempts {
+			return nil, fmt.Errorf("signing failed after %d attempts: %v", attempt, err)
+		}
+		if s.log != nil {
+			// The certificates are signed concurrently, and share the log
+			slowKeyGenerationLogMu.Lock()
+			util.PrettyPrintWarn(s.log, "Signing the certificate for %s failed on attempt %d of %d, retrying in %s: %v", s.description, attempt, attempts, delay, err)
+			slowKeyGenerationLogMu.Unlock()
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// sign runs a single signing operation, and gives up on it once the timeout
+// expires. The underlying signer cannot be interrupted, so an operation that
+// timed out keeps running in the background until the signer returns.
+func (s *retryingSigner) sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if s.timeout <= 0 {
+		return s.signer.Sign(rand, digest, opts)
+	}
+	type result struct {
+		signature []byte
+		err       error
+	}
+	done := make(chan result, 1)
+	go func() {
+		signature, err := s.signer.Sign(rand, digest, opts)
+		done <- result{signature, err}
+	}()
+	select {
+	case r := <-done:
+		return r.signature, r.err
+	case <-time.After(s.timeout):
+		return nil, signTimeoutErr{timeout: s.timeout}
+	}
+}
@@ -0,0 +1,183 @@
+package install
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apprenda/kismatic/pkg/tls"
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+// flakySigner fails the first signing operations with the given error
+type flakySigner struct {
+	crypto.Signer
+	failures int
+	err      error
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *flakySigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.mu.Lock()
+	s.calls++
+	fail := s.calls <= s.failures
+	s.mu.Unlock()
+	if fail {
+		return nil, s.err
+	}
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+type temporaryErr struct {
+	temporary bool
+}
+
+func (e temporaryErr) Error() string   { return "signer error" }
+func (e temporaryErr) Temporary() bool { return e.temporary }
+
+// returns a signer for the tests, backed by the key of a new CA
+func getTestSigner(t *testing.T) crypto.Signer {
+	key, _, err := tls.NewCACert("test/ca-csr.json", "someCA", "1h")
+	if err != nil {
+		t.Fatalf("error creating CA for test: %v", err)
+	}
+	priv, err := helpers.ParsePrivateKeyPEM(key)
+	if err != nil {
+		t.Fatalf("error parsing CA key: %v", err)
+	}
+	return priv
+}
+
+func TestRetryingSigner(t *testing.T) {
+	priv := getTestSigner(t)
+	digest := sha256.Sum256([]byte("foo"))
+	tests := []struct {
+		failures      int
+		err           error
+		expectedCalls int
+		valid         bool
+	}{
+		{failures: 2, err: errors.New("unavailable"), expectedCalls: 3, valid: true},
+		{failures: 2, err: temporaryErr{temporary: true}, expectedCalls: 3, valid: true},
+		{failures: 3, err: errors.New("unavailable"), expectedCalls: 3, valid: false},
+		{failures: 1, err: PermanentSignerError{Err: errors.New("denied by policy")}, expectedCalls: 1, valid: false},
+		{failures: 1, err: temporaryErr{temporary: false}, expectedCalls: 1, valid: false},
+	}
+	for i, test := range tests {
+		var log bytes.Buffer
+		pki := LocalPKI{Log: &log, SignRetries: 2, SignRetryBackoff: time.Millisecond}
+		flaky := &flakySigner{Signer: priv, failures: test.failures, err: test.err}
+		signer := pki.retryingSigner(flaky, certificateSpec{description: "master01 API server"})
+		_, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if (err == nil) != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got error %v", i, test.valid, err)
+		}
+		if flaky.calls != test.expectedCalls {
+			t.Errorf("test %d: expected %d signing attempts, but got %d", i, test.expectedCalls, flaky.calls)
+		}
+		if test.expectedCalls > 1 && !strings.Contains(log.String(), "master01 API server failed on attempt 1 of 3") {
+			t.Errorf("test %d: expected the certificate and the attempt to be logged, but got %q", i, log.String())
+		}
+	}
+}
+
+func TestRetryingSignerTimeout(t *testing.T) {
+	priv := getTestSigner(t)
+	digest := sha256.Sum256([]byte("foo"))
+
+	// Every attempt times out, other than the last one
+	pki := LocalPKI{Log: ioutil.Discard, SignTimeout: 50 * time.Millisecond, SignRetries: 2, SignRetryBackoff: time.Millisecond}
+	slow := &slowSigner{Signer: priv, slowCalls: 2, delay: 300 * time.Millisecond}
+	if _, err := pki.retryingSigner(slow, certificateSpec{}).Sign(rand.Reader, digest[:], crypto.SHA256); err != nil {
+		t.Errorf("expected the signing operations that timed out to be retried, but got error: %v", err)
+	}
+
+	pki.SignRetries = 0
+	slow = &slowSigner{Signer: priv, slowCalls: 1, delay: 300 * time.Millisecond}
+	_, err := pki.retryingSigner(slow, certificateSpec{}).Sign(rand.Reader, digest[:], crypto.SHA256)
+	if _, ok := err.(signTimeoutErr); !ok {
+		t.Errorf("expected a timeout error, but got %v", err)
+	}
+}
+
+// slowSigner is slow for the first signing operations
+type slowSigner struct {
+	crypto.Signer
+	slowCalls int
+	delay     time.Duration
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *slowSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.mu.Lock()
+	s.calls++
+	slow := s.calls <= s.slowCalls
+	s.mu.Unlock()
+	if slow {
+		time.Sleep(s.delay)
+	}
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+func TestRetryingSignerNotUsedByDefault(t *testing.T) {
+	pki := getPKI(t)
+	signer := &flakySigner{}
+	if s := pki.retryingSigner(signer, certificateSpec{}); s != signer {
+		t.Errorf("expected the signer to be used as is when retries and timeout are not set")
+	}
+	pki.SignRetries = 1
+	if s := pki.retryingSigner(nil, certificateSpec{}); s != nil {
+		t.Errorf("expected no signer when the CA does not have one")
+	}
+}
+
+func TestGenerateClusterCertificatesRetriesCASigner(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	priv, err := helpers.ParsePrivateKeyPEM(ca.Key)
+	if err != nil {
+		t.Fatalf("error parsing CA key: %v", err)
+	}
+	flaky := &flakySigner{Signer: priv, failures: 1, err: errors.New("unavailable")}
+	pki.CASigner = flaky
+	pki.SignRetries = 1
+	pki.SignRetryBackoff = time.Millisecond
+
+	signingCA, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("unexpected error getting the CA: %v", err)
+	}
+	if err := pki.GenerateClusterCertificates(p, signingCA); err != nil {
+		t.Fatalf("expected the failed signing operation to be retried, but got error: %v", err)
+	}
+	caCert := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "ca.pem"), t)
+	admin := mustReadCertFile(filepath.Join(pki.GeneratedCertsDirectory, "admin.pem"), t)
+	if err := admin.CheckSignatureFrom(caCert); err != nil {
+		t.Errorf("expected the certificate to be signed by the CA: %v", err)
+	}
+
+	flaky.calls = 0
+	flaky.failures = 1
+	flaky.err = PermanentSignerError{Err: errors.New("denied by policy")}
+	if err := pki.RotateLeafCerts(p); err == nil {
+		t.Errorf("expected an error when the signer denies the request")
+	}
+}
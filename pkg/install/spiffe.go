@@ -0,0 +1,26 @@
+package install
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// the characters allowed in SPIFFE trust domains and path segments
+	spiffeTrustDomainRE = regexp.MustCompile(`^[a-z0-9._-]+$`)
+	spiffePathSegmentRE = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+)
+
+// returns the SPIFFE ID of the node in the trust domain
+func spiffeID(trustDomain string, node Node) string {
+	return fmt.Sprintf("spiffe://%s/node/%s", strings.ToLower(trustDomain), strings.ToLower(node.Host))
+}
+
+// returns an error if the trust domain is not a valid SPIFFE trust domain
+func validateSPIFFETrustDomain(trustDomain string) error {
+	if len(trustDomain) > 255 || !spiffeTrustDomainRE.MatchString(strings.ToLower(trustDomain)) {
+		return fmt.Errorf("SPIFFE trust domain %q is invalid. It must be a name such as example.org, without a scheme or path", trustDomain)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package install
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/apprenda/kismatic/pkg/util"
+)
+
+const trustBundleFilename = "trust-bundle.pem"
+
+func (ta *TrustAnchor) validate() (bool, []error) {
+	v := newValidator()
+	if _, err := ta.certificates(); err != nil {
+		v.addError(err)
+	}
+	return v.valid()
+}
+
+// returns the name of the trust anchor used in errors
+func (ta TrustAnchor) name() string {
+	if ta.File != "" {
+		return ta.File
+	}
+	return "PEM data"
+}
+
+// returns the CA certificates of the trust anchor. Returns an error if it
+// does not contain a certificate, if it contains anything other than
+// certificates, or if any of the certificates is not the certificate of a CA.
+func (ta TrustAnchor) certificates() ([]*x509.Certificate, error) {
+	if (ta.File == "") == (ta.PEM == "") {
+		return nil, errors.New("Exactly one of the file and the PEM data of a trust anchor must be set")
+	}
+	b := []byte(ta.PEM)
+	if ta.File != "" {
+		var err error
+		if b, err = ioutil.ReadFile(ta.File); err != nil {
+			return nil, fmt.Errorf("Error reading trust anchor: %v", err)
+		}
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("Trust anchor %q contains a %q block. Trust anchors can only contain certificates", ta.name(), block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing certificate of trust anchor %q: %v", ta.name(), err)
+		}
+		if !cert.BasicConstraintsValid || !cert.IsCA {
+			return nil, fmt.Errorf("Certificate %q of trust anchor %q is not the certificate of a CA", cert.Subject.CommonName, ta.name())
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("Trust anchor %q does not contain any PEM encoded certificates", ta.name())
+	}
+	return certs, nil
+}
+
+// returns the certificates of the extra trust anchors, in order
+func (c CertsConfig) extraTrustAnchors() ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, ta := range c.ExtraTrustAnchors {
+		cs, err := ta.certificates()
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cs...)
+	}
+	return certs, nil
+}
+
+// writes the trust bundle, including the extra trust anchors, to the bundle
+// directory of a node when enabled. Returns the names of the files written.
+func (lp *LocalPKI) writeBundleTrustAnchors(p *Plan, dir string) ([]string, error) {
+	if !p.Cluster.Certificates.TrustAnchorsInNodeBundles {
+		return nil, nil
+	}
+	var bundle bytes.Buffer
+	if err := lp.ExportTrustBundle(p, &bundle); err != nil {
+		return nil, err
+	}
+	if err := util.WriteFile(filepath.Join(dir, trustBundleFilename), bundle.Bytes(), 0644); err != nil {
+		return nil, fmt.Errorf("error writing %q: %v", trustBundleFilename, err)
+	}
+	return []string{trustBundleFilename}, nil
+}
@@ -0,0 +1,125 @@
+package install
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apprenda/kismatic/pkg/tls"
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+func TestExportTrustBundleExtraTrustAnchors(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	_, proxyCert, err := tls.NewCACert("test/ca-csr.json", "someProxyCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating proxy CA for test: %v", err)
+	}
+	_, corpCert, err := tls.NewCACert("test/ca-csr.json", "someCorporateCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating corporate CA for test: %v", err)
+	}
+	corpFile := filepath.Join(pki.GeneratedCertsDirectory, "corporate.pem")
+	if err := ioutil.WriteFile(corpFile, corpCert, 0644); err != nil {
+		t.Fatalf("error writing corporate CA for test: %v", err)
+	}
+	p.Cluster.Certificates.ExtraTrustAnchors = []TrustAnchor{{PEM: string(proxyCert)}, {File: corpFile}}
+
+	out := &bytes.Buffer{}
+	if err := pki.ExportTrustBundle(p, out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	certs, err := helpers.ParseCertificatesPEM(out.Bytes())
+	if err != nil {
+		t.Fatalf("error parsing trust bundle: %v", err)
+	}
+	expected := []string{p.Cluster.Name, "someProxyCA", "someCorporateCA"}
+	if len(certs) != len(expected) {
+		t.Fatalf("expected %d certificates in the trust bundle, but got %d", len(expected), len(certs))
+	}
+	for i, c := range certs {
+		if c.Subject.CommonName != expected[i] {
+			t.Errorf("expected certificate %d of the trust bundle to be %q, but got %q", i, expected[i], c.Subject.CommonName)
+		}
+	}
+
+	// The node bundles get the trust bundle, but keep the cluster CA as is
+	p.Cluster.Certificates.TrustAnchorsInNodeBundles = true
+	if err := pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	bundleDir, err := ioutil.TempDir("", "trust-anchors-tests")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer cleanup(bundleDir, t)
+	files, err := pki.WriteNodeBundle(p, p.Worker.Nodes[0], bundleDir)
+	if err != nil {
+		t.Fatalf("error writing node bundle: %v", err)
+	}
+	if !contains(trustBundleFilename, files) {
+		t.Errorf("expected %q to be in the bundle, but got %v", trustBundleFilename, files)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(bundleDir, trustBundleFilename))
+	if err != nil {
+		t.Fatalf("error reading trust bundle of the node: %v", err)
+	}
+	if !bytes.Equal(b, out.Bytes()) {
+		t.Errorf("expected the trust bundle of the node to match the exported trust bundle")
+	}
+	b, err = ioutil.ReadFile(filepath.Join(bundleDir, "ca.pem"))
+	if err != nil {
+		t.Fatalf("error reading CA certificate of the node: %v", err)
+	}
+	caCerts, err := helpers.ParseCertificatesPEM(b)
+	if err != nil {
+		t.Fatalf("error parsing CA certificate of the node: %v", err)
+	}
+	if len(caCerts) != 1 {
+		t.Errorf("expected the CA certificate of the node not to include the extra trust anchors, but got %d certificates", len(caCerts))
+	}
+}
+
+func TestExtraTrustAnchorsCertificates(t *testing.T) {
+	caKey, caCert, err := tls.NewCACert("test/ca-csr.json", "someCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating CA for test: %v", err)
+	}
+	req := csr.CertificateRequest{CN: "someLeaf", KeyRequest: &csr.BasicKeyRequest{A: "ecdsa", S: 256}}
+	_, leafCert, err := tls.NewCert(&tls.CA{Key: caKey, Cert: caCert}, req, time.Hour)
+	if err != nil {
+		t.Fatalf("error creating certificate for test: %v", err)
+	}
+	tests := []struct {
+		anchor TrustAnchor
+		certs  int
+		valid  bool
+	}{
+		{anchor: TrustAnchor{PEM: string(caCert)}, certs: 1, valid: true},
+		{anchor: TrustAnchor{PEM: string(caCert) + string(caCert)}, certs: 2, valid: true},
+		{anchor: TrustAnchor{}, valid: false},
+		{anchor: TrustAnchor{PEM: string(caCert), File: "ca.pem"}, valid: false},
+		{anchor: TrustAnchor{File: "does-not-exist.pem"}, valid: false},
+		{anchor: TrustAnchor{PEM: "not a certificate"}, valid: false},
+		{anchor: TrustAnchor{PEM: string(caCert) + string(caKey)}, valid: false},
+		{anchor: TrustAnchor{PEM: string(leafCert)}, valid: false},
+	}
+	for i, test := range tests {
+		certs, err := test.anchor.certificates()
+		if (err == nil) != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got error %v", i, test.valid, err)
+		}
+		if len(certs) != test.certs {
+			t.Errorf("test %d: expected %d certificates, but got %d", i, test.certs, len(certs))
+		}
+	}
+}
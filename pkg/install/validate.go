@@ -12,8 +12,10 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/apprenda/kismatic/pkg/ssh"
+	"github.com/apprenda/kismatic/pkg/tls"
 	"github.com/apprenda/kismatic/pkg/util"
 )
 
@@ -30,6 +32,41 @@ func ValidatePlan(p *Plan) (bool, []error) {
 	return v.valid()
 }
 
+// ValidationOptions control how the installation plan is validated
+type ValidationOptions struct {
+	// Strict promotes validation warnings to errors
+	Strict bool
+}
+
+// ValidationResult contains the outcome of validating the installation plan.
+// Warnings are advisories that do not prevent the installation from proceeding.
+type ValidationResult struct {
+	Errors   []error
+	Warnings []error
+}
+
+// Valid returns true if the validation did not find any errors
+func (r ValidationResult) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// ValidatePlanWithOptions runs validation against the installation plan, and
+// returns the errors and warnings that were found. When running in strict mode,
+// all warnings are returned as errors.
+func ValidatePlanWithOptions(p *Plan, opts ValidationOptions) ValidationResult {
+	res := ValidationResult{}
+	if ok, errs := ValidatePlan(p); !ok {
+		res.Errors = errs
+	}
+	warnings := p.warnings()
+	if opts.Strict {
+		res.Errors = append(res.Errors, warnings...)
+		return res
+	}
+	res.Warnings = warnings
+	return res
+}
+
 // ValidateNode runs validation against the given node.
 func ValidateNode(node *Node) (bool, []error) {
 	v := newValidator()
@@ -124,6 +161,81 @@ func (v *validator) valid() (bool, []error) {
 	return true, nil
 }
 
+// warnings returns the advisories about the plan that do not make it invalid
+func (p *Plan) warnings() []error {
+	warns := []error{}
+	if p.Etcd.ExpectedCount > 0 && p.Etcd.ExpectedCount%2 == 0 {
+		warns = append(warns, fmt.Errorf("Etcd nodes: an odd number of etcd nodes is recommended, as an even number does not increase the cluster's fault tolerance"))
+	}
+	if ipnet, err := p.ServiceNetwork(); err == nil {
+		if ones, bits := ipnet.Mask.Size(); bits-ones < 8 {
+			warns = append(warns, fmt.Errorf("Service CIDR block %q is small, and only allows for %d services", p.Cluster.Networking.ServiceCIDRBlock, 1<<uint(bits-ones)-2))
+		}
+	}
+	if len(p.Master.Nodes) > 1 {
+		for _, n := range p.Master.Nodes {
+			if contains(p.Master.LoadBalancedFQDN, []string{n.Host, n.IP, n.InternalIP}) {
+				warns = append(warns, fmt.Errorf("Master nodes: load balanced FQDN %q is the address of master node %q. Clients will lose access to the API server if this node is down. Use the address of a load balancer in front of all master nodes", p.Master.LoadBalancedFQDN, n.Host))
+			}
+		}
+	}
+	if p.Cluster.Certificates.DisableKubernetesServiceIPSAN {
+		warns = append(warns, fmt.Errorf("The kubernetes service IP is not included in the API server certificates. Clients reaching the API server through the service IP will fail certificate verification"))
+	}
+	for _, n := range p.GetUniqueNodes() {
+		if n.InternalIP != "" && n.InternalIP == n.IP {
+			warns = append(warns, fmt.Errorf("Node %q: internal IP %q is the same as the IP %q, and can be omitted", n.Host, n.InternalIP, n.IP))
+		}
+	}
+	if p.Cluster.Certificates.NodeAddressHints {
+		warns = append(warns, nodeAddressHints(p)...)
+	}
+	return warns
+}
+
+// nodeAddressHints returns warnings about the addresses of the nodes that
+// are likely mistakes: an IP and internal IP that differ by a single
+// character, or an address that is shared with another node
+func nodeAddressHints(p *Plan) []error {
+	warns := []error{}
+	nodes := p.AllNodes()
+	for i, pn := range nodes {
+		n := pn.Node
+		if n.InternalIP != "" && n.InternalIP != n.IP && singleEditApart(n.IP, n.InternalIP) {
+			warns = append(warns, fmt.Errorf("Node %q: IP %q and internal IP %q differ by a single character, which might be a typo", n.Host, n.IP, n.InternalIP))
+		}
+		for _, other := range nodes[i+1:] {
+			o := other.Node
+			for _, addr := range []string{n.IP, n.InternalIP} {
+				if addr != "" && contains(addr, []string{o.IP, o.InternalIP}) {
+					warns = append(warns, fmt.Errorf("Node %q (IP %q, internal IP %q) shares the address %q with node %q (IP %q, internal IP %q)", n.Host, n.IP, n.InternalIP, addr, o.Host, o.IP, o.InternalIP))
+					break
+				}
+			}
+		}
+	}
+	return warns
+}
+
+// returns true if a single character has to be inserted, removed or
+// replaced in one of the strings to obtain the other
+func singleEditApart(a, b string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	if len(b)-len(a) > 1 {
+		return false
+	}
+	i := 0
+	for i < len(a) && a[i] == b[i] {
+		i++
+	}
+	if len(a) == len(b) {
+		return i < len(a) && a[i+1:] == b[i+1:]
+	}
+	return a[i:] == b[i+1:]
+}
+
 func (p *Plan) validate() (bool, []error) {
 	v := newValidator()
 
@@ -139,14 +251,198 @@ func (p *Plan) validate() (bool, []error) {
 	v.validateWithErrPrefix("Ingress nodes", &p.Ingress)
 	v.validate(&p.NFS)
 	v.validateWithErrPrefix("Storage nodes", &p.Storage)
+	// An empty plan is usually the result of an indentation mistake that drops the node lists
+	if len(p.Etcd.Nodes)+len(p.Master.Nodes)+len(p.Worker.Nodes) == 0 {
+		v.addError(fmt.Errorf("The plan does not contain any nodes, at least one etcd node and one master node are required. Verify the indentation of the node lists"))
+	}
+
+	if p.Metadata != nil {
+		v.validateWithErrPrefix("Metadata", p.Metadata)
+	}
+	if p.EtcdPorts != nil {
+		v.validateWithErrPrefix("Etcd ports", etcdPortSet{plan: p})
+	}
+
+	// Windows nodes can only run workloads
+	for _, n := range p.GetUniqueNodes() {
+		if !n.Windows {
+			continue
+		}
+		for _, r := range p.GetRolesForIP(n.IP) {
+			if r != "worker" {
+				v.addError(fmt.Errorf("Node %q is a Windows node, and cannot be used as a %s node", n.Host, r))
+			}
+		}
+	}
+
+	// Each hostname in the certificate SANs must map back to a single node
+	for _, err := range overlappingNodeHostnames(p) {
+		v.addError(err)
+	}
+
+	// The SPIFFE ID of each node is derived from its hostname
+	if p.Cluster.Certificates.SPIFFETrustDomain != "" {
+		for _, n := range p.AllNodes() {
+			if !spiffePathSegmentRE.MatchString(n.Node.Host) {
+				v.addError(fmt.Errorf("Node %q: hostname cannot be used in a SPIFFE ID. It can only contain letters, digits, dots, dashes and underscores", n.Node.Host))
+			}
+		}
+	}
+
+	// A node that is listed in multiple node groups has a single validity
+	validity := map[string]string{}
+	for _, nodes := range [][]Node{p.Etcd.Nodes, p.Master.Nodes, p.Worker.Nodes, p.Ingress.Nodes, p.Storage.Nodes} {
+		for _, n := range nodes {
+			if cv, ok := validity[n.Host]; ok && cv != n.CertValidity {
+				v.addError(fmt.Errorf("Node %q: certificate validity must be the same in every node group the node is listed in, but got %q and %q", n.Host, cv, n.CertValidity))
+			}
+			validity[n.Host] = n.CertValidity
+		}
+	}
+
+	// The certificates of a node cannot outlive the CA
+	if caExpiry, err := time.ParseDuration(p.Cluster.Certificates.caExpiry()); err == nil {
+		for _, n := range p.AllNodes() {
+			d, err := time.ParseDuration(n.Node.CertValidity)
+			if err == nil && d > caExpiry {
+				v.addError(fmt.Errorf("Node %q: certificate validity %q is longer than the CA certificate expiry %q. Certificates cannot be valid for longer than the CA that signed them", n.Node.Host, n.Node.CertValidity, p.Cluster.Certificates.caExpiry()))
+			}
+		}
+	}
 
 	return v.valid()
 }
 
+// returns an error for every pair of nodes with hostnames that overlap, either
+// because they are the same once short name SANs are added, or because the
+// hostname of one node is a wildcard that matches the other
+func overlappingNodeHostnames(p *Plan) []error {
+	errs := []error{}
+	nodes := p.AllNodes()
+	for i := range nodes {
+		for j := i + 1; j < len(nodes); j++ {
+			a, b := nodes[i].Node, nodes[j].Node
+		pair:
+			for _, x := range nodeHostnameSANs(*p, a) {
+				for _, y := range nodeHostnameSANs(*p, b) {
+					if strings.EqualFold(x, y) || wildcardMatches(x, y) || wildcardMatches(y, x) {
+						errs = append(errs, fmt.Errorf("Node %q and node %q have overlapping hostnames %q and %q, which makes their certificates ambiguous", a.Host, b.Host, x, y))
+						break pair
+					}
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// returns true if the pattern is a wildcard DNS name that matches the hostname
+func wildcardMatches(pattern, host string) bool {
+	if !strings.HasPrefix(pattern, "*.") {
+		return false
+	}
+	suffix := strings.ToLower(pattern[1:])
+	host = strings.ToLower(host)
+	if !strings.HasSuffix(host, suffix) {
+		return false
+	}
+	label := strings.TrimSuffix(host, suffix)
+	return label != "" && !strings.ContainsAny(label, ".*")
+}
+
+var (
+	qualifiedNameRE = regexp.MustCompile(`^([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9]$`)
+	dnsSubdomainRE  = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// validates a Kubernetes label or annotation key, which is a name of at most
+// 63 characters with an optional DNS subdomain prefix
+func validMetadataKey(key string) bool {
+	name := key
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		prefix := key[:i]
+		if len(prefix) == 0 || len(prefix) > 253 || !dnsSubdomainRE.MatchString(prefix) {
+			return false
+		}
+		name = key[i+1:]
+	}
+	return len(name) <= 63 && qualifiedNameRE.MatchString(name)
+}
+
+func (m *Metadata) validate() (bool, []error) {
+	v := newValidator()
+	for k, val := range m.Labels {
+		if !validMetadataKey(k) {
+			v.addError(fmt.Errorf("Label key %q is invalid", k))
+		}
+		if len(val) > 63 || (val != "" && !qualifiedNameRE.MatchString(val)) {
+			v.addError(fmt.Errorf("Label %q has an invalid value %q", k, val))
+		}
+	}
+	for k := range m.Annotations {
+		if !validMetadataKey(k) {
+			v.addError(fmt.Errorf("Annotation key %q is invalid", k))
+		}
+	}
+	return v.valid()
+}
+
+// well-known kubernetes ports that are bound on nodes with the given role
+var kubernetesPortsByRole = map[string][]int{
+	"master":  {6443, 8080, 10249, 10250, 10251, 10252, 10255},
+	"worker":  {10249, 10250, 10255},
+	"ingress": {80, 443, 10249, 10250, 10255},
+	"storage": {10249, 10250, 10255},
+}
+
+type etcdPortSet struct {
+	plan *Plan
+}
+
+func (s etcdPortSet) validate() (bool, []error) {
+	v := newValidator()
+	configured := *s.plan.EtcdPorts
+	effective := s.plan.etcdPorts()
+	ports := []struct {
+		name       string
+		configured int
+		port       int
+	}{
+		{"Kubernetes client port", configured.KubernetesClientPort, effective.KubernetesClientPort},
+		{"Kubernetes peer port", configured.KubernetesPeerPort, effective.KubernetesPeerPort},
+		{"Networking client port", configured.NetworkingClientPort, effective.NetworkingClientPort},
+		{"Networking peer port", configured.NetworkingPeerPort, effective.NetworkingPeerPort},
+	}
+	used := map[int]string{}
+	for _, p := range ports {
+		if p.configured < 0 || p.configured > 65535 {
+			v.addError(fmt.Errorf("%s %d is invalid. Port must be in the range 1-65535", p.name, p.configured))
+			continue
+		}
+		if other, ok := used[p.port]; ok {
+			v.addError(fmt.Errorf("%s %d is already used as the %s", p.name, p.port, other))
+			continue
+		}
+		used[p.port] = strings.ToLower(p.name)
+		for _, n := range s.plan.Etcd.Nodes {
+			for _, role := range s.plan.GetRolesForIP(n.IP) {
+				for _, reserved := range kubernetesPortsByRole[role] {
+					if p.port == reserved {
+						v.addError(fmt.Errorf("%s %d conflicts with a %s port on node %q", p.name, p.port, role, n.Host))
+					}
+				}
+			}
+		}
+	}
+	return v.valid()
+}
+
 func (c *Cluster) validate() (bool, []error) {
 	v := newValidator()
-	if c.Name == "" {
+	if strings.TrimSpace(c.Name) == "" {
 		v.addError(errors.New("Cluster name cannot be empty"))
+	} else if len(c.Name) > maxCommonNameLength {
+		v.addError(fmt.Errorf("Cluster name %q is invalid. It is used as the common name of the CA certificate, which must be at most %d characters long", c.Name, maxCommonNameLength))
 	}
 	if c.AdminPassword == "" {
 		v.addError(errors.New("Admin password cannot be empty"))
@@ -179,12 +475,56 @@ func (n *NetworkConfig) validate() (bool, []error) {
 	if n.ServiceCIDRBlock == "" {
 		v.addError(errors.New("Service CIDR block cannot be empty"))
 	}
-	if _, _, err := net.ParseCIDR(n.ServiceCIDRBlock); n.ServiceCIDRBlock != "" && err != nil {
-		v.addError(fmt.Errorf("Invalid Service CIDR block provided: %v", err))
+	var serviceNet *net.IPNet
+	if n.ServiceCIDRBlock != "" {
+		var err error
+		if serviceNet, err = parseServiceCIDR(n.ServiceCIDRBlock); err != nil {
+			v.addError(err)
+		}
+	}
+	if n.DNSServiceIP != "" {
+		ip := net.ParseIP(n.DNSServiceIP)
+		if ip == nil {
+			v.addError(fmt.Errorf("Invalid DNS service IP %q provided", n.DNSServiceIP))
+		} else if serviceNet != nil && !serviceNet.Contains(ip) {
+			v.addError(fmt.Errorf("DNS service IP %q must be within the Service CIDR block %q", n.DNSServiceIP, n.ServiceCIDRBlock))
+		} else if serviceNet != nil {
+			if kubeIP, err := util.GetIPFromNetwork(serviceNet, 1); err == nil && kubeIP.Equal(ip) {
+				v.addError(fmt.Errorf("DNS service IP %q cannot be the same as the kubernetes service IP", n.DNSServiceIP))
+			}
+		}
 	}
 	return v.valid()
 }
 
+// minServiceCIDRHostBits is the number of host bits required in the service
+// CIDR block to allocate the kubernetes and DNS service IPs
+const minServiceCIDRHostBits = 2
+
+// returns an error that explains how to fix the service CIDR block if it is
+// not a CIDR block, or if it is too small to allocate the service IPs
+func validateServiceCIDR(cidr string) error {
+	_, err := parseServiceCIDR(cidr)
+	return err
+}
+
+// parseServiceCIDR parses the service CIDR block, and returns an error if the
+// network is too small to allocate the kubernetes service IP
+func parseServiceCIDR(cidr string) (*net.IPNet, error) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		if ip := net.ParseIP(cidr); ip != nil {
+			return nil, fmt.Errorf("Service CIDR block %q is an IP address, not a CIDR block. Add the prefix length of the network, such as %s/16", cidr, cidr)
+		}
+		return nil, fmt.Errorf("Service CIDR block %q is not a valid CIDR block. It must be an IP address followed by a prefix length, such as 172.20.0.0/16", cidr)
+	}
+	ones, bits := ipnet.Mask.Size()
+	if bits-ones < minServiceCIDRHostBits {
+		return nil, fmt.Errorf("Service CIDR block %q is too small to allocate the kubernetes service IP. The prefix length must be /%d or less", cidr, bits-minServiceCIDRHostBits)
+	}
+	return ipnet, nil
+}
+
 func (c *CertsConfig) validate() (bool, []error) {
 	v := newValidator()
 	if _, err := time.ParseDuration(c.Expiry); err != nil {
@@ -193,6 +533,224 @@ func (c *CertsConfig) validate() (bool, []error) {
 	if _, err := time.ParseDuration(c.CAExpiry); c.CAExpiry != "" && err != nil { // don't error when empty for backwards compat
 		v.addError(fmt.Errorf("Invalid CA certificate expiry %q provider: %v", c.CAExpiry, err))
 	}
+	if c.providedCA() {
+		v.addError(c.validateProvidedCA()...)
+	}
+	if c.SigningProfile != nil {
+		v.validateWithErrPrefix("Signing profile", c.SigningProfile)
+		algo := c.leafKeyRequest().A
+		if err := tls.ValidateUsagesForKeyAlgorithm(c.SigningProfile.Usages, algo); err != nil {
+			v.addError(fmt.Errorf("Signing profile is not compatible with the %s key algorithm of the certificates: %v", algo, err))
+		}
+	}
+	names := map[string]bool{}
+	for i, cc := range c.ClientCertificates {
+		v.validateWithErrPrefix(fmt.Sprintf("Client certificate #%d", i+1), &cc)
+		if names[cc.Name] {
+			v.addError(fmt.Errorf("Client certificate name %q is used more than once", cc.Name))
+		}
+		names[cc.Name] = true
+	}
+	if notAfter, err := c.notAfter(); err != nil {
+		v.addError(err)
+	} else if !notAfter.IsZero() {
+		now := time.Now()
+		if !notAfter.After(now) {
+			v.addError(fmt.Errorf("Certificates not after date %q has already passed", c.NotAfter))
+		}
+		if caExpiry, err := time.ParseDuration(c.caExpiry()); err == nil && notAfter.After(now.Add(caExpiry)) {
+			v.addError(fmt.Errorf("Certificates not after date %q is after the expiry of the CA certificate. Certificates cannot be valid for longer than the CA that signed them", c.NotAfter))
+		}
+	}
+	expiry, errExpiry := time.ParseDuration(c.leafExpiry())
+	// The default expiry of the CA is used when it is not set, as the CA then
+	// gets the default expiry
+	caExpiry, errCAExpiry := time.ParseDuration(c.caExpiry())
+	if errExpiry == nil && errCAExpiry == nil && expiry > caExpiry {
+		v.addError(fmt.Errorf("Certificate expiry %q is longer than the CA certificate expiry %q. Certificates cannot be valid for longer than the CA that signed them", c.leafExpiry(), c.caExpiry()))
+	}
+	if err := tls.ValidateCAOptions(c.caOptions()); err != nil {
+		v.addError(err)
+	}
+	if c.CACSR != nil {
+		v.validateWithErrPrefix("CA CSR", c.CACSR)
+	}
+	if c.CAKeyAlgorithm != "" || c.CAKeySize != 0 {
+		if err := validateKeyRequest(c.CAKeyAlgorithm, c.CAKeySize); err != nil {
+			v.addError(fmt.Errorf("CA key: %v", err))
+		}
+		if c.CACSR != nil && (c.CACSR.KeyAlgorithm != "" || c.CACSR.KeySize != 0) {
+			v.addError(errors.New("CA key algorithm and size cannot be set in both the certificates configuration and the CA CSR"))
+		}
+	}
+	if err := validateKeyRequest(c.KeyAlgorithm, c.KeySize); err != nil {
+		v.addError(fmt.Errorf("Certificate key: %v", err))
+	}
+	if algo, ok := c.caKeyAlgorithm(); ok {
+		if err := tls.ValidateUsagesForKeyAlgorithm(c.CAKeyUsages, algo); err != nil {
+			v.addError(fmt.Errorf("CA key usages are not compatible with the %s key algorithm of the CA: %v", algo, err))
+		}
+	}
+	if c.BootstrapToken != nil {
+		v.validate(c.BootstrapToken)
+	}
+	if c.CAConfigMap != nil {
+		v.validate(c.CAConfigMap)
+	}
+	for i, ta := range c.ExtraTrustAnchors {
+		v.validateWithErrPrefix(fmt.Sprintf("Extra trust anchor #%d", i+1), &ta)
+	}
+	if c.TrustAnchorsInNodeBundles && len(c.ExtraTrustAnchors) == 0 {
+		v.addError(errors.New("Trust anchors in node bundles requires at least one extra trust anchor"))
+	}
+	if c.NodeDomain != "" {
+		if d := strings.ToLower(c.NodeDomain); len(d) > 253 || !dnsSubdomainRE.MatchString(d) {
+			v.addError(fmt.Errorf("Node domain %q is not a valid DNS name", c.NodeDomain))
+		}
+		if !c.NodeShortNameSANs {
+			v.addError(errors.New("Node domain is only used when node short name SANs are enabled"))
+		}
+	}
+	if c.SPIFFETrustDomain != "" {
+		if err := validateSPIFFETrustDomain(c.SPIFFETrustDomain); err != nil {
+			v.addError(err)
+		}
+	}
+	if c.MaxSANs < 0 {
+		v.addError(fmt.Errorf("Maximum number of SANs %d cannot be negative", c.MaxSANs))
+	}
+	for _, a := range c.SANAllowlist {
+		if net.ParseIP(a) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(a); err == nil {
+			continue
+		}
+		if d := strings.ToLower(a); len(d) > 253 || !dnsSubdomainRE.MatchString(d) {
+			v.addError(fmt.Errorf("SAN allowlist entry %q is not a valid IP address, CIDR block or DNS name", a))
+		}
+	}
+	return v.valid()
+}
+
+func (cc *ClientCertificate) validate() (bool, []error) {
+	v := newValidator()
+	if cc.Name == "" {
+		v.addError(errors.New("Name is required"))
+	} else if !qualifiedNameRE.MatchString(cc.Name) {
+		v.addError(fmt.Errorf("Name %q is invalid. It must consist of alphanumeric characters, '-', '_' or '.'", cc.Name))
+	} else if cc.Name == "ca" || cc.Name == adminCertFilename {
+		v.addError(fmt.Errorf("Name %q is reserved for a certificate generated by kismatic", cc.Name))
+	}
+	if cc.User == "" {
+		v.addError(errors.New("User is required"))
+	}
+	if len(cc.Groups) == 0 {
+		v.addError(errors.New("At least one group is required"))
+	}
+	for _, g := range cc.Groups {
+		if g == "" {
+			v.addError(errors.New("Group names cannot be empty"))
+		}
+	}
+	return v.valid()
+}
+
+func (sp *SigningProfile) validate() (bool, []error) {
+	v := newValidator()
+	if len(sp.Usages) == 0 {
+		v.addError(errors.New("At least one key usage is required"))
+	}
+	if err := tls.ValidateUsages(sp.Usages); err != nil {
+		v.addError(err)
+	}
+	if _, err := time.ParseDuration(sp.Expiry); sp.Expiry != "" && err != nil {
+		v.addError(fmt.Errorf("Invalid expiry %q provided: %v", sp.Expiry, err))
+	}
+	return v.valid()
+}
+
+func (bt *BootstrapToken) validate() (bool, []error) {
+	v := newValidator()
+	if d, err := bt.ttl(); err != nil {
+		v.addError(err)
+	} else if d < 0 {
+		v.addError(fmt.Errorf("Bootstrap token TTL %q cannot be negative", bt.TTL))
+	}
+	for _, u := range bt.Usages {
+		if !contains(u, bootstrapTokenUsages) {
+			v.addError(fmt.Errorf("Bootstrap token usage %q is invalid. Options are %v", u, bootstrapTokenUsages))
+		}
+	}
+	return v.valid()
+}
+
+func (cm *CAConfigMap) validate() (bool, []error) {
+	v := newValidator()
+	if n := cm.name(); len(n) > 253 || !dnsSubdomainRE.MatchString(n) {
+		v.addError(fmt.Errorf("CA ConfigMap name %q is not a valid DNS subdomain", n))
+	}
+	if ns := cm.namespace(); len(ns) > 63 || !dnsLabelRE.MatchString(ns) {
+		v.addError(fmt.Errorf("CA ConfigMap namespace %q is not a valid DNS label", ns))
+	}
+	return v.valid()
+}
+
+func (c *CACSR) validate() (bool, []error) {
+	v := newValidator()
+	if err := validateKeyRequest(c.KeyAlgorithm, c.KeySize); err != nil {
+		v.addError(err)
+	}
+	for _, h := range c.Hosts {
+		if h == "" {
+			v.addError(errors.New("Hosts cannot contain empty values"))
+		}
+	}
+	for i, n := range c.Names {
+		v.validateWithErrPrefix(fmt.Sprintf("Name #%d", i+1), &n)
+	}
+	return v.valid()
+}
+
+// returns an error if the key algorithm and size are not supported. A zero
+// size means the default size of the algorithm.
+func validateKeyRequest(algo string, size int) error {
+	switch algo {
+	case "", "rsa":
+		if size != 0 && (size < 2048 || size > 8192) {
+			return fmt.Errorf("RSA key size %d is invalid. Key size must be between 2048 and 8192", size)
+		}
+	case "ecdsa":
+		if size != 0 && size != 256 && size != 384 && size != 521 {
+			return fmt.Errorf("ECDSA key size %d is invalid. Key size must be one of 256, 384 or 521", size)
+		}
+	default:
+		return fmt.Errorf("Key algorithm %q is invalid. Options are rsa or ecdsa", algo)
+	}
+	return nil
+}
+
+var countryCodeRE = regexp.MustCompile(`^[A-Z]{2}$`)
+
+func (n *CSRName) validate() (bool, []error) {
+	v := newValidator()
+	if n.Country != "" && !countryCodeRE.MatchString(n.Country) {
+		v.addError(fmt.Errorf("Country %q is invalid. It must be a two-letter ISO 3166 country code, such as US", n.Country))
+	}
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"State", n.State},
+		{"Locality", n.Locality},
+		{"Organization", n.Organization},
+		{"Organizational unit", n.OrganizationalUnit},
+	}
+	for _, f := range fields {
+		if strings.IndexFunc(f.value, unicode.IsControl) != -1 {
+			v.addError(fmt.Errorf("%s %q contains control characters", f.name, f.value))
+		}
+	}
 	return v.valid()
 }
 
@@ -386,6 +944,32 @@ func (mng *MasterNodeGroup) validate() (bool, []error) {
 		v.addError(fmt.Errorf("Load balanced shortname is required"))
 	}
 
+	extraIPs := []net.IP{}
+	for _, s := range mng.APIServerExtraIPs {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			v.addError(fmt.Errorf("API server extra IP %q is not a valid IP address", s))
+			continue
+		}
+		for _, other := range extraIPs {
+			if ip.Equal(other) {
+				v.addError(fmt.Errorf("API server extra IP %q is duplicated", s))
+			}
+		}
+		extraIPs = append(extraIPs, ip)
+	}
+	extraNames := []string{}
+	for _, n := range mng.APIServerExtraNames {
+		if len(n) > 253 || !dnsSubdomainRE.MatchString(strings.ToLower(n)) {
+			v.addError(fmt.Errorf("API server extra name %q is not a valid DNS name", n))
+			continue
+		}
+		if containsFold(n, extraNames) {
+			v.addError(fmt.Errorf("API server extra name %q is duplicated", n))
+		}
+		extraNames = append(extraNames, n)
+	}
+
 	return v.valid()
 }
 
@@ -394,6 +978,9 @@ func (n *Node) validate() (bool, []error) {
 	if n.Host == "" {
 		v.addError(fmt.Errorf("Node host field is required"))
 	}
+	if strings.Contains(n.Host, "*") {
+		v.addError(fmt.Errorf("Node %q: hostname cannot be a wildcard. Wildcards can only be used in SANs that are requested explicitly", n.Host))
+	}
 	if n.IP == "" {
 		v.addError(fmt.Errorf("Node IP field is required"))
 	}
@@ -403,9 +990,65 @@ func (n *Node) validate() (bool, []error) {
 	if ip := net.ParseIP(n.InternalIP); n.InternalIP != "" && ip == nil {
 		v.addError(fmt.Errorf("Invalid InternalIP provided"))
 	}
+	if kind := specialUseIPKind(net.ParseIP(n.IP)); kind != "" {
+		v.addError(fmt.Errorf("Node %q: IP %q is a %s address, which cannot be used to reach the node", n.Host, n.IP, kind))
+	}
+	if kind := specialUseIPKind(net.ParseIP(n.InternalIP)); kind != "" {
+		v.addError(fmt.Errorf("Node %q: InternalIP %q is a %s address, which cannot be used to reach the node", n.Host, n.InternalIP, kind))
+	}
+	if n.NetBIOSName != "" {
+		if !n.Windows {
+			v.addError(fmt.Errorf("NetBIOS name can only be set on Windows nodes"))
+		}
+		if len(n.NetBIOSName) > 15 {
+			v.addError(fmt.Errorf("NetBIOS name %q is invalid. It must be at most 15 characters long", n.NetBIOSName))
+		}
+		if strings.ContainsAny(n.NetBIOSName, "\\/:*?\"<>|. ") {
+			v.addError(fmt.Errorf("NetBIOS name %q contains invalid characters", n.NetBIOSName))
+		}
+	}
+	if n.CertValidity != "" {
+		if d, err := time.ParseDuration(n.CertValidity); err != nil {
+			v.addError(fmt.Errorf("Node %q: invalid certificate validity %q provided: %v", n.Host, n.CertValidity, err))
+		} else if d <= 0 {
+			v.addError(fmt.Errorf("Node %q: certificate validity %q must be positive", n.Host, n.CertValidity))
+		}
+	}
+	for name := range n.Vars {
+		if !ansibleVarNameRE.MatchString(name) {
+			v.addError(fmt.Errorf("Node %q: variable name %q is invalid. It must start with a letter or underscore, followed by letters, digits or underscores", n.Host, name))
+			continue
+		}
+		if contains(name, reservedNodeVars) {
+			v.addError(fmt.Errorf("Node %q: variable %q is set by kismatic from the plan, and cannot be overridden", n.Host, name))
+		}
+	}
 	return v.valid()
 }
 
+var ansibleVarNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedNodeVars are the inventory variables that are derived from the plan
+var reservedNodeVars = []string{"ansible_host", "internal_ipv4", "ansible_ssh_private_key_file", "ansible_port", "ansible_user"}
+
+// returns the kind of special-use address of the IP, or an empty string
+// if the IP can be used as the address of a node
+func specialUseIPKind(ip net.IP) string {
+	switch {
+	case ip == nil:
+		return ""
+	case ip.IsUnspecified():
+		return "unspecified"
+	case ip.IsLoopback():
+		return "loopback"
+	case ip.IsLinkLocalUnicast():
+		return "link-local"
+	case ip.IsMulticast():
+		return "multicast"
+	}
+	return ""
+}
+
 func (dr *DockerRegistry) validate() (bool, []error) {
 	v := newValidator()
 	if dr.SetupInternal == true && (dr.Address != "" || dr.CAPath != "") {
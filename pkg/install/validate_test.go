@@ -2,91 +2,103 @@ package install
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/apprenda/kismatic/pkg/tls"
 )
 
-var validPlan = Plan{
-	Cluster: Cluster{
-		Name:          "test",
-		AdminPassword: "password",
-		Networking: NetworkConfig{
-			Type:             "overlay",
-			PodCIDRBlock:     "172.16.0.0/16",
-			ServiceCIDRBlock: "172.20.0.0/16",
-		},
-		Certificates: CertsConfig{
-			Expiry: "17250h",
-		},
-		SSH: SSHConfig{
-			User: "root",
-			Key:  "/bin/sh",
-			Port: 22,
-		},
-	},
-	AddOns: AddOns{
-		CNI: &CNI{
-			Provider: "calico",
-			Options: CNIOptions{
-				Calico: CalicoOptions{
-					Mode: "overlay",
+var validPlan = newValidPlan()
+
+// returns a new plan that is valid. Tests that change the plan should start
+// from a new plan, as validPlan is changed by some of the tests.
+func newValidPlan() Plan {
+	return Plan{
+		Cluster: Cluster{
+			Name:          "test",
+			AdminPassword: "password",
+			Networking: NetworkConfig{
+				Type:             "overlay",
+				PodCIDRBlock:     "172.16.0.0/16",
+				ServiceCIDRBlock: "172.20.0.0/16",
+			},
+			Certificates: CertsConfig{
+				Expiry: "17250h",
+			},
+			SSH: SSHConfig{
+				User: "root",
+				Key:  "/bin/sh",
+				Port: 22,
+			},
+		},
+		AddOns: AddOns{
+			CNI: &CNI{
+				Provider: "calico",
+				Options: CNIOptions{
+					Calico: CalicoOptions{
+						Mode: "overlay",
+					},
 				},
 			},
-		},
-		HeapsterMonitoring: &HeapsterMonitoring{
-			Options: HeapsterOptions{
-				Heapster: Heapster{
-					Replicas:    2,
-					ServiceType: "ClusterIP",
+			HeapsterMonitoring: &HeapsterMonitoring{
+				Options: HeapsterOptions{
+					Heapster: Heapster{
+						Replicas:    2,
+						ServiceType: "ClusterIP",
+					},
 				},
 			},
 		},
-	},
-	Etcd: NodeGroup{
-		ExpectedCount: 1,
-		Nodes: []Node{
-			{
-				Host: "etcd01",
-				IP:   "192.168.205.10",
+		Etcd: NodeGroup{
+			ExpectedCount: 1,
+			Nodes: []Node{
+				{
+					Host: "etcd01",
+					IP:   "192.168.205.10",
+				},
 			},
 		},
-	},
-	Master: MasterNodeGroup{
-		ExpectedCount: 1,
-		Nodes: []Node{
-			{
-				Host: "master01",
-				IP:   "192.168.205.11",
+		Master: MasterNodeGroup{
+			ExpectedCount: 1,
+			Nodes: []Node{
+				{
+					Host: "master01",
+					IP:   "192.168.205.11",
+				},
 			},
+			LoadBalancedFQDN:      "test",
+			LoadBalancedShortName: "test",
 		},
-		LoadBalancedFQDN:      "test",
-		LoadBalancedShortName: "test",
-	},
-	Worker: NodeGroup{
-		ExpectedCount: 1,
-		Nodes: []Node{
-			{
-				Host: "worker01",
-				IP:   "192.168.205.12",
+		Worker: NodeGroup{
+			ExpectedCount: 1,
+			Nodes: []Node{
+				{
+					Host: "worker01",
+					IP:   "192.168.205.12",
+				},
 			},
 		},
-	},
-	Ingress: OptionalNodeGroup{
-		ExpectedCount: 1,
-		Nodes: []Node{
-			{
-				Host: "etcd01",
-				IP:   "192.168.205.10",
+		Ingress: OptionalNodeGroup{
+			ExpectedCount: 1,
+			Nodes: []Node{
+				{
+					Host: "etcd01",
+					IP:   "192.168.205.10",
+				},
 			},
 		},
-	},
-	NFS: NFS{
-		Volumes: []NFSVolume{
-			{
-				Host: "10.10.2.20",
-				Path: "/",
+		NFS: NFS{
+			Volumes: []NFSVolume{
+				{
+					Host: "10.10.2.20",
+					Path: "/",
+				},
 			},
 		},
-	},
+	}
 }
 
 func assertInvalidPlan(t *testing.T, p Plan) {
@@ -134,6 +146,250 @@ func TestValidatePlanInvalidServicesCIDR(t *testing.T) {
 	assertInvalidPlan(t, p)
 }
 
+func TestValidateServiceCIDR(t *testing.T) {
+	tests := []struct {
+		cidr     string
+		errMatch string
+	}{
+		{cidr: "172.20.0.0/16"},
+		{cidr: "172.20.0.0/30"},
+		{cidr: "fd00::/126"},
+		{cidr: "172.20.0.0", errMatch: "Add the prefix length"},
+		{cidr: "fd00::", errMatch: "Add the prefix length"},
+		{cidr: "foo", errMatch: "not a valid CIDR block"},
+		{cidr: "172.20.0.0/33", errMatch: "not a valid CIDR block"},
+		{cidr: "172.20.0.0/31", errMatch: "must be /30 or less"},
+		{cidr: "172.20.0.1/32", errMatch: "must be /30 or less"},
+		{cidr: "fd00::/127", errMatch: "must be /126 or less"},
+	}
+	for _, test := range tests {
+		err := validateServiceCIDR(test.cidr)
+		if test.errMatch == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %v", test.cidr, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.errMatch) {
+			t.Errorf("%q: expected error containing %q, but got %v", test.cidr, test.errMatch, err)
+		}
+	}
+}
+
+func TestValidatePlanDNSServiceIP(t *testing.T) {
+	tests := []struct {
+		dnsServiceIP string
+		valid        bool
+	}{
+		{
+			dnsServiceIP: "",
+			valid:        true,
+		},
+		{
+			dnsServiceIP: "172.20.0.10",
+			valid:        true,
+		},
+		{
+			dnsServiceIP: "not-an-ip",
+			valid:        false,
+		},
+		{
+			dnsServiceIP: "10.3.0.10",
+			valid:        false,
+		},
+		{
+			dnsServiceIP: "172.20.0.1",
+			valid:        false,
+		},
+	}
+	for _, test := range tests {
+		p := validPlan
+		p.Cluster.Networking.DNSServiceIP = test.dnsServiceIP
+		valid, _ := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("DNS service IP %q: expected valid = %v, but got %v", test.dnsServiceIP, test.valid, valid)
+		}
+	}
+}
+
+func TestValidatePlanWithOptionsWarnings(t *testing.T) {
+	res := ValidatePlanWithOptions(&validPlan, ValidationOptions{})
+	if !res.Valid() || len(res.Warnings) != 0 {
+		t.Fatalf("expected valid plan without warnings, but got errors %v and warnings %v", res.Errors, res.Warnings)
+	}
+
+	p := validPlan
+	p.Cluster.Networking.ServiceCIDRBlock = "172.20.0.0/28"
+	res = ValidatePlanWithOptions(&p, ValidationOptions{})
+	if !res.Valid() {
+		t.Errorf("expected warnings to not make the plan invalid, but got errors %v", res.Errors)
+	}
+	if len(res.Warnings) != 1 {
+		t.Errorf("expected 1 warning, but got %v", res.Warnings)
+	}
+
+	res = ValidatePlanWithOptions(&p, ValidationOptions{Strict: true})
+	if res.Valid() {
+		t.Errorf("expected warnings to be promoted to errors in strict mode")
+	}
+	if len(res.Warnings) != 0 {
+		t.Errorf("expected no warnings in strict mode, but got %v", res.Warnings)
+	}
+}
+
+func TestPlanWarnings(t *testing.T) {
+	p := validPlan
+	p.Etcd = NodeGroup{
+		ExpectedCount: 2,
+		Nodes: []Node{
+			{
+				Host: "etcd01",
+				IP:   "192.168.205.10",
+			},
+			{
+				Host:       "etcd02",
+				IP:         "192.168.205.13",
+				InternalIP: "192.168.205.13",
+			},
+		},
+	}
+	warns := p.warnings()
+	if len(warns) != 2 {
+		t.Errorf("expected 2 warnings, but got %v", warns)
+	}
+}
+
+func TestValidateWindowsNode(t *testing.T) {
+	tests := []struct {
+		node  Node
+		valid bool
+	}{
+		{
+			node:  Node{Host: "win01", IP: "10.0.0.1", Windows: true},
+			valid: true,
+		},
+		{
+			node:  Node{Host: "win01", IP: "10.0.0.1", Windows: true, NetBIOSName: "WIN01"},
+			valid: true,
+		},
+		{
+			node:  Node{Host: "node01", IP: "10.0.0.1", NetBIOSName: "NODE01"},
+			valid: false,
+		},
+		{
+			node:  Node{Host: "win01", IP: "10.0.0.1", Windows: true, NetBIOSName: "AVERYLONGNETBIOSNAME"},
+			valid: false,
+		},
+		{
+			node:  Node{Host: "win01", IP: "10.0.0.1", Windows: true, NetBIOSName: "WIN.01"},
+			valid: false,
+		},
+	}
+	for i, test := range tests {
+		valid, errs := ValidateNode(&test.node)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestValidateNodeVars(t *testing.T) {
+	tests := []struct {
+		vars  map[string]string
+		valid bool
+	}{
+		{
+			vars:  map[string]string{"ansible_python_interpreter": "/usr/bin/python3", "rack": "r1"},
+			valid: true,
+		},
+		{
+			vars:  map[string]string{"_private": "true"},
+			valid: true,
+		},
+		{
+			vars:  map[string]string{"1rack": "r1"},
+			valid: false,
+		},
+		{
+			vars:  map[string]string{"rack name": "r1"},
+			valid: false,
+		},
+		{
+			vars:  map[string]string{"ansible_host": "10.0.0.2"},
+			valid: false,
+		},
+		{
+			vars:  map[string]string{"internal_ipv4": "10.0.0.2"},
+			valid: false,
+		},
+	}
+	for i, test := range tests {
+		n := Node{Host: "node01", IP: "10.0.0.1", Vars: test.vars}
+		valid, errs := ValidateNode(&n)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestValidatePlanWindowsMasterNode(t *testing.T) {
+	p := validPlan
+	p.Master.Nodes = []Node{
+		{
+			Host:    "master01",
+			IP:      "192.168.205.11",
+			Windows: true,
+		},
+	}
+	assertInvalidPlan(t, p)
+}
+
+func TestValidatePlanEtcdPorts(t *testing.T) {
+	tests := []struct {
+		ports EtcdPorts
+		valid bool
+	}{
+		{
+			ports: EtcdPorts{},
+			valid: true,
+		},
+		{
+			ports: EtcdPorts{KubernetesClientPort: 12379, KubernetesPeerPort: 12380},
+			valid: true,
+		},
+		{
+			ports: EtcdPorts{KubernetesClientPort: 70000},
+			valid: false,
+		},
+		{
+			ports: EtcdPorts{KubernetesClientPort: -1},
+			valid: false,
+		},
+		{
+			ports: EtcdPorts{KubernetesPeerPort: 2379},
+			valid: false,
+		},
+		{
+			ports: EtcdPorts{NetworkingClientPort: 12379, KubernetesClientPort: 12379},
+			valid: false,
+		},
+		{
+			// etcd01 is also an ingress node
+			ports: EtcdPorts{NetworkingPeerPort: 443},
+			valid: false,
+		},
+	}
+	for i, test := range tests {
+		p := validPlan
+		ports := test.ports
+		p.EtcdPorts = &ports
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
 func TestValidatePlanEmptyPassword(t *testing.T) {
 	p := validPlan
 	p.Cluster.AdminPassword = ""
@@ -167,6 +423,66 @@ func TestValidatePlanInvalidCACertificatesExpiry(t *testing.T) {
 	assertInvalidPlan(t, p)
 }
 
+func TestValidatePlanCertExpiryLongerThanCAExpiry(t *testing.T) {
+	p := validPlan
+	p.Cluster.Certificates.Expiry = "17520h"
+	p.Cluster.Certificates.CAExpiry = "8760h"
+	assertInvalidPlan(t, p)
+}
+
+func TestValidatePlanNodeCertValidity(t *testing.T) {
+	tests := []struct {
+		validity string
+		caExpiry string
+		valid    bool
+	}{
+		{validity: "48h", caExpiry: "17520h", valid: true},
+		{validity: "48h", valid: true},
+		{validity: "foo", valid: false},
+		{validity: "-1h", valid: false},
+		{validity: "20000h", caExpiry: "17520h", valid: false},
+	}
+	for i, test := range tests {
+		p := validPlan
+		p.Cluster.Certificates.CAExpiry = test.caExpiry
+		n := p.Worker.Nodes[0]
+		n.CertValidity = test.validity
+		p.Worker.Nodes = []Node{n}
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestValidatePlanNodeCertValidityInMultipleGroups(t *testing.T) {
+	p := validPlan
+	n := p.Master.Nodes[0]
+	n.CertValidity = "48h"
+	p.Worker.Nodes = []Node{n}
+	assertInvalidPlan(t, p)
+}
+
+func TestValidatePlanSPIFFETrustDomain(t *testing.T) {
+	tests := []struct {
+		trustDomain string
+		valid       bool
+	}{
+		{trustDomain: "example.org", valid: true},
+		{trustDomain: "cluster_local", valid: true},
+		{trustDomain: "spiffe://example.org", valid: false},
+		{trustDomain: "example.org/path", valid: false},
+	}
+	for i, test := range tests {
+		p := validPlan
+		p.Cluster.Certificates.SPIFFETrustDomain = test.trustDomain
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
 func TestValidatePlanEmptySSHUser(t *testing.T) {
 	p := validPlan
 	p.Cluster.SSH.User = ""
@@ -1172,3 +1488,764 @@ func TestPackageManagerAddOn(t *testing.T) {
 		}
 	}
 }
+
+func TestValidatePlanCAKeyUsages(t *testing.T) {
+	p := validPlan
+	p.Cluster.Certificates.CAKeyUsages = []string{"crl sign"}
+	assertInvalidPlan(t, p)
+}
+
+func TestValidatePlanMetadata(t *testing.T) {
+	tests := []struct {
+		metadata Metadata
+		valid    bool
+	}{
+		{
+			metadata: Metadata{
+				Labels:      map[string]string{"team": "platform", "example.com/environment": "prod", "empty": ""},
+				Annotations: map[string]string{"example.com/managed-by": "kismatic, with spaces"},
+			},
+			valid: true,
+		},
+		{
+			metadata: Metadata{Labels: map[string]string{"-team": "platform"}},
+			valid:    false,
+		},
+		{
+			metadata: Metadata{Labels: map[string]string{"team": "not valid"}},
+			valid:    false,
+		},
+		{
+			metadata: Metadata{Labels: map[string]string{"Example.com/team": "platform"}},
+			valid:    false,
+		},
+		{
+			metadata: Metadata{Annotations: map[string]string{"/managed-by": "kismatic"}},
+			valid:    false,
+		},
+	}
+	for i, test := range tests {
+		p := newValidPlan()
+		m := test.metadata
+		p.Metadata = &m
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestValidatePlanCACSR(t *testing.T) {
+	tests := []struct {
+		csr   CACSR
+		valid bool
+	}{
+		{
+			csr:   CACSR{},
+			valid: true,
+		},
+		{
+			csr:   CACSR{KeyAlgorithm: "ecdsa", KeySize: 384},
+			valid: true,
+		},
+		{
+			csr:   CACSR{KeyAlgorithm: "rsa", KeySize: 1024},
+			valid: false,
+		},
+		{
+			csr:   CACSR{KeyAlgorithm: "dsa"},
+			valid: false,
+		},
+		{
+			csr:   CACSR{Hosts: []string{""}},
+			valid: false,
+		},
+		{
+			csr:   CACSR{Names: []CSRName{{Country: "US", State: "New York", Locality: "Troy", Organization: "Apprenda"}}},
+			valid: true,
+		},
+		{
+			csr:   CACSR{Names: []CSRName{{Country: "USA"}}},
+			valid: false,
+		},
+		{
+			csr:   CACSR{Names: []CSRName{{Country: "us"}}},
+			valid: false,
+		},
+		{
+			csr:   CACSR{Names: []CSRName{{Locality: "Troy\n"}}},
+			valid: false,
+		},
+	}
+	for i, test := range tests {
+		p := newValidPlan()
+		c := test.csr
+		p.Cluster.Certificates.CACSR = &c
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestValidatePlanBootstrapToken(t *testing.T) {
+	tests := []struct {
+		token BootstrapToken
+		valid bool
+	}{
+		{
+			token: BootstrapToken{},
+			valid: true,
+		},
+		{
+			token: BootstrapToken{TTL: "0s", Usages: []string{"signing"}},
+			valid: true,
+		},
+		{
+			token: BootstrapToken{TTL: "foo"},
+			valid: false,
+		},
+		{
+			token: BootstrapToken{TTL: "-1h"},
+			valid: false,
+		},
+		{
+			token: BootstrapToken{Usages: []string{"foo"}},
+			valid: false,
+		},
+	}
+	for i, test := range tests {
+		p := newValidPlan()
+		bt := test.token
+		p.Cluster.Certificates.BootstrapToken = &bt
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestValidatePlanCAConfigMap(t *testing.T) {
+	tests := []struct {
+		configMap CAConfigMap
+		valid     bool
+	}{
+		{
+			configMap: CAConfigMap{},
+			valid:     true,
+		},
+		{
+			configMap: CAConfigMap{Name: "cluster-ca.crt", Namespace: "default"},
+			valid:     true,
+		},
+		{
+			configMap: CAConfigMap{Name: "Cluster_CA"},
+			valid:     false,
+		},
+		{
+			configMap: CAConfigMap{Namespace: "kube.system"},
+			valid:     false,
+		},
+	}
+	for i, test := range tests {
+		p := newValidPlan()
+		cm := test.configMap
+		p.Cluster.Certificates.CAConfigMap = &cm
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestValidatePlanExtraTrustAnchors(t *testing.T) {
+	_, caCert, err := tls.NewCACert("test/ca-csr.json", "someCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating CA for test: %v", err)
+	}
+	tests := []struct {
+		anchors     []TrustAnchor
+		nodeBundles bool
+		valid       bool
+	}{
+		{
+			anchors: []TrustAnchor{{PEM: string(caCert)}},
+			valid:   true,
+		},
+		{
+			anchors:     []TrustAnchor{{PEM: string(caCert)}},
+			nodeBundles: true,
+			valid:       true,
+		},
+		{
+			anchors: []TrustAnchor{{PEM: string(caCert)}, {File: "does-not-exist.pem"}},
+			valid:   false,
+		},
+		{
+			nodeBundles: true,
+			valid:       false,
+		},
+	}
+	for i, test := range tests {
+		p := newValidPlan()
+		p.Cluster.Certificates.ExtraTrustAnchors = test.anchors
+		p.Cluster.Certificates.TrustAnchorsInNodeBundles = test.nodeBundles
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestValidatePlanKeyUsagesKeyAlgorithm(t *testing.T) {
+	tests := []struct {
+		signingProfile *SigningProfile
+		caKeyAlgorithm string
+		caKeyUsages    []string
+		valid          bool
+	}{
+		{
+			signingProfile: &SigningProfile{Usages: []string{"signing", "key encipherment", "server auth"}},
+			valid:          true,
+		},
+		{
+			signingProfile: &SigningProfile{Usages: []string{"signing", "key agreement", "server auth"}},
+			valid:          false,
+		},
+		{
+			caKeyAlgorithm: "ecdsa",
+			caKeyUsages:    []string{"cert sign", "crl sign"},
+			valid:          true,
+		},
+		{
+			caKeyAlgorithm: "ecdsa",
+			caKeyUsages:    []string{"cert sign", "crl sign", "key encipherment"},
+			valid:          false,
+		},
+		{
+			caKeyUsages: []string{"cert sign", "crl sign", "key encipherment"},
+			valid:       true,
+		},
+	}
+	for i, test := range tests {
+		p := newValidPlan()
+		p.Cluster.Certificates.SigningProfile = test.signingProfile
+		p.Cluster.Certificates.CAKeyAlgorithm = test.caKeyAlgorithm
+		p.Cluster.Certificates.CAKeyUsages = test.caKeyUsages
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestValidatePlanProvidedCA(t *testing.T) {
+	caDir, err := ioutil.TempDir("", "provided-ca-tests")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(caDir)
+	certFile, keyFile := writeProvidedCA(caDir, "someCA", t)
+	tests := []struct {
+		certFile string
+		keyFile  string
+		caCSR    *CACSR
+		valid    bool
+	}{
+		{certFile: certFile, keyFile: keyFile, valid: true},
+		{certFile: certFile, valid: false},
+		{keyFile: keyFile, valid: false},
+		{certFile: certFile, keyFile: keyFile, caCSR: &CACSR{}, valid: false},
+	}
+	for i, test := range tests {
+		p := newValidPlan()
+		p.Cluster.Certificates.CACertFile = test.certFile
+		p.Cluster.Certificates.CAKeyFile = test.keyFile
+		p.Cluster.Certificates.CACSR = test.caCSR
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestPlanWarningsMultiMasterWithoutLoadBalancer(t *testing.T) {
+	p := validPlan
+	p.Master = MasterNodeGroup{
+		ExpectedCount: 2,
+		Nodes: []Node{
+			{
+				Host: "master01",
+				IP:   "192.168.205.11",
+			},
+			{
+				Host: "master02",
+				IP:   "192.168.205.14",
+			},
+		},
+		LoadBalancedFQDN:      "master01",
+		LoadBalancedShortName: "master01",
+	}
+	if warns := p.warnings(); len(warns) != 1 {
+		t.Errorf("expected 1 warning, but got %v", warns)
+	}
+	res := ValidatePlanWithOptions(&p, ValidationOptions{Strict: true})
+	if res.Valid() {
+		t.Errorf("expected plan to be invalid in strict mode")
+	}
+
+	// single master clusters can use the node's address
+	p.Master.ExpectedCount = 1
+	p.Master.Nodes = p.Master.Nodes[:1]
+	if warns := p.warnings(); len(warns) != 0 {
+		t.Errorf("expected no warnings, but got %v", warns)
+	}
+}
+
+func TestValidateNodeSpecialUseIPs(t *testing.T) {
+	tests := []struct {
+		ip         string
+		internalIP string
+		valid      bool
+	}{
+		{ip: "10.0.0.1", internalIP: "192.168.0.1", valid: true},
+		{ip: "127.0.0.1", valid: false},
+		{ip: "10.0.0.1", internalIP: "127.0.1.1", valid: false},
+		{ip: "169.254.10.1", valid: false},
+		{ip: "224.0.0.1", valid: false},
+		{ip: "0.0.0.0", valid: false},
+		{ip: "::1", valid: false},
+		{ip: "fe80::1", valid: false},
+	}
+	for _, test := range tests {
+		n := Node{Host: "host1", IP: test.ip, InternalIP: test.internalIP}
+		ok, errs := n.validate()
+		if ok != test.valid {
+			t.Errorf("IP %q, InternalIP %q: expected valid = %v, but got %v", test.ip, test.internalIP, test.valid, ok)
+		}
+		if !ok && !strings.Contains(fmt.Sprint(errs), "host1") {
+			t.Errorf("expected the errors to include the host, but got %v", errs)
+		}
+	}
+}
+
+func TestValidateMasterNodeGroupAPIServerExtraIPs(t *testing.T) {
+	tests := []struct {
+		ips   []string
+		valid bool
+	}{
+		{ips: []string{"203.0.113.10", "2001:db8::1"}, valid: true},
+		{ips: []string{"foo"}, valid: false},
+		{ips: []string{"2001:db8::1", "2001:db8::0001"}, valid: false},
+	}
+	for _, test := range tests {
+		p := newValidPlan()
+		p.Master.APIServerExtraIPs = test.ips
+		if ok, errs := p.Master.validate(); ok != test.valid {
+			t.Errorf("expected valid = %v for %v, but got %v: %v", test.valid, test.ips, ok, errs)
+		}
+	}
+}
+
+func TestValidateCSRNameReportsField(t *testing.T) {
+	n := CSRName{Country: "US", State: "New\tYork"}
+	ok, errs := n.validate()
+	if ok {
+		t.Fatalf("expected the name to be invalid")
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "State") {
+		t.Errorf("expected a single error for the State field, but got %v", errs)
+	}
+}
+
+func TestValidateMasterNodeGroupAPIServerExtraNames(t *testing.T) {
+	tests := []struct {
+		names []string
+		valid bool
+	}{
+		{names: []string{"api.internal.example.com", "api.example.com"}, valid: true},
+		{names: []string{"API.example.com"}, valid: true},
+		{names: []string{"api_example.com"}, valid: false},
+		{names: []string{""}, valid: false},
+		{names: []string{"api.example.com", "API.EXAMPLE.COM"}, valid: false},
+	}
+	for _, test := range tests {
+		p := newValidPlan()
+		p.Master.APIServerExtraNames = test.names
+		if ok, errs := p.Master.validate(); ok != test.valid {
+			t.Errorf("expected valid = %v for %v, but got %v: %v", test.valid, test.names, ok, errs)
+		}
+	}
+}
+
+func TestValidatePlanCAKeyRequest(t *testing.T) {
+	tests := []struct {
+		algo  string
+		size  int
+		csr   *CACSR
+		valid bool
+	}{
+		{algo: "rsa", size: 4096, valid: true},
+		{algo: "ecdsa", valid: true},
+		{size: 3072, valid: true},
+		{algo: "ecdsa", size: 2048, valid: false},
+		{algo: "dsa", valid: false},
+		{algo: "rsa", size: 4096, csr: &CACSR{}, valid: true},
+		{algo: "rsa", size: 4096, csr: &CACSR{KeyAlgorithm: "ecdsa"}, valid: false},
+	}
+	for i, test := range tests {
+		p := newValidPlan()
+		p.Cluster.Certificates.CAKeyAlgorithm = test.algo
+		p.Cluster.Certificates.CAKeySize = test.size
+		p.Cluster.Certificates.CACSR = test.csr
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestValidatePlanCertificateKeyRequest(t *testing.T) {
+	tests := []struct {
+		algo           string
+		size           int
+		signingProfile *SigningProfile
+		valid          bool
+	}{
+		{algo: "ecdsa", valid: true},
+		{algo: "ecdsa", size: 384, valid: true},
+		{algo: "rsa", size: 4096, valid: true},
+		{size: 1024, valid: false},
+		{algo: "ecdsa", size: 2048, valid: false},
+		{algo: "dsa", valid: false},
+		{algo: "ecdsa", signingProfile: &SigningProfile{Usages: []string{"signing", "key encipherment", "server auth"}}, valid: false},
+		{algo: "ecdsa", signingProfile: &SigningProfile{Usages: []string{"signing", "server auth"}}, valid: true},
+	}
+	for i, test := range tests {
+		p := newValidPlan()
+		p.Cluster.Certificates.KeyAlgorithm = test.algo
+		p.Cluster.Certificates.KeySize = test.size
+		p.Cluster.Certificates.SigningProfile = test.signingProfile
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestValidatePlanNodeDomain(t *testing.T) {
+	tests := []struct {
+		shortNames bool
+		domain     string
+		valid      bool
+	}{
+		{shortNames: true, valid: true},
+		{shortNames: true, domain: "example.com", valid: true},
+		{shortNames: true, domain: "Example.COM", valid: true},
+		{shortNames: true, domain: "-example.com", valid: false},
+		{shortNames: false, domain: "example.com", valid: false},
+	}
+	for i, test := range tests {
+		p := newValidPlan()
+		p.Cluster.Certificates.NodeShortNameSANs = test.shortNames
+		p.Cluster.Certificates.NodeDomain = test.domain
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestValidatePlanSANAllowlist(t *testing.T) {
+	tests := []struct {
+		allowlist []string
+		valid     bool
+	}{
+		{allowlist: []string{"10.0.0.1", "10.0.0.0/8", "fd00::1", "example.com", "Master01"}, valid: true},
+		{allowlist: []string{"10.0.0.0/33"}, valid: false},
+		{allowlist: []string{"foo_bar"}, valid: false},
+		{allowlist: []string{""}, valid: false},
+	}
+	for i, test := range tests {
+		p := newValidPlan()
+		p.Cluster.Certificates.SANAllowlist = test.allowlist
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestValidatePlanNegativeMaxSANs(t *testing.T) {
+	p := validPlan
+	p.Cluster.Certificates.MaxSANs = -1
+	assertInvalidPlan(t, p)
+}
+
+func TestValidatePlanSigningProfile(t *testing.T) {
+	tests := []struct {
+		profile *SigningProfile
+		valid   bool
+	}{
+		{profile: &SigningProfile{Usages: []string{"signing", "server auth"}}, valid: true},
+		{profile: &SigningProfile{Usages: []string{"client auth"}, Expiry: "8760h"}, valid: true},
+		{profile: &SigningProfile{}, valid: false},
+		{profile: &SigningProfile{Usages: []string{"foo"}}, valid: false},
+		{profile: &SigningProfile{Usages: []string{"signing"}, Expiry: "foo"}, valid: false},
+		{profile: &SigningProfile{Usages: []string{"signing"}, Expiry: "100000h"}, valid: false},
+	}
+	for i, test := range tests {
+		p := newValidPlan()
+		p.Cluster.Certificates.CAExpiry = "17520h"
+		p.Cluster.Certificates.SigningProfile = test.profile
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestValidatePlanCertsNotAfter(t *testing.T) {
+	tests := []struct {
+		notAfter string
+		valid    bool
+	}{
+		{notAfter: "", valid: true},
+		{notAfter: time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339), valid: true},
+		{notAfter: time.Now().Add(-24 * time.Hour).UTC().Format(time.RFC3339), valid: false},
+		{notAfter: time.Now().Add(20000 * time.Hour).UTC().Format(time.RFC3339), valid: false},
+		{notAfter: "2030-01-01", valid: false},
+	}
+	for i, test := range tests {
+		p := newValidPlan()
+		p.Cluster.Certificates.CAExpiry = "17520h"
+		p.Cluster.Certificates.NotAfter = test.notAfter
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestValidatePlanClientCertificates(t *testing.T) {
+	tests := []struct {
+		certs []ClientCertificate
+		valid bool
+	}{
+		{
+			certs: []ClientCertificate{{Name: "ops", User: "alice", Groups: []string{"system:masters", "ops"}}},
+			valid: true,
+		},
+		{
+			certs: []ClientCertificate{{Name: "ops", User: "alice"}},
+			valid: false,
+		},
+		{
+			certs: []ClientCertificate{{Name: "ops", Groups: []string{"ops"}}},
+			valid: false,
+		},
+		{
+			certs: []ClientCertificate{{Name: "../ops", User: "alice", Groups: []string{"ops"}}},
+			valid: false,
+		},
+		{
+			certs: []ClientCertificate{{Name: "admin", User: "alice", Groups: []string{"ops"}}},
+			valid: false,
+		},
+		{
+			certs: []ClientCertificate{
+				{Name: "ops", User: "alice", Groups: []string{"ops"}},
+				{Name: "ops", User: "bob", Groups: []string{"ops"}},
+			},
+			valid: false,
+		},
+	}
+	for i, test := range tests {
+		p := newValidPlan()
+		p.Cluster.Certificates.ClientCertificates = test.certs
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestValidatePlanClusterName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{name: "test", valid: true},
+		{name: "", valid: false},
+		{name: "   ", valid: false},
+		{name: strings.Repeat("a", 64), valid: true},
+		{name: strings.Repeat("a", 65), valid: false},
+	}
+	for i, test := range tests {
+		p := newValidPlan()
+		p.Cluster.Name = test.name
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestValidatePlanWildcardHostname(t *testing.T) {
+	p := validPlan
+	p.Worker.Nodes = []Node{{Host: "*.example.com", IP: "192.168.205.12"}}
+	assertInvalidPlan(t, p)
+}
+
+func TestValidatePlanOverlappingHostnames(t *testing.T) {
+	tests := []struct {
+		masterHost string
+		workerHost string
+		shortNames bool
+		valid      bool
+	}{
+		{masterHost: "master01", workerHost: "worker01", valid: true},
+		{masterHost: "master01", workerHost: "worker01", shortNames: true, valid: true},
+		{masterHost: "master01", workerHost: "master01.example.com", valid: true},
+		{masterHost: "master01", workerHost: "master01.example.com", shortNames: true, valid: false},
+		{masterHost: "master01.example.com", workerHost: "*.example.com", valid: false},
+		{masterHost: "master01.sub.example.com", workerHost: "*.example.com", valid: false},
+	}
+	for i, test := range tests {
+		p := newValidPlan()
+		p.Cluster.Certificates.NodeShortNameSANs = test.shortNames
+		p.Master.Nodes = []Node{{Host: test.masterHost, IP: "192.168.205.11"}}
+		p.Worker.Nodes = []Node{{Host: test.workerHost, IP: "192.168.205.12"}}
+		valid, errs := ValidatePlan(&p)
+		if valid != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got %v: %v", i, test.valid, valid, errs)
+		}
+	}
+}
+
+func TestOverlappingNodeHostnamesReportsBothNodes(t *testing.T) {
+	p := validPlan
+	p.Master.Nodes = []Node{{Host: "master01.example.com", IP: "192.168.205.11"}}
+	p.Worker.Nodes = []Node{{Host: "*.example.com", IP: "192.168.205.12"}}
+	errs := overlappingNodeHostnames(&p)
+	if len(errs) != 1 {
+		t.Fatalf("expected one error, but got %v", errs)
+	}
+	if !strings.Contains(errs[0].Error(), "master01.example.com") || !strings.Contains(errs[0].Error(), "*.example.com") {
+		t.Errorf("expected the error to name both nodes, but got %v", errs[0])
+	}
+}
+
+func TestWildcardMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		matches bool
+	}{
+		{"*.example.com", "node.example.com", true},
+		{"*.example.com", "NODE.Example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "a.node.example.com", false},
+		{"node.example.com", "node.example.com", false},
+		{"*.example.com", "*.example.com", false},
+	}
+	for _, test := range tests {
+		if got := wildcardMatches(test.pattern, test.host); got != test.matches {
+			t.Errorf("wildcardMatches(%q, %q): expected %v, but got %v", test.pattern, test.host, test.matches, got)
+		}
+	}
+}
+
+func TestNodeAddressHints(t *testing.T) {
+	tests := []struct {
+		workers []Node
+		warns   int
+	}{
+		{
+			workers: []Node{{Host: "worker01", IP: "192.168.205.12", InternalIP: "10.0.0.12"}},
+		},
+		{
+			workers: []Node{{Host: "worker01", IP: "192.168.205.12", InternalIP: "192.168.205.12"}},
+		},
+		{
+			workers: []Node{{Host: "worker01", IP: "192.168.205.12", InternalIP: "192.168.205.13"}},
+			warns:   1,
+		},
+		{
+			workers: []Node{{Host: "worker01", IP: "192.168.205.12", InternalIP: "192.168.205.1"}},
+			warns:   1,
+		},
+		{
+			workers: []Node{{Host: "worker01", IP: "192.168.205.12", InternalIP: "192.168.205.11"}},
+			warns:   2,
+		},
+		{
+			workers: []Node{
+				{Host: "worker01", IP: "192.168.205.12", InternalIP: "10.0.0.12"},
+				{Host: "worker02", IP: "192.168.205.20", InternalIP: "10.0.0.12"},
+			},
+			warns: 1,
+		},
+	}
+	for i, test := range tests {
+		p := newValidPlan()
+		p.Worker.Nodes = test.workers
+		if warns := nodeAddressHints(&p); len(warns) != test.warns {
+			t.Errorf("test %d: expected %d warnings, but got %v", i, test.warns, warns)
+		}
+	}
+
+	p := newValidPlan()
+	p.Worker.Nodes = []Node{{Host: "worker01", IP: "192.168.205.12", InternalIP: "192.168.205.13"}}
+	before := len(p.warnings())
+	p.Cluster.Certificates.NodeAddressHints = true
+	warns := p.warnings()
+	if len(warns) != before+1 {
+		t.Fatalf("expected the hints to be added to the warnings, but got %v", warns)
+	}
+	msg := warns[len(warns)-1].Error()
+	for _, s := range []string{"worker01", "192.168.205.12", "192.168.205.13"} {
+		if !strings.Contains(msg, s) {
+			t.Errorf("expected the warning to include %q, but got %q", s, msg)
+		}
+	}
+}
+
+func TestSingleEditApart(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"10.0.0.1", "10.0.0.2", true},
+		{"10.0.0.1", "10.0.0.11", true},
+		{"10.0.0.11", "10.0.0.1", true},
+		{"10.0.0.1", "10.0.0.1", false},
+		{"10.0.0.1", "10.0.0.22", false},
+		{"10.0.0.1", "192.168.0.1", false},
+	}
+	for _, test := range tests {
+		if got := singleEditApart(test.a, test.b); got != test.expected {
+			t.Errorf("singleEditApart(%q, %q): expected %v, but got %v", test.a, test.b, test.expected, got)
+		}
+	}
+}
+
+func TestValidatePlanNoNodes(t *testing.T) {
+	p := validPlan
+	p.Etcd = NodeGroup{}
+	p.Master = MasterNodeGroup{LoadBalancedFQDN: p.Master.LoadBalancedFQDN, LoadBalancedShortName: p.Master.LoadBalancedShortName}
+	p.Worker = NodeGroup{}
+	valid, errs := ValidatePlan(&p)
+	if valid {
+		t.Fatalf("expected invalid, but got valid")
+	}
+	found := false
+	for _, err := range errs {
+		if strings.Contains(err.Error(), "does not contain any nodes") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected an error about the plan not containing any nodes, but got %v", errs)
+	}
+}
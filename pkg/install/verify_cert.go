@@ -0,0 +1,107 @@
+package install
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/apprenda/kismatic/pkg/tls"
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+// CertNotSignedByCAErr is returned when a certificate is not signed by the CA
+type CertNotSignedByCAErr struct {
+	Err error
+}
+
+func (e CertNotSignedByCAErr) Error() string {
+	return fmt.Sprintf("certificate is not signed by the CA: %v", e.Err)
+}
+
+// CertExpiredErr is returned when a certificate has expired
+type CertExpiredErr struct {
+	NotAfter time.Time
+}
+
+func (e CertExpiredErr) Error() string {
+	return fmt.Sprintf("certificate expired on %s", e.NotAfter.UTC().Format(time.RFC3339))
+}
+
+// CertNotYetValidErr is returned when the validity period of a certificate has not started
+type CertNotYetValidErr struct {
+	NotBefore time.Time
+}
+
+func (e CertNotYetValidErr) Error() string {
+	return fmt.Sprintf("certificate is not valid until %s", e.NotBefore.UTC().Format(time.RFC3339))
+}
+
+// CertMissingSANErr is returned when a certificate does not cover an address of the node
+type CertMissingSANErr struct {
+	SAN string
+}
+
+func (e CertMissingSANErr) Error() string {
+	return fmt.Sprintf("certificate does not include %q in its subject alternative names", e.SAN)
+}
+
+// VerifyNodeCert verifies that the PEM encoded certificate is signed by the CA,
+// is currently valid, and includes the host name and the IP addresses of the
+// node in its subject alternative names. The certificate may be followed by
+// the certificate of its issuer.
+func VerifyNodeCert(certPEM []byte, n *Node, ca *tls.CA) error {
+	certs, err := helpers.ParseCertificatesPEM(certPEM)
+	if err != nil {
+		return fmt.Errorf("error parsing certificate: %v", err)
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("no certificate found")
+	}
+	cert := certs[0]
+	caCert, err := helpers.ParseCertificatePEM(ca.Cert)
+	if err != nil {
+		return fmt.Errorf("error parsing CA certificate: %v", err)
+	}
+	if err := cert.CheckSignatureFrom(caCert); err != nil {
+		return CertNotSignedByCAErr{Err: err}
+	}
+	now := time.Now()
+	if now.After(cert.NotAfter) {
+		return CertExpiredErr{NotAfter: cert.NotAfter}
+	}
+	if now.Before(cert.NotBefore) {
+		return CertNotYetValidErr{NotBefore: cert.NotBefore}
+	}
+	if !containsFold(n.Host, cert.DNSNames) {
+		return CertMissingSANErr{SAN: n.Host}
+	}
+	for _, addr := range []string{n.IP, n.InternalIP} {
+		if addr == "" {
+			continue
+		}
+		if !certHasIP(cert.IPAddresses, net.ParseIP(addr)) {
+			return CertMissingSANErr{SAN: addr}
+		}
+	}
+	return nil
+}
+
+// returns true if the string is in the list, compared case-insensitively
+func containsFold(x string, xs []string) bool {
+	for _, s := range xs {
+		if strings.EqualFold(x, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func certHasIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package install
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apprenda/kismatic/pkg/tls"
+	"github.com/cloudflare/cfssl/csr"
+)
+
+func mustGenerateNodeCert(t *testing.T, ca *tls.CA, hosts []string, opts tls.CertOptions) []byte {
+	req := csr.CertificateRequest{
+		CN:         hosts[0],
+		Hosts:      hosts,
+		KeyRequest: &csr.BasicKeyRequest{A: "rsa", S: 2048},
+	}
+	_, cert, err := tls.NewCertWithOptions(ca, req, opts)
+	if err != nil {
+		t.Fatalf("error generating certificate for test: %v", err)
+	}
+	return cert
+}
+
+func TestVerifyNodeCert(t *testing.T) {
+	key, cert, err := tls.NewCACert("test/ca-csr.json", "someCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating CA for test: %v", err)
+	}
+	ca := &tls.CA{Key: key, Cert: cert}
+	otherKey, otherCert, err := tls.NewCACert("test/ca-csr.json", "someOtherCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating CA for test: %v", err)
+	}
+	otherCA := &tls.CA{Key: otherKey, Cert: otherCert}
+	node := &Node{Host: "worker01", IP: "10.0.0.10", InternalIP: "192.168.0.10"}
+	hosts := []string{"worker01", "10.0.0.10", "192.168.0.10"}
+
+	valid := mustGenerateNodeCert(t, ca, hosts, tls.CertOptions{Expiry: time.Hour})
+	if err := VerifyNodeCert(valid, node, ca); err != nil {
+		t.Errorf("expected the certificate to be valid, but got error: %v", err)
+	}
+
+	if _, ok := VerifyNodeCert(valid, node, otherCA).(CertNotSignedByCAErr); !ok {
+		t.Errorf("expected a CertNotSignedByCAErr when verifying against another CA")
+	}
+
+	expired := mustGenerateNodeCert(t, ca, hosts, tls.CertOptions{Expiry: time.Hour, NotBefore: time.Now().Add(-2 * time.Hour)})
+	if _, ok := VerifyNodeCert(expired, node, ca).(CertExpiredErr); !ok {
+		t.Errorf("expected a CertExpiredErr for an expired certificate")
+	}
+
+	notYetValid := mustGenerateNodeCert(t, ca, hosts, tls.CertOptions{Expiry: time.Hour, NotBefore: time.Now().Add(time.Hour)})
+	if _, ok := VerifyNodeCert(notYetValid, node, ca).(CertNotYetValidErr); !ok {
+		t.Errorf("expected a CertNotYetValidErr for a certificate that is not valid yet")
+	}
+
+	missingIP := mustGenerateNodeCert(t, ca, []string{"worker01", "10.0.0.10"}, tls.CertOptions{Expiry: time.Hour})
+	err = VerifyNodeCert(missingIP, node, ca)
+	if e, ok := err.(CertMissingSANErr); !ok || e.SAN != "192.168.0.10" {
+		t.Errorf("expected a CertMissingSANErr for the internal IP, but got %v", err)
+	}
+
+	missingHost := mustGenerateNodeCert(t, ca, []string{"worker02", "10.0.0.10", "192.168.0.10"}, tls.CertOptions{Expiry: time.Hour})
+	err = VerifyNodeCert(missingHost, node, ca)
+	if e, ok := err.(CertMissingSANErr); !ok || e.SAN != "worker01" {
+		t.Errorf("expected a CertMissingSANErr for the host name, but got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package install
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+// ManifestDiscrepanciesErr is returned when the files of the certificates
+// directory do not match the certificate manifest
+type ManifestDiscrepanciesErr struct {
+	Discrepancies []string
+}
+
+func (e ManifestDiscrepanciesErr) Error() string {
+	return fmt.Sprintf("found %d discrepancies with the certificate manifest: %s", len(e.Discrepancies), strings.Join(e.Discrepancies, "; "))
+}
+
+// VerifyManifest verifies that the files listed in the certificate manifest
+// of the directory were not altered or removed since they were generated.
+// The fingerprint of every certificate is compared to the one recorded in
+// the manifest, and every private key must match its certificate. Every
+// discrepancy is reported in a ManifestDiscrepanciesErr.
+func VerifyManifest(dir string) error {
+	lp := LocalPKI{GeneratedCertsDirectory: dir}
+	m, err := lp.readManifest()
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return fmt.Errorf("certificate manifest not found in %q", dir)
+	}
+	discrepancies := []string{}
+	for _, e := range m.Certificates {
+		discrepancies = append(discrepancies, verifyManifestEntry(dir, e)...)
+	}
+	if len(discrepancies) > 0 {
+		return ManifestDiscrepanciesErr{Discrepancies: discrepancies}
+	}
+	return nil
+}
+
+// returns the discrepancies between the entry of the manifest and its files
+func verifyManifestEntry(dir string, e CertificateManifestEntry) []string {
+	if e.Failed {
+		return []string{fmt.Sprintf("%s: the files of the certificate were not written", e.Name)}
+	}
+	discrepancies := []string{}
+	var cert *x509.Certificate
+	if e.CertFile != "" {
+		b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(e.CertFile)))
+		switch {
+		case os.IsNotExist(err):
+			discrepancies = append(discrepancies, fmt.Sprintf("%s: certificate file %s was removed", e.Name, e.CertFile))
+		case err != nil:
+			discrepancies = append(discrepancies, fmt.Sprintf("%s: error reading certificate file %s: %v", e.Name, e.CertFile, err))
+		default:
+			certs, err := helpers.ParseCertificatesPEM(b)
+			if err != nil || len(certs) == 0 {
+				discrepancies = append(discrepancies, fmt.Sprintf("%s: certificate file %s does not contain a valid certificate", e.Name, e.CertFile))
+				break
+			}
+			cert = certs[0]
+			if fp := certFingerprint(cert); e.SHA256 != "" && fp != e.SHA256 {
+				discrepancies = append(discrepancies, fmt.Sprintf("%s: certificate file %s was modified, its fingerprint is %s instead of %s", e.Name, e.CertFile, fp, e.SHA256))
+			}
+		}
+	}
+	if e.KeyFile != "" {
+		b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(e.KeyFile)))
+		switch {
+		case os.IsNotExist(err):
+			discrepancies = append(discrepancies, fmt.Sprintf("%s: private key file %s was removed", e.Name, e.KeyFile))
+		case err != nil:
+			discrepancies = append(discrepancies, fmt.Sprintf("%s: error reading private key file %s: %v", e.Name, e.KeyFile, err))
+		case cert != nil && !keyMatchesCert(b, cert):
+			discrepancies = append(discrepancies, fmt.Sprintf("%s: private key file %s does not match the certificate", e.Name, e.KeyFile))
+		}
+	}
+	for _, f := range e.Files {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f))); os.IsNotExist(err) {
+			discrepancies = append(discrepancies, fmt.Sprintf("%s: file %s was removed", e.Name, f))
+		} else if err != nil {
+			discrepancies = append(discrepancies, fmt.Sprintf("%s: error reading file %s: %v", e.Name, f, err))
+		}
+	}
+	return discrepancies
+}
+
+// returns the SHA-256 fingerprint of the certificate, in hex
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// returns true if the PEM encoded private key is the key of the certificate
+func keyMatchesCert(keyPEM []byte, cert *x509.Certificate) bool {
+	priv, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return false
+	}
+	certPub, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return false
+	}
+	keyPub, err := x509.MarshalPKIXPublicKey(priv.Public())
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(certPub, keyPub)
+}
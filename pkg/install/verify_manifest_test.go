@@ -0,0 +1,68 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyManifest(t *testing.T) {
+	pki := getPKI(t)
+	defer cleanup(pki.GeneratedCertsDirectory, t)
+	dir := pki.GeneratedCertsDirectory
+
+	if err := VerifyManifest(dir); err == nil {
+		t.Errorf("expected an error when the manifest does not exist")
+	}
+
+	p := getPlan()
+	ca, err := pki.GenerateClusterCA(p)
+	if err != nil {
+		t.Fatalf("error generating CA for test: %v", err)
+	}
+	if err = pki.GenerateClusterCertificates(p, ca); err != nil {
+		t.Fatalf("error generating cluster certificates: %v", err)
+	}
+	if err = VerifyManifest(dir); err != nil {
+		t.Errorf("expected the generated certificates to match the manifest, but got %v", err)
+	}
+	// The manifest is kept up to date when a certificate is regenerated
+	if err = pki.RegenerateCert(p, "admin"); err != nil {
+		t.Fatalf("error regenerating certificate: %v", err)
+	}
+	if err = VerifyManifest(dir); err != nil {
+		t.Errorf("expected the regenerated certificate to match the manifest, but got %v", err)
+	}
+
+	// Replace a certificate, and remove a private key
+	etcd, err := ioutil.ReadFile(filepath.Join(dir, "etcd01-etcd.pem"))
+	if err != nil {
+		t.Fatalf("error reading certificate: %v", err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "admin.pem"), etcd, 0644); err != nil {
+		t.Fatalf("error writing certificate: %v", err)
+	}
+	if err = os.Remove(filepath.Join(dir, "worker01-kubelet-key.pem")); err != nil {
+		t.Fatalf("error removing private key: %v", err)
+	}
+	err = VerifyManifest(dir)
+	discrepancies, ok := err.(ManifestDiscrepanciesErr)
+	if !ok {
+		t.Fatalf("expected a ManifestDiscrepanciesErr, but got %v", err)
+	}
+	expected := []string{
+		"admin: certificate file admin.pem was modified",
+		"admin: private key file admin-key.pem does not match the certificate",
+		"worker01-kubelet: private key file worker01-kubelet-key.pem was removed",
+	}
+	if len(discrepancies.Discrepancies) != len(expected) {
+		t.Fatalf("expected %d discrepancies, but got %v", len(expected), discrepancies.Discrepancies)
+	}
+	for i, d := range discrepancies.Discrepancies {
+		if !strings.HasPrefix(d, expected[i]) {
+			t.Errorf("expected discrepancy %q, but got %q", expected[i], d)
+		}
+	}
+}
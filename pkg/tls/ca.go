@@ -1,17 +1,29 @@
 package tls
 
 import (
+	"bytes"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"math/big"
 	"os"
-	"path/filepath"
+	"time"
 
+	"github.com/cloudflare/cfssl/cli/genkey"
+	"github.com/cloudflare/cfssl/config"
 	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/helpers"
 	"github.com/cloudflare/cfssl/initca"
 	"github.com/cloudflare/cfssl/log"
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/cloudflare/cfssl/signer/local"
 )
 
+// DefaultCAExpiry is the expiry of the CA certificates created without one,
+// which is the default of cfssl
+const DefaultCAExpiry = "43800h"
+
 func init() {
 	log.Level = log.LevelError
 }
@@ -25,45 +37,261 @@ type Subject struct {
 	OrganizationalUnit string
 }
 
+// CAOptions are the basic constraints and key usages of a Certificate Authority
+type CAOptions struct {
+	// Usages is the list of key usages of the CA, as defined by cfssl.
+	// Defaults to "cert sign" and "crl sign" if empty.
+	Usages []string
+	// Serial is the serial number of the CA certificate. A random serial
+	// number is used if nil.
+	Serial *big.Int
+}
+
+// ValidateCAOptions returns an error if the CA options describe a CA that
+// cannot be created, or that cannot be used to sign certificates.
+func ValidateCAOptions(opts CAOptions) error {
+	if len(opts.Usages) == 0 {
+		return nil
+	}
+	certSign := false
+	for _, u := range opts.Usages {
+		_, ku := config.KeyUsage[u]
+		_, eku := config.ExtKeyUsage[u]
+		if !ku && !eku {
+			return fmt.Errorf("CA key usage %q is invalid", u)
+		}
+		if u == "cert sign" {
+			certSign = true
+		}
+	}
+	if !certSign {
+		return fmt.Errorf("CA key usages must include %q, as the CA must be able to sign certificates", "cert sign")
+	}
+	return nil
+}
+
 // NewCACert creates a new Certificate Authority and returns it's private key and public certificate.
 func NewCACert(csrFile string, commonName string, expiry string) (key, cert []byte, err error) {
+	return NewCACertWithOptions(csrFile, commonName, expiry, CAOptions{})
+}
+
+// NewCACertWithOptions creates a new Certificate Authority with the given basic
+// constraints and key usages, and returns it's private key and public certificate.
+func NewCACertWithOptions(csrFile string, commonName string, expiry string, opts CAOptions) (key, cert []byte, err error) {
+	caCSR, err := ReadCSRFile(csrFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewCACertFromRequest(caCSR, commonName, expiry, opts)
+}
+
+// ReadCSRFile reads the certificate request defined in the cfssl CSR file
+func ReadCSRFile(csrFile string) (csr.CertificateRequest, error) {
 	// Open CSR file
 	f, err := os.Open(csrFile)
 	if os.IsNotExist(err) {
-		return nil, nil, fmt.Errorf("%q does not exist", csrFile)
+		return csr.CertificateRequest{}, fmt.Errorf("%q does not exist", csrFile)
 	}
 	if err != nil {
-		return nil, nil, fmt.Errorf("error opening %q", csrFile)
+		return csr.CertificateRequest{}, fmt.Errorf("error opening %q", csrFile)
 	}
+	defer f.Close()
 	// Create CSR struct
-	caCSR := &csr.CertificateRequest{
+	caCSR := csr.CertificateRequest{
 		KeyRequest: csr.NewBasicKeyRequest(),
 	}
-	err = json.NewDecoder(f).Decode(caCSR)
+	err = json.NewDecoder(f).Decode(&caCSR)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error decoding CSR: %v", err)
+		return csr.CertificateRequest{}, fmt.Errorf("error decoding CSR: %v", err)
+	}
+	return caCSR, nil
+}
+
+// NewCACertFromRequest creates a new Certificate Authority using the given
+// certificate request, and returns it's private key and public certificate.
+func NewCACertFromRequest(req csr.CertificateRequest, commonName string, expiry string, opts CAOptions) (key, cert []byte, err error) {
+	if err = ValidateCAOptions(opts); err != nil {
+		return nil, nil, err
+	}
+	caCSR := &req
+	if caCSR.KeyRequest == nil {
+		caCSR.KeyRequest = csr.NewBasicKeyRequest()
 	}
 	caCSR.CN = commonName
+	// The expiry is always set, as cfssl keeps the expiry of the previous CA
+	// it created when the request has none
+	if expiry == "" {
+		expiry = DefaultCAExpiry
+	}
 	caCSR.CA = &csr.CAConfig{Expiry: expiry}
-	// Generate CA Cert according to CSR
-	cert, _, key, err = initca.New(caCSR)
+	if len(opts.Usages) == 0 && opts.Serial == nil {
+		// Generate CA Cert according to CSR
+		cert, _, key, err = initca.New(caCSR)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating CA cert: %v", err)
+		}
+		return key, cert, nil
+	}
+	return newCACertWithPolicy(caCSR, opts)
+}
+
+// newCACertWithPolicy creates a self-signed CA certificate that has the basic
+// constraints and key usages defined in the options.
+func newCACertWithPolicy(req *csr.CertificateRequest, opts CAOptions) (key, cert []byte, err error) {
+	policy, err := caSigningPolicy(req.CA.Expiry, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	g := &csr.Generator{Validator: genkey.Validator}
+	csrPEM, key, err := g.ProcessRequest(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error processing CA CSR: %v", err)
+	}
+	priv, err := helpers.ParsePrivateKeyPEM(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing CA private key: %v", err)
+	}
+	s, err := local.NewSigner(priv, nil, signer.DefaultSigAlgo(priv), policy)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating CA signer: %v", err)
+	}
+	cert, err = s.Sign(signer.SignRequest{Hosts: req.Hosts, Request: string(csrPEM), Serial: opts.Serial})
 	if err != nil {
 		return nil, nil, fmt.Errorf("error creating CA cert: %v", err)
 	}
 	return key, cert, nil
 }
 
-// ReadCACert read CA file
-func ReadCACert(name, dir string) (key, cert []byte, err error) {
-	dest := filepath.Join(dir, keyName(name))
-	key, errKey := ioutil.ReadFile(dest)
-	if errKey != nil {
-		return nil, nil, fmt.Errorf("error reading private key: %v", errKey)
+// returns the signing policy used to issue CA certificates
+func caSigningPolicy(expiryStr string, opts CAOptions) (*config.Signing, error) {
+	if expiryStr == "" {
+		expiryStr = DefaultCAExpiry
+	}
+	expiry, err := time.ParseDuration(expiryStr)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a valid duration for CA expiry", expiryStr)
+	}
+	usages := opts.Usages
+	if len(usages) == 0 {
+		usages = []string{"cert sign", "crl sign"}
 	}
-	dest = filepath.Join(dir, certName(name))
-	cert, errCert := ioutil.ReadFile(dest)
-	if errCert != nil {
-		return nil, nil, fmt.Errorf("error reading certificate: %v", errKey)
+	policy := &config.Signing{
+		Default: &config.SigningProfile{
+			Usage:        usages,
+			Expiry:       expiry,
+			ExpiryString: expiryStr,
+			CA:           true,
+		},
+	}
+	if opts.Serial != nil {
+		policy.Default.ClientProvidesSerialNumbers = true
+	}
+	return policy, nil
+}
+
+// NewIntermediateCACert creates a new Certificate Authority that is signed by
+// the given root CA, and returns it's private key and public certificate.
+func NewIntermediateCACert(root *CA, req csr.CertificateRequest, expiry string, opts CAOptions) (key, cert []byte, err error) {
+	if err := ValidateCAOptions(opts); err != nil {
+		return nil, nil, err
+	}
+	policy, err := caSigningPolicy(expiry, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	rootPriv, err := root.signer()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting root CA signer: %v", err)
+	}
+	rootCert, err := helpers.ParseCertificatePEM(root.Cert)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing root CA cert: %v", err)
+	}
+	g := &csr.Generator{Validator: genkey.Validator}
+	csrPEM, key, err := g.ProcessRequest(&req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error processing intermediate CA CSR: %v", err)
+	}
+	s, err := local.NewSigner(rootPriv, rootCert, signer.DefaultSigAlgo(rootPriv), policy)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating root CA signer: %v", err)
+	}
+	cert, err = s.Sign(signer.SignRequest{Hosts: req.Hosts, Request: string(csrPEM), Serial: opts.Serial})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating intermediate CA cert: %v", err)
 	}
 	return key, cert, nil
 }
+
+// VerifyChain verifies that the first certificate of the PEM data chains to
+// one of the root certificates, through the intermediate certificates that
+// follow it. The certificates must be ordered from the leaf to the root, each
+// certificate being identified as the issuer of the previous one.
+func VerifyChain(chainPEM, rootsPEM []byte) error {
+	chain, err := helpers.ParseCertificatesPEM(chainPEM)
+	if err != nil {
+		return fmt.Errorf("error parsing certificate chain: %v", err)
+	}
+	if len(chain) == 0 {
+		return fmt.Errorf("no certificate found in the chain")
+	}
+	roots, err := helpers.ParseCertificatesPEM(rootsPEM)
+	if err != nil {
+		return fmt.Errorf("error parsing root certificates: %v", err)
+	}
+	if len(roots) == 0 {
+		return fmt.Errorf("no root certificate found")
+	}
+	for i := 0; i < len(chain)-1; i++ {
+		aki, ski := chain[i].AuthorityKeyId, chain[i+1].SubjectKeyId
+		if len(aki) > 0 && len(ski) > 0 && !bytes.Equal(aki, ski) {
+			return fmt.Errorf("certificate %q is not issued by the next certificate in the chain %q", chain[i].Subject.CommonName, chain[i+1].Subject.CommonName)
+		}
+	}
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, r := range roots {
+		opts.Roots.AddCert(r)
+	}
+	for _, c := range chain[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	if _, err := chain[0].Verify(opts); err != nil {
+		return fmt.Errorf("certificate %q does not chain to the root CA: %v", chain[0].Subject.CommonName, err)
+	}
+	return nil
+}
+
+// VerifyKeyPair returns an error if the public key of the CA certificate does
+// not correspond to the private key of the CA, or to its signer. Otherwise,
+// every certificate signed by the CA would fail verification.
+func (ca *CA) VerifyKeyPair() error {
+	cert, err := helpers.ParseCertificatePEM(ca.Cert)
+	if err != nil {
+		return fmt.Errorf("error parsing CA certificate: %v", err)
+	}
+	s, err := ca.signer()
+	if err != nil {
+		return err
+	}
+	certPub, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return fmt.Errorf("error encoding public key of the CA certificate: %v", err)
+	}
+	keyPub, err := x509.MarshalPKIXPublicKey(s.Public())
+	if err != nil {
+		return fmt.Errorf("error encoding public key of the CA private key: %v", err)
+	}
+	if !bytes.Equal(certPub, keyPub) {
+		return errors.New("the CA certificate does not correspond to the CA private key. Make sure both files belong to the same CA")
+	}
+	return nil
+}
+
+// ReadCACert read CA file
+func ReadCACert(name, dir string) (key, cert []byte, err error) {
+	return DefaultFileNameScheme.ReadCACert(name, dir)
+}
@@ -1,10 +1,12 @@
 package tls
 
 import (
+	"crypto/x509"
 	"reflect"
 	"testing"
 	"time"
 
+	"github.com/cloudflare/cfssl/csr"
 	"github.com/cloudflare/cfssl/helpers"
 )
 
@@ -46,3 +48,166 @@ func TestNewCACert(t *testing.T) {
 		t.Errorf("expected expiration date %q, got %q", expectedExpiration, parsedCert.NotAfter)
 	}
 }
+
+func TestNewCACertWithOptions(t *testing.T) {
+	opts := CAOptions{
+		Usages: []string{"cert sign"},
+	}
+	_, cert, err := NewCACertWithOptions("test/ca-csr.json", "someCommonName", "24h", opts)
+	if err != nil {
+		t.Fatalf("error creating CA cert: %v", err)
+	}
+	parsedCert, err := helpers.ParseCertificatePEM(cert)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	if !parsedCert.IsCA || !parsedCert.BasicConstraintsValid {
+		t.Errorf("Generated CA cert is not CA")
+	}
+	if parsedCert.KeyUsage != x509.KeyUsageCertSign {
+		t.Errorf("expected key usage to be cert sign only, but got %v", parsedCert.KeyUsage)
+	}
+}
+
+func TestValidateCAOptions(t *testing.T) {
+	tests := []struct {
+		opts  CAOptions
+		valid bool
+	}{
+		{
+			opts:  CAOptions{},
+			valid: true,
+		},
+		{
+			opts:  CAOptions{Usages: []string{"cert sign", "crl sign"}},
+			valid: true,
+		},
+		{
+			opts:  CAOptions{Usages: []string{"crl sign"}},
+			valid: false,
+		},
+		{
+			opts:  CAOptions{Usages: []string{"cert sign", "foo"}},
+			valid: false,
+		},
+	}
+	for i, test := range tests {
+		if err := ValidateCAOptions(test.opts); (err == nil) != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got error %v", i, test.valid, err)
+		}
+	}
+}
+
+func TestNewCACertFromRequest(t *testing.T) {
+	req := csr.CertificateRequest{
+		KeyRequest: &csr.BasicKeyRequest{A: "ecdsa", S: 256},
+		Names:      []csr.Name{{C: "US", O: "someOrg"}},
+	}
+	_, cert, err := NewCACertFromRequest(req, "someCommonName", "24h", CAOptions{})
+	if err != nil {
+		t.Fatalf("error creating CA cert: %v", err)
+	}
+	parsedCert, err := helpers.ParseCertificatePEM(cert)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	if parsedCert.Subject.CommonName != "someCommonName" {
+		t.Errorf("CN mismatch: expected %q, found %q", "someCommonName", parsedCert.Subject.CommonName)
+	}
+	if !reflect.DeepEqual(parsedCert.Subject.Organization, []string{"someOrg"}) {
+		t.Errorf("expected organization %q, but got %v", "someOrg", parsedCert.Subject.Organization)
+	}
+	if parsedCert.PublicKeyAlgorithm != x509.ECDSA {
+		t.Errorf("expected an ECDSA key, but got %v", parsedCert.PublicKeyAlgorithm)
+	}
+}
+
+func TestNewCACertFromRequestDefaultExpiry(t *testing.T) {
+	for _, opts := range []CAOptions{{}, {Usages: []string{"cert sign"}}} {
+		_, cert, err := NewCACertFromRequest(csr.CertificateRequest{}, "someCommonName", "", opts)
+		if err != nil {
+			t.Fatalf("error creating CA cert with options %+v: %v", opts, err)
+		}
+		parsedCert, err := helpers.ParseCertificatePEM(cert)
+		if err != nil {
+			t.Fatalf("error parsing certificate: %v", err)
+		}
+		expiry, _ := time.ParseDuration(DefaultCAExpiry)
+		expectedExpiration := time.Now().UTC().Add(expiry)
+		if expectedExpiration.Year() != parsedCert.NotAfter.Year() || expectedExpiration.YearDay() != parsedCert.NotAfter.YearDay() {
+			t.Errorf("expected expiration date %q with options %+v, got %q", expectedExpiration, opts, parsedCert.NotAfter)
+		}
+	}
+}
+
+func TestNewIntermediateCACertVerifyChain(t *testing.T) {
+	rootKey, rootCert, err := NewCACert("test/ca-csr.json", "someRootCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating root CA cert: %v", err)
+	}
+	root := &CA{Key: rootKey, Cert: rootCert}
+	req := csr.CertificateRequest{
+		CN:         "someIntermediateCA",
+		KeyRequest: &csr.BasicKeyRequest{A: "rsa", S: 2048},
+	}
+	intKey, intCert, err := NewIntermediateCACert(root, req, "24h", CAOptions{})
+	if err != nil {
+		t.Fatalf("error creating intermediate CA cert: %v", err)
+	}
+	parsedInt, err := helpers.ParseCertificatePEM(intCert)
+	if err != nil {
+		t.Fatalf("error parsing intermediate certificate: %v", err)
+	}
+	if !parsedInt.IsCA {
+		t.Errorf("intermediate cert is not CA")
+	}
+	if parsedInt.Issuer.CommonName != "someRootCA" {
+		t.Errorf("expected the intermediate to be issued by %q, but got %q", "someRootCA", parsedInt.Issuer.CommonName)
+	}
+
+	intermediate := &CA{Key: intKey, Cert: intCert}
+	leafReq := csr.CertificateRequest{
+		CN:         "someLeaf",
+		KeyRequest: &csr.BasicKeyRequest{A: "rsa", S: 2048},
+	}
+	_, leafCert, err := NewCert(intermediate, leafReq, time.Hour)
+	if err != nil {
+		t.Fatalf("error creating leaf cert: %v", err)
+	}
+
+	if err := VerifyChain(BundleCACert(leafCert, intCert), rootCert); err != nil {
+		t.Errorf("expected the chain to be valid, but got error: %v", err)
+	}
+	if err := VerifyChain(leafCert, rootCert); err == nil {
+		t.Errorf("expected an error when the intermediate is missing from the chain")
+	}
+	if err := VerifyChain(BundleCACert(intCert, leafCert), rootCert); err == nil {
+		t.Errorf("expected an error when the chain is not ordered from the leaf")
+	}
+	_, otherRoot, err := NewCACert("test/ca-csr.json", "someOtherRootCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating root CA cert: %v", err)
+	}
+	if err := VerifyChain(BundleCACert(leafCert, intCert), otherRoot); err == nil {
+		t.Errorf("expected an error when verifying against an unrelated root")
+	}
+}
+
+func TestCAVerifyKeyPair(t *testing.T) {
+	key, cert, err := NewCACert("test/ca-csr.json", "someCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating CA cert: %v", err)
+	}
+	otherKey, _, err := NewCACert("test/ca-csr.json", "someOtherCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating CA cert: %v", err)
+	}
+	ca := &CA{Key: key, Cert: cert}
+	if err := ca.VerifyKeyPair(); err != nil {
+		t.Errorf("expected the key pair to be valid, but got error: %v", err)
+	}
+	mismatched := &CA{Key: otherKey, Cert: cert}
+	if err := mismatched.VerifyKeyPair(); err == nil {
+		t.Errorf("expected an error when the key belongs to another CA")
+	}
+}
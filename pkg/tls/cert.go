@@ -1,15 +1,23 @@
 package tls
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
+	"io"
+	"math/big"
+	"net"
+	"reflect"
 	"sort"
 	"time"
 
-	"github.com/apprenda/kismatic/pkg/util"
 	"github.com/cloudflare/cfssl/cli/genkey"
 	"github.com/cloudflare/cfssl/config"
 	"github.com/cloudflare/cfssl/csr"
@@ -20,25 +28,180 @@ import (
 
 // CA contains information about the Certificate Authority
 type CA struct {
-	// Key is the CA's private key.
+	// Key is the CA's private key. Not required if a Signer is provided.
 	Key []byte
 	// Password is the CA's private key password. Can be empty if not password is set.
 	Password string
 	// Cert is the CA's public certificate.
 	Cert []byte
+	// ConfigFile is the path to a cfssl configuration file that contains the
+	// signing profile to use. The default signing configuration is used if empty.
+	ConfigFile string
+	// Profile is the name of the signing profile defined in the ConfigFile.
+	// The default profile of the ConfigFile is used if empty.
+	Profile string
+	// Signer signs certificates using the CA's private key. It can be backed
+	// by an HSM or a KMS, so that the private key is never held in memory.
+	// The CA's Key is used to sign certificates if nil.
+	Signer crypto.Signer
+}
+
+// returns the signer of the CA, parsing its private key if no signer was provided
+func (ca *CA) signer() (crypto.Signer, error) {
+	if ca.Signer != nil {
+		return ca.Signer, nil
+	}
+	priv, err := helpers.ParsePrivateKeyPEMWithPassword(ca.Key, []byte(ca.Password))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing privte key: %v", err)
+	}
+	return priv, nil
+}
+
+// CertOptions are the options used by the CA when signing a certificate
+type CertOptions struct {
+	// Expiry is the validity period of the certificate
+	Expiry time.Duration
+	// Usages is the list of key usages of the certificate, as defined by cfssl.
+	// E.g. "signing", "key encipherment", "client auth". The cfssl defaults are used if empty.
+	Usages []string
+	// Rand is the source of randomness used to generate the private key.
+	// The key is generated using crypto/rand if nil.
+	Rand io.Reader
+	// Serial is the serial number of the certificate. A random serial number
+	// is used if nil.
+	Serial *big.Int
+	// NotBefore is the start of the certificate's validity period. The current
+	// time is used if zero.
+	NotBefore time.Time
+	// NotAfter is the end of the certificate's validity period, which takes
+	// precedence over the Expiry. The Expiry is used if zero.
+	NotAfter time.Time
+	// Extensions are additional extensions added to the certificate
+	Extensions []pkix.Extension
+	// URIs are added to the subject alternative names of the certificate,
+	// along with the hosts of the certificate request. E.g. a SPIFFE ID.
+	URIs []string
+	// Key is the PEM encoded private key of the certificate, which is
+	// reused instead of generating a new one. The key request is ignored
+	// when set.
+	Key []byte
+}
+
+const (
+	// MinSerialBits is the minimum number of random bits of a serial number
+	MinSerialBits = 64
+	// MaxSerialBits is the maximum number of random bits of a serial number.
+	// Serial numbers are positive, and limited to 20 octets once encoded.
+	MaxSerialBits = 159
+)
+
+// RandomSerial returns a random, positive serial number that has up to the
+// given number of bits. Randomness is read from crypto/rand if r is nil.
+func RandomSerial(r io.Reader, bits int) (*big.Int, error) {
+	if bits < MinSerialBits || bits > MaxSerialBits {
+		return nil, fmt.Errorf("serial number size of %d bits is invalid, it must be between %d and %d bits", bits, MinSerialBits, MaxSerialBits)
+	}
+	if r == nil {
+		r = rand.Reader
+	}
+	max := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	for {
+		serial, err := rand.Int(r, max)
+		if err != nil {
+			return nil, fmt.Errorf("error generating serial number: %v", err)
+		}
+		if serial.Sign() > 0 {
+			return serial, nil
+		}
+	}
+}
+
+// ValidateUsages returns an error if any of the key usages is not defined by cfssl
+func ValidateUsages(usages []string) error {
+	for _, u := range usages {
+		_, ku := config.KeyUsage[u]
+		_, eku := config.ExtKeyUsage[u]
+		if !ku && !eku {
+			return fmt.Errorf("key usage %q is invalid", u)
+		}
+	}
+	return nil
+}
+
+// the key usages that can only be used with keys of a given algorithm. RSA
+// keys cannot be used for key agreement, and ECDSA keys cannot encrypt.
+var usageKeyAlgorithms = map[string]string{
+	"key encipherment":  "rsa",
+	"data encipherment": "rsa",
+	"key agreement":     "ecdsa",
+	"encipher only":     "ecdsa",
+	"decipher only":     "ecdsa",
+}
+
+// ValidateUsagesForKeyAlgorithm returns an error if any of the key usages
+// is not supported by keys of the given algorithm, either rsa or ecdsa.
+// An empty algorithm is rsa.
+func ValidateUsagesForKeyAlgorithm(usages []string, algo string) error {
+	if algo == "" {
+		algo = "rsa"
+	}
+	for _, u := range usages {
+		if a, ok := usageKeyAlgorithms[u]; ok && a != algo {
+			return fmt.Errorf("key usage %q is only supported by %s keys, not by %s keys", u, a, algo)
+		}
+	}
+	return nil
+}
+
+// UsagesForKeyAlgorithm returns the key usages that are supported by keys of
+// the given algorithm, dropping the others. The cfssl default usages are used
+// if empty.
+func UsagesForKeyAlgorithm(usages []string, algo string) []string {
+	if len(usages) == 0 {
+		usages = config.DefaultConfig().Usage
+	}
+	if algo == "" {
+		algo = "rsa"
+	}
+	supported := make([]string, 0, len(usages))
+	for _, u := range usages {
+		if a, ok := usageKeyAlgorithms[u]; !ok || a == algo {
+			supported = append(supported, u)
+		}
+	}
+	return supported
 }
 
 // NewCert creates a new certificate/key pair using the CertificateAuthority provided
 func NewCert(ca *CA, req csr.CertificateRequest, expiry time.Duration) (key, cert []byte, err error) {
-	g := &csr.Generator{Validator: genkey.Validator}
-	csrBytes, key, err := g.ProcessRequest(&req)
+	return NewCertWithOptions(ca, req, CertOptions{Expiry: expiry})
+}
+
+// NewCertWithOptions creates a new certificate/key pair using the CertificateAuthority
+// provided, and the given signing options
+func NewCertWithOptions(ca *CA, req csr.CertificateRequest, opts CertOptions) (key, cert []byte, err error) {
+	var csrBytes []byte
+	if opts.Key != nil {
+		priv, perr := helpers.ParsePrivateKeyPEM(opts.Key)
+		if perr != nil {
+			return nil, nil, fmt.Errorf("error parsing private key: %v", perr)
+		}
+		key = opts.Key
+		csrBytes, err = csr.Generate(priv, &req)
+	} else if opts.Rand == nil {
+		g := &csr.Generator{Validator: genkey.Validator}
+		csrBytes, key, err = g.ProcessRequest(&req)
+	} else {
+		csrBytes, key, err = processRequestWithRand(opts.Rand, &req)
+	}
 	if err != nil {
 		return nil, nil, fmt.Errorf("error processing CSR: %v", err)
 	}
-	// Get CA private key
-	caPriv, err := helpers.ParsePrivateKeyPEMWithPassword(ca.Key, []byte(ca.Password))
+	// Get CA signer
+	caPriv, err := ca.signer()
 	if err != nil {
-		return nil, nil, fmt.Errorf("error parsing privte key: %v", err)
+		return nil, nil, err
 	}
 	// Parse CA Cert
 	caCert, err := helpers.ParseCertificatePEM(ca.Cert)
@@ -50,8 +213,51 @@ func NewCert(ca *CA, req csr.CertificateRequest, expiry time.Duration) (key, cer
 	caConfig := &config.Signing{
 		Default: config.DefaultConfig(),
 	}
-	caConfig.Default.Expiry = expiry
-	caConfig.Default.ExpiryString = expiry.String()
+	if ca.ConfigFile != "" {
+		profile, err := LoadSigningProfile(ca.ConfigFile, ca.Profile)
+		if err != nil {
+			return nil, nil, err
+		}
+		caConfig.Default = profile
+	}
+	if opts.Expiry != 0 {
+		caConfig.Default.Expiry = opts.Expiry
+		caConfig.Default.ExpiryString = opts.Expiry.String()
+	}
+	if len(opts.Usages) > 0 {
+		caConfig.Default.Usage = opts.Usages
+	}
+	if !opts.NotBefore.IsZero() {
+		caConfig.Default.NotBefore = opts.NotBefore
+		caConfig.Default.NotAfter = opts.NotBefore.Add(caConfig.Default.Expiry)
+	}
+	if !opts.NotAfter.IsZero() {
+		caConfig.Default.NotAfter = opts.NotAfter
+	}
+	if opts.Serial != nil {
+		caConfig.Default.ClientProvidesSerialNumbers = true
+	}
+	extras := opts.Extensions
+	if len(opts.URIs) > 0 {
+		san, err := SubjectAltNameExtension(req.Hosts, opts.URIs)
+		if err != nil {
+			return nil, nil, err
+		}
+		extras = append(append([]pkix.Extension{}, opts.Extensions...), san)
+	}
+	extensions := make([]signer.Extension, 0, len(extras))
+	for _, e := range extras {
+		// cfssl only adds the extensions allowed by the signing profile
+		if caConfig.Default.ExtensionWhitelist == nil {
+			caConfig.Default.ExtensionWhitelist = map[string]bool{}
+		}
+		caConfig.Default.ExtensionWhitelist[e.Id.String()] = true
+		extensions = append(extensions, signer.Extension{
+			ID:       config.OID(e.Id),
+			Critical: e.Critical,
+			Value:    hex.EncodeToString(e.Value),
+		})
+	}
 	// Create signer using CA
 	s, err := local.NewSigner(caPriv, caCert, sigAlgo, caConfig)
 	if err != nil {
@@ -59,7 +265,9 @@ func NewCert(ca *CA, req csr.CertificateRequest, expiry time.Duration) (key, cer
 	}
 	// Generate cert using CA signer
 	signReq := signer.SignRequest{
-		Request: string(csrBytes),
+		Request:    string(csrBytes),
+		Serial:     opts.Serial,
+		Extensions: extensions,
 	}
 	cert, err = s.Sign(signReq)
 	if err != nil {
@@ -68,127 +276,392 @@ func NewCert(ca *CA, req csr.CertificateRequest, expiry time.Duration) (key, cer
 	return key, cert, nil
 }
 
-// WriteCert writes cert and key files
-func WriteCert(key, cert []byte, name, dir string) error {
-	// Create destination dir if it doesn't exist
-	err := util.CreateDir(dir, 0744)
+// selfSignedCertExpiry is the validity period of self-signed certificates
+const selfSignedCertExpiry = 8760 * time.Hour
+
+// GenerateSelfSigned creates a self-signed serving certificate for the given
+// hosts, which can be host names or IP addresses. The first host is used as
+// the common name. The certificate is its own issuer, and is valid for a year.
+// The certificate is created directly, as the cfssl signer only self-signs
+// CA certificates, which cannot have host names.
+func GenerateSelfSigned(hosts []string, subject csr.Name) (key, cert []byte, err error) {
+	if len(hosts) == 0 {
+		return nil, nil, fmt.Errorf("at least one host is required")
+	}
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
-		return err
+		return nil, nil, fmt.Errorf("error generating RSA key: %v", err)
 	}
-	// Write private key with read-only for user
-	err = ioutil.WriteFile(filepath.Join(dir, keyName(name)), key, 0600)
+	serial, err := RandomSerial(rand.Reader, MaxSerialBits)
 	if err != nil {
-		return fmt.Errorf("error writing private key: %v", err)
+		return nil, nil, err
+	}
+	name := pkix.Name{CommonName: hosts[0]}
+	if subject != (csr.Name{}) {
+		name.Country = nonEmpty(subject.C)
+		name.Province = nonEmpty(subject.ST)
+		name.Locality = nonEmpty(subject.L)
+		name.Organization = nonEmpty(subject.O)
+		name.OrganizationalUnit = nonEmpty(subject.OU)
+	}
+	notBefore := time.Now().Add(-5 * time.Minute).UTC()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               name,
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(selfSignedCertExpiry),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
 	}
-	// Write cert
-	err = ioutil.WriteFile(filepath.Join(dir, certName(name)), cert, 0644)
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
 	if err != nil {
-		return fmt.Errorf("error writing certificate: %v", err)
+		return nil, nil, fmt.Errorf("error creating self-signed certificate: %v", err)
 	}
-	return nil
+	key = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return key, cert, nil
 }
 
-// ReadCert reads the certificate with the given name in the provided directory.
-func ReadCert(name, dir string) (*x509.Certificate, error) {
-	certPath := filepath.Join(dir, certName(name))
-	certBytes, err := ioutil.ReadFile(certPath)
-	if err != nil {
-		return nil, err
+// returns the value as a list, or nil if it is empty
+func nonEmpty(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return []string{v}
+}
+
+// processRequestWithRand generates the private key of the request using the
+// given source of randomness, and returns the PEM encoded CSR and private key.
+func processRequestWithRand(rand io.Reader, req *csr.CertificateRequest) (csrBytes, key []byte, err error) {
+	if err = genkey.Validator(req); err != nil {
+		return nil, nil, err
+	}
+	algo, size := "rsa", 2048
+	if req.KeyRequest != nil {
+		algo, size = req.KeyRequest.Algo(), req.KeyRequest.Size()
 	}
-	cert, err := helpers.ParseCertificatePEM(certBytes)
+	var priv crypto.Signer
+	var block pem.Block
+	switch algo {
+	case "rsa":
+		rsaKey, err := rsa.GenerateKey(rand, size)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error generating RSA key: %v", err)
+		}
+		priv = rsaKey
+		block = pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}
+	case "ecdsa":
+		var curve elliptic.Curve
+		switch size {
+		case 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, nil, fmt.Errorf("invalid ECDSA key size %d", size)
+		}
+		ecKey, err := ecdsa.GenerateKey(curve, rand)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error generating ECDSA key: %v", err)
+		}
+		der, err := x509.MarshalECPrivateKey(ecKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error encoding ECDSA key: %v", err)
+		}
+		priv = ecKey
+		block = pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	default:
+		return nil, nil, fmt.Errorf("invalid key algorithm %q", algo)
+	}
+	csrBytes, err = csr.Generate(priv, req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return cert, nil
+	return csrBytes, pem.EncodeToMemory(&block), nil
 }
 
-// CertKeyPairExists returns true if a key and matching certificate exist.
-// Matching is defined as having the expected file names. No validation
-// is performed on the actual bytes of the cert/key
-func CertKeyPairExists(name, dir string) (bool, error) {
-	kn := keyName(name)
-	var err error
-	if _, err = os.Stat(filepath.Join(dir, kn)); os.IsNotExist(err) {
-		return false, nil
-	}
+// KeyMatches returns true if the PEM encoded private key uses the given
+// algorithm, either rsa or ecdsa, and has the given size
+func KeyMatches(keyPEM []byte, algo string, size int) (bool, error) {
+	priv, err := helpers.ParsePrivateKeyPEM(keyPEM)
 	if err != nil {
-		return false, err
+		return false, fmt.Errorf("error parsing private key: %v", err)
 	}
-	cn := certName(name)
-	if _, err = os.Stat(filepath.Join(dir, cn)); os.IsNotExist(err) {
+	switch pub := priv.Public().(type) {
+	case *rsa.PublicKey:
+		return algo == "rsa" && pub.N.BitLen() == size, nil
+	case *ecdsa.PublicKey:
+		return algo == "ecdsa" && pub.Curve.Params().BitSize == size, nil
+	default:
 		return false, nil
 	}
+}
+
+// LoadSigningProfile reads the cfssl configuration file and returns the signing
+// profile with the given name, or the default profile if the name is empty.
+// Returns an error listing the available profiles if the profile is not defined,
+// or if the profile's usages or expiry are not valid.
+func LoadSigningProfile(configFile, name string) (*config.SigningProfile, error) {
+	cfg, err := config.LoadFile(configFile)
 	if err != nil {
-		return false, err
+		return nil, fmt.Errorf("error reading signing configuration %q: %v", configFile, err)
+	}
+	if cfg.Signing == nil {
+		return nil, fmt.Errorf("signing configuration %q does not contain a signing section", configFile)
+	}
+	profile := cfg.Signing.Default
+	if name != "" {
+		var ok bool
+		if profile, ok = cfg.Signing.Profiles[name]; !ok {
+			available := []string{}
+			for p := range cfg.Signing.Profiles {
+				available = append(available, p)
+			}
+			sort.Strings(available)
+			return nil, fmt.Errorf("signing profile %q was not found in %q. Available profiles are: %v", name, configFile, available)
+		}
 	}
-	return true, nil
+	if profile == nil {
+		return nil, fmt.Errorf("signing configuration %q does not define a default signing profile", configFile)
+	}
+	if name == "" {
+		name = "default"
+	}
+	if len(profile.Usage) == 0 {
+		return nil, fmt.Errorf("signing profile %q in %q does not define any usages", name, configFile)
+	}
+	for _, u := range profile.Usage {
+		_, ku := config.KeyUsage[u]
+		_, eku := config.ExtKeyUsage[u]
+		if !ku && !eku {
+			return nil, fmt.Errorf("signing profile %q in %q contains the invalid usage %q", name, configFile, u)
+		}
+	}
+	if profile.Expiry <= 0 {
+		return nil, fmt.Errorf("signing profile %q in %q must have an expiry greater than zero", name, configFile)
+	}
+	return profile, nil
 }
 
-// CertValid returns a list of validation warnings if the certificate values do not match
-// the expected values.
-// Validation rules:
-// - common name: must match exactly
-// - subject alternate names: the expected SANs must be a subset of the cert's SANs
-// - organizations: the expected organizations must be a subset of the cert's organizations
-// Subset validation is performed to allow operator to supply their own SANs and organizations
-// Returns an error if trying to validate a cert that does not exist, or there
-// is an issue reading or parsing the certificate
-func CertValid(commonName string, SANs []string, organizations []string, name, dir string) (warn []error, err error) {
-	// check if cert exists
-	cn := certName(name)
-	if _, err = os.Stat(filepath.Join(dir, cn)); os.IsNotExist(err) {
-		return nil, fmt.Errorf("certificate %s does not exist", cn)
-	} else if err != nil {
-		return nil, fmt.Errorf("unexpected error looking for certificate %s", cn)
+// WriteCert writes cert and key files. The key file is not written if the key is nil.
+func WriteCert(key, cert []byte, name, dir string) error {
+	return DefaultFileNameScheme.WriteCert(key, cert, name, dir)
+}
+
+// BundleCACert returns the certificate followed by the CA certificate.
+func BundleCACert(cert, caCert []byte) []byte {
+	bundle := make([]byte, 0, len(cert)+len(caCert)+1)
+	bundle = append(bundle, cert...)
+	if len(bundle) > 0 && bundle[len(bundle)-1] != '\n' {
+		bundle = append(bundle, '\n')
 	}
+	return append(bundle, caCert...)
+}
 
-	// read the certificate file
-	certBytes, err := ioutil.ReadFile(filepath.Join(dir, cn))
+// parseLeafCertificatePEM parses the first certificate of the PEM data,
+// which may be followed by the certificate of its issuer.
+func parseLeafCertificatePEM(certPEM []byte) (*x509.Certificate, error) {
+	certs, err := helpers.ParseCertificatesPEM(certPEM)
 	if err != nil {
-		return nil, fmt.Errorf("error reding cert %s: %v", name, err)
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found")
 	}
+	return certs[0], nil
+}
 
-	// verify certificate
-	cert, err := helpers.ParseCertificatePEM(certBytes)
+// CSRFromCert returns a certificate request with the subject, SANs and key
+// parameters of the given certificate, so that an equivalent certificate can be
+// issued without the request the certificate was created from. Key usages are
+// not part of the request, use UsagesFromCert to get them.
+func CSRFromCert(certPEM []byte) (csr.CertificateRequest, error) {
+	cert, err := parseLeafCertificatePEM(certPEM)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing cert %s: %v", name, err)
+		return csr.CertificateRequest{}, fmt.Errorf("error parsing certificate: %v", err)
+	}
+	req := csr.CertificateRequest{
+		CN:    cert.Subject.CommonName,
+		Names: subjectNames(cert),
+	}
+	for _, h := range cert.DNSNames {
+		req.Hosts = append(req.Hosts, h)
+	}
+	for _, ip := range cert.IPAddresses {
+		req.Hosts = append(req.Hosts, ip.String())
+	}
+	for _, e := range cert.EmailAddresses {
+		req.Hosts = append(req.Hosts, e)
 	}
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		req.KeyRequest = &csr.BasicKeyRequest{A: "rsa", S: pub.N.BitLen()}
+	case *ecdsa.PublicKey:
+		req.KeyRequest = &csr.BasicKeyRequest{A: "ecdsa", S: pub.Curve.Params().BitSize}
+	default:
+		return csr.CertificateRequest{}, fmt.Errorf("unsupported public key type %T", cert.PublicKey)
+	}
+	return req, nil
+}
 
-	if cert.Subject.CommonName != commonName {
-		warn = append(warn, fmt.Errorf("Certificate %q: CN validation failed\n    expected %q, instead got %q", cn, commonName, cert.Subject.CommonName))
+// returns the names of the certificate's subject. cfssl builds the subject by
+// appending the fields of each name in order, so multi-valued attributes, such
+// as multiple organizations, are spread across as many names as needed.
+func subjectNames(cert *x509.Certificate) []csr.Name {
+	s := cert.Subject
+	n := 0
+	for _, values := range [][]string{s.Country, s.Province, s.Locality, s.Organization, s.OrganizationalUnit} {
+		if len(values) > n {
+			n = len(values)
+		}
+	}
+	at := func(values []string, i int) string {
+		if i < len(values) {
+			return values[i]
+		}
+		return ""
+	}
+	names := make([]csr.Name, 0, n)
+	for i := 0; i < n; i++ {
+		names = append(names, csr.Name{
+			C:  at(s.Country, i),
+			ST: at(s.Province, i),
+			L:  at(s.Locality, i),
+			O:  at(s.Organization, i),
+			OU: at(s.OrganizationalUnit, i),
+		})
 	}
+	return names
+}
 
-	var certSANs []string
-	for _, ip := range cert.IPAddresses {
-		certSANs = append(certSANs, ip.String())
+// UsagesFromCert returns the key usages of the certificate, as defined by
+// cfssl, sorted by name. When cfssl defines more than one name for a usage,
+// the first name in alphabetical order is returned.
+func UsagesFromCert(cert *x509.Certificate) []string {
+	ku := map[x509.KeyUsage]string{}
+	for name, u := range config.KeyUsage {
+		if cert.KeyUsage&u == 0 {
+			continue
+		}
+		if cur, ok := ku[u]; !ok || name < cur {
+			ku[u] = name
+		}
+	}
+	eku := map[x509.ExtKeyUsage]string{}
+	for name, u := range config.ExtKeyUsage {
+		if !hasExtKeyUsage(cert, u) {
+			continue
+		}
+		if cur, ok := eku[u]; !ok || name < cur {
+			eku[u] = name
+		}
+	}
+	usages := []string{}
+	for _, name := range ku {
+		usages = append(usages, name)
 	}
-	// DNS can be any string value
-	certSANs = append(certSANs, cert.DNSNames...)
+	for _, name := range eku {
+		usages = append(usages, name)
+	}
+	sort.Strings(usages)
+	return usages
+}
 
-	// check if the SANs in the certificate contain the requested SANs
-	// allows for operators to add their own custom SANs in the cert
-	subset := util.Subset(SANs, certSANs)
-	if !subset {
-		// sort for readability
-		sort.Strings(SANs)
-		sort.Strings(certSANs)
-		warn = append(warn, fmt.Errorf("Certificate %q: SANs validation failed\n    expected: \n\t%v \n    instead got: \n\t%v", cn, SANs, certSANs))
+// CertDifferences compares a certificate with one issued to replace it, such
+// as by another CA during a CA migration, and returns their differences. The
+// certificates are equivalent if there are none. The subject, the SANs, the
+// key usages and the key parameters are compared, while the issuer, the
+// validity period, the serial number and the key itself are ignored.
+func CertDifferences(oldPEM, newPEM []byte) ([]string, error) {
+	oldReq, err := CSRFromCert(oldPEM)
+	if err != nil {
+		return nil, fmt.Errorf("error reading old certificate: %v", err)
+	}
+	newReq, err := CSRFromCert(newPEM)
+	if err != nil {
+		return nil, fmt.Errorf("error reading new certificate: %v", err)
+	}
+	oldCert, err := parseLeafCertificatePEM(oldPEM)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing old certificate: %v", err)
+	}
+	newCert, err := parseLeafCertificatePEM(newPEM)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing new certificate: %v", err)
 	}
+	diffs := []string{}
+	compare := func(field string, o, n []string) {
+		o, n = sortedCopy(o), sortedCopy(n)
+		if !reflect.DeepEqual(o, n) {
+			diffs = append(diffs, fmt.Sprintf("%s: %v in the old certificate, %v in the new certificate", field, o, n))
+		}
+	}
+	compare("common name", []string{oldReq.CN}, []string{newReq.CN})
+	compare("country", oldCert.Subject.Country, newCert.Subject.Country)
+	compare("province", oldCert.Subject.Province, newCert.Subject.Province)
+	compare("locality", oldCert.Subject.Locality, newCert.Subject.Locality)
+	compare("organization", oldCert.Subject.Organization, newCert.Subject.Organization)
+	compare("organizational unit", oldCert.Subject.OrganizationalUnit, newCert.Subject.OrganizationalUnit)
+	compare("subject alternate names", oldReq.Hosts, newReq.Hosts)
+	compare("key usages", UsagesFromCert(oldCert), UsagesFromCert(newCert))
+	oldKey := fmt.Sprintf("%s-%d", oldReq.KeyRequest.Algo(), oldReq.KeyRequest.Size())
+	newKey := fmt.Sprintf("%s-%d", newReq.KeyRequest.Algo(), newReq.KeyRequest.Size())
+	compare("key", []string{oldKey}, []string{newKey})
+	return diffs, nil
+}
+
+// returns a sorted copy of the strings, which is never nil
+func sortedCopy(xs []string) []string {
+	sorted := make([]string, len(xs))
+	copy(sorted, xs)
+	sort.Strings(sorted)
+	return sorted
+}
 
-	// Validate organizations
-	subset = util.Subset(organizations, cert.Subject.Organization)
-	if !subset {
-		sort.Strings(organizations)
-		sort.Strings(cert.Subject.Organization)
-		warn = append(warn,
-			fmt.Errorf("Certificate %q: Organizations validation failed\n    expected: \n\t%v \n    instead got: \n\t%v",
-				cn, organizations, cert.Subject.Organization),
-		)
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage {
+			return true
+		}
 	}
+	return false
+}
+
+// ReadCert reads the certificate with the given name in the provided directory.
+func ReadCert(name, dir string) (*x509.Certificate, error) {
+	return DefaultFileNameScheme.ReadCert(name, dir)
+}
 
-	return warn, nil
+// CertKeyPairExists returns true if a key and matching certificate exist.
+// Matching is defined as having the expected file names. No validation
+// is performed on the actual bytes of the cert/key
+func CertKeyPairExists(name, dir string) (bool, error) {
+	return DefaultFileNameScheme.CertKeyPairExists(name, dir)
+}
+
+// CertValid returns a list of validation warnings if the certificate values do not match
+// the expected values.
+// Validation rules:
+// - common name: must match exactly
+// - subject alternate names: the expected SANs must be a subset of the cert's SANs
+// - organizations: the expected organizations must be a subset of the cert's organizations
+// Subset validation is performed to allow operator to supply their own SANs and organizations
+// Returns an error if trying to validate a cert that does not exist, or there
+// is an issue reading or parsing the certificate
+func CertValid(commonName string, SANs []string, organizations []string, name, dir string) (warn []error, err error) {
+	return DefaultFileNameScheme.CertValid(commonName, SANs, organizations, name, dir)
 }
 
-func keyName(s string) string { return fmt.Sprintf("%s-key.pem", s) }
+func keyName(s string) string { return DefaultFileNameScheme.KeyFile(s) }
 
-func certName(s string) string { return fmt.Sprintf("%s.pem", s) }
+func certName(s string) string { return DefaultFileNameScheme.CertFile(s) }
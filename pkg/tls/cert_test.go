@@ -2,12 +2,19 @@ package tls
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"io"
 	"io/ioutil"
+	"math/big"
 	"net"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -350,3 +357,603 @@ func cleanup(dir string, t *testing.T) {
 		t.Fatalf("failed cleaning up temp directory: %v", err)
 	}
 }
+
+func TestNewCertWithOptionsUsages(t *testing.T) {
+	key, caCert, err := NewCACert("test/ca-csr.json", "someCN", "12345h")
+	if err != nil {
+		t.Fatalf("error creating CA: %v", err)
+	}
+	ca := &CA{
+		Key:  key,
+		Cert: caCert,
+	}
+	opts := CertOptions{
+		Expiry: time.Hour,
+		Usages: []string{"signing", "key encipherment", "client auth"},
+	}
+	_, cert, err := NewCertWithOptions(ca, *buildReq("client", nil, nil), opts)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	parsedCert, err := helpers.ParseCertificatePEM(cert)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	expected := []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	if !reflect.DeepEqual(parsedCert.ExtKeyUsage, expected) {
+		t.Errorf("expected extended key usages %v, but got %v", expected, parsedCert.ExtKeyUsage)
+	}
+}
+
+func TestNewCertWithOptionsURIs(t *testing.T) {
+	key, caCert, err := NewCACert("test/ca-csr.json", "someCN", "12345h")
+	if err != nil {
+		t.Fatalf("error creating CA: %v", err)
+	}
+	ca := &CA{
+		Key:  key,
+		Cert: caCert,
+	}
+	opts := CertOptions{
+		Expiry: time.Hour,
+		URIs:   []string{"spiffe://cluster.local/node/node01"},
+	}
+	_, cert, err := NewCertWithOptions(ca, *buildReq("node01", []string{"node01", "10.0.0.1"}, nil), opts)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	parsedCert, err := helpers.ParseCertificatePEM(cert)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	uris, err := CertURIs(parsedCert)
+	if err != nil {
+		t.Fatalf("error reading URIs: %v", err)
+	}
+	if !reflect.DeepEqual(uris, opts.URIs) {
+		t.Errorf("expected URI SANs %v, but got %v", opts.URIs, uris)
+	}
+	if !reflect.DeepEqual(parsedCert.DNSNames, []string{"node01"}) {
+		t.Errorf("expected the DNS names of the request to be kept, but got %v", parsedCert.DNSNames)
+	}
+	if len(parsedCert.IPAddresses) != 1 || !parsedCert.IPAddresses[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("expected the IP addresses of the request to be kept, but got %v", parsedCert.IPAddresses)
+	}
+
+	opts.URIs = []string{"not a uri"}
+	if _, _, err := NewCertWithOptions(ca, *buildReq("node01", nil, nil), opts); err == nil {
+		t.Errorf("expected an error when the URI is invalid")
+	}
+}
+
+func TestLoadSigningProfile(t *testing.T) {
+	tests := []struct {
+		profile string
+		valid   bool
+	}{
+		{
+			profile: "kubernetes",
+			valid:   true,
+		},
+		{
+			profile: "",
+			valid:   false, // the default profile does not define usages
+		},
+		{
+			profile: "doesnotexist",
+			valid:   false,
+		},
+	}
+	for _, test := range tests {
+		_, err := LoadSigningProfile("test/ca-config.json", test.profile)
+		if (err == nil) != test.valid {
+			t.Errorf("profile %q: expected valid = %v, but got error %v", test.profile, test.valid, err)
+		}
+	}
+	if _, err := LoadSigningProfile("test/doesnotexist.json", "kubernetes"); err == nil {
+		t.Errorf("expected an error when the configuration file does not exist")
+	}
+}
+
+func TestValidateUsagesForKeyAlgorithm(t *testing.T) {
+	tests := []struct {
+		usages []string
+		algo   string
+		valid  bool
+	}{
+		{usages: []string{"signing", "key encipherment", "server auth"}, algo: "rsa", valid: true},
+		{usages: []string{"signing", "key encipherment", "server auth"}, algo: "", valid: true},
+		{usages: []string{"signing", "key encipherment", "server auth"}, algo: "ecdsa", valid: false},
+		{usages: []string{"signing", "key agreement", "client auth"}, algo: "ecdsa", valid: true},
+		{usages: []string{"signing", "key agreement", "client auth"}, algo: "rsa", valid: false},
+		{usages: []string{"digital signature", "server auth"}, algo: "ecdsa", valid: true},
+	}
+	for i, test := range tests {
+		if err := ValidateUsagesForKeyAlgorithm(test.usages, test.algo); (err == nil) != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got error %v", i, test.valid, err)
+		}
+	}
+}
+
+func TestUsagesForKeyAlgorithm(t *testing.T) {
+	tests := []struct {
+		usages   []string
+		algo     string
+		expected []string
+	}{
+		{usages: []string{"signing", "key encipherment", "server auth"}, algo: "rsa", expected: []string{"signing", "key encipherment", "server auth"}},
+		{usages: []string{"signing", "key encipherment", "server auth"}, algo: "ecdsa", expected: []string{"signing", "server auth"}},
+		{usages: []string{"signing", "key agreement", "client auth"}, algo: "", expected: []string{"signing", "client auth"}},
+		{algo: "ecdsa", expected: []string{"signing", "server auth", "client auth"}},
+	}
+	for i, test := range tests {
+		if got := UsagesForKeyAlgorithm(test.usages, test.algo); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("test %d: expected usages %v, but got %v", i, test.expected, got)
+		}
+	}
+}
+
+func TestLoadSigningProfileMissingProfileListsAvailable(t *testing.T) {
+	_, err := LoadSigningProfile("test/ca-config.json", "doesnotexist")
+	if err == nil {
+		t.Fatalf("expected an error when the profile does not exist")
+	}
+	if !strings.Contains(err.Error(), "[kubernetes]") {
+		t.Errorf("expected the error to list the available profiles, but got %q", err)
+	}
+}
+
+func TestBundleCACert(t *testing.T) {
+	tests := []struct {
+		cert     string
+		ca       string
+		expected string
+	}{
+		{
+			cert:     "cert\n",
+			ca:       "ca\n",
+			expected: "cert\nca\n",
+		},
+		{
+			cert:     "cert",
+			ca:       "ca\n",
+			expected: "cert\nca\n",
+		},
+	}
+	for _, test := range tests {
+		if b := string(BundleCACert([]byte(test.cert), []byte(test.ca))); b != test.expected {
+			t.Errorf("expected %q, but got %q", test.expected, b)
+		}
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("no entropy")
+}
+
+func TestNewCertWithOptionsSerialAndNotBefore(t *testing.T) {
+	key, caCert, err := NewCACert("test/ca-csr.json", "someCN", "12345h")
+	if err != nil {
+		t.Fatalf("error creating CA: %v", err)
+	}
+	ca := &CA{
+		Key:  key,
+		Cert: caCert,
+	}
+	notBefore := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	opts := CertOptions{
+		Expiry:    time.Hour,
+		Serial:    big.NewInt(42),
+		NotBefore: notBefore,
+		Rand:      rand.Reader,
+	}
+	_, cert, err := NewCertWithOptions(ca, *buildReq("client", nil, nil), opts)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	parsedCert, err := helpers.ParseCertificatePEM(cert)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	if parsedCert.SerialNumber.Cmp(big.NewInt(42)) != 0 {
+		t.Errorf("expected serial number 42, but got %v", parsedCert.SerialNumber)
+	}
+	if !parsedCert.NotBefore.Equal(notBefore) {
+		t.Errorf("expected the certificate to be valid from %v, but got %v", notBefore, parsedCert.NotBefore)
+	}
+	if expected := notBefore.Add(time.Hour); !parsedCert.NotAfter.Equal(expected) {
+		t.Errorf("expected the certificate to be valid until %v, but got %v", expected, parsedCert.NotAfter)
+	}
+}
+
+func TestNewCertWithOptionsUsesRandSource(t *testing.T) {
+	key, caCert, err := NewCACert("test/ca-csr.json", "someCN", "12345h")
+	if err != nil {
+		t.Fatalf("error creating CA: %v", err)
+	}
+	ca := &CA{
+		Key:  key,
+		Cert: caCert,
+	}
+	opts := CertOptions{
+		Expiry: time.Hour,
+		Rand:   failingReader{},
+	}
+	if _, _, err = NewCertWithOptions(ca, *buildReq("client", nil, nil), opts); err == nil {
+		t.Errorf("expected an error when the random source fails")
+	}
+}
+
+func TestNewCertWithOptionsReusesKey(t *testing.T) {
+	key, caCert, err := NewCACert("test/ca-csr.json", "someCN", "12345h")
+	if err != nil {
+		t.Fatalf("error creating CA: %v", err)
+	}
+	ca := &CA{
+		Key:  key,
+		Cert: caCert,
+	}
+	leafKey, leafCert, err := NewCertWithOptions(ca, *buildReq("client", nil, nil), CertOptions{Expiry: time.Hour})
+	if err != nil {
+		t.Fatalf("error creating cert: %v", err)
+	}
+	reusedKey, reusedCert, err := NewCertWithOptions(ca, *buildReq("client", nil, nil), CertOptions{Expiry: time.Hour, Key: leafKey})
+	if err != nil {
+		t.Fatalf("error creating cert with an existing key: %v", err)
+	}
+	if !bytes.Equal(reusedKey, leafKey) {
+		t.Errorf("expected the existing key to be returned")
+	}
+	before, err := helpers.ParseCertificatePEM(leafCert)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	after, err := helpers.ParseCertificatePEM(reusedCert)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	if before.Equal(after) {
+		t.Errorf("expected a new certificate to be signed")
+	}
+	if !reflect.DeepEqual(before.PublicKey, after.PublicKey) {
+		t.Errorf("expected the certificate to be issued for the existing key")
+	}
+	if _, _, err := NewCertWithOptions(ca, *buildReq("client", nil, nil), CertOptions{Expiry: time.Hour, Key: []byte("not a key")}); err == nil {
+		t.Errorf("expected an error when the existing key is invalid")
+	}
+}
+
+func TestKeyMatches(t *testing.T) {
+	rsaKey, _, err := NewCACertFromRequest(csr.CertificateRequest{KeyRequest: &csr.BasicKeyRequest{A: "rsa", S: 2048}}, "someCN", "1h", CAOptions{})
+	if err != nil {
+		t.Fatalf("error creating CA: %v", err)
+	}
+	ecdsaKey, _, err := NewCACertFromRequest(csr.CertificateRequest{KeyRequest: &csr.BasicKeyRequest{A: "ecdsa", S: 256}}, "someCN", "1h", CAOptions{})
+	if err != nil {
+		t.Fatalf("error creating CA: %v", err)
+	}
+	tests := []struct {
+		key     []byte
+		algo    string
+		size    int
+		matches bool
+	}{
+		{key: rsaKey, algo: "rsa", size: 2048, matches: true},
+		{key: rsaKey, algo: "rsa", size: 4096, matches: false},
+		{key: rsaKey, algo: "ecdsa", size: 256, matches: false},
+		{key: ecdsaKey, algo: "ecdsa", size: 256, matches: true},
+		{key: ecdsaKey, algo: "ecdsa", size: 384, matches: false},
+		{key: ecdsaKey, algo: "rsa", size: 2048, matches: false},
+	}
+	for i, test := range tests {
+		matches, err := KeyMatches(test.key, test.algo, test.size)
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if matches != test.matches {
+			t.Errorf("test %d: expected matches = %v, but got %v", i, test.matches, matches)
+		}
+	}
+	if _, err := KeyMatches([]byte("not a key"), "rsa", 2048); err == nil {
+		t.Errorf("expected an error when the key is invalid")
+	}
+}
+
+func TestGenerateSelfSigned(t *testing.T) {
+	hosts := []string{"myhost.example.com", "10.0.0.1"}
+	key, cert, err := GenerateSelfSigned(hosts, csr.Name{O: "someOrg"})
+	if err != nil {
+		t.Fatalf("error generating self-signed certificate: %v", err)
+	}
+	if _, err = helpers.ParsePrivateKeyPEM(key); err != nil {
+		t.Errorf("error parsing private key: %v", err)
+	}
+	parsedCert, err := helpers.ParseCertificatePEM(cert)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	if parsedCert.Subject.CommonName != "myhost.example.com" {
+		t.Errorf("expected common name %q, but got %q", "myhost.example.com", parsedCert.Subject.CommonName)
+	}
+	if !reflect.DeepEqual(parsedCert.Subject.Organization, []string{"someOrg"}) {
+		t.Errorf("expected organization %q, but got %v", "someOrg", parsedCert.Subject.Organization)
+	}
+	if err = parsedCert.CheckSignature(parsedCert.SignatureAlgorithm, parsedCert.RawTBSCertificate, parsedCert.Signature); err != nil {
+		t.Errorf("expected the certificate to be self-signed: %v", err)
+	}
+	if err = parsedCert.VerifyHostname("myhost.example.com"); err != nil {
+		t.Errorf("expected the certificate to be valid for the host name: %v", err)
+	}
+	if err = parsedCert.VerifyHostname("10.0.0.1"); err != nil {
+		t.Errorf("expected the certificate to be valid for the IP: %v", err)
+	}
+}
+
+func TestGenerateSelfSignedNoHosts(t *testing.T) {
+	if _, _, err := GenerateSelfSigned(nil, csr.Name{}); err == nil {
+		t.Errorf("expected an error when no hosts are provided")
+	}
+}
+
+// countingSigner counts the signatures made with the wrapped signer
+type countingSigner struct {
+	crypto.Signer
+	signatures int
+}
+
+func (s *countingSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.signatures++
+	return s.Signer.Sign(rand, digest, opts)
+}
+
+func TestNewCertWithSigner(t *testing.T) {
+	caKey, caCert, err := NewCACert("test/ca-csr.json", "someCN", "24h")
+	if err != nil {
+		t.Fatalf("error creating CA cert: %v", err)
+	}
+	priv, err := helpers.ParsePrivateKeyPEM(caKey)
+	if err != nil {
+		t.Fatalf("error parsing CA key: %v", err)
+	}
+	signer := &countingSigner{Signer: priv}
+	// The CA's key is not provided, so all signatures must go through the signer
+	ca := &CA{Cert: caCert, Signer: signer}
+	req := csr.CertificateRequest{
+		CN:         "someLeaf",
+		KeyRequest: &csr.BasicKeyRequest{A: "rsa", S: 2048},
+	}
+	_, cert, err := NewCert(ca, req, time.Hour)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	if signer.signatures == 0 {
+		t.Errorf("expected the certificate to be signed with the provided signer")
+	}
+	parsedCA, err := helpers.ParseCertificatePEM(caCert)
+	if err != nil {
+		t.Fatalf("error parsing CA certificate: %v", err)
+	}
+	parsedCert, err := helpers.ParseCertificatePEM(cert)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	if err := parsedCert.CheckSignatureFrom(parsedCA); err != nil {
+		t.Errorf("expected the certificate to be signed by the CA: %v", err)
+	}
+}
+
+func TestFileNameScheme(t *testing.T) {
+	if f := (FileNameScheme{}).CertFile("foo"); f != "foo.pem" {
+		t.Errorf("expected the default certificate file name, but got %q", f)
+	}
+	if f := (FileNameScheme{}).KeyFile("foo"); f != "foo-key.pem" {
+		t.Errorf("expected the default key file name, but got %q", f)
+	}
+	s := FileNameScheme{Cert: "{name}/tls.crt", Key: "{name}/tls.key"}
+	if f := s.CertFile("foo"); f != filepath.Join("foo", "tls.crt") {
+		t.Errorf("unexpected certificate file name %q", f)
+	}
+
+	tests := []struct {
+		scheme FileNameScheme
+		valid  bool
+	}{
+		{scheme: FileNameScheme{}, valid: true},
+		{scheme: FileNameScheme{Cert: "{name}.crt", Key: "{name}.key"}, valid: true},
+		{scheme: FileNameScheme{Cert: "{name}/tls.crt", Key: "{name}/tls.key"}, valid: true},
+		{scheme: FileNameScheme{Cert: "tls.crt", Key: "tls.key"}, valid: false},
+		{scheme: FileNameScheme{Cert: "{name}.pem", Key: "{name}.pem"}, valid: false},
+		{scheme: FileNameScheme{Cert: "../{name}.crt"}, valid: false},
+		{scheme: FileNameScheme{Cert: "/etc/{name}.crt"}, valid: false},
+	}
+	for i, test := range tests {
+		if err := test.scheme.Validate(); (err == nil) != test.valid {
+			t.Errorf("test %d: expected valid = %v, but got error %v", i, test.valid, err)
+		}
+	}
+}
+
+func TestFileNameSchemeWriteAndRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls-tests")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	key, cert, err := NewCACert("test/ca-csr.json", "someCN", "24h")
+	if err != nil {
+		t.Fatalf("error creating CA cert: %v", err)
+	}
+	s := FileNameScheme{Cert: "{name}/tls.crt", Key: "{name}/tls.key"}
+	if err := s.WriteCert(key, cert, "ca", dir); err != nil {
+		t.Fatalf("error writing certificate: %v", err)
+	}
+	exists, err := s.CertKeyPairExists("ca", dir)
+	if err != nil || !exists {
+		t.Errorf("expected the certificate and key to exist, got %v, %v", exists, err)
+	}
+	readKey, readCert, err := s.ReadCACert("ca", dir)
+	if err != nil {
+		t.Fatalf("error reading certificate: %v", err)
+	}
+	if !bytes.Equal(readKey, key) || !bytes.Equal(readCert, cert) {
+		t.Errorf("expected the key and certificate to be read back")
+	}
+	if exists, _ := CertKeyPairExists("ca", dir); exists {
+		t.Errorf("expected the certificate not to exist with the default scheme")
+	}
+}
+
+func TestCSRFromCert(t *testing.T) {
+	key, caCert, err := NewCACert("test/ca-csr.json", "someCN", "24h")
+	if err != nil {
+		t.Fatalf("error creating CA: %v", err)
+	}
+	ca := &CA{Key: key, Cert: caCert}
+	SANs := []string{"foo.example.com", "10.0.0.1"}
+	orgs := []string{"system:masters", "someOrg"}
+	opts := CertOptions{
+		Expiry: time.Hour,
+		Usages: []string{"signing", "key encipherment", "client auth"},
+	}
+	_, cert, err := NewCertWithOptions(ca, *buildReq("client", SANs, orgs), opts)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+
+	req, err := CSRFromCert(cert)
+	if err != nil {
+		t.Fatalf("error building request from certificate: %v", err)
+	}
+	if req.CN != "client" {
+		t.Errorf("expected common name %q, but got %q", "client", req.CN)
+	}
+	if !reflect.DeepEqual(req.Hosts, SANs) {
+		t.Errorf("expected hosts %v, but got %v", SANs, req.Hosts)
+	}
+	if req.KeyRequest == nil || req.KeyRequest.Algo() != "rsa" || req.KeyRequest.Size() != 2048 {
+		t.Errorf("expected a 2048 bit rsa key request, but got %v", req.KeyRequest)
+	}
+
+	// The certificate issued from the request must be equivalent to the original
+	_, reissued, err := NewCertWithOptions(ca, req, opts)
+	if err != nil {
+		t.Fatalf("error creating certificate from request: %v", err)
+	}
+	original, err := helpers.ParseCertificatePEM(cert)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	parsed, err := helpers.ParseCertificatePEM(reissued)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	if !reflect.DeepEqual(parsed.Subject.Organization, original.Subject.Organization) {
+		t.Errorf("expected organizations %v, but got %v", original.Subject.Organization, parsed.Subject.Organization)
+	}
+	if !reflect.DeepEqual(parsed.DNSNames, original.DNSNames) || len(parsed.IPAddresses) != len(original.IPAddresses) {
+		t.Errorf("expected SANs %v %v, but got %v %v", original.DNSNames, original.IPAddresses, parsed.DNSNames, parsed.IPAddresses)
+	}
+
+	expectedUsages := []string{"client auth", "digital signature", "key encipherment"}
+	if usages := UsagesFromCert(original); !reflect.DeepEqual(usages, expectedUsages) {
+		t.Errorf("expected usages %v, but got %v", expectedUsages, usages)
+	}
+}
+
+func TestCertDifferences(t *testing.T) {
+	oldKey, oldCACert, err := NewCACert("test/ca-csr.json", "someOldCA", "24h")
+	if err != nil {
+		t.Fatalf("error creating CA: %v", err)
+	}
+	newKey, newCACert, err := NewCACert("test/ca-csr.json", "someNewCA", "48h")
+	if err != nil {
+		t.Fatalf("error creating CA: %v", err)
+	}
+	oldCA := &CA{Key: oldKey, Cert: oldCACert}
+	newCA := &CA{Key: newKey, Cert: newCACert}
+	clientUsages := []string{"signing", "key encipherment", "client auth"}
+	_, old, err := NewCertWithOptions(oldCA, *buildReq("node", []string{"node.example.com", "10.0.0.1"}, []string{"someOrg"}), CertOptions{Expiry: time.Hour, Usages: clientUsages})
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	tests := []struct {
+		SANs   []string
+		orgs   []string
+		usages []string
+		diff   string
+	}{
+		{
+			// The order of the SANs does not matter
+			SANs:   []string{"10.0.0.1", "node.example.com"},
+			orgs:   []string{"someOrg"},
+			usages: clientUsages,
+		},
+		{
+			SANs:   []string{"node.example.com"},
+			orgs:   []string{"someOrg"},
+			usages: clientUsages,
+			diff:   "subject alternate names",
+		},
+		{
+			SANs:   []string{"node.example.com", "10.0.0.1"},
+			orgs:   []string{"someOtherOrg"},
+			usages: clientUsages,
+			diff:   "organization",
+		},
+		{
+			SANs:   []string{"node.example.com", "10.0.0.1"},
+			orgs:   []string{"someOrg"},
+			usages: []string{"signing", "key encipherment", "server auth"},
+			diff:   "key usages",
+		},
+	}
+	for i, test := range tests {
+		_, replacement, err := NewCertWithOptions(newCA, *buildReq("node", test.SANs, test.orgs), CertOptions{Expiry: 2 * time.Hour, Usages: test.usages})
+		if err != nil {
+			t.Fatalf("error creating certificate: %v", err)
+		}
+		diffs, err := CertDifferences(old, replacement)
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if test.diff == "" {
+			if len(diffs) != 0 {
+				t.Errorf("test %d: expected the certificates to be equivalent, but got %v", i, diffs)
+			}
+			continue
+		}
+		if len(diffs) != 1 || !strings.HasPrefix(diffs[0], test.diff+":") {
+			t.Errorf("test %d: expected a single difference in the %s, but got %v", i, test.diff, diffs)
+		}
+	}
+	if _, err := CertDifferences(old, []byte("foo")); err == nil {
+		t.Errorf("expected an error when a certificate is invalid")
+	}
+}
+
+func TestCSRFromCertInvalid(t *testing.T) {
+	if _, err := CSRFromCert([]byte("foo")); err == nil {
+		t.Errorf("expected an error when the certificate is invalid")
+	}
+}
+
+func TestRandomSerial(t *testing.T) {
+	for _, bits := range []int{MinSerialBits, 128, MaxSerialBits} {
+		serial, err := RandomSerial(nil, bits)
+		if err != nil {
+			t.Fatalf("error generating %d bit serial number: %v", bits, err)
+		}
+		if serial.Sign() <= 0 || serial.BitLen() > bits {
+			t.Errorf("expected a positive serial number of up to %d bits, but got %v", bits, serial)
+		}
+	}
+	for _, bits := range []int{0, MinSerialBits - 1, MaxSerialBits + 1} {
+		if _, err := RandomSerial(nil, bits); err == nil {
+			t.Errorf("expected an error for a %d bit serial number", bits)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apprenda/kismatic/pkg/util"
+)
+
+const derExtension = ".der"
+
+var (
+	oidPublicKeyRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+
+	oidNamedCurveP224 = asn1.ObjectIdentifier{1, 3, 132, 0, 33}
+	oidNamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidNamedCurveP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+	oidNamedCurveP521 = asn1.ObjectIdentifier{1, 3, 132, 0, 35}
+)
+
+// pkcs8 is the ASN.1 structure of a PKCS #8 private key, as defined in RFC 5208
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// DERCertFile returns the name of the DER encoded certificate file, which is
+// the name of the certificate file with a ".der" extension.
+func (s FileNameScheme) DERCertFile(name string) string {
+	return withDERExtension(s.CertFile(name))
+}
+
+// DERKeyFile returns the name of the DER encoded private key file, which is
+// the name of the private key file with a ".der" extension. A "-key" suffix
+// is added if the name would otherwise be the same as the certificate's.
+func (s FileNameScheme) DERKeyFile(name string) string {
+	f := withDERExtension(s.KeyFile(name))
+	if f == s.DERCertFile(name) {
+		f = strings.TrimSuffix(f, derExtension) + "-key" + derExtension
+	}
+	return f
+}
+
+func withDERExtension(file string) string {
+	return strings.TrimSuffix(file, filepath.Ext(file)) + derExtension
+}
+
+// WriteCertDER writes the DER encoded versions of the PEM encoded cert and key.
+// Only the first certificate is written if the PEM data contains the
+// certificate of the issuer, and the key is written in PKCS #8 form. The key
+// file is not written if the key is nil. As with WriteCert, the modes of the
+// files are 0600 and 0644 regardless of the umask.
+func (s FileNameScheme) WriteCertDER(key, cert []byte, name, dir string) error {
+	if key != nil {
+		keyDER, err := PrivateKeyToPKCS8(key)
+		if err != nil {
+			return err
+		}
+		keyPath := filepath.Join(dir, s.DERKeyFile(name))
+		if err = os.MkdirAll(filepath.Dir(keyPath), 0744); err != nil {
+			return fmt.Errorf("error creating private key directory: %v", err)
+		}
+		if err = util.WriteFile(keyPath, keyDER, 0600); err != nil {
+			return fmt.Errorf("error writing DER private key: %v", err)
+		}
+	}
+	certDER, err := CertToDER(cert)
+	if err != nil {
+		return err
+	}
+	certPath := filepath.Join(dir, s.DERCertFile(name))
+	if err = os.MkdirAll(filepath.Dir(certPath), 0744); err != nil {
+		return fmt.Errorf("error creating certificate directory: %v", err)
+	}
+	if err = util.WriteFile(certPath, certDER, 0644); err != nil {
+		return fmt.Errorf("error writing DER certificate: %v", err)
+	}
+	return nil
+}
+
+// CertToDER returns the DER encoding of the first certificate of the PEM data
+func CertToDER(certPEM []byte) ([]byte, error) {
+	for b := certPEM; ; {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return nil, fmt.Errorf("no certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return block.Bytes, nil
+		}
+	}
+}
+
+// PrivateKeyToPKCS8 returns the DER encoding of the PEM encoded RSA or ECDSA
+// private key, in PKCS #8 form
+func PrivateKeyToPKCS8(keyPEM []byte) ([]byte, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("error decoding private key: no PEM data found")
+	}
+	var k pkcs8
+	switch block.Type {
+	case "PRIVATE KEY":
+		// Already in PKCS #8 form
+		if _, err := x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("error parsing private key: %v", err)
+		}
+		return block.Bytes, nil
+	case "RSA PRIVATE KEY":
+		if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("error parsing private key: %v", err)
+		}
+		k.Algo = pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyRSA, Parameters: asn1.RawValue{Tag: asn1.TagNull}}
+		k.PrivateKey = block.Bytes
+	case "EC PRIVATE KEY":
+		priv, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing private key: %v", err)
+		}
+		curve, err := namedCurveOID(priv)
+		if err != nil {
+			return nil, err
+		}
+		params, err := asn1.Marshal(curve)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding private key: %v", err)
+		}
+		k.Algo = pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyECDSA, Parameters: asn1.RawValue{FullBytes: params}}
+		k.PrivateKey = block.Bytes
+	default:
+		return nil, fmt.Errorf("unsupported private key type %q", block.Type)
+	}
+	b, err := asn1.Marshal(k)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding private key: %v", err)
+	}
+	return b, nil
+}
+
+func namedCurveOID(priv *ecdsa.PrivateKey) (asn1.ObjectIdentifier, error) {
+	switch priv.Curve {
+	case elliptic.P224():
+		return oidNamedCurveP224, nil
+	case elliptic.P256():
+		return oidNamedCurveP256, nil
+	case elliptic.P384():
+		return oidNamedCurveP384, nil
+	case elliptic.P521():
+		return oidNamedCurveP521, nil
+	}
+	return nil, fmt.Errorf("unsupported elliptic curve %q", priv.Curve.Params().Name)
+}
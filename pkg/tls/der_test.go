@@ -0,0 +1,139 @@
+package tls
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+func TestDERFileNames(t *testing.T) {
+	tests := []struct {
+		scheme FileNameScheme
+		cert   string
+		key    string
+	}{
+		{
+			scheme: FileNameScheme{},
+			cert:   "ca.der",
+			key:    "ca-key.der",
+		},
+		{
+			scheme: FileNameScheme{Cert: "{name}.crt", Key: "{name}.key"},
+			cert:   "ca.der",
+			key:    "ca-key.der",
+		},
+		{
+			scheme: FileNameScheme{Cert: "{name}/tls.crt", Key: "{name}/tls.key"},
+			cert:   filepath.FromSlash("ca/tls.der"),
+			key:    filepath.FromSlash("ca/tls-key.der"),
+		},
+	}
+	for i, test := range tests {
+		if f := test.scheme.DERCertFile("ca"); f != test.cert {
+			t.Errorf("test %d: expected certificate file %q, but got %q", i, test.cert, f)
+		}
+		if f := test.scheme.DERKeyFile("ca"); f != test.key {
+			t.Errorf("test %d: expected key file %q, but got %q", i, test.key, f)
+		}
+	}
+}
+
+func TestWriteCertDER(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls-tests")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	key, caCert, err := NewCACert("test/ca-csr.json", "someCN", "24h")
+	if err != nil {
+		t.Fatalf("error creating CA cert: %v", err)
+	}
+	ca := &CA{Key: key, Cert: caCert}
+	leafKey, leafCert, err := NewCertWithOptions(ca, *buildReq("client", nil, nil), CertOptions{Expiry: time.Hour})
+	if err != nil {
+		t.Fatalf("error creating cert: %v", err)
+	}
+	s := DefaultFileNameScheme
+	if err := s.WriteCertDER(leafKey, BundleCACert(leafCert, caCert), "client", dir); err != nil {
+		t.Fatalf("error writing DER certificate: %v", err)
+	}
+
+	certDER, err := ioutil.ReadFile(filepath.Join(dir, "client.der"))
+	if err != nil {
+		t.Fatalf("error reading DER certificate: %v", err)
+	}
+	parsed, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatalf("error parsing DER certificate: %v", err)
+	}
+	expected, err := helpers.ParseCertificatePEM(leafCert)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	if !parsed.Equal(expected) {
+		t.Errorf("expected the DER file to contain the leaf certificate only")
+	}
+
+	keyDER, err := ioutil.ReadFile(filepath.Join(dir, "client-key.der"))
+	if err != nil {
+		t.Fatalf("error reading DER private key: %v", err)
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(keyDER)
+	if err != nil {
+		t.Fatalf("error parsing PKCS #8 private key: %v", err)
+	}
+	expectedKey, err := helpers.ParsePrivateKeyPEM(leafKey)
+	if err != nil {
+		t.Fatalf("error parsing private key: %v", err)
+	}
+	if !reflect.DeepEqual(priv, expectedKey) {
+		t.Errorf("expected the DER file to contain the private key")
+	}
+	if info, err := os.Stat(filepath.Join(dir, "client-key.der")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected the DER private key to be readable by the owner only, got %v, %v", info, err)
+	}
+
+	// The key is not written if nil
+	if err := s.WriteCertDER(nil, leafCert, "other", dir); err != nil {
+		t.Fatalf("error writing DER certificate: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other-key.der")); !os.IsNotExist(err) {
+		t.Errorf("expected the DER private key not to be written, but got %v", err)
+	}
+}
+
+func TestPrivateKeyToPKCS8(t *testing.T) {
+	for _, kr := range []*csr.BasicKeyRequest{{A: "rsa", S: 2048}, {A: "ecdsa", S: 256}, {A: "ecdsa", S: 384}} {
+		key, _, err := NewCACertFromRequest(csr.CertificateRequest{KeyRequest: kr}, "someCN", "1h", CAOptions{})
+		if err != nil {
+			t.Fatalf("error creating CA: %v", err)
+		}
+		der, err := PrivateKeyToPKCS8(key)
+		if err != nil {
+			t.Errorf("%s-%d: unexpected error: %v", kr.A, kr.S, err)
+			continue
+		}
+		priv, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			t.Errorf("%s-%d: error parsing PKCS #8 private key: %v", kr.A, kr.S, err)
+			continue
+		}
+		expected, err := helpers.ParsePrivateKeyPEM(key)
+		if err != nil {
+			t.Fatalf("error parsing private key: %v", err)
+		}
+		if !reflect.DeepEqual(priv, expected) {
+			t.Errorf("%s-%d: expected the PKCS #8 key to be the same key", kr.A, kr.S)
+		}
+	}
+	if _, err := PrivateKeyToPKCS8([]byte("not a key")); err == nil {
+		t.Errorf("expected an error when the key is invalid")
+	}
+}
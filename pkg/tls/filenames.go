@@ -0,0 +1,205 @@
+package tls
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/apprenda/kismatic/pkg/util"
+)
+
+const fileNamePlaceholder = "{name}"
+
+// A FileNameScheme defines the names of the certificate and private key files,
+// relative to the directory that contains them. The "{name}" placeholder is
+// replaced with the name of the certificate. For example, "{name}.crt" or
+// "{name}/tls.crt". The default names are used for empty fields.
+type FileNameScheme struct {
+	Cert string
+	Key  string
+}
+
+// DefaultFileNameScheme names the files "<name>.pem" and "<name>-key.pem"
+var DefaultFileNameScheme = FileNameScheme{
+	Cert: "{name}.pem",
+	Key:  "{name}-key.pem",
+}
+
+// CertFile returns the name of the certificate file
+func (s FileNameScheme) CertFile(name string) string {
+	tmpl := s.Cert
+	if tmpl == "" {
+		tmpl = DefaultFileNameScheme.Cert
+	}
+	return filepath.FromSlash(strings.Replace(tmpl, fileNamePlaceholder, name, -1))
+}
+
+// KeyFile returns the name of the private key file
+func (s FileNameScheme) KeyFile(name string) string {
+	tmpl := s.Key
+	if tmpl == "" {
+		tmpl = DefaultFileNameScheme.Key
+	}
+	return filepath.FromSlash(strings.Replace(tmpl, fileNamePlaceholder, name, -1))
+}
+
+// Validate returns an error if the scheme can result in files that collide,
+// or that are outside of the directory that contains them.
+func (s FileNameScheme) Validate() error {
+	for _, tmpl := range []string{s.Cert, s.Key} {
+		if tmpl == "" {
+			continue
+		}
+		if !strings.Contains(tmpl, fileNamePlaceholder) {
+			return fmt.Errorf("file name template %q must contain the %s placeholder", tmpl, fileNamePlaceholder)
+		}
+		if filepath.IsAbs(tmpl) || strings.HasPrefix(tmpl, "/") {
+			return fmt.Errorf("file name template %q must be a relative path", tmpl)
+		}
+		for _, part := range strings.Split(tmpl, "/") {
+			if part == ".." {
+				return fmt.Errorf("file name template %q must not refer to the parent directory", tmpl)
+			}
+		}
+	}
+	if s.CertFile("x") == s.KeyFile("x") {
+		return fmt.Errorf("certificate and key file name templates must be different")
+	}
+	return nil
+}
+
+// WriteCert writes cert and key files. The key file is not written if the key is nil.
+// Private keys are always written with mode 0600 and certificates with mode
+// 0644, regardless of the umask.
+func (s FileNameScheme) WriteCert(key, cert []byte, name, dir string) error {
+	// Create destination dir if it doesn't exist
+	err := util.CreateDir(dir, 0744)
+	if err != nil {
+		return err
+	}
+	// Write private key with read-only for user
+	if key != nil {
+		keyPath := filepath.Join(dir, s.KeyFile(name))
+		if err = os.MkdirAll(filepath.Dir(keyPath), 0744); err != nil {
+			return fmt.Errorf("error creating private key directory: %v", err)
+		}
+		err = util.WriteFile(keyPath, key, 0600)
+		if err != nil {
+			return fmt.Errorf("error writing private key: %v", err)
+		}
+	}
+	// Write cert
+	certPath := filepath.Join(dir, s.CertFile(name))
+	if err = os.MkdirAll(filepath.Dir(certPath), 0744); err != nil {
+		return fmt.Errorf("error creating certificate directory: %v", err)
+	}
+	err = util.WriteFile(certPath, cert, 0644)
+	if err != nil {
+		return fmt.Errorf("error writing certificate: %v", err)
+	}
+	return nil
+}
+
+// ReadCert reads the certificate with the given name in the provided directory.
+func (s FileNameScheme) ReadCert(name, dir string) (*x509.Certificate, error) {
+	certBytes, err := ioutil.ReadFile(filepath.Join(dir, s.CertFile(name)))
+	if err != nil {
+		return nil, err
+	}
+	return parseLeafCertificatePEM(certBytes)
+}
+
+// ReadCACert reads the private key and certificate of the CA with the given name
+func (s FileNameScheme) ReadCACert(name, dir string) (key, cert []byte, err error) {
+	key, errKey := ioutil.ReadFile(filepath.Join(dir, s.KeyFile(name)))
+	if errKey != nil {
+		return nil, nil, fmt.Errorf("error reading private key: %v", errKey)
+	}
+	cert, errCert := ioutil.ReadFile(filepath.Join(dir, s.CertFile(name)))
+	if errCert != nil {
+		return nil, nil, fmt.Errorf("error reading certificate: %v", errCert)
+	}
+	return key, cert, nil
+}
+
+// CertKeyPairExists returns true if a key and matching certificate exist.
+// Matching is defined as having the expected file names. No validation
+// is performed on the actual bytes of the cert/key
+func (s FileNameScheme) CertKeyPairExists(name, dir string) (bool, error) {
+	var err error
+	if _, err = os.Stat(filepath.Join(dir, s.KeyFile(name))); os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err = os.Stat(filepath.Join(dir, s.CertFile(name))); os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CertValid returns a list of validation warnings if the certificate values do
+// not match the expected values. See the CertValid function for the rules.
+func (s FileNameScheme) CertValid(commonName string, SANs []string, organizations []string, name, dir string) (warn []error, err error) {
+	// check if cert exists
+	cn := s.CertFile(name)
+	if _, err = os.Stat(filepath.Join(dir, cn)); os.IsNotExist(err) {
+		return nil, fmt.Errorf("certificate %s does not exist", cn)
+	} else if err != nil {
+		return nil, fmt.Errorf("unexpected error looking for certificate %s", cn)
+	}
+
+	// read the certificate file
+	certBytes, err := ioutil.ReadFile(filepath.Join(dir, cn))
+	if err != nil {
+		return nil, fmt.Errorf("error reding cert %s: %v", name, err)
+	}
+
+	// verify certificate
+	cert, err := parseLeafCertificatePEM(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing cert %s: %v", name, err)
+	}
+
+	if cert.Subject.CommonName != commonName {
+		warn = append(warn, fmt.Errorf("Certificate %q: CN validation failed\n    expected %q, instead got %q", cn, commonName, cert.Subject.CommonName))
+	}
+
+	var certSANs []string
+	for _, ip := range cert.IPAddresses {
+		certSANs = append(certSANs, ip.String())
+	}
+	// DNS can be any string value
+	certSANs = append(certSANs, cert.DNSNames...)
+
+	// check if the SANs in the certificate contain the requested SANs
+	// allows for operators to add their own custom SANs in the cert
+	subset := util.Subset(SANs, certSANs)
+	if !subset {
+		// sort for readability
+		sort.Strings(SANs)
+		sort.Strings(certSANs)
+		warn = append(warn, fmt.Errorf("Certificate %q: SANs validation failed\n    expected: \n\t%v \n    instead got: \n\t%v", cn, SANs, certSANs))
+	}
+
+	// Validate organizations
+	subset = util.Subset(organizations, cert.Subject.Organization)
+	if !subset {
+		sort.Strings(organizations)
+		sort.Strings(cert.Subject.Organization)
+		warn = append(warn,
+			fmt.Errorf("Certificate %q: Organizations validation failed\n    expected: \n\t%v \n    instead got: \n\t%v",
+				cn, organizations, cert.Subject.Organization),
+		)
+	}
+
+	return warn, nil
+}
@@ -0,0 +1,94 @@
+package tls
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+var oidExtensionSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// tags of the general names of the subject alternative name extension, as
+// defined in RFC 5280
+const (
+	sanTagEmail = 1
+	sanTagDNS   = 2
+	sanTagURI   = 6
+	sanTagIP    = 7
+)
+
+// SubjectAltNameExtension returns the subject alternative name extension of
+// a certificate that includes the hosts, which are sorted into DNS names, IP
+// addresses and email addresses as cfssl does, followed by the URIs. The
+// extension takes the place of the one that would be built from the hosts of
+// the certificate request, as URI SANs are not supported otherwise.
+func SubjectAltNameExtension(hosts []string, uris []string) (pkix.Extension, error) {
+	var names []asn1.RawValue
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			names = append(names, asn1.RawValue{Tag: sanTagIP, Class: asn1.ClassContextSpecific, Bytes: ip})
+		} else if email, err := mail.ParseAddress(h); err == nil && strings.Contains(h, "@") {
+			names = append(names, asn1.RawValue{Tag: sanTagEmail, Class: asn1.ClassContextSpecific, Bytes: []byte(email.Address)})
+		} else {
+			names = append(names, asn1.RawValue{Tag: sanTagDNS, Class: asn1.ClassContextSpecific, Bytes: []byte(h)})
+		}
+	}
+	for _, u := range uris {
+		if err := ValidateURI(u); err != nil {
+			return pkix.Extension{}, err
+		}
+		names = append(names, asn1.RawValue{Tag: sanTagURI, Class: asn1.ClassContextSpecific, Bytes: []byte(u)})
+	}
+	b, err := asn1.Marshal(names)
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("error encoding subject alternative names: %v", err)
+	}
+	return pkix.Extension{Id: oidExtensionSubjectAltName, Value: b}, nil
+}
+
+// ValidateURI returns an error if the URI cannot be used as a SAN, as it
+// is not an absolute URI
+func ValidateURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("URI SAN %q is invalid: %v", uri, err)
+	}
+	if u.Scheme == "" || (u.Host == "" && u.Opaque == "") {
+		return fmt.Errorf("URI SAN %q is invalid: it must be an absolute URI", uri)
+	}
+	return nil
+}
+
+// CertURIs returns the URIs in the subject alternative names of the
+// certificate, which are not parsed by crypto/x509
+func CertURIs(cert *x509.Certificate) ([]string, error) {
+	var uris []string
+	for _, e := range cert.Extensions {
+		if !e.Id.Equal(oidExtensionSubjectAltName) {
+			continue
+		}
+		var seq asn1.RawValue
+		if rest, err := asn1.Unmarshal(e.Value, &seq); err != nil || len(rest) != 0 {
+			return nil, fmt.Errorf("error parsing subject alternative names: %v", err)
+		}
+		for rest := seq.Bytes; len(rest) > 0; {
+			var v asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &v); err != nil {
+				return nil, fmt.Errorf("error parsing subject alternative names: %v", err)
+			}
+			if v.Class == asn1.ClassContextSpecific && v.Tag == sanTagURI {
+				uris = append(uris, string(v.Bytes))
+			}
+		}
+	}
+	return uris, nil
+}
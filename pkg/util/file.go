@@ -21,3 +21,22 @@ func BackupDirectory(sourceDir string, backupDir string) (bool, error) {
 	// Directory does not already exist, nothing to do
 	return backedup, nil
 }
+
+// WriteFile writes the data to the file, creating it if it does not exist,
+// and sets its permissions to perm. Unlike ioutil.WriteFile, the permissions
+// are not subject to the umask of the process, and are also set when the
+// file exists already. The permissions are set before the data is written,
+// so that a file that holds a private key is never readable by others.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err == nil {
+		_, err = f.Write(data)
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
@@ -40,3 +40,47 @@ func TestBackupClientDirectoryNotExists(t *testing.T) {
 		t.Errorf("Expected directory to not exist")
 	}
 }
+
+func TestWriteFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "ket-writefile-test")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// permissions that the default umask would clear
+	path := filepath.Join(tmpDir, "new")
+	if err := WriteFile(path, []byte("foo"), 0666); err != nil {
+		t.Fatalf("unexpected error writing file: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if info.Mode().Perm() != 0666 {
+		t.Errorf("expected mode 0666, but got %v", info.Mode().Perm())
+	}
+
+	// the permissions of an existing file are replaced
+	path = filepath.Join(tmpDir, "existing")
+	if err := ioutil.WriteFile(path, []byte("some longer content"), 0644); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	if err := WriteFile(path, []byte("key"), 0600); err != nil {
+		t.Fatalf("unexpected error writing file: %v", err)
+	}
+	info, err = os.Stat(path)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, but got %v", info.Mode().Perm())
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading file: %v", err)
+	}
+	if string(b) != "key" {
+		t.Errorf("expected the content to be replaced, but got %q", b)
+	}
+}
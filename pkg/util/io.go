@@ -52,13 +52,20 @@ func PromptForString(in io.Reader, out io.Writer, prompt string, defaultValue st
 
 // CreateDir check if directory exists and create it
 func CreateDir(dir string, perm os.FileMode) error {
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
+	fi, err := os.Stat(dir)
+	if os.IsNotExist(err) {
 		err := os.Mkdir(dir, perm)
 		if err != nil {
 			return fmt.Errorf("error creating destination dir: %v", err)
 		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error checking destination dir: %v", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("destination dir %q exists and is not a directory", dir)
 	}
-
 	return nil
 }
 
@@ -14,6 +14,14 @@ func GetIPFromCIDR(cidr string, n int) (net.IP, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error parsing CIDR: %v", err)
 	}
+	return GetIPFromNetwork(ipnet, n)
+}
+
+// GetIPFromNetwork returns the n-th IP address of the network
+func GetIPFromNetwork(ipnet *net.IPNet, n int) (net.IP, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("cannot compute n=%d IP address", n)
+	}
 
 	// Need a copy of the IP byte slice to reuse ipnet
 	ip := make([]byte, len(ipnet.IP), cap(ipnet.IP))
@@ -31,7 +39,7 @@ func GetIPFromCIDR(cidr string, n int) (net.IP, error) {
 
 	// Verify the resulting IP is contained in the CIDR
 	if !ipnet.Contains(ip) {
-		return nil, fmt.Errorf("Could not compute the n=%d IP address of CIDR %q (resulting IP %q is not in CIDR)", n, cidr, net.IP(ip))
+		return nil, fmt.Errorf("Could not compute the n=%d IP address of CIDR %q (resulting IP %q is not in CIDR)", n, ipnet.String(), net.IP(ip))
 	}
 
 	return ip, nil